@@ -0,0 +1,42 @@
+package main
+
+import (
+	"codeswitch/services"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// runCLI 执行管理命令（codeswitch-headless provider list 等）并返回进程退出码。
+// 命令结果写 stdout，错误写 stderr；服务层的运行日志已由 main 接到 stderr，不会混进表格与 JSON
+func runCLI(args []string, stdout, stderr io.Writer) int {
+	if err := services.InitDatabase(); err != nil {
+		fmt.Fprintf(stderr, "数据库初始化失败: %v\n", err)
+		return 1
+	}
+	if err := services.InitGlobalDBQueue(); err != nil {
+		fmt.Fprintf(stderr, "初始化数据库队列失败: %v\n", err)
+		return 1
+	}
+	// 黑名单等写入经队列异步落库，退出前必须排空
+	defer func() {
+		if err := services.ShutdownGlobalDBQueue(10 * time.Second); err != nil {
+			fmt.Fprintf(stderr, "⚠️ 队列关闭超时: %v\n", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := services.RunCLI(ctx, args, stdout, stderr); err != nil {
+		if services.IsCLIUsageError(err) {
+			return 2
+		}
+		fmt.Fprintf(stderr, "错误: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,99 @@
+// codeswitch-headless 无界面入口：不带参数运行为守护进程，只启动代理及其后台服务、
+// 不创建窗口与托盘，适合无桌面的 Linux 主机、容器或 SSH 会话；带管理命令运行
+// （codeswitch-headless provider list 等）为命令行模式。只依赖 services 包、不链接 Wails，
+// 可以 CGO_ENABLED=0 构建。配置与数据仍读写 ~/.code-switch，与桌面应用可互换使用
+// （同一时刻只能有一个进程监听代理端口）
+package main
+
+import (
+	"codeswitch/services"
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/daodao97/xgo/xlog"
+)
+
+func main() {
+	// 运行日志一律写 stderr，stdout 只留给命令结果。xlog 与桌面入口一致收敛到 Warn，
+	// 避免每个转发请求输出完整 curl
+	xlog.SetLogger(slog.New(xlog.NewPrettyHandler(os.Stderr, xlog.PrettyHandlerOptions{
+		SlogOpts: slog.HandlerOptions{Level: slog.LevelWarn},
+	})))
+	// SetLogger 内部的 slog.SetDefault 会把标准库 log 也接到 Warn 级 handler 上，
+	// 启动与关停日志（Info 级）会被整个吞掉；桌面端由 ConsoleService 接管 log，这里直接写 stderr
+	log.SetOutput(os.Stderr)
+
+	// 带任何参数即为命令行模式（未知命令打印用法后退出），避免拼错命令时误起一个守护进程
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:], os.Stdout, os.Stderr))
+	}
+	runDaemon()
+}
+
+// runDaemon 守护进程模式：收到 SIGINT/SIGTERM 后按与桌面应用相同的顺序优雅关停
+func runDaemon() {
+	if err := services.InitDatabase(); err != nil {
+		log.Fatalf("数据库初始化失败: %v", err)
+	}
+	log.Println("✅ 数据库已初始化")
+	if err := services.InitGlobalDBQueue(); err != nil {
+		log.Fatalf("初始化数据库队列失败: %v", err)
+	}
+	log.Println("✅ 数据库写入队列已启动")
+
+	providerService := services.NewProviderService()
+	settingsService := services.NewSettingsService()
+	appSettings := services.NewAppSettingsService(services.NewAutoStartService())
+	// 没有 App 引用：通知服务只做日志与黑名单联动，不向前端发事件
+	notificationService := services.NewNotificationService(appSettings)
+	defaultModelPolicy := services.NewDefaultModelPolicy()
+	modelSyncService := services.NewModelSyncService(appSettings, defaultModelPolicy)
+	blacklistService := services.NewBlacklistService(settingsService, notificationService)
+	relayListenAddrs := services.ResolveRelayListenAddresses()
+	for _, addr := range relayListenAddrs {
+		if addr != "127.0.0.1:18100" {
+			log.Printf("⚠️ 代理按网络设置监听 %s（非仅回环，该网段内的其它设备可访问，请确认这是你需要的）", addr)
+		}
+	}
+	relayConnectAddr := services.RelayConnectAddress(relayListenAddrs[0])
+	geminiService := services.NewGeminiService(relayConnectAddr, defaultModelPolicy)
+	providerRelay := services.NewProviderRelayService(providerService, geminiService, blacklistService, notificationService, appSettings, relayListenAddrs...)
+	healthCheckService := services.NewHealthCheckService(providerService, blacklistService, settingsService, defaultModelPolicy)
+	providerRelay.SetHealthCheckService(healthCheckService)
+	if err := healthCheckService.Start(); err != nil {
+		log.Fatalf("初始化健康检查服务失败: %v", err)
+	}
+
+	// 没有界面可以提示端口冲突，监听失败直接退出
+	if err := providerRelay.Start(); err != nil {
+		_ = services.ShutdownGlobalDBQueue(10 * time.Second)
+		log.Fatalf("代理服务启动失败: %v", err)
+	}
+	log.Printf("✅ 无界面模式已启动，代理监听 %v", providerRelay.BoundAddresses())
+
+	modelSyncService.Start()
+	stopChan := make(chan struct{})
+	services.StartBlacklistRecoverTimer(blacklistService, stopChan)
+	services.StartAvailabilityBootstrap(appSettings, healthCheckService, stopChan)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+	log.Println("🛑 收到退出信号，停止后台服务...")
+
+	close(stopChan)
+	healthCheckService.StopBackgroundPolling()
+	modelSyncService.Stop()
+	if err := providerRelay.Stop(); err != nil {
+		log.Printf("provider relay stop error: %v", err)
+	}
+	// 与桌面应用一致：给被中断的请求协程时间把 request_log 塞进写入队列
+	time.Sleep(500 * time.Millisecond)
+	services.ShutdownDBQueues()
+	log.Println("✅ 所有后台服务已停止")
+}
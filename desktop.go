@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+// wailsDesktop 以 Wails App 实现 services.DesktopApp，服务层经它推送事件与弹对话框
+type wailsDesktop struct {
+	app *application.App
+}
+
+func (d wailsDesktop) Emit(name string, data any) {
+	d.app.Event.Emit(name, data)
+}
+
+func (d wailsDesktop) Quit() {
+	d.app.Quit()
+}
+
+func (d wailsDesktop) SaveFileDialog(filename, filterName, filterPattern string) (string, error) {
+	dialog := application.SaveFileDialog().
+		SetFilename(filename).
+		AddFilter(filterName, filterPattern).
+		CanCreateDirectories(true)
+	// 绑定当前（发起调用的）窗口，避免对话框失焦或弹到别的窗口后面
+	if w := d.app.Window.Current(); w != nil {
+		dialog.AttachToWindow(w)
+	}
+	return dialog.PromptForSingleSelection()
+}
@@ -9,6 +9,15 @@ import { Call as $Call, CancellablePromise as $CancellablePromise, Create as $Cr
 // @ts-ignore: Unused imports
 import * as $models from "./models.js";
 
+/**
+ * ClientDailyStats 按客户端汇总今日用量；未识别客户端的请求记为 (unknown)
+ */
+export function ClientDailyStats(platform: string): $CancellablePromise<$models.ClientDailyStat[]> {
+    return $Call.ByID(1120744925, platform).then(($result: any) => {
+        return $$createType11($result);
+    });
+}
+
 export function CostSince(start: string, platform: string): $CancellablePromise<number> {
     return $Call.ByID(445919367, start, platform);
 }
@@ -64,3 +73,5 @@ const $$createType6 = $Create.Array($$createType5);
 const $$createType7 = $models.ProviderDailyStat.createFrom;
 const $$createType8 = $Create.Array($$createType7);
 const $$createType9 = $models.LogStats.createFrom;
+const $$createType10 = $models.ClientDailyStat.createFrom;
+const $$createType11 = $Create.Array($$createType10);
@@ -12,6 +12,39 @@ import * as modelpricing$0 from "../resources/model-pricing/models.js";
 // @ts-ignore: Unused imports
 import * as time$0 from "../../time/models.js";
 
+/**
+ * AdminAPIConfig 管理 API 配置
+ */
+export class AdminAPIConfig {
+    "enabled": boolean;
+
+    /**
+     * MetricsEnabled 开启 /metrics（见 metrics.go），与管理 API 共用令牌
+     */
+    "metricsEnabled": boolean;
+    "token"?: string;
+
+    /** Creates a new AdminAPIConfig instance. */
+    constructor($$source: Partial<AdminAPIConfig> = {}) {
+        if (!("enabled" in $$source)) {
+            this["enabled"] = false;
+        }
+        if (!("metricsEnabled" in $$source)) {
+            this["metricsEnabled"] = false;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new AdminAPIConfig instance from a string or object.
+     */
+    static createFrom($$source: any = {}): AdminAPIConfig {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new AdminAPIConfig($$parsedSource as Partial<AdminAPIConfig>);
+    }
+}
+
 export class AppSettings {
     "show_heatmap": boolean;
     "show_home_title": boolean;
@@ -900,6 +933,199 @@ export class ClaudeProxyStatus {
     }
 }
 
+/**
+ * ClientAuthConfig 客户端认证配置
+ */
+export class ClientAuthConfig {
+    "enabled": boolean;
+
+    /**
+     * CLIToken 自动注入本机与 WSL 中 CLI 配置的令牌名
+     */
+    "cliToken"?: string;
+    "tokens": ClientToken[] | null;
+
+    /**
+     * Quotas 按客户端的每日配额（见 client_quota.go）
+     */
+    "quotas"?: ClientQuota[];
+
+    /** Creates a new ClientAuthConfig instance. */
+    constructor($$source: Partial<ClientAuthConfig> = {}) {
+        if (!("enabled" in $$source)) {
+            this["enabled"] = false;
+        }
+        if (!("tokens" in $$source)) {
+            this["tokens"] = null;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ClientAuthConfig instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ClientAuthConfig {
+        const $$createField2_0 = $$createType35;
+        const $$createField3_0 = $$createType37;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("tokens" in $$parsedSource) {
+            $$parsedSource["tokens"] = $$createField2_0($$parsedSource["tokens"]);
+        }
+        if ("quotas" in $$parsedSource) {
+            $$parsedSource["quotas"] = $$createField3_0($$parsedSource["quotas"]);
+        }
+        return new ClientAuthConfig($$parsedSource as Partial<ClientAuthConfig>);
+    }
+}
+
+/**
+ * ClientDailyStat 客户端今日用量
+ */
+export class ClientDailyStat {
+    "client": string;
+    "total_requests": number;
+    "successful_requests": number;
+    "failed_requests": number;
+    "success_rate": number;
+    "input_tokens": number;
+    "output_tokens": number;
+    "reasoning_tokens": number;
+    "cache_create_tokens": number;
+    "cache_read_tokens": number;
+    "cost_total": number;
+
+    /** Creates a new ClientDailyStat instance. */
+    constructor($$source: Partial<ClientDailyStat> = {}) {
+        if (!("client" in $$source)) {
+            this["client"] = "";
+        }
+        if (!("total_requests" in $$source)) {
+            this["total_requests"] = 0;
+        }
+        if (!("successful_requests" in $$source)) {
+            this["successful_requests"] = 0;
+        }
+        if (!("failed_requests" in $$source)) {
+            this["failed_requests"] = 0;
+        }
+        if (!("success_rate" in $$source)) {
+            this["success_rate"] = 0;
+        }
+        if (!("input_tokens" in $$source)) {
+            this["input_tokens"] = 0;
+        }
+        if (!("output_tokens" in $$source)) {
+            this["output_tokens"] = 0;
+        }
+        if (!("reasoning_tokens" in $$source)) {
+            this["reasoning_tokens"] = 0;
+        }
+        if (!("cache_create_tokens" in $$source)) {
+            this["cache_create_tokens"] = 0;
+        }
+        if (!("cache_read_tokens" in $$source)) {
+            this["cache_read_tokens"] = 0;
+        }
+        if (!("cost_total" in $$source)) {
+            this["cost_total"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ClientDailyStat instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ClientDailyStat {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new ClientDailyStat($$parsedSource as Partial<ClientDailyStat>);
+    }
+}
+
+/**
+ * ClientQuota 客户端每日配额，两项均为 0 表示不限
+ */
+export class ClientQuota {
+    /**
+     * Client 客户端标识（令牌名或自报名），* 为未单独配置的客户端的默认配额
+     */
+    "client": string;
+
+    /**
+     * 每日 token 上限（输入 + 输出 + 缓存写入）
+     */
+    "dailyTokens"?: number;
+
+    /**
+     * 每日费用上限（美元，含价格倍率）
+     */
+    "dailyCost"?: number;
+
+    /** Creates a new ClientQuota instance. */
+    constructor($$source: Partial<ClientQuota> = {}) {
+        if (!("client" in $$source)) {
+            this["client"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ClientQuota instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ClientQuota {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new ClientQuota($$parsedSource as Partial<ClientQuota>);
+    }
+}
+
+/**
+ * ClientToken 客户端访问令牌
+ */
+export class ClientToken {
+    "name": string;
+    "token": string;
+
+    /**
+     * Platforms 可访问的平台（claude/codex/openai/gemini/custom:<工具ID>，支持通配符），空为全部
+     */
+    "platforms"?: string[];
+
+    /**
+     * 过期时间，空为永不过期
+     */
+    "expiresAt"?: time$0.Time | null;
+    "createdAt": time$0.Time;
+
+    /** Creates a new ClientToken instance. */
+    constructor($$source: Partial<ClientToken> = {}) {
+        if (!("name" in $$source)) {
+            this["name"] = "";
+        }
+        if (!("token" in $$source)) {
+            this["token"] = "";
+        }
+        if (!("createdAt" in $$source)) {
+            this["createdAt"] = null;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ClientToken instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ClientToken {
+        const $$createField2_0 = $$createType7;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("platforms" in $$parsedSource) {
+            $$parsedSource["platforms"] = $$createField2_0($$parsedSource["platforms"]);
+        }
+        return new ClientToken($$parsedSource as Partial<ClientToken>);
+    }
+}
+
 /**
  * ConfigFile 配置文件信息
  */
@@ -1699,11 +1925,47 @@ export class GeminiProvider {
      */
     "maxConcurrency"?: number;
 
+    /**
+     * 每分钟请求数上限（0=不限）
+     */
+    "rpmLimit"?: number;
+
+    /**
+     * 每分钟 token 数上限（输入+输出，0=不限）
+     */
+    "tpmLimit"?: number;
+
+    /**
+     * 消费上限（USD，0=不限），达到后退出调度直到周期重置
+     */
+    "spendCap"?: number;
+
+    /**
+     * 消费上限周期：daily/weekly/monthly（默认 daily）
+     */
+    "spendCapPeriod"?: string;
+
+    /**
+     * 负载权重（0=默认 1，开启轮询时同 Level 内平滑加权轮询）
+     */
+    "weight"?: number;
+
+    /**
+     * 价格倍率（0=按官方价），ModelPriceMultipliers 按模型覆盖
+     */
+    "priceMultiplier"?: number;
+    "modelPriceMultipliers"?: { [_ in string]?: number };
+
     /**
      * 跳过上游 TLS 证书验证（仅该供应商，存在中间人风险）
      */
     "insecureSkipVerify"?: boolean;
 
+    /**
+     * 出站代理（http/https/socks5/socks5h，空为直连）
+     */
+    "proxyUrl"?: string;
+
     /**
      * 模型白名单（精确或通配符），空表示不限制
      */
@@ -2587,12 +2849,47 @@ export class Provider {
      */
     "fallbackApiUrls"?: string[];
 
+    /**
+     * 额外 API 密钥（可选，最多 16 个）- 与 APIKey 组成密钥池，各自额度独立
+     * 密钥被拒（401/403）、限流（429）或额度耗尽时冷却该密钥并在同一请求内换下一把，
+     * 全部失败才算该供应商一次失败。KeySelection：fill_first（默认，用尽一把再换）/ round_robin（轮询）
+     */
+    "extraApiKeys"?: string[];
+    "keySelection"?: string;
+
     /**
      * 最大并发请求数（0=不限）- 仅约束代理转发的推理请求，
      * /v1/models、健康检查等内部请求不占配额；为单进程内限制
      */
     "maxConcurrency"?: number;
 
+    /**
+     * 速率上限（0=不限）- 每分钟请求数与每分钟 token 数（输入+输出，按请求日志实际用量），
+     * 令牌桶按分钟匀速恢复；超限的供应商与并发满载一样跳过，全部超限时短暂排队
+     */
+    "rpmLimit"?: number;
+    "tpmLimit"?: number;
+
+    /**
+     * 消费上限（USD，0=不限）- 按请求日志费用统计当前周期（daily/weekly/monthly，默认 daily）
+     * 的花费，达到上限后该供应商退出调度，直到周期重置
+     */
+    "spendCap"?: number;
+    "spendCapPeriod"?: string;
+
+    /**
+     * 负载权重（0=默认 1）- 开启轮询时同 Level 内按平滑加权轮询分配请求，
+     * 如 3 与 1 约为 75%/25%；全部为默认值时即普通轮询
+     */
+    "weight"?: number;
+
+    /**
+     * 价格倍率（0=按官方价）- 用于请求日志费用与"最低成本优先"排序；
+     * ModelPriceMultipliers 按模型覆盖（精确匹配优先，支持通配符）
+     */
+    "priceMultiplier"?: number;
+    "modelPriceMultipliers"?: { [_ in string]?: number };
+
     /**
      * 模型白名单 - Provider 原生支持的模型名
      * 使用 map 实现 O(1) 查找，向后兼容（omitempty）
@@ -2650,6 +2947,12 @@ export class Provider {
      */
     "insecureSkipVerify"?: boolean;
 
+    /**
+     * 出站代理 - 仅对该供应商生效，支持 http/https/socks5/socks5h，认证写在地址中（user:pass@host:port）
+     * 为空时直连（仍遵循 HTTP(S)_PROXY 环境变量）；转发、健康检查、连通性测试与模型列表请求统一走该代理
+     */
+    "proxyUrl"?: string;
+
     /**
      * 请求清理开关 - 启用后转发前移除非标准字段和不支持的请求头
      * 解决 LiteLLM 等中转服务的 "Extra inputs are not permitted" 兼容性问题
@@ -2657,6 +2960,11 @@ export class Provider {
     "requestSanitizeEnabled"?: boolean;
     "sanitizeConfig"?: SanitizeConfig | null;
 
+    /**
+     * 请求改写规则 - 按声明序对请求头/请求体执行 set / delete / replace
+     */
+    "rewriteRules"?: RewriteRule[];
+
     /**
      * [已废弃] 连通性检测开关 - 迁移到 AvailabilityMonitorEnabled
      */
@@ -2714,10 +3022,14 @@ export class Provider {
         const $$createField13_0 = $$createType4;
         const $$createField17_0 = $$createType26;
         const $$createField22_0 = $$createType28;
+        const $$createField23_0 = $$createType33;
         let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
         if ("fallbackApiUrls" in $$parsedSource) {
             $$parsedSource["fallbackApiUrls"] = $$createField10_0($$parsedSource["fallbackApiUrls"]);
         }
+        if ("extraApiKeys" in $$parsedSource) {
+            $$parsedSource["extraApiKeys"] = $$createField10_0($$parsedSource["extraApiKeys"]);
+        }
         if ("supportedModels" in $$parsedSource) {
             $$parsedSource["supportedModels"] = $$createField12_0($$parsedSource["supportedModels"]);
         }
@@ -2730,6 +3042,9 @@ export class Provider {
         if ("sanitizeConfig" in $$parsedSource) {
             $$parsedSource["sanitizeConfig"] = $$createField22_0($$parsedSource["sanitizeConfig"]);
         }
+        if ("rewriteRules" in $$parsedSource) {
+            $$parsedSource["rewriteRules"] = $$createField23_0($$parsedSource["rewriteRules"]);
+        }
         return new Provider($$parsedSource as Partial<Provider>);
     }
 }
@@ -2972,6 +3287,26 @@ export class ReqeustLog {
      */
     "has_capture": boolean;
 
+    /**
+     * Hedged 对冲竞速中落败被取消的尝试（不计失败、不计入供应商统计）
+     */
+    "hedged": boolean;
+
+    /**
+     * SessionKey 会话粘性识别出的会话标识（哈希），未开启粘性或无法识别时为空
+     */
+    "session_key": string;
+
+    /**
+     * StickyHit 本次尝试发往会话已粘住的供应商
+     */
+    "sticky_hit": boolean;
+
+    /**
+     * ClientName 客户端标识：访问令牌名，未开启客户端认证时取 X-Code-Switch-Client 自报名，无法识别时为空
+     */
+    "client_name": string;
+
     /** Creates a new ReqeustLog instance. */
     constructor($$source: Partial<ReqeustLog> = {}) {
         if (!("id" in $$source)) {
@@ -3052,6 +3387,18 @@ export class ReqeustLog {
         if (!("has_capture" in $$source)) {
             this["has_capture"] = false;
         }
+        if (!("hedged" in $$source)) {
+            this["hedged"] = false;
+        }
+        if (!("session_key" in $$source)) {
+            this["session_key"] = "";
+        }
+        if (!("sticky_hit" in $$source)) {
+            this["sticky_hit"] = false;
+        }
+        if (!("client_name" in $$source)) {
+            this["client_name"] = "";
+        }
 
         Object.assign(this, $$source);
     }
@@ -3214,6 +3561,59 @@ export class RetryConfig {
     }
 }
 
+/**
+ * RewriteRule 一条请求改写规则
+ */
+export class RewriteRule {
+    /**
+     * header / body
+     */
+    "target": string;
+
+    /**
+     * set / delete / replace
+     */
+    "op": string;
+
+    /**
+     * 请求头名或 sjson 路径
+     */
+    "path": string;
+
+    /**
+     * set/replace 的新值；body 为 JSON 字面量
+     */
+    "value"?: string;
+
+    /**
+     * replace 的匹配条件（可选）
+     */
+    "from"?: string;
+
+    /** Creates a new RewriteRule instance. */
+    constructor($$source: Partial<RewriteRule> = {}) {
+        if (!("target" in $$source)) {
+            this["target"] = "";
+        }
+        if (!("op" in $$source)) {
+            this["op"] = "";
+        }
+        if (!("path" in $$source)) {
+            this["path"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new RewriteRule instance from a string or object.
+     */
+    static createFrom($$source: any = {}): RewriteRule {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new RewriteRule($$parsedSource as Partial<RewriteRule>);
+    }
+}
+
 /**
  * SanitizeConfig 请求清理高级配置（黑名单模式）。
  * 三个列表用指针区分三态：nil 指针（字段缺失/null）= 用内置默认黑名单；
@@ -3729,3 +4129,9 @@ const $$createType28 = $Create.Nullable($$createType27);
 const $$createType29 = SeedField.createFrom;
 const $$createType30 = $Create.Array($$createType29);
 const $$createType31 = $Create.Nullable($$createType7);
+const $$createType32 = RewriteRule.createFrom;
+const $$createType33 = $Create.Array($$createType32);
+const $$createType34 = ClientToken.createFrom;
+const $$createType35 = $Create.Array($$createType34);
+const $$createType36 = ClientQuota.createFrom;
+const $$createType37 = $Create.Array($$createType36);
@@ -13,10 +13,6 @@ import { Call as $Call, CancellablePromise as $CancellablePromise, Create as $Cr
 // eslint-disable-next-line @typescript-eslint/ban-ts-comment
 // @ts-ignore: Unused imports
 import * as modelpricing$0 from "../resources/model-pricing/models.js";
-// eslint-disable-next-line @typescript-eslint/ban-ts-comment
-// @ts-ignore: Unused imports
-import * as application$0 from "../../github.com/wailsapp/wails/v3/pkg/application/models.js";
-
 // eslint-disable-next-line @typescript-eslint/ban-ts-comment
 // @ts-ignore: Unused imports
 import * as $models from "./models.js";
@@ -60,10 +56,10 @@ export function RestoreBuiltinPricing(): $CancellablePromise<$models.ModelSyncSt
 }
 
 /**
- * SetApp 注入 Wails App 引用,用于向前端广播同步事件。
+ * SetApp 注入桌面外壳引用,用于向前端广播同步事件。
  * 注册为 Wails 服务后该方法可被前端 RPC 调用，传 nil 会让同步完成事件广播失效，忽略之。
  */
-export function SetApp(app: application$0.App | null): $CancellablePromise<void> {
+export function SetApp(app: any): $CancellablePromise<void> {
     return $Call.ByID(1084458757, app);
 }
 
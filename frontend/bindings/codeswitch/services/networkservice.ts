@@ -23,6 +23,23 @@ export function ConfigureWSLClients(targets: $models.TargetCli): $CancellablePro
     });
 }
 
+/**
+ * CreateClientToken 生成新令牌；expiresAt 为 RFC3339 时间，空为永不过期。
+ * 第一个令牌自动设为 CLI 注入令牌
+ */
+export function CreateClientToken(name: string, platforms: string[], expiresAt: string): $CancellablePromise<$models.ClientToken | null> {
+    return $Call.ByID(3344892562, name, platforms, expiresAt).then(($result: any) => {
+        return $$createType6($result);
+    });
+}
+
+/**
+ * DeleteClientToken 删除令牌；删除 CLI 注入令牌时改用剩余的第一个，删光则自动关闭认证
+ */
+export function DeleteClientToken(name: string): $CancellablePromise<void> {
+    return $Call.ByID(1575305075, name);
+}
+
 /**
  * DetectWSL 检测 WSL 状态
  */
@@ -32,6 +49,24 @@ export function DetectWSL(): $CancellablePromise<$models.WSLDetection> {
     });
 }
 
+/**
+ * GetAdminAPIConfig 返回管理 API 配置（含令牌，供设置页复制）
+ */
+export function GetAdminAPIConfig(): $CancellablePromise<$models.AdminAPIConfig> {
+    return $Call.ByID(3488831069).then(($result: any) => {
+        return $$createType8($result);
+    });
+}
+
+/**
+ * GetClientAuthConfig 读取客户端认证配置
+ */
+export function GetClientAuthConfig(): $CancellablePromise<$models.ClientAuthConfig> {
+    return $Call.ByID(3087654053).then(($result: any) => {
+        return $$createType7($result);
+    });
+}
+
 /**
  * GetNetworkSettings 获取网络设置
  */
@@ -65,6 +100,15 @@ export function ReadWSLResolveConf(distro: string): $CancellablePromise<string>
     return $Call.ByID(1117286778, distro);
 }
 
+/**
+ * RegenerateAdminToken 重新生成管理令牌，旧令牌立即失效
+ */
+export function RegenerateAdminToken(): $CancellablePromise<$models.AdminAPIConfig> {
+    return $Call.ByID(4065395060).then(($result: any) => {
+        return $$createType8($result);
+    });
+}
+
 /**
  * SaveNetworkSettings 保存网络设置
  */
@@ -72,9 +116,52 @@ export function SaveNetworkSettings(settings: $models.NetworkSettings): $Cancell
     return $Call.ByID(624586330, settings);
 }
 
+/**
+ * SetAdminAPIEnabled 开启或关闭管理 API；首次开启时生成令牌
+ */
+export function SetAdminAPIEnabled(enabled: boolean): $CancellablePromise<$models.AdminAPIConfig> {
+    return $Call.ByID(2902154032, enabled).then(($result: any) => {
+        return $$createType8($result);
+    });
+}
+
+/**
+ * SetCLIClientToken 指定注入 CLI 配置的令牌
+ */
+export function SetCLIClientToken(name: string): $CancellablePromise<void> {
+    return $Call.ByID(1440900120, name);
+}
+
+/**
+ * SetClientAuthEnabled 开关客户端认证
+ */
+export function SetClientAuthEnabled(enabled: boolean): $CancellablePromise<void> {
+    return $Call.ByID(4002383888, enabled);
+}
+
+/**
+ * SetClientQuota 设置客户端每日配额（client 为 * 即默认配额）；两项均为 0 时删除该配额
+ */
+export function SetClientQuota(client: string, dailyTokens: number, dailyCost: number): $CancellablePromise<void> {
+    return $Call.ByID(6147991, client, dailyTokens, dailyCost);
+}
+
+/**
+ * SetMetricsEnabled 开启或关闭 /metrics；首次开启时生成令牌
+ */
+export function SetMetricsEnabled(enabled: boolean): $CancellablePromise<$models.AdminAPIConfig> {
+    return $Call.ByID(3925417124, enabled).then(($result: any) => {
+        return $$createType8($result);
+    });
+}
+
 // Private type creation functions
 const $$createType0 = $models.ConfigureResult.createFrom;
 const $$createType1 = $models.WSLDetection.createFrom;
 const $$createType2 = $models.NetworkSettings.createFrom;
 const $$createType3 = $Create.Map($Create.Any, $Create.Any);
 const $$createType4 = $Create.Map($Create.Any, $$createType3);
+const $$createType5 = $models.ClientToken.createFrom;
+const $$createType6 = $Create.Nullable($$createType5);
+const $$createType7 = $models.ClientAuthConfig.createFrom;
+const $$createType8 = $models.AdminAPIConfig.createFrom;
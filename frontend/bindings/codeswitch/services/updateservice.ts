@@ -10,10 +10,6 @@
 // @ts-ignore: Unused imports
 import { Call as $Call, CancellablePromise as $CancellablePromise, Create as $Create } from "@wailsio/runtime";
 
-// eslint-disable-next-line @typescript-eslint/ban-ts-comment
-// @ts-ignore: Unused imports
-import * as application$0 from "../../github.com/wailsapp/wails/v3/pkg/application/models.js";
-
 // eslint-disable-next-line @typescript-eslint/ban-ts-comment
 // @ts-ignore: Unused imports
 import * as $models from "./models.js";
@@ -73,10 +69,10 @@ export function RequestRestart(): $CancellablePromise<void> {
 }
 
 /**
- * SetApp 设置 Wails App 引用
+ * SetApp 设置桌面外壳引用
  * 注册为 Wails 服务后该方法可被前端 RPC 调用，传 nil 会让更新事件广播失效，忽略之。
  */
-export function SetApp(app: application$0.App | null): $CancellablePromise<void> {
+export function SetApp(app: any): $CancellablePromise<void> {
     return $Call.ByID(2055329212, app);
 }
 
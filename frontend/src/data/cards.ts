@@ -18,8 +18,24 @@ export type AutomationCard = {
   apiEndpoint?: string
   // 备用 API 地址（最多 4 个）：主地址失败时同一请求内按序兜底
   fallbackApiUrls?: string[]
+  // 额外 API 密钥（最多 16 个）：与主密钥组成密钥池，被拒/限流/额度耗尽时同一请求内换下一把
+  extraApiKeys?: string[]
+  // 密钥选择策略：fill_first（默认，用尽一把再换）/ round_robin（轮询）
+  keySelection?: string
   // 最大并发请求数（0=不限，仅代理转发，单进程内）
   maxConcurrency?: number
+  // 每分钟请求数 / token 数（输入+输出）上限（0=不限），超限时与并发满载一样跳过
+  rpmLimit?: number
+  tpmLimit?: number
+  // 消费上限（USD，0=不限）与统计周期 daily/weekly/monthly，达到后退出调度直到周期重置
+  spendCap?: number
+  spendCapPeriod?: string
+  // 负载权重（0=默认 1，开启轮询时同 Level 内平滑加权轮询）
+  weight?: number
+  // 价格倍率（0=按官方价）：费用统计与"最低成本优先"按 官方价 × 倍率 计算
+  priceMultiplier?: number
+  // 按模型覆盖的价格倍率（键支持通配符，如 "claude-opus-*"）
+  modelPriceMultipliers?: Record<string, number>
   // CLI 配置：存储供应商关联的 CLI 可编辑配置
   cliConfig?: Record<string, any>
 
@@ -38,6 +54,9 @@ export type AutomationCard = {
   // 跳过上游 TLS 证书验证（仅该供应商，自签名/企业代理场景；存在中间人风险）
   insecureSkipVerify?: boolean
 
+  // 出站代理（仅该供应商）：http/https/socks5/socks5h，认证写在地址中；空为直连
+  proxyUrl?: string
+
   // 请求清理：启用后转发前移除非标准字段和请求头（黑名单模式）
   requestSanitizeEnabled?: boolean
   sanitizeConfig?: {
@@ -46,6 +65,15 @@ export type AutomationCard = {
     blockedBetaValues?: string[]
   }
 
+  // 请求改写：按声明序对请求头/请求体执行 set / delete / replace（与请求清理开关无关）
+  rewriteRules?: Array<{
+    target: 'header' | 'body'
+    op: 'set' | 'delete' | 'replace'
+    path: string            // 请求头名或 JSON 路径（如 metadata.user_id）
+    value?: string          // 新值；body 为 JSON 字面量
+    from?: string           // replace 的匹配条件（可选）
+  }>
+
   // === 旧连通性字段（已废弃，仅用于兼容旧数据） ===
   /** @deprecated 已迁移到 availabilityMonitorEnabled */
   connectivityCheck?: boolean
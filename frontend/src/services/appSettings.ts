@@ -12,6 +12,7 @@ export type AppSettings = {
   budget_show_countdown: boolean
   budget_show_forecast: boolean
   budget_forecast_method: string
+  budget_hard_stop: boolean // 预算用尽后代理直接拒绝请求（429），直到周期重置
   budget_total_codex: number
   budget_used_adjustment_codex: number
   budget_cycle_enabled_codex: boolean
@@ -21,13 +22,28 @@ export type AppSettings = {
   budget_show_countdown_codex: boolean
   budget_show_forecast_codex: boolean
   budget_forecast_method_codex: string
+  budget_hard_stop_codex: boolean
   auto_start: boolean
   auto_update: boolean
   auto_sync_models: boolean // 模型/价格数据自动同步开关
   auto_connectivity_test: boolean
   enable_switch_notify: boolean // 供应商切换通知开关
   enable_round_robin: boolean   // 同 Level 轮询负载均衡开关
+  enable_latency_routing: boolean // 同 Level 最快优先（按实测延迟排序，开启后取代轮询）
+  latency_explore_percent: number // 最快优先模式下的探测比例（%）
+  latency_routing_levels: number[] // 最快优先生效的 Level，留空表示所有 Level
+  enable_cost_routing: boolean // 同 Level 最低成本优先（按预估费用 × 价格倍率排序，优先于最快优先与轮询）
+  enable_hedged_requests: boolean // 对冲请求：首响超过 hedge_delay_ms 时并发试同 Level 下一个供应商（仅流式）
+  hedge_delay_ms: number // 对冲触发延迟（毫秒）
+  enable_sticky_routing: boolean // 会话粘性：同一会话优先发往上次成功的供应商（保护 prompt cache 命中）
+  sticky_ttl_minutes: number // 会话粘性有效期（分钟）
+  enable_cache_heartbeat: boolean // 缓存保活：会话闲置时补发最小请求，避免 prompt cache 过期
+  cache_heartbeat_max: number // 每个会话最多补发的心跳次数
   enable_tray_popup: boolean    // 托盘弹窗开关（仅 macOS 托盘生效；关→开需重启）
+  enable_tracing: boolean // 请求追踪：每个转发请求生成一条 trace
+  tracing_exporter: 'otlp' | 'file' // 追踪导出方式：OTLP/HTTP 或 JSON Lines 文件
+  tracing_endpoint: string // OTLP/HTTP 接收地址
+  tracing_file: string // JSON Lines 文件路径（空为 ~/.code-switch/traces.jsonl）
 }
 
 const DEFAULT_SETTINGS: AppSettings = {
@@ -42,6 +58,7 @@ const DEFAULT_SETTINGS: AppSettings = {
   budget_show_countdown: false,
   budget_show_forecast: false,
   budget_forecast_method: 'cycle',
+  budget_hard_stop: false,
   budget_total_codex: 0,
   budget_used_adjustment_codex: 0,
   budget_cycle_enabled_codex: false,
@@ -51,6 +68,7 @@ const DEFAULT_SETTINGS: AppSettings = {
   budget_show_countdown_codex: false,
   budget_show_forecast_codex: false,
   budget_forecast_method_codex: 'cycle',
+  budget_hard_stop_codex: false,
   auto_start: false,
   auto_update: true,
   auto_sync_models: true, // 默认开启模型价格自动同步
@@ -58,7 +76,21 @@ const DEFAULT_SETTINGS: AppSettings = {
 
   enable_switch_notify: true,  // 默认开启
   enable_round_robin: false,   // 默认关闭轮询
+  enable_latency_routing: false, // 默认关闭最快优先
+  latency_explore_percent: 5,
+  latency_routing_levels: [],
+  enable_cost_routing: false, // 默认关闭最低成本优先
+  enable_hedged_requests: false, // 默认关闭对冲请求
+  hedge_delay_ms: 5000,
+  enable_sticky_routing: false, // 默认关闭会话粘性
+  sticky_ttl_minutes: 60,
+  enable_cache_heartbeat: false, // 默认关闭缓存保活
+  cache_heartbeat_max: 6,
   enable_tray_popup: true,     // 默认开启托盘弹窗
+  enable_tracing: false, // 默认关闭请求追踪
+  tracing_exporter: 'file',
+  tracing_endpoint: 'http://127.0.0.1:4318/v1/traces',
+  tracing_file: '',
 }
 
 export const fetchAppSettings = async (): Promise<AppSettings> => {
@@ -1,6 +1,6 @@
 import { Call } from '@wailsio/runtime'
 
-export type LogPlatform = 'claude' | 'codex' | 'gemini'
+export type LogPlatform = 'claude' | 'codex' | 'gemini' | 'openai'
 
 export type RequestLog = {
   id: number
@@ -25,6 +25,10 @@ export type RequestLog = {
   ephemeral_1h_cost?: number
   has_pricing?: boolean
   has_capture?: boolean
+  hedged?: boolean // 对冲竞速中落败被取消的尝试
+  session_key?: string // 会话粘性识别出的会话标识（哈希）
+  sticky_hit?: boolean // 本次尝试命中会话粘性
+  client_name?: string // 客户端标识（访问令牌名或 X-Code-Switch-Client 自报名）
 }
 
 // 抓包详情：仅当抓包模式开启时该行才有内容，按需单独拉取
@@ -123,6 +127,16 @@ export const fetchProviderDailyStats = async (
   return Call.ByName('codeswitch/services.LogService.ProviderDailyStats', platform)
 }
 
+export type ClientDailyStat = Omit<ProviderDailyStat, 'provider'> & {
+  client: string
+}
+
+export const fetchClientDailyStats = async (
+  platform: LogPlatform | '' = '',
+): Promise<ClientDailyStat[]> => {
+  return Call.ByName('codeswitch/services.LogService.ClientDailyStats', platform)
+}
+
 export type HeatmapStat = {
   day: string
   total_requests: number
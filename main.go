@@ -168,6 +168,7 @@ func main() {
 	speedTestService := services.NewSpeedTestService()
 	connectivityTestService := services.NewConnectivityTestService(providerService, blacklistService, settingsService, defaultModelPolicy)
 	healthCheckService := services.NewHealthCheckService(providerService, blacklistService, settingsService, defaultModelPolicy)
+	providerRelay.SetHealthCheckService(healthCheckService)
 	// 初始化健康检查数据库表
 	if err := healthCheckService.Start(); err != nil {
 		log.Fatalf("初始化健康检查服务失败: %v", err)
@@ -188,64 +189,13 @@ func main() {
 
 	// 启动黑名单自动恢复定时器（每分钟检查一次）
 	blacklistStopChan := make(chan struct{})
-	services.SafeGo("blacklist-recover-timer", func() {
-		ticker := time.NewTicker(1 * time.Minute)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				// 逐次兜底：单次恢复 panic 只丢这一轮，定时器继续存活
-				func() {
-					defer services.RecoverAndLog("blacklist-auto-recover")
-					if err := blacklistService.AutoRecoverExpired(); err != nil {
-						log.Printf("自动恢复黑名单失败: %v", err)
-					}
-				}()
-			case <-blacklistStopChan:
-				log.Println("✅ 黑名单定时器已停止")
-				return
-			}
-		}
-	})
+	services.StartBlacklistRecoverTimer(blacklistService, blacklistStopChan)
 
 	// 根据应用设置决定是否启动可用性监控（复用旧的 auto_connectivity_test 字段）。
 	// 延迟期间应用可能已经退出，必须能被 OnShutdown 取消，
 	// 否则巡检会在 StopBackgroundPolling 之后被重新拉起。
 	availabilityStopChan := make(chan struct{})
-	services.SafeGo("availability-bootstrap", func() {
-		select {
-		case <-time.After(3 * time.Second): // 延迟3秒，等待应用初始化
-		case <-availabilityStopChan:
-			return
-		}
-
-		settings, err := appSettings.GetAppSettings()
-
-		// 默认启用自动监控（保持开箱即用）
-		autoEnabled := true
-		if err != nil {
-			log.Printf("读取应用设置失败（使用默认值）: %v", err)
-		} else {
-			// 读取成功，使用配置值
-			autoEnabled = settings.AutoConnectivityTest
-		}
-
-		// 应用可能在读配置期间进入关停流程，再次确认后才启动巡检
-		select {
-		case <-availabilityStopChan:
-			return
-		default:
-		}
-
-		// 旧的 AutoConnectivityTest 字段现在控制可用性监控
-		if autoEnabled {
-			healthCheckService.SetAutoAvailabilityPolling(true)
-			log.Println("✅ 自动可用性监控已启动")
-		} else {
-			log.Println("ℹ️  自动可用性监控已禁用（可在设置中开启）")
-		}
-	})
+	services.StartAvailabilityBootstrap(appSettings, healthCheckService, availabilityStopChan)
 
 	//fmt.Println(clipboardService)
 	// Create a new Wails application by providing the necessary options.
@@ -301,16 +251,18 @@ func main() {
 		},
 	})
 
+	desktop := wailsDesktop{app: app}
+	services.SetDesktopApp(desktop)
 	// 设置 NotificationService 的 App 引用，用于发送事件到前端
-	notificationService.SetApp(app)
+	notificationService.SetApp(desktop)
 	// 窗口 impl 在 app.Run() 内部才创建；启动完成前禁止聚焦类调用
 	app.Event.OnApplicationEvent(events.Common.ApplicationStarted, func(*application.ApplicationEvent) {
 		appStarted.Store(true)
 	})
 	// 设置 UpdateService 的 App 引用，用于发送更新事件
-	updateService.SetApp(app)
+	updateService.SetApp(desktop)
 	// 设置 ModelSyncService 的 App 引用，用于广播同步完成事件
-	modelSyncService.SetApp(app)
+	modelSyncService.SetApp(desktop)
 
 	// 代理放在 App 引用注入之后再启动：早于此的供应商切换事件会因为 app 还是 nil 而丢失。
 	// 端口被占用（多开、其它程序占用 18100）时不能直接退出进程——GUI 构建下没有控制台，
@@ -367,19 +319,7 @@ func main() {
 		time.Sleep(500 * time.Millisecond)
 
 		// 4. 优雅关闭数据库写入队列（10秒超时，双队列架构）
-		if err := services.ShutdownGlobalDBQueue(10 * time.Second); err != nil {
-			log.Printf("⚠️ 队列关闭超时: %v", err)
-		} else {
-			// 单次队列统计
-			stats1 := services.GetGlobalDBQueueStats()
-			log.Printf("✅ 单次队列已关闭，统计：成功=%d 失败=%d 平均延迟=%.2fms",
-				stats1.SuccessWrites, stats1.FailedWrites, stats1.AvgLatencyMs)
-
-			// 批量队列统计
-			stats2 := services.GetGlobalDBQueueLogsStats()
-			log.Printf("✅ 批量队列已关闭，统计：成功=%d 失败=%d 平均延迟=%.2fms（批均分） 批次=%d",
-				stats2.SuccessWrites, stats2.FailedWrites, stats2.AvgLatencyMs, stats2.BatchCommits)
-		}
+		services.ShutdownDBQueues()
 
 		log.Println("✅ 所有后台服务已停止")
 	})
@@ -0,0 +1,522 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 本地管理 API：代理监听器上的 /_admin/ 路由，供看板与自动化脚本读写供应商、
+// 黑名单、可用性结果、请求日志、统计与抓包会话，处理逻辑直接复用界面调用的同一批服务方法。
+// 默认关闭；开启时在本机生成管理令牌存于 ~/.code-switch/admin-api.json（0600），
+// 请求以 Authorization: Bearer <令牌> 或 X-Admin-Token 携带。关闭时所有路由返回 404。
+// 管理路由不受客户端访问令牌约束（两者令牌互不通用）。
+
+const (
+	adminAPIFile      = "admin-api.json"
+	adminAPIPrefix    = "/_admin/"
+	adminTokenPrefix  = "csa-"
+	adminTokenHeader  = "X-Admin-Token"
+	adminLogLimitMax  = 1000
+	adminCaptureLimit = 500
+)
+
+// AdminAPIConfig 管理 API 配置
+type AdminAPIConfig struct {
+	Enabled bool `json:"enabled"`
+	// MetricsEnabled 开启 /metrics（见 metrics.go），与管理 API 共用令牌
+	MetricsEnabled bool   `json:"metricsEnabled"`
+	Token          string `json:"token,omitempty"`
+}
+
+// generateAdminToken 生成随机管理令牌
+func generateAdminToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成管理令牌失败: %w", err)
+	}
+	return adminTokenPrefix + hex.EncodeToString(buf), nil
+}
+
+// ---------- 存储 ----------
+
+// adminAPIStore 配置文件的进程内缓存：按文件修改时间失效（与客户端令牌同一机制）
+type adminAPIStore struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	size    int64
+	cfg     *AdminAPIConfig
+}
+
+var adminAPI = &adminAPIStore{}
+
+func adminAPIPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("获取用户目录失败: %w", err)
+	}
+	return filepath.Join(home, appSettingsDir, adminAPIFile), nil
+}
+
+// load 返回当前配置（调用方不得修改返回值）；文件不存在视为未开启
+func (s *adminAPIStore) load() (*AdminAPIConfig, error) {
+	path, err := adminAPIPath()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loadLocked(path)
+}
+
+// loadLocked load 的实现，调用方须持有 s.mu
+func (s *adminAPIStore) loadLocked(path string) (*AdminAPIConfig, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.path, s.cfg = path, &AdminAPIConfig{}
+		s.modTime, s.size = time.Time{}, 0
+		return s.cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg != nil && s.path == path && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取管理 API 配置失败: %w", err)
+	}
+	cfg := &AdminAPIConfig{}
+	if len(strings.TrimSpace(string(data))) > 0 {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("解析管理 API 配置失败: %w", err)
+		}
+	}
+	s.path, s.cfg, s.modTime, s.size = path, cfg, info.ModTime(), info.Size()
+	return cfg, nil
+}
+
+// update 在最新配置的副本上执行 mutate 后落盘；管理 API 或指标开启时确保已有令牌。
+// 读-改-写全程持锁，并发更新不会互相覆盖
+func (s *adminAPIStore) update(mutate func(cfg *AdminAPIConfig) error) (*AdminAPIConfig, error) {
+	path, err := adminAPIPath()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, err := s.loadLocked(path)
+	if err != nil {
+		return nil, err
+	}
+	next := *current
+	if err := mutate(&next); err != nil {
+		return nil, err
+	}
+	if (next.Enabled || next.MetricsEnabled) && next.Token == "" {
+		if next.Token, err = generateAdminToken(); err != nil {
+			return nil, err
+		}
+	}
+	if next.Token != "" && len(next.Token) < 16 {
+		return nil, errors.New("管理令牌过短（至少 16 个字符）")
+	}
+	data, err := json.MarshalIndent(&next, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("序列化管理 API 配置失败: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("创建配置目录失败: %w", err)
+	}
+	// 文件含明文令牌，仅当前用户可读
+	if err := atomicWriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("写入管理 API 配置失败: %w", err)
+	}
+	s.cfg = nil
+	return &next, nil
+}
+
+// isAdminAPIPath 请求是否命中管理路由
+func isAdminAPIPath(path string) bool {
+	return strings.HasPrefix(path, adminAPIPrefix)
+}
+
+// usesAdminToken 请求是否由管理令牌认证（管理路由与 /metrics）
+func usesAdminToken(path string) bool {
+	return isAdminAPIPath(path) || path == metricsPath
+}
+
+// adminCredentialOf 取请求携带的管理令牌
+func adminCredentialOf(r *http.Request) string {
+	if v := strings.TrimSpace(r.Header.Get(adminTokenHeader)); v != "" {
+		return v
+	}
+	v := strings.TrimSpace(r.Header.Get("Authorization"))
+	if len(v) > 7 && strings.EqualFold(v[:7], "bearer ") {
+		return strings.TrimSpace(v[7:])
+	}
+	return ""
+}
+
+// adminAuthMiddleware 未开启时 404（不暴露管理接口的存在），令牌不符 401。
+// 配置读取失败时拒绝服务
+func adminAuthMiddleware() gin.HandlerFunc {
+	return adminTokenMiddleware(func(cfg *AdminAPIConfig) bool { return cfg.Enabled })
+}
+
+// adminTokenMiddleware 按 enabled 判断路由是否开启，开启后校验管理令牌
+func adminTokenMiddleware(enabled func(cfg *AdminAPIConfig) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg, err := adminAPI.load()
+		if err != nil {
+			fmt.Printf("[AdminAPI] 读取管理 API 配置失败，拒绝请求: %v\n", err)
+			adminError(c, http.StatusServiceUnavailable, errors.New("管理 API 配置读取失败"))
+			return
+		}
+		if !enabled(cfg) || cfg.Token == "" {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(adminCredentialOf(c.Request)), []byte(cfg.Token)) != 1 {
+			adminError(c, http.StatusUnauthorized, errors.New("缺少或无效的管理令牌"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// adminError 以 {"error": "..."} 结束请求
+func adminError(c *gin.Context, status int, err error) {
+	c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+}
+
+// adminRespond 成功返回 data，失败按 500 返回错误
+func adminRespond(c *gin.Context, data any, err error) {
+	if err != nil {
+		adminError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, data)
+}
+
+// adminBadRequest 请求参数或业务校验失败统一按 400
+func adminBadRequest(c *gin.Context, err error) {
+	adminError(c, http.StatusBadRequest, err)
+}
+
+// adminIntQuery 读取整数查询参数，缺省为 def
+func adminIntQuery(c *gin.Context, name string, def int64) (int64, error) {
+	raw := strings.TrimSpace(c.Query(name))
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("参数 %s 必须是整数", name)
+	}
+	return v, nil
+}
+
+// adminIDParam 读取路径中的整数 ID
+func adminIDParam(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		adminBadRequest(c, fmt.Errorf("无效的 ID: %s", c.Param("id")))
+		return 0, false
+	}
+	return id, true
+}
+
+// registerAdminRoutes 注册 /_admin/ 路由
+func (prs *ProviderRelayService) registerAdminRoutes(router gin.IRouter) {
+	admin := router.Group(strings.TrimSuffix(adminAPIPrefix, "/"), adminAuthMiddleware())
+
+	// 供应商（claude / codex / openai / custom:<工具ID>）：PUT 整表替换，与界面保存同一路径
+	admin.GET("/providers/:platform", prs.adminListProviders)
+	admin.PUT("/providers/:platform", prs.adminSaveProviders)
+
+	// Gemini 供应商（与界面共用同一 GeminiService 实例，改动即时生效）
+	if prs.geminiService != nil {
+		admin.GET("/gemini/providers", prs.adminListGeminiProviders)
+		admin.POST("/gemini/providers", prs.adminAddGeminiProvider)
+		admin.PUT("/gemini/providers/:id", prs.adminUpdateGeminiProvider)
+		admin.DELETE("/gemini/providers/:id", prs.adminDeleteGeminiProvider)
+		admin.POST("/gemini/reorder", prs.adminReorderGeminiProviders)
+	}
+
+	// 黑名单
+	admin.GET("/blacklist", prs.adminBlacklist)
+	admin.POST("/blacklist/unblock", prs.adminUnblock)
+
+	// 可用性监控最新结果
+	admin.GET("/health", prs.adminHealth)
+
+	// 请求日志与统计
+	admin.GET("/logs", prs.adminLogs)
+	admin.GET("/logs/:id", prs.adminLogDetail)
+	admin.GET("/stats", prs.adminStats)
+
+	// 抓包会话
+	admin.GET("/captures", prs.adminCaptureSessions)
+	admin.GET("/captures/:id/logs", prs.adminCaptureLogs)
+	admin.DELETE("/captures/:id", prs.adminDeleteCapture)
+}
+
+func (prs *ProviderRelayService) adminListProviders(c *gin.Context) {
+	providers, err := prs.providerService.LoadProviders(c.Param("platform"))
+	if err != nil {
+		adminBadRequest(c, err)
+		return
+	}
+	if providers == nil {
+		providers = []Provider{}
+	}
+	c.JSON(http.StatusOK, providers)
+}
+
+func (prs *ProviderRelayService) adminSaveProviders(c *gin.Context) {
+	var providers []Provider
+	if err := c.ShouldBindJSON(&providers); err != nil {
+		adminBadRequest(c, fmt.Errorf("请求体须为供应商数组: %w", err))
+		return
+	}
+	kind := c.Param("platform")
+	if err := prs.providerService.SaveProviders(kind, providers); err != nil {
+		adminBadRequest(c, err)
+		return
+	}
+	saved, err := prs.providerService.LoadProviders(kind)
+	adminRespond(c, saved, err)
+}
+
+func (prs *ProviderRelayService) adminListGeminiProviders(c *gin.Context) {
+	providers := prs.geminiService.GetProviders()
+	if providers == nil {
+		providers = []GeminiProvider{}
+	}
+	c.JSON(http.StatusOK, providers)
+}
+
+func (prs *ProviderRelayService) adminAddGeminiProvider(c *gin.Context) {
+	var provider GeminiProvider
+	if err := c.ShouldBindJSON(&provider); err != nil {
+		adminBadRequest(c, err)
+		return
+	}
+	if err := prs.geminiService.AddProvider(provider); err != nil {
+		adminBadRequest(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, prs.geminiService.GetProviders())
+}
+
+func (prs *ProviderRelayService) adminUpdateGeminiProvider(c *gin.Context) {
+	var provider GeminiProvider
+	if err := c.ShouldBindJSON(&provider); err != nil {
+		adminBadRequest(c, err)
+		return
+	}
+	provider.ID = c.Param("id")
+	if err := prs.geminiService.UpdateProvider(provider); err != nil {
+		adminBadRequest(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, prs.geminiService.GetProviders())
+}
+
+func (prs *ProviderRelayService) adminDeleteGeminiProvider(c *gin.Context) {
+	if err := prs.geminiService.DeleteProvider(c.Param("id")); err != nil {
+		adminBadRequest(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, prs.geminiService.GetProviders())
+}
+
+func (prs *ProviderRelayService) adminReorderGeminiProviders(c *gin.Context) {
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		adminBadRequest(c, err)
+		return
+	}
+	if err := prs.geminiService.ReorderProviders(body.IDs); err != nil {
+		adminBadRequest(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, prs.geminiService.GetProviders())
+}
+
+// adminBlacklist 未指定 platform 时返回全部平台
+func (prs *ProviderRelayService) adminBlacklist(c *gin.Context) {
+	platforms := blacklistPlatforms
+	if p := strings.TrimSpace(c.Query("platform")); p != "" {
+		platforms = []string{p}
+	}
+	statuses := []BlacklistStatus{}
+	for _, p := range platforms {
+		list, err := prs.blacklistService.GetBlacklistStatus(p)
+		if err != nil {
+			adminError(c, http.StatusInternalServerError, err)
+			return
+		}
+		statuses = append(statuses, list...)
+	}
+	c.JSON(http.StatusOK, statuses)
+}
+
+// adminUnblock 与主界面的"解除拉黑"相同：保留等级，重新开始降级计时
+func (prs *ProviderRelayService) adminUnblock(c *gin.Context) {
+	var body struct {
+		Platform string `json:"platform"`
+		Provider string `json:"provider"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Platform == "" || body.Provider == "" {
+		adminBadRequest(c, errors.New("需要 platform 与 provider"))
+		return
+	}
+	if err := prs.blacklistService.ManualUnblockAndReset(body.Platform, body.Provider); err != nil {
+		adminBadRequest(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// SetHealthCheckService 注入应用内的健康检查服务，管理 API 复用它读取检查结果
+func (prs *ProviderRelayService) SetHealthCheckService(hcs *HealthCheckService) {
+	prs.healthCheckService = hcs
+}
+
+func (prs *ProviderRelayService) adminHealth(c *gin.Context) {
+	if prs.healthCheckService == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "健康检查服务未启用"})
+		return
+	}
+	results, err := prs.healthCheckService.GetLatestResults()
+	adminRespond(c, results, err)
+}
+
+func (prs *ProviderRelayService) adminLogs(c *gin.Context) {
+	limit, err := adminIntQuery(c, "limit", 100)
+	if err != nil {
+		adminBadRequest(c, err)
+		return
+	}
+	logs, err := NewLogService().ListRequestLogs(c.Query("platform"), c.Query("provider"), min(int(limit), adminLogLimitMax))
+	adminRespond(c, logs, err)
+}
+
+func (prs *ProviderRelayService) adminLogDetail(c *gin.Context) {
+	id, ok := adminIDParam(c)
+	if !ok {
+		return
+	}
+	detail, err := NewLogService().GetRequestLogDetail(id)
+	if err != nil {
+		adminError(c, http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, detail)
+}
+
+func (prs *ProviderRelayService) adminStats(c *gin.Context) {
+	stats, err := NewLogService().StatsSince(c.Query("platform"))
+	adminRespond(c, stats, err)
+}
+
+func (prs *ProviderRelayService) adminCaptureSessions(c *gin.Context) {
+	sessions, err := prs.ListCaptureSessions()
+	adminRespond(c, sessions, err)
+}
+
+func (prs *ProviderRelayService) adminCaptureLogs(c *gin.Context) {
+	id, ok := adminIDParam(c)
+	if !ok {
+		return
+	}
+	sinceID, err1 := adminIntQuery(c, "since_id", 0)
+	beforeID, err2 := adminIntQuery(c, "before_id", 0)
+	limit, err3 := adminIntQuery(c, "limit", 100)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		adminBadRequest(c, err)
+		return
+	}
+	rows, err := prs.GetCaptureSessionLogs(id, sinceID, beforeID, min(int(limit), adminCaptureLimit))
+	adminRespond(c, rows, err)
+}
+
+func (prs *ProviderRelayService) adminDeleteCapture(c *gin.Context) {
+	id, ok := adminIDParam(c)
+	if !ok {
+		return
+	}
+	deleted, err := prs.DeleteCaptureSession(id)
+	adminRespond(c, gin.H{"deleted": deleted}, err)
+}
+
+// ---------- 设置页 ----------
+
+// GetAdminAPIConfig 返回管理 API 配置（含令牌，供设置页复制）
+func (ns *NetworkService) GetAdminAPIConfig() (AdminAPIConfig, error) {
+	cfg, err := adminAPI.load()
+	if err != nil {
+		return AdminAPIConfig{}, err
+	}
+	return *cfg, nil
+}
+
+// SetAdminAPIEnabled 开启或关闭管理 API；首次开启时生成令牌
+func (ns *NetworkService) SetAdminAPIEnabled(enabled bool) (AdminAPIConfig, error) {
+	cfg, err := adminAPI.update(func(cfg *AdminAPIConfig) error {
+		cfg.Enabled = enabled
+		return nil
+	})
+	if err != nil {
+		return AdminAPIConfig{}, err
+	}
+	fmt.Printf("[AdminAPI] 管理 API: enabled=%v\n", cfg.Enabled)
+	return *cfg, nil
+}
+
+// SetMetricsEnabled 开启或关闭 /metrics；首次开启时生成令牌
+func (ns *NetworkService) SetMetricsEnabled(enabled bool) (AdminAPIConfig, error) {
+	cfg, err := adminAPI.update(func(cfg *AdminAPIConfig) error {
+		cfg.MetricsEnabled = enabled
+		return nil
+	})
+	if err != nil {
+		return AdminAPIConfig{}, err
+	}
+	fmt.Printf("[Metrics] /metrics: enabled=%v\n", cfg.MetricsEnabled)
+	return *cfg, nil
+}
+
+// RegenerateAdminToken 重新生成管理令牌，旧令牌立即失效
+func (ns *NetworkService) RegenerateAdminToken() (AdminAPIConfig, error) {
+	cfg, err := adminAPI.update(func(cfg *AdminAPIConfig) error {
+		token, err := generateAdminToken()
+		if err != nil {
+			return err
+		}
+		cfg.Token = token
+		return nil
+	})
+	if err != nil {
+		return AdminAPIConfig{}, err
+	}
+	return *cfg, nil
+}
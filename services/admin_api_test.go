@@ -0,0 +1,211 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// 管理 API 默认关闭（404）；开启后校验管理令牌，且不受客户端访问令牌约束
+func TestAdminAPIAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	home := setupRenameTestEnv(t)
+	router := gin.New()
+	newTestRelayService(NewProviderService()).registerRoutes(router)
+	send := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send("GET", "/_admin/providers/claude", "", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("默认关闭应 404: %d", w.Code)
+	}
+
+	ns := &NetworkService{}
+	cfg, err := ns.SetAdminAPIEnabled(true)
+	if err != nil {
+		t.Fatalf("开启管理 API 失败: %v", err)
+	}
+	if !strings.HasPrefix(cfg.Token, adminTokenPrefix) {
+		t.Fatalf("开启时应生成令牌: %q", cfg.Token)
+	}
+	if info, err := os.Stat(filepath.Join(home, appSettingsDir, adminAPIFile)); err != nil || (info.Mode().Perm()&0o077 != 0 && os.PathSeparator == '/') {
+		t.Errorf("令牌文件应仅属主可读: %v %v", info, err)
+	}
+
+	if w := send("GET", "/_admin/providers/claude", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("缺少令牌应 401: %d", w.Code)
+	}
+	if w := send("GET", "/_admin/providers/claude", "csa-wrong-token-value", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("错误令牌应 401: %d", w.Code)
+	}
+	req := httptest.NewRequest("GET", "/_admin/providers/claude", nil)
+	req.Header.Set(adminTokenHeader, cfg.Token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("X-Admin-Token 应通过: %d %s", w.Code, w.Body.String())
+	}
+
+	// 客户端认证开启后管理路由仍只认管理令牌
+	if _, err := ns.CreateClientToken("laptop", nil, ""); err != nil {
+		t.Fatalf("创建客户端令牌失败: %v", err)
+	}
+	if err := ns.SetClientAuthEnabled(true); err != nil {
+		t.Fatalf("开启客户端认证失败: %v", err)
+	}
+	if w := send("GET", "/_admin/providers/claude", cfg.Token, ""); w.Code != http.StatusOK {
+		t.Errorf("管理令牌不应被客户端认证拦截: %d %s", w.Code, w.Body.String())
+	}
+
+	old := cfg.Token
+	if cfg, err = ns.RegenerateAdminToken(); err != nil || cfg.Token == old || !cfg.Enabled {
+		t.Fatalf("重新生成令牌失败: %+v %v", cfg, err)
+	}
+	if w := send("GET", "/_admin/stats", old, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("旧令牌应立即失效: %d", w.Code)
+	}
+	if _, err := ns.SetAdminAPIEnabled(false); err != nil {
+		t.Fatalf("关闭管理 API 失败: %v", err)
+	}
+	if w := send("GET", "/_admin/stats", cfg.Token, ""); w.Code != http.StatusNotFound {
+		t.Errorf("关闭后应 404: %d", w.Code)
+	}
+}
+
+func TestAdminAPIEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupBlacklistFixEnv(t)
+	db, _ := xdb.DB("default")
+	if err := ensureRequestLogTableWithDB(db); err != nil {
+		t.Fatalf("建表失败: %v", err)
+	}
+	now := time.Now()
+	if _, err := db.Exec(`INSERT INTO request_log (platform, model, provider, http_code, input_tokens, output_tokens, created_at) VALUES ('claude', 'claude-sonnet-4-5', 'p1', 200, 10, 5, ?)`,
+		now.UTC().Format(timeLayout)); err != nil {
+		t.Fatalf("写入请求日志失败: %v", err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO provider_blacklist (platform, provider_name, failure_count, blacklisted_at, blacklisted_until, last_failure_at, blacklist_level)
+		VALUES ('claude', 'p1', 3, ?, ?, ?, 1)
+	`, now, now.Add(10*time.Minute), now); err != nil {
+		t.Fatalf("seed 失败: %v", err)
+	}
+
+	cfg, err := (&NetworkService{}).SetAdminAPIEnabled(true)
+	if err != nil {
+		t.Fatalf("开启管理 API 失败: %v", err)
+	}
+	ps := NewProviderService()
+	relay := newTestRelayService(ps)
+	relay.geminiService = NewGeminiService("127.0.0.1:18100", nil)
+	relay.SetHealthCheckService(NewHealthCheckService(ps, relay.blacklistService, nil, nil))
+	router := gin.New()
+	relay.registerRoutes(router)
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+cfg.Token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("PUT", "/_admin/providers/claude", `[{"id":1,"name":"p1","apiUrl":"https://a.example.com","apiKey":"k","enabled":true}]`)
+	if w.Code != http.StatusOK || gjson.Get(w.Body.String(), "0.name").String() != "p1" {
+		t.Fatalf("保存供应商失败: %d %s", w.Code, w.Body.String())
+	}
+	if w := send("PUT", "/_admin/providers/claude", `[{"id":1,"name":"p1","proxyUrl":"ftp://x:21"}]`); w.Code != http.StatusBadRequest {
+		t.Errorf("应沿用保存校验: %d", w.Code)
+	}
+	if w := send("PUT", "/_admin/providers/claude", `{"id":1}`); w.Code != http.StatusBadRequest {
+		t.Errorf("非数组请求体应 400: %d", w.Code)
+	}
+	if providers, _ := ps.LoadProviders("claude"); len(providers) != 1 || providers[0].APIURL != "https://a.example.com" {
+		t.Errorf("磁盘配置不符: %+v", providers)
+	}
+
+	if w := send("POST", "/_admin/gemini/providers", `{"name":"g1","baseUrl":"https://g.example.com","apiKey":"k","enabled":true}`); w.Code != http.StatusCreated {
+		t.Fatalf("添加 gemini 供应商失败: %d %s", w.Code, w.Body.String())
+	}
+	id := relay.geminiService.GetProviders()[0].ID
+	if w := send("PUT", "/_admin/gemini/providers/"+id, `{"name":"g1","baseUrl":"https://g2.example.com","enabled":false}`); w.Code != http.StatusOK {
+		t.Fatalf("更新 gemini 供应商失败: %d %s", w.Code, w.Body.String())
+	}
+	if p := relay.geminiService.GetProviders()[0]; p.BaseURL != "https://g2.example.com" || p.Enabled || p.APIKey != "k" {
+		t.Errorf("gemini 更新不符（未提供的 API Key 应保留）: %+v", p)
+	}
+	if w := send("DELETE", "/_admin/gemini/providers/"+id, ""); w.Code != http.StatusOK || len(relay.geminiService.GetProviders()) != 0 {
+		t.Errorf("删除 gemini 供应商失败: %d", w.Code)
+	}
+
+	w = send("GET", "/_admin/blacklist?platform=claude", "")
+	if w.Code != http.StatusOK || !gjson.Get(w.Body.String(), "0.isBlacklisted").Bool() {
+		t.Fatalf("黑名单查询不符: %d %s", w.Code, w.Body.String())
+	}
+	if w := send("POST", "/_admin/blacklist/unblock", `{"platform":"claude"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("缺少 provider 应 400: %d", w.Code)
+	}
+	if w := send("POST", "/_admin/blacklist/unblock", `{"platform":"claude","provider":"p1"}`); w.Code != http.StatusOK {
+		t.Fatalf("解除拉黑失败: %d %s", w.Code, w.Body.String())
+	}
+
+	if w := send("GET", "/_admin/health", ""); w.Code != http.StatusOK || gjson.Get(w.Body.String(), "claude.0.providerName").String() != "p1" {
+		t.Errorf("可用性结果不符: %d %s", w.Code, w.Body.String())
+	}
+	w = send("GET", "/_admin/logs?platform=claude&limit=10", "")
+	if w.Code != http.StatusOK || gjson.Get(w.Body.String(), "#").Int() != 1 {
+		t.Errorf("日志查询不符: %d %s", w.Code, w.Body.String())
+	}
+	if w := send("GET", "/_admin/logs?limit=abc", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("非法 limit 应 400: %d", w.Code)
+	}
+	if w := send("GET", "/_admin/stats?platform=claude", ""); w.Code != http.StatusOK || gjson.Get(w.Body.String(), "total_requests").Int() != 1 {
+		t.Errorf("统计不符: %d %s", w.Code, w.Body.String())
+	}
+	if w := send("GET", "/_admin/captures/x/logs", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("非法会话 ID 应 400: %d", w.Code)
+	}
+}
+
+// 并发开启时读-改-写须串行：各方拿到的令牌一致，不会互相覆盖
+func TestAdminAPIStoreConcurrentUpdate(t *testing.T) {
+	setupRenameTestEnv(t)
+	var wg sync.WaitGroup
+	tokens := make([]string, 8)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cfg, err := adminAPI.update(func(cfg *AdminAPIConfig) error {
+				cfg.Enabled = true
+				return nil
+			})
+			if err != nil {
+				t.Errorf("更新失败: %v", err)
+				return
+			}
+			tokens[i] = cfg.Token
+		}(i)
+	}
+	wg.Wait()
+	for _, tok := range tokens[1:] {
+		if tok != tokens[0] {
+			t.Fatalf("并发更新生成了不同令牌: %v", tokens)
+		}
+	}
+}
@@ -29,6 +29,7 @@ type AppSettings struct {
 	BudgetShowCountdown  bool   `json:"budget_show_countdown"`
 	BudgetShowForecast   bool   `json:"budget_show_forecast"`
 	BudgetForecastMethod string `json:"budget_forecast_method"`
+	BudgetHardStop       bool   `json:"budget_hard_stop"` // 预算用尽后代理直接拒绝请求（429），直到周期重置
 	BudgetTotalCodex          float64 `json:"budget_total_codex"`
 	BudgetUsedAdjustmentCodex float64 `json:"budget_used_adjustment_codex"`
 	BudgetCycleEnabledCodex   bool   `json:"budget_cycle_enabled_codex"`
@@ -38,13 +39,28 @@ type AppSettings struct {
 	BudgetShowCountdownCodex  bool   `json:"budget_show_countdown_codex"`
 	BudgetShowForecastCodex   bool   `json:"budget_show_forecast_codex"`
 	BudgetForecastMethodCodex string `json:"budget_forecast_method_codex"`
+	BudgetHardStopCodex       bool   `json:"budget_hard_stop_codex"`
 	AutoStart            bool `json:"auto_start"`
 	AutoUpdate           bool `json:"auto_update"`
 	AutoSyncModels       bool `json:"auto_sync_models"`       // 模型/价格数据自动同步开关
 	AutoConnectivityTest bool `json:"auto_connectivity_test"`
 	EnableSwitchNotify   bool `json:"enable_switch_notify"`   // 供应商切换通知开关
 	EnableRoundRobin     bool `json:"enable_round_robin"`     // 同 Level 轮询负载均衡开关（默认关闭）
+	EnableLatencyRouting bool `json:"enable_latency_routing"` // 同 Level 最快优先（按实测延迟排序，开启后取代轮询）
+	LatencyExplorePercent int `json:"latency_explore_percent"` // 最快优先模式下的探测比例（%），让慢供应商的统计保持新鲜
+	LatencyRoutingLevels []int `json:"latency_routing_levels"` // 最快优先生效的 Level，留空表示所有 Level
+	EnableCostRouting    bool `json:"enable_cost_routing"`    // 同 Level 最低成本优先（按预估费用排序，开启后取代最快优先与轮询）
+	EnableHedging        bool `json:"enable_hedged_requests"` // 对冲请求：首响迟迟不来时并发试同 Level 下一个供应商（仅流式）
+	HedgeDelayMs         int  `json:"hedge_delay_ms"`         // 对冲触发延迟（毫秒），主请求超过该时长仍无响应头才发对冲
+	EnableStickyRouting  bool `json:"enable_sticky_routing"`  // 会话粘性：同一会话优先发往上次成功的供应商（保护 prompt cache 命中）
+	StickyTTLMinutes     int  `json:"sticky_ttl_minutes"`     // 会话粘性有效期（分钟），会话闲置超过该时长即解除绑定
+	EnableCacheHeartbeat bool `json:"enable_cache_heartbeat"` // Prompt Cache 保活：会话闲置时在 TTL 到期前补发 max_tokens=1 的心跳（仅 Claude）
+	CacheHeartbeatMax    int  `json:"cache_heartbeat_max"`    // 单个会话累计心跳次数上限
 	EnableTrayPopup      bool `json:"enable_tray_popup"`      // 托盘弹窗开关（仅 macOS 托盘生效；关→开需重启）
+	EnableTracing        bool   `json:"enable_tracing"`   // 请求追踪：每个转发请求生成一条 trace（选路/尝试/重试/等待/流转发）
+	TracingExporter      string `json:"tracing_exporter"` // 追踪导出方式：otlp（OTLP/HTTP）或 file（JSON Lines）
+	TracingEndpoint      string `json:"tracing_endpoint"` // OTLP/HTTP 接收地址
+	TracingFile          string `json:"tracing_file"`     // JSON Lines 文件路径（支持 ~/ 前缀）
 }
 
 type AppSettingsService struct {
@@ -168,6 +184,7 @@ func (as *AppSettingsService) defaultSettings() AppSettings {
 		BudgetShowCountdown:  false,
 		BudgetShowForecast:   false,
 		BudgetForecastMethod: "cycle",
+		BudgetHardStop:       false,
 		BudgetTotalCodex:          0,
 		BudgetUsedAdjustmentCodex: 0,
 		BudgetCycleEnabledCodex:   false,
@@ -177,13 +194,27 @@ func (as *AppSettingsService) defaultSettings() AppSettings {
 		BudgetShowCountdownCodex:  false,
 		BudgetShowForecastCodex:   false,
 		BudgetForecastMethodCodex: "cycle",
+		BudgetHardStopCodex:       false,
 		AutoStart:            autoStartEnabled,
 		AutoUpdate:           true,  // 默认开启自动更新
 		AutoSyncModels:       true,  // 默认开启模型价格自动同步
 		AutoConnectivityTest: true,  // 默认开启自动可用性监控（开箱即用）
 		EnableSwitchNotify:   true,  // 默认开启切换通知
 		EnableRoundRobin:     false, // 默认关闭轮询（使用顺序降级）
+		EnableLatencyRouting: false, // 默认关闭最快优先
+		LatencyExplorePercent: defaultLatencyExplorePercent,
+		EnableCostRouting:    false, // 默认关闭最低成本优先
+		EnableHedging:        false, // 默认关闭对冲请求
+		HedgeDelayMs:         defaultHedgeDelayMs,
+		EnableStickyRouting:  false, // 默认关闭会话粘性
+		StickyTTLMinutes:     defaultStickyTTLMinutes,
+		EnableCacheHeartbeat: false, // 默认关闭缓存保活心跳（会产生额外费用）
+		CacheHeartbeatMax:    defaultCacheHeartbeatMax,
 		EnableTrayPopup:      true,  // 默认开启托盘弹窗（保持既有行为）
+		EnableTracing:        false, // 默认关闭请求追踪
+		TracingExporter:      tracingExporterFile,
+		TracingEndpoint:      defaultTracingEndpoint,
+		TracingFile:          "",    // 空表示 ~/.code-switch/traces.jsonl
 	}
 }
 
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"log"
+	"time"
+)
+
+// 桌面应用与无界面守护进程共用的后台任务与关停步骤
+
+// StartBlacklistRecoverTimer 启动黑名单自动恢复定时器（每分钟检查一次），关闭 stop 即停止
+func StartBlacklistRecoverTimer(blacklistService *BlacklistService, stop <-chan struct{}) {
+	SafeGo("blacklist-recover-timer", func() {
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				// 逐次兜底：单次恢复 panic 只丢这一轮，定时器继续存活
+				func() {
+					defer RecoverAndLog("blacklist-auto-recover")
+					if err := blacklistService.AutoRecoverExpired(); err != nil {
+						log.Printf("自动恢复黑名单失败: %v", err)
+					}
+				}()
+			case <-stop:
+				log.Println("✅ 黑名单定时器已停止")
+				return
+			}
+		}
+	})
+}
+
+// StartAvailabilityBootstrap 延迟 3 秒后按应用设置决定是否启动可用性监控；
+// 延迟或读配置期间关闭 stop 则放弃启动
+func StartAvailabilityBootstrap(appSettings *AppSettingsService, healthCheckService *HealthCheckService, stop <-chan struct{}) {
+	SafeGo("availability-bootstrap", func() {
+		select {
+		case <-time.After(3 * time.Second): // 延迟3秒，等待应用初始化
+		case <-stop:
+			return
+		}
+
+		settings, err := appSettings.GetAppSettings()
+
+		// 默认启用自动监控（保持开箱即用）
+		autoEnabled := true
+		if err != nil {
+			log.Printf("读取应用设置失败（使用默认值）: %v", err)
+		} else {
+			// 读取成功，使用配置值
+			autoEnabled = settings.AutoConnectivityTest
+		}
+
+		// 应用可能在读配置期间进入关停流程，再次确认后才启动巡检
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		// 旧的 AutoConnectivityTest 字段现在控制可用性监控
+		if autoEnabled {
+			healthCheckService.SetAutoAvailabilityPolling(true)
+			log.Println("✅ 自动可用性监控已启动")
+		} else {
+			log.Println("ℹ️  自动可用性监控已禁用（可在设置中开启）")
+		}
+	})
+}
+
+// ShutdownDBQueues 优雅关闭数据库写入队列（10秒超时，双队列架构）并输出统计
+func ShutdownDBQueues() {
+	if err := ShutdownGlobalDBQueue(10 * time.Second); err != nil {
+		log.Printf("⚠️ 队列关闭超时: %v", err)
+		return
+	}
+	// 单次队列统计
+	stats1 := GetGlobalDBQueueStats()
+	log.Printf("✅ 单次队列已关闭，统计：成功=%d 失败=%d 平均延迟=%.2fms",
+		stats1.SuccessWrites, stats1.FailedWrites, stats1.AvgLatencyMs)
+
+	// 批量队列统计
+	stats2 := GetGlobalDBQueueLogsStats()
+	log.Printf("✅ 批量队列已关闭，统计：成功=%d 失败=%d 平均延迟=%.2fms（批均分） 批次=%d",
+		stats2.SuccessWrites, stats2.FailedWrites, stats2.AvgLatencyMs, stats2.BatchCommits)
+}
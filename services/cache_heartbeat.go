@@ -0,0 +1,643 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// ========== Prompt Cache 保活心跳 ==========
+//
+// Claude 的 prompt cache 闲置 5 分钟即过期（1h TTL 为 1 小时），长会话中途离开一会儿，
+// 回来的第一个请求就要按 1.25× 重新写缓存。缓存每被读取一次 TTL 就刷新
+// （见 doc/cache-heartbeat-feasibility.md），开启后中继记住最近会话的可缓存前缀，
+// 在 TTL 到期前向同一供应商补发 max_tokens=1 的请求，以 0.1× 的缓存读取价续命。
+//
+//   - 仅 claude 平台、Anthropic 原生协议的供应商；请求带 cache_control 且上游确实
+//     报告了缓存用量才记录，会话标识与会话粘性同一套（metadata.user_id 等）；
+//   - 会话按"会话标识 + 模型"区分，记录最近一次成功请求的出站前缀（含凭据与改写后的头），
+//     真实请求到来即重新计时；
+//   - 每个会话累计心跳次数有上限；心跳未命中缓存（只写不读）、失败、供应商被禁用/删除、
+//     触达消费上限或平台预算时停止该会话的心跳，不重试；
+//   - 开启扩展思考的请求无法以 max_tokens=1 重放思考参数，而思考参数变化会让消息级缓存失效，
+//     这类会话只保活 system/tools 前缀；
+//   - 心跳不走调度、拉黑与日志主表，单独记入 cache_heartbeat_log（费用同样按价格倍率计），
+//     但计入供应商消费上限与平台预算；状态在内存中，重启即清空。
+
+const (
+	// cacheHeartbeatInterval5m / cacheHeartbeatInterval1h 心跳间隔：留出 1 分钟 / 5 分钟余量
+	cacheHeartbeatInterval5m = 4 * time.Minute
+	cacheHeartbeatInterval1h = 55 * time.Minute
+
+	defaultCacheHeartbeatMax = 6
+	maxCacheHeartbeatMax     = 60
+
+	// maxCacheHeartbeatSessions 记录的会话数上限，超过时先淘汰已停止的，再淘汰最久未活跃的
+	maxCacheHeartbeatSessions = 256
+
+	// cacheHeartbeatTimeout 单次心跳请求超时
+	cacheHeartbeatTimeout = 60 * time.Second
+)
+
+// cacheTTL1hPattern 请求中任意 cache_control 声明了 1h TTL
+var cacheTTL1hPattern = regexp.MustCompile(`"ttl"\s*:\s*"1h"`)
+
+// buildCacheHeartbeatBody 从一次成功请求的出站请求体构造心跳请求体：保留 model/system/tools/
+// tool_choice/metadata 与截至最后一个 cache_control 标记的消息，max_tokens 置 1。
+// 没有任何可命中的缓存标记时返回 ok=false；interval 按 TTL 给出心跳间隔
+func buildCacheHeartbeatBody(body []byte) (heartbeat []byte, interval time.Duration, ok bool) {
+	if !gjson.ValidBytes(body) || !bytes.Contains(body, []byte("cache_control")) {
+		return nil, 0, false
+	}
+	root := gjson.ParseBytes(body)
+	model := root.Get("model").String()
+	if model == "" {
+		return nil, 0, false
+	}
+
+	thinking := root.Get("thinking")
+	thinkingOn := thinking.Exists() && thinking.Get("type").String() != "disabled"
+
+	var kept []string
+	if !thinkingOn {
+		messages := root.Get("messages").Array()
+		last := -1
+		for i, msg := range messages {
+			if strings.Contains(msg.Raw, `"cache_control"`) {
+				last = i
+			}
+		}
+		for i := 0; i <= last; i++ {
+			kept = append(kept, messages[i].Raw)
+		}
+	}
+	if len(kept) == 0 {
+		// 消息部分不保活：system/tools 上必须有标记，否则心跳什么也命中不了
+		if !strings.Contains(root.Get("system").Raw, `"cache_control"`) &&
+			!strings.Contains(root.Get("tools").Raw, `"cache_control"`) {
+			return nil, 0, false
+		}
+		kept = []string{`{"role":"user","content":"."}`}
+	}
+
+	out := []byte(`{}`)
+	out, _ = sjson.SetBytes(out, "model", model)
+	out, _ = sjson.SetBytes(out, "max_tokens", 1)
+	for _, field := range []string{"system", "tools", "tool_choice", "metadata"} {
+		if v := root.Get(field); v.Exists() {
+			out, _ = sjson.SetRawBytes(out, field, []byte(v.Raw))
+		}
+	}
+	out, _ = sjson.SetRawBytes(out, "messages", []byte("["+strings.Join(kept, ",")+"]"))
+	// 部分中转只接受与客户端一致的流式请求，保留原始 stream 取值
+	if root.Get("stream").Bool() {
+		out, _ = sjson.SetBytes(out, "stream", true)
+	}
+
+	interval = cacheHeartbeatInterval5m
+	if cacheTTL1hPattern.Match(body) {
+		interval = cacheHeartbeatInterval1h
+	}
+	return out, interval, true
+}
+
+// CacheHeartbeatSession 正在保活的会话（前端/接口展示用，不含凭据与请求体）
+type CacheHeartbeatSession struct {
+	Platform   string `json:"platform"`
+	Provider   string `json:"provider"`
+	Model      string `json:"model"`
+	SessionKey string `json:"session_key"`
+	Beats      int    `json:"beats"`
+	Active     bool   `json:"active"`       // false 表示已达上限或已停止
+	LastSeenAt int64  `json:"last_seen_at"` // 最近一次真实请求，Unix 毫秒
+	NextBeatAt int64  `json:"next_beat_at"` // 下一次心跳，Unix 毫秒；未排期为 0
+}
+
+// heartbeatSession 单个会话的保活状态
+type heartbeatSession struct {
+	platform        string
+	providerID      int64
+	providerName    string
+	model           string
+	sessionKey      string
+	url             string
+	headers         map[string]string
+	body            []byte
+	insecure        bool
+	proxyURL        string
+	priceMultiplier float64
+	interval        time.Duration
+
+	beats    int
+	lastSeen time.Time
+	nextBeat time.Time
+	timer    *time.Timer
+	// gen 每次排期递增；到点回调据此识别自己是否已被重新计时取代
+	gen uint64
+}
+
+// cacheHeartbeatScheduler 按 "platform:sessionKey:model" 维护会话保活状态（进程内）
+type cacheHeartbeatScheduler struct {
+	mu       sync.Mutex
+	sessions map[string]*heartbeatSession
+	now      func() time.Time
+	// interval 非零时覆盖按 TTL 推算的间隔（测试用）
+	interval time.Duration
+}
+
+func newCacheHeartbeatScheduler() *cacheHeartbeatScheduler {
+	return &cacheHeartbeatScheduler{
+		sessions: make(map[string]*heartbeatSession),
+		now:      time.Now,
+	}
+}
+
+// evictLocked 会话数超限时腾位：先淘汰已停止的，再淘汰最久未活跃的
+func (s *cacheHeartbeatScheduler) evictLocked() {
+	var victim string
+	var oldest time.Time
+	for key, sess := range s.sessions {
+		if sess.timer == nil {
+			delete(s.sessions, key)
+			return
+		}
+		if victim == "" || sess.lastSeen.Before(oldest) {
+			victim, oldest = key, sess.lastSeen
+		}
+	}
+	if victim != "" {
+		s.sessions[victim].timer.Stop()
+		delete(s.sessions, victim)
+	}
+}
+
+// stopAll 取消全部已排期的心跳（代理停止时调用）
+func (s *cacheHeartbeatScheduler) stopAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, sess := range s.sessions {
+		if sess.timer != nil {
+			sess.timer.Stop()
+		}
+		delete(s.sessions, key)
+	}
+}
+
+// snapshot 返回全部会话的展示状态
+func (s *cacheHeartbeatScheduler) snapshot() []CacheHeartbeatSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]CacheHeartbeatSession, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		item := CacheHeartbeatSession{
+			Platform:   sess.platform,
+			Provider:   sess.providerName,
+			Model:      sess.model,
+			SessionKey: sess.sessionKey,
+			Beats:      sess.beats,
+			Active:     sess.timer != nil,
+			LastSeenAt: sess.lastSeen.UnixMilli(),
+		}
+		if sess.timer != nil {
+			item.NextBeatAt = sess.nextBeat.UnixMilli()
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// cacheHeartbeatSettings 读取心跳开关与单会话上限
+func (prs *ProviderRelayService) cacheHeartbeatSettings() (bool, int) {
+	if prs.appSettings == nil {
+		return false, 0
+	}
+	settings, err := prs.appSettings.GetAppSettings()
+	if err != nil {
+		return false, 0
+	}
+	return settings.EnableCacheHeartbeat, normalizeCacheHeartbeatMax(settings.CacheHeartbeatMax)
+}
+
+// normalizeCacheHeartbeatMax 单会话心跳上限：未配置/非正数取默认，上限 60
+func normalizeCacheHeartbeatMax(n int) int {
+	if n <= 0 {
+		return defaultCacheHeartbeatMax
+	}
+	if n > maxCacheHeartbeatMax {
+		return maxCacheHeartbeatMax
+	}
+	return n
+}
+
+// observeCacheHeartbeat 转发成功后记录会话的可缓存前缀并重新计时。
+// native 表示本次未做协议转换（出站即 Anthropic Messages 请求）
+func (prs *ProviderRelayService) observeCacheHeartbeat(
+	c *gin.Context,
+	kind string,
+	provider Provider,
+	targetURL string,
+	query map[string]string,
+	headers map[string]string,
+	bodyBytes []byte,
+	native bool,
+	requestLog *ReqeustLog,
+) {
+	if kind != "claude" || !native || prs.heartbeats == nil ||
+		requestLog.CacheCreateTokens+requestLog.CacheReadTokens == 0 {
+		return
+	}
+	enabled, limit := prs.cacheHeartbeatSettings()
+	if !enabled {
+		return
+	}
+	sessionKey := sessionKeyOf(c)
+	if sessionKey == "" && c.Request != nil {
+		sessionKey = deriveSessionKey(c.Request.Header, bodyBytes)
+	}
+	if sessionKey == "" {
+		return
+	}
+	body, interval, ok := buildCacheHeartbeatBody(bodyBytes)
+	if !ok {
+		return
+	}
+
+	hbHeaders := cloneMap(headers)
+	for name := range rewriteProtectedHeaders {
+		deleteHeaderFold(hbHeaders, name)
+	}
+	if !gjson.GetBytes(body, "stream").Bool() {
+		setHeaderCanonical(hbHeaders, "accept", "application/json")
+	}
+
+	s := prs.heartbeats
+	if s.interval > 0 {
+		interval = s.interval
+	}
+	model := gjson.GetBytes(body, "model").String()
+	key := kind + ":" + sessionKey + ":" + model
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.sessions[key]
+	if sess == nil {
+		if len(s.sessions) >= maxCacheHeartbeatSessions {
+			s.evictLocked()
+		}
+		sess = &heartbeatSession{platform: kind, model: model, sessionKey: sessionKey}
+		s.sessions[key] = sess
+	}
+	sess.providerID = provider.ID
+	sess.providerName = provider.Name
+	sess.url = buildCaptureURL(targetURL, query)
+	sess.headers = hbHeaders
+	sess.body = body
+	sess.insecure = provider.InsecureSkipVerify
+	sess.proxyURL = provider.ProxyURL
+	sess.priceMultiplier = provider.GetPriceMultiplier(model)
+	sess.interval = interval
+	sess.lastSeen = s.now()
+
+	if sess.timer != nil {
+		sess.timer.Stop()
+		sess.timer = nil
+	}
+	if sess.beats >= limit {
+		return
+	}
+	prs.scheduleHeartbeatLocked(key, sess)
+}
+
+// scheduleHeartbeatLocked 为会话排期下一次心跳，调用方持有 s.mu
+func (prs *ProviderRelayService) scheduleHeartbeatLocked(key string, sess *heartbeatSession) {
+	sess.nextBeat = prs.heartbeats.now().Add(sess.interval)
+	sess.gen++
+	gen := sess.gen
+	sess.timer = time.AfterFunc(sess.interval, func() {
+		prs.fireCacheHeartbeat(key, gen)
+	})
+}
+
+// fireCacheHeartbeat 到点发送心跳；gen 不一致说明已被重新计时或停止，直接放弃
+func (prs *ProviderRelayService) fireCacheHeartbeat(key string, gen uint64) {
+	defer RecoverAndLog("cache-heartbeat")
+
+	s := prs.heartbeats
+	s.mu.Lock()
+	sess := s.sessions[key]
+	if sess == nil || sess.timer == nil || sess.gen != gen {
+		s.mu.Unlock()
+		return
+	}
+	job := *sess
+	s.mu.Unlock()
+
+	hbLog, reason := prs.sendCacheHeartbeat(&job)
+
+	enabled, limit := prs.cacheHeartbeatSettings()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 发送期间真实请求到来会换上新的计时器，此时以新排期为准
+	if s.sessions[key] != sess || sess.timer == nil || sess.gen != gen {
+		return
+	}
+	sess.timer = nil
+	if hbLog != nil {
+		sess.beats++
+	}
+	switch {
+	case reason != "":
+		fmt.Printf("[Heartbeat] 会话 %s（%s）停止保活: %s\n", sess.sessionKey, sess.providerName, reason)
+	case !enabled:
+	case sess.beats >= limit:
+		fmt.Printf("[Heartbeat] 会话 %s（%s）已达心跳上限 %d 次\n", sess.sessionKey, sess.providerName, limit)
+	default:
+		prs.scheduleHeartbeatLocked(key, sess)
+	}
+}
+
+// sendCacheHeartbeat 发送一次心跳并落库；返回日志（未实际发出时为 nil）与停止原因（继续保活时为空）
+func (prs *ProviderRelayService) sendCacheHeartbeat(job *heartbeatSession) (*CacheHeartbeatLog, string) {
+	if enabled, _ := prs.cacheHeartbeatSettings(); !enabled {
+		return nil, "心跳已关闭"
+	}
+	provider, ok := prs.findHeartbeatProvider(job.platform, job.providerID)
+	if !ok {
+		return nil, "供应商已禁用或删除"
+	}
+	if exceeded, _ := prs.spendCapExceeded(job.platform, provider.Name, provider.SpendCap, provider.SpendCapPeriod); exceeded {
+		return nil, "供应商已达消费上限"
+	}
+	if exhausted, _, _ := prs.platformBudgetExhausted(job.platform); exhausted {
+		return nil, "平台预算已用尽"
+	}
+
+	hbLog := &CacheHeartbeatLog{
+		Platform:        job.platform,
+		Provider:        provider.Name,
+		Model:           job.model,
+		SessionKey:      job.sessionKey,
+		PriceMultiplier: job.priceMultiplier,
+	}
+	usage := &ReqeustLog{}
+	start := time.Now()
+	err := doCacheHeartbeat(job, usage, hbLog)
+	hbLog.DurationSec = time.Since(start).Seconds()
+	hbLog.InputTokens, hbLog.OutputTokens = usage.InputTokens, usage.OutputTokens
+	hbLog.CacheCreateTokens, hbLog.CacheReadTokens = usage.CacheCreateTokens, usage.CacheReadTokens
+	hbLog.Ephemeral5mTokens, hbLog.Ephemeral1hTokens = usage.Ephemeral5mTokens, usage.Ephemeral1hTokens
+	hbLog.ServiceTier = usage.ServiceTier
+
+	usage.Model, usage.PriceMultiplier = job.model, job.priceMultiplier
+	prs.spend.record(job.platform, provider.Name, usage)
+	if err := writeCacheHeartbeatLog(hbLog); err != nil {
+		fmt.Printf("写入 cache_heartbeat_log 失败: %v\n", err)
+	}
+
+	switch {
+	case err != nil:
+		return hbLog, err.Error()
+	case hbLog.CacheReadTokens == 0:
+		// 前缀已不在缓存里，继续心跳只会反复写缓存
+		return hbLog, "未命中缓存"
+	}
+	fmt.Printf("[Heartbeat] 会话 %s → %s: 缓存读取 %d tokens\n", job.sessionKey, provider.Name, hbLog.CacheReadTokens)
+	return hbLog, ""
+}
+
+// findHeartbeatProvider 按 ID 取仍启用的供应商（名称可能已改）
+func (prs *ProviderRelayService) findHeartbeatProvider(platform string, id int64) (Provider, bool) {
+	providers, err := prs.providerService.LoadProviders(platform)
+	if err != nil {
+		return Provider{}, false
+	}
+	for _, p := range providers {
+		if p.ID == id && p.Enabled {
+			return p, true
+		}
+	}
+	return Provider{}, false
+}
+
+// doCacheHeartbeat 发出心跳请求并解析用量；非 2xx 视为失败
+func doCacheHeartbeat(job *heartbeatSession, usage *ReqeustLog, hbLog *CacheHeartbeatLog) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheHeartbeatTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.url, bytes.NewReader(job.body))
+	if err != nil {
+		return err
+	}
+	for name, value := range job.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := relayClientFor(job.insecure, job.proxyURL, job.providerName).Do(req)
+	if err != nil {
+		hbLog.ErrorMessage = truncateUTF8(err.Error(), 500)
+		return err
+	}
+	defer resp.Body.Close()
+	hbLog.HttpCode = resp.StatusCode
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		hbLog.ErrorMessage = truncateUTF8(err.Error(), 500)
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		hbLog.ErrorMessage = truncateUTF8(string(data), 500)
+		return fmt.Errorf("upstream status %d", resp.StatusCode)
+	}
+	if strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		for scanner.Scan() {
+			if payload, ok := strings.CutPrefix(scanner.Text(), "data:"); ok {
+				ClaudeCodeParseTokenUsageFromResponse(strings.TrimSpace(payload), usage)
+			}
+		}
+		return nil
+	}
+	ClaudeCodeParseTokenUsageFromResponse(string(data), usage)
+	return nil
+}
+
+// GetCacheHeartbeatSessions 返回当前记录的保活会话（进程内状态，重启清零）
+func (prs *ProviderRelayService) GetCacheHeartbeatSessions() []CacheHeartbeatSession {
+	if prs.heartbeats == nil {
+		return []CacheHeartbeatSession{}
+	}
+	return prs.heartbeats.snapshot()
+}
+
+// ========== 心跳日志 ==========
+
+// CacheHeartbeatLog 一次心跳请求的记录（独立于 request_log）
+type CacheHeartbeatLog struct {
+	ID                int64   `json:"id"`
+	Platform          string  `json:"platform"`
+	Provider          string  `json:"provider"`
+	Model             string  `json:"model"`
+	SessionKey        string  `json:"session_key"`
+	HttpCode          int     `json:"http_code"`
+	InputTokens       int     `json:"input_tokens"`
+	OutputTokens      int     `json:"output_tokens"`
+	CacheCreateTokens int     `json:"cache_create_tokens"`
+	CacheReadTokens   int     `json:"cache_read_tokens"`
+	Ephemeral5mTokens int     `json:"ephemeral_5m_tokens"`
+	Ephemeral1hTokens int     `json:"ephemeral_1h_tokens"`
+	ServiceTier       string  `json:"service_tier"`
+	PriceMultiplier   float64 `json:"price_multiplier"`
+	DurationSec       float64 `json:"duration_sec"`
+	ErrorMessage      string  `json:"error_message"`
+	CreatedAt         string  `json:"created_at"`
+	TotalCost         float64 `json:"total_cost"`
+	HasPricing        bool    `json:"has_pricing"`
+}
+
+// CacheHeartbeatStats 心跳汇总
+type CacheHeartbeatStats struct {
+	Heartbeats      int     `json:"heartbeats"`
+	Failed          int     `json:"failed"`
+	CacheReadTokens int     `json:"cache_read_tokens"`
+	TotalCost       float64 `json:"total_cost"`
+}
+
+const cacheHeartbeatInsertSQL = `
+	INSERT INTO cache_heartbeat_log (
+		platform, provider, model, session_key, http_code,
+		input_tokens, output_tokens, cache_create_tokens, cache_read_tokens,
+		ephemeral_5m_tokens, ephemeral_1h_tokens, service_tier, price_multiplier,
+		duration_sec, error_message
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// ensureCacheHeartbeatTable 创建心跳日志表。列名与 request_log 的计费列一致，
+// 费用统计（costSince）可按同一口径读取两张表
+func ensureCacheHeartbeatTable(db *sql.DB) error {
+	const createSQL = `CREATE TABLE IF NOT EXISTS cache_heartbeat_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		platform TEXT,
+		provider TEXT,
+		model TEXT,
+		session_key TEXT DEFAULT '',
+		http_code INTEGER DEFAULT 0,
+		input_tokens INTEGER DEFAULT 0,
+		output_tokens INTEGER DEFAULT 0,
+		reasoning_tokens INTEGER DEFAULT 0,
+		cache_create_tokens INTEGER DEFAULT 0,
+		cache_read_tokens INTEGER DEFAULT 0,
+		ephemeral_5m_tokens INTEGER DEFAULT 0,
+		ephemeral_1h_tokens INTEGER DEFAULT 0,
+		service_tier TEXT DEFAULT '',
+		price_multiplier REAL DEFAULT 1,
+		duration_sec REAL DEFAULT 0,
+		error_message TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := db.Exec(createSQL); err != nil {
+		return err
+	}
+	_, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_cache_heartbeat_log_created_at ON cache_heartbeat_log(created_at)`)
+	return err
+}
+
+// writeCacheHeartbeatLog 心跳日志走单次写队列（低频，与 request_log 的批量通道分开）
+func writeCacheHeartbeatLog(hbLog *CacheHeartbeatLog) error {
+	if InDBMaintenance() {
+		return ErrDBMaintenance
+	}
+	if GlobalDBQueue == nil {
+		return fmt.Errorf("队列未初始化")
+	}
+	return GlobalDBQueue.Exec(cacheHeartbeatInsertSQL,
+		hbLog.Platform, hbLog.Provider, hbLog.Model, hbLog.SessionKey, hbLog.HttpCode,
+		hbLog.InputTokens, hbLog.OutputTokens, hbLog.CacheCreateTokens, hbLog.CacheReadTokens,
+		hbLog.Ephemeral5mTokens, hbLog.Ephemeral1hTokens, hbLog.ServiceTier,
+		effectiveLogPriceMultiplier(hbLog.PriceMultiplier), hbLog.DurationSec, hbLog.ErrorMessage,
+	)
+}
+
+// ListCacheHeartbeatLogs 按时间倒序列出心跳日志（含费用）
+func (ls *LogService) ListCacheHeartbeatLogs(limit int) ([]CacheHeartbeatLog, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	records, err := xdb.New("cache_heartbeat_log").Selects(
+		xdb.OrderByDesc("id"),
+		xdb.Limit(limit),
+	)
+	if err != nil {
+		if errors.Is(err, xdb.ErrNotFound) || isNoSuchTableErr(err) {
+			return []CacheHeartbeatLog{}, nil
+		}
+		return nil, err
+	}
+	logs := make([]CacheHeartbeatLog, 0, len(records))
+	for _, record := range records {
+		entry := CacheHeartbeatLog{
+			ID:                record.GetInt64("id"),
+			Platform:          record.GetString("platform"),
+			Provider:          record.GetString("provider"),
+			Model:             record.GetString("model"),
+			SessionKey:        record.GetString("session_key"),
+			HttpCode:          record.GetInt("http_code"),
+			InputTokens:       record.GetInt("input_tokens"),
+			OutputTokens:      record.GetInt("output_tokens"),
+			CacheCreateTokens: record.GetInt("cache_create_tokens"),
+			CacheReadTokens:   record.GetInt("cache_read_tokens"),
+			Ephemeral5mTokens: record.GetInt("ephemeral_5m_tokens"),
+			Ephemeral1hTokens: record.GetInt("ephemeral_1h_tokens"),
+			ServiceTier:       record.GetString("service_tier"),
+			PriceMultiplier:   recordPriceMultiplier(record),
+			DurationSec:       record.GetFloat64("duration_sec"),
+			ErrorMessage:      record.GetString("error_message"),
+			CreatedAt:         record.GetString("created_at"),
+		}
+		cost := ls.calculateCost(entry.Model, buildSnapshotFromRecord(record), entry.PriceMultiplier)
+		entry.TotalCost, entry.HasPricing = cost.TotalCost, cost.HasPricing
+		logs = append(logs, entry)
+	}
+	return logs, nil
+}
+
+// CacheHeartbeatStatsSince 统计 start 起的心跳次数、失败次数、缓存读取量与费用
+func (ls *LogService) CacheHeartbeatStatsSince(start string) (CacheHeartbeatStats, error) {
+	stats := CacheHeartbeatStats{}
+	startTime, err := parseTimeInput(start)
+	if err != nil {
+		return stats, err
+	}
+	records, err := xdb.New("cache_heartbeat_log").Selects(
+		xdb.WhereGte("created_at", startTime.UTC().Format(timeLayout)),
+	)
+	if err != nil {
+		if errors.Is(err, xdb.ErrNotFound) || isNoSuchTableErr(err) {
+			return stats, nil
+		}
+		return stats, err
+	}
+	for _, record := range records {
+		stats.Heartbeats++
+		code := record.GetInt("http_code")
+		if code < 200 || code >= 300 {
+			stats.Failed++
+		}
+		stats.CacheReadTokens += record.GetInt("cache_read_tokens")
+		stats.TotalCost += ls.calculateCost(record.GetString("model"), buildSnapshotFromRecord(record), recordPriceMultiplier(record)).TotalCost
+	}
+	return stats, nil
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// TestBuildCacheHeartbeatBody 截取到最后一个 cache_control 标记的消息，max_tokens 置 1；思考请求只保活 system
+func TestBuildCacheHeartbeatBody(t *testing.T) {
+	body := []byte(`{"model":"claude-sonnet-4-5","max_tokens":32000,"stream":true,
+		"system":[{"type":"text","text":"sys","cache_control":{"type":"ephemeral"}}],
+		"messages":[
+			{"role":"user","content":"a"},
+			{"role":"assistant","content":"b"},
+			{"role":"user","content":[{"type":"text","text":"c","cache_control":{"type":"ephemeral"}}]},
+			{"role":"assistant","content":"d"},
+			{"role":"user","content":"e"}
+		],
+		"metadata":{"user_id":"u"},"temperature":1}`)
+	hb, interval, ok := buildCacheHeartbeatBody(body)
+	if !ok || interval != cacheHeartbeatInterval5m {
+		t.Fatalf("应生成 5m 心跳: ok=%v interval=%v", ok, interval)
+	}
+	if n := len(gjson.GetBytes(hb, "messages").Array()); n != 3 {
+		t.Errorf("应保留到第 3 条消息: %d (%s)", n, hb)
+	}
+	if gjson.GetBytes(hb, "max_tokens").Int() != 1 || !gjson.GetBytes(hb, "stream").Bool() ||
+		gjson.GetBytes(hb, "temperature").Exists() || gjson.GetBytes(hb, "metadata.user_id").String() != "u" {
+		t.Errorf("心跳请求体不符: %s", hb)
+	}
+
+	thinking := []byte(`{"model":"m","thinking":{"type":"enabled","budget_tokens":2048},
+		"system":[{"type":"text","text":"sys","cache_control":{"type":"ephemeral","ttl":"1h"}}],
+		"messages":[{"role":"user","content":[{"type":"text","text":"c","cache_control":{"type":"ephemeral"}}]}]}`)
+	hb, interval, ok = buildCacheHeartbeatBody(thinking)
+	if !ok || interval != cacheHeartbeatInterval1h {
+		t.Fatalf("应生成 1h 心跳: ok=%v interval=%v", ok, interval)
+	}
+	if gjson.GetBytes(hb, "thinking").Exists() || gjson.GetBytes(hb, "messages.0.content").String() != "." {
+		t.Errorf("思考请求只应保活 system 前缀: %s", hb)
+	}
+
+	if _, _, ok := buildCacheHeartbeatBody([]byte(`{"model":"m","messages":[{"role":"user","content":"x"}]}`)); ok {
+		t.Error("没有缓存标记不应生成心跳")
+	}
+}
+
+// TestCacheHeartbeatKeepsSessionAlive 会话闲置时按间隔补发心跳，达到上限后停止，心跳单独记日志并计入消费
+func TestCacheHeartbeatKeepsSessionAlive(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+	db, err := xdb.DB("default")
+	if err != nil {
+		t.Fatalf("获取数据库失败: %v", err)
+	}
+	if err := ensureCacheHeartbeatTable(db); err != nil {
+		t.Fatalf("创建心跳表失败: %v", err)
+	}
+	oldQueue := GlobalDBQueue
+	GlobalDBQueue = NewDBWriteQueue(db, 100, false)
+	t.Cleanup(func() {
+		_ = GlobalDBQueue.Shutdown(time.Second)
+		GlobalDBQueue = oldQueue
+	})
+
+	var realHits, beatHits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if gjson.GetBytes(body, "max_tokens").Int() == 1 {
+			beatHits.Add(1)
+			if r.Header.Get("Authorization") != "Bearer k" {
+				t.Errorf("心跳应带供应商凭据")
+			}
+			_, _ = w.Write([]byte(`{"usage":{"input_tokens":3,"output_tokens":1,"cache_read_input_tokens":5000}}`))
+			return
+		}
+		realHits.Add(1)
+		_, _ = w.Write([]byte(`{"usage":{"input_tokens":3,"output_tokens":10,"cache_creation_input_tokens":5000}}`))
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{{ID: 1, Name: "hb", APIURL: upstream.URL, APIKey: "k", Enabled: true}}); err != nil {
+		t.Fatalf("保存供应商失败: %v", err)
+	}
+	prs := newTestRelayService(ps)
+	prs.heartbeats.interval = 20 * time.Millisecond
+	settings, _ := prs.appSettings.GetAppSettings()
+	settings.EnableCacheHeartbeat = true
+	settings.CacheHeartbeatMax = 2
+	if _, err := prs.appSettings.SaveAppSettings(settings); err != nil {
+		t.Fatalf("保存设置失败: %v", err)
+	}
+	defer prs.heartbeats.stopAll()
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":1024,
+		"system":[{"type":"text","text":"sys","cache_control":{"type":"ephemeral"}}],
+		"messages":[{"role":"user","content":"hi"}],"metadata":{"user_id":"session-1"}}`))
+	prs.proxyHandler("claude", "/v1/messages")(c)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("应转发成功: %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if sessions := prs.GetCacheHeartbeatSessions(); len(sessions) == 1 && !sessions[0].Active {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sessions := prs.GetCacheHeartbeatSessions()
+	if len(sessions) != 1 || sessions[0].Beats != 2 || sessions[0].Active {
+		t.Fatalf("达到上限后应停止: %+v", sessions)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if realHits.Load() != 1 || beatHits.Load() != 2 {
+		t.Errorf("应有 1 次真实请求、2 次心跳: %d / %d", realHits.Load(), beatHits.Load())
+	}
+
+	logs, err := NewLogService().ListCacheHeartbeatLogs(10)
+	if err != nil || len(logs) != 2 {
+		t.Fatalf("心跳应单独记日志: %v %+v", err, logs)
+	}
+	if logs[0].Provider != "hb" || logs[0].CacheReadTokens != 5000 || logs[0].HttpCode != http.StatusOK {
+		t.Errorf("心跳日志不符: %+v", logs[0])
+	}
+	var mainRows int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM request_log`).Scan(&mainRows)
+	if mainRows > 1 {
+		t.Errorf("心跳不应写入 request_log: %d 行", mainRows)
+	}
+}
+
+// TestCacheHeartbeatStopsOnCacheMiss 心跳未命中缓存即停止该会话，不再继续写缓存
+func TestCacheHeartbeatStopsOnCacheMiss(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+
+	var beatHits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if gjson.GetBytes(body, "max_tokens").Int() == 1 {
+			beatHits.Add(1)
+		}
+		_, _ = w.Write([]byte(`{"usage":{"input_tokens":3,"output_tokens":1,"cache_creation_input_tokens":5000}}`))
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{{ID: 1, Name: "hb", APIURL: upstream.URL, APIKey: "k", Enabled: true}}); err != nil {
+		t.Fatalf("保存供应商失败: %v", err)
+	}
+	prs := newTestRelayService(ps)
+	prs.heartbeats.interval = 20 * time.Millisecond
+	settings, _ := prs.appSettings.GetAppSettings()
+	settings.EnableCacheHeartbeat = true
+	if _, err := prs.appSettings.SaveAppSettings(settings); err != nil {
+		t.Fatalf("保存设置失败: %v", err)
+	}
+	defer prs.heartbeats.stopAll()
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5",
+		"system":[{"type":"text","text":"sys","cache_control":{"type":"ephemeral"}}],
+		"messages":[{"role":"user","content":"hi"}],"metadata":{"user_id":"session-2"}}`))
+	prs.proxyHandler("claude", "/v1/messages")(c)
+
+	time.Sleep(200 * time.Millisecond)
+	if n := beatHits.Load(); n != 1 {
+		t.Errorf("未命中缓存后应停止心跳: %d 次", n)
+	}
+	if sessions := prs.GetCacheHeartbeatSessions(); len(sessions) != 1 || sessions[0].Active {
+		t.Errorf("会话应标记为已停止: %+v", sessions)
+	}
+}
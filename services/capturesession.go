@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/daodao97/xgo/xdb"
-	"github.com/wailsapp/wails/v3/pkg/application"
 )
 
 // ========== 抓包会话 ==========
@@ -775,16 +774,9 @@ func (prs *ProviderRelayService) ExportCaptureSessionWithDialog(sessionID int64,
 		}
 	}
 
-	dialog := application.SaveFileDialog().
-		SetFilename(fmt.Sprintf("capture-session-%d-%s.json", sessionID, time.Now().Format("20060102-150405"))).
-		AddFilter("JSON 抓包数据 (*.json)", "*.json").
-		CanCreateDirectories(true)
-	if app := application.Get(); app != nil {
-		if w := app.Window.Current(); w != nil {
-			dialog.AttachToWindow(w)
-		}
-	}
-	path, err := dialog.PromptForSingleSelection()
+	path, err := promptSaveFile(
+		fmt.Sprintf("capture-session-%d-%s.json", sessionID, time.Now().Format("20060102-150405")),
+		"JSON 抓包数据 (*.json)", "*.json")
 	if err != nil {
 		return CaptureExportResult{}, fmt.Errorf("打开保存对话框失败: %w", err)
 	}
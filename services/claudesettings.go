@@ -14,7 +14,6 @@ const (
 	claudeSettingsDir      = ".claude"
 	claudeSettingsFileName = "settings.json"
 	claudeBackupFileName   = "cc-studio.back.settings.json"
-	claudeAuthTokenValue   = "code-switch-r"
 )
 
 type ClaudeProxyStatus struct {
@@ -126,6 +125,9 @@ func (css *ClaudeSettingsService) EnableProxy() error {
 		return fmt.Errorf("无法读取 settings.json: %w", statErr)
 	}
 
+	// 认证 token：开启客户端认证时为 CLI 注入令牌，否则为占位值
+	authToken := cliAuthToken("claude")
+
 	// 首次启用：记录启用前的关键字段基线到状态文件
 	if !stateExists {
 		envRaw, _ := existingData["env"].(map[string]interface{})
@@ -134,7 +136,7 @@ func (css *ClaudeSettingsService) EnableProxy() error {
 			FileExisted:       fileExisted,
 			EnvExisted:        envRaw != nil,
 			InjectedBaseURL:   css.baseURL(),
-			InjectedAuthToken: claudeAuthTokenValue,
+			InjectedAuthToken: authToken,
 		}
 		if envRaw != nil {
 			if v, ok := envRaw["ANTHROPIC_BASE_URL"]; ok {
@@ -156,7 +158,7 @@ func (css *ClaudeSettingsService) EnableProxy() error {
 	if !ok {
 		env = make(map[string]interface{})
 	}
-	env["ANTHROPIC_AUTH_TOKEN"] = claudeAuthTokenValue
+	env["ANTHROPIC_AUTH_TOKEN"] = authToken
 	env["ANTHROPIC_BASE_URL"] = css.baseURL()
 	existingData["env"] = env
 
@@ -212,7 +214,7 @@ func (css *ClaudeSettingsService) DisableProxy() error {
 			delete(env, "ANTHROPIC_BASE_URL")
 			changed = true
 		}
-		if anyToString(env["ANTHROPIC_AUTH_TOKEN"]) == claudeAuthTokenValue {
+		if isRelayAuthToken(anyToString(env["ANTHROPIC_AUTH_TOKEN"])) {
 			delete(env, "ANTHROPIC_AUTH_TOKEN")
 			changed = true
 		}
@@ -0,0 +1,821 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// 命令行管理接口：codeswitch-headless <命令> [子命令] [参数]，供脚本化管理供应商、代理开关、
+// 黑名单与请求日志。全部经现有服务读写同一份 ~/.code-switch 配置与数据库，
+// 校验与落盘路径与界面一致；输出默认为表格，--json 输出 JSON。
+// 正在运行的应用每次转发都重新读取 claude/codex/自定义 CLI 供应商配置，改动即时生效；
+// Gemini 供应商由应用缓存在内存中，需重启应用后生效。
+
+const cliUsage = `用法: codeswitch-headless <命令> [子命令] [参数]
+
+供应商（--platform: claude / codex / gemini / custom:<工具ID>，默认 claude）:
+  provider list      [--platform P] [--show-keys]
+  provider add       [--platform P] --name N --url U [--key K] [--level L] [--endpoint E] [--protocol X] [--proxy URL] [--model M] [--disabled]
+  provider edit      [--platform P] <ID|名称> [--rename N] [--url U] [--key K] [--level L] [--endpoint E] [--protocol X] [--proxy URL] [--model M]
+  provider enable    [--platform P] <ID|名称>...
+  provider disable   [--platform P] <ID|名称>...
+  provider reorder   [--platform P] <ID|名称>...   按给定顺序排到最前，其余保持原相对顺序
+
+代理开关（claude / codex / gemini / custom:<工具ID>）:
+  proxy status
+  proxy enable  <平台>
+  proxy disable <平台>
+
+黑名单:
+  blacklist list    [--platform P]     不指定平台时列出 claude、codex、gemini
+  blacklist unblock --platform P <供应商名称>
+
+请求日志与统计:
+  logs  [--platform P] [--provider N] [-n 20] [--follow]
+  stats [--platform P]                 今日汇总与按供应商统计
+
+所有命令均支持 --json 输出 JSON。
+`
+
+// cliCommands 命令名 → 处理函数
+var cliCommands = map[string]func(*cliContext, []string) error{
+	"provider":  (*cliContext).runProvider,
+	"proxy":     (*cliContext).runProxy,
+	"blacklist": (*cliContext).runBlacklist,
+	"logs":      (*cliContext).runLogs,
+	"stats":     (*cliContext).runStats,
+}
+
+// cliContext 一次命令执行的上下文与所需服务
+type cliContext struct {
+	ctx        context.Context
+	out        io.Writer
+	jsonOutput bool
+
+	relayAddr   string
+	policy      *DefaultModelPolicy
+	providers   *ProviderService
+	gemini      *GeminiService
+	blacklist   *BlacklistService
+	logs        *LogService
+	followEvery time.Duration
+	errOut      io.Writer
+}
+
+// RunCLI 执行管理命令。调用方负责初始化数据库与写入队列，并在返回后关闭队列。
+// ctx 取消时 logs --follow 退出
+func RunCLI(ctx context.Context, args []string, out, errOut io.Writer) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "--help" || args[0] == "-h" {
+		fmt.Fprint(out, cliUsage)
+		return nil
+	}
+	run, ok := cliCommands[args[0]]
+	if !ok {
+		fmt.Fprint(errOut, cliUsage)
+		return fmt.Errorf("未知命令: %s", args[0])
+	}
+
+	relayAddr := RelayConnectAddress(ResolveRelayListenAddresses()[0])
+	policy := NewDefaultModelPolicy()
+	settings := NewSettingsService()
+	appSettings := NewAppSettingsService(NewAutoStartService())
+	c := &cliContext{
+		ctx:         ctx,
+		out:         out,
+		relayAddr:   relayAddr,
+		policy:      policy,
+		providers:   NewProviderService(),
+		gemini:      NewGeminiService(relayAddr, policy),
+		blacklist:   NewBlacklistService(settings, NewNotificationService(appSettings)),
+		logs:        NewLogService(),
+		followEvery: 2 * time.Second,
+		errOut:      errOut,
+	}
+	if err := run(c, args[1:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return nil
+}
+
+// flagSet 创建子命令的参数集，统一挂上 --json
+func (c *cliContext) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("codeswitch-headless "+name, flag.ContinueOnError)
+	fs.SetOutput(c.errOut)
+	fs.BoolVar(&c.jsonOutput, "json", false, "输出 JSON")
+	return fs
+}
+
+// cliUsageError 参数解析失败：flag 包已输出错误与用法
+type cliUsageError struct{ error }
+
+// IsCLIUsageError 参数错误已由 flag 包输出，调用方无需再打印
+func IsCLIUsageError(err error) bool {
+	var usage cliUsageError
+	return errors.As(err, &usage)
+}
+
+// parseInterspersed 解析参数并允许标志与位置参数交错（flag 包遇到首个位置参数即停止）
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, cliUsageError{err}
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// subcommand 拆出子命令名
+func subcommand(group string, args []string, allowed ...string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", nil, fmt.Errorf("%s 缺少子命令（%s）", group, strings.Join(allowed, " / "))
+	}
+	for _, name := range allowed {
+		if args[0] == name {
+			return name, args[1:], nil
+		}
+	}
+	return "", nil, fmt.Errorf("%s 不支持子命令 %s（%s）", group, args[0], strings.Join(allowed, " / "))
+}
+
+// emit 按输出模式打印：JSON 直接编码 value，表格模式调用 table
+func (c *cliContext) emit(value any, table func(w *tabwriter.Writer)) error {
+	if c.jsonOutput {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(value)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// done 写操作的结果提示
+func (c *cliContext) done(format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if c.jsonOutput {
+		return c.emit(map[string]any{"ok": true, "message": msg}, nil)
+	}
+	_, err := fmt.Fprintln(c.out, msg)
+	return err
+}
+
+// ========== provider ==========
+
+// cliProvider 供应商在命令行中的统一视图（gemini 的 ID 为字符串）
+type cliProvider struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	APIKey   string `json:"apiKey"`
+	Enabled  bool   `json:"enabled"`
+	Level    int    `json:"level"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	Model    string `json:"model,omitempty"`
+}
+
+// providerEdit 可编辑字段；nil 表示未指定
+type providerEdit struct {
+	rename, url, key, endpoint, protocol, proxy, model *string
+	level                                              *int
+}
+
+func (c *cliContext) runProvider(args []string) error {
+	sub, args, err := subcommand("provider", args, "list", "add", "edit", "enable", "disable", "reorder")
+	if err != nil {
+		return err
+	}
+	fs := c.flagSet("provider " + sub)
+	platform := fs.String("platform", "claude", "平台")
+	showKeys := fs.Bool("show-keys", false, "显示完整 API Key")
+	name := fs.String("name", "", "供应商名称")
+	disabled := fs.Bool("disabled", false, "新增后保持禁用")
+	var edit providerEdit
+	strFlag := func(target **string, flagName, usage string) {
+		fs.Func(flagName, usage, func(v string) error { *target = &v; return nil })
+	}
+	strFlag(&edit.rename, "rename", "新名称")
+	strFlag(&edit.url, "url", "API 地址")
+	strFlag(&edit.key, "key", "API Key")
+	strFlag(&edit.endpoint, "endpoint", "API 端点路径")
+	strFlag(&edit.protocol, "protocol", "上游协议")
+	strFlag(&edit.proxy, "proxy", "出站代理地址（空字符串为直连）")
+	strFlag(&edit.model, "model", "模型（仅 gemini）")
+	fs.Func("level", "优先级分组 1-10", func(v string) error {
+		n, err := strconv.Atoi(v)
+		// 与界面一致：优先级分组为 1-10
+		if err != nil || n < 1 || n > 10 {
+			return fmt.Errorf("level 必须是 1-10 的整数")
+		}
+		edit.level = &n
+		return nil
+	})
+	refs, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	kind := strings.TrimSpace(*platform)
+
+	switch sub {
+	case "list":
+		return c.listProviders(kind, *showKeys)
+	case "add":
+		if edit.rename != nil {
+			return fmt.Errorf("新增请用 --name 指定名称")
+		}
+		return c.addProvider(kind, strings.TrimSpace(*name), edit, !*disabled)
+	}
+	if len(refs) == 0 {
+		return fmt.Errorf("provider %s 需要指定供应商 ID 或名称", sub)
+	}
+	switch sub {
+	case "edit":
+		if len(refs) != 1 {
+			return fmt.Errorf("provider edit 一次只能修改一个供应商")
+		}
+		return c.editProvider(kind, refs[0], edit)
+	case "enable", "disable":
+		return c.setProvidersEnabled(kind, refs, sub == "enable")
+	default:
+		return c.reorderProviders(kind, refs)
+	}
+}
+
+func (c *cliContext) loadCLIProviders(kind string) ([]cliProvider, error) {
+	var list []cliProvider
+	if kind == "gemini" {
+		for _, p := range c.gemini.GetProviders() {
+			list = append(list, cliProvider{ID: p.ID, Name: p.Name, URL: p.BaseURL, APIKey: p.APIKey,
+				Enabled: p.Enabled, Level: p.Level, Model: p.Model})
+		}
+		return list, nil
+	}
+	providers, err := c.providers.LoadProviders(kind)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range providers {
+		list = append(list, cliProvider{ID: strconv.FormatInt(p.ID, 10), Name: p.Name, URL: p.APIURL, APIKey: p.APIKey,
+			Enabled: p.Enabled, Level: p.Level, Endpoint: p.APIEndpoint, Protocol: p.UpstreamProtocol})
+	}
+	return list, nil
+}
+
+func (c *cliContext) listProviders(kind string, showKeys bool) error {
+	list, err := c.loadCLIProviders(kind)
+	if err != nil {
+		return err
+	}
+	if list == nil {
+		list = []cliProvider{}
+	}
+	for i := range list {
+		if list[i].Level <= 0 {
+			list[i].Level = 1
+		}
+		if !showKeys && list[i].APIKey != "" {
+			list[i].APIKey = maskAPIKey(list[i].APIKey)
+		}
+	}
+	return c.emit(list, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "#\tID\tNAME\tENABLED\tLEVEL\tURL\tAPI KEY")
+		for i, p := range list {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%v\t%d\t%s\t%s\n", i+1, p.ID, p.Name, p.Enabled, p.Level, p.URL, p.APIKey)
+		}
+	})
+}
+
+// findProviderIndex 按 ID 或名称（不区分大小写）定位供应商
+func findProviderIndex(n int, idOf, nameOf func(int) string, ref string) (int, error) {
+	ref = strings.TrimSpace(ref)
+	for i := 0; i < n; i++ {
+		if idOf(i) == ref {
+			return i, nil
+		}
+	}
+	for i := 0; i < n; i++ {
+		if strings.EqualFold(strings.TrimSpace(nameOf(i)), ref) {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("未找到供应商: %s", ref)
+}
+
+func (c *cliContext) addProvider(kind, name string, edit providerEdit, enabled bool) error {
+	if name == "" || edit.url == nil || strings.TrimSpace(*edit.url) == "" {
+		return fmt.Errorf("provider add 需要 --name 与 --url")
+	}
+	if kind == "gemini" {
+		for _, p := range c.gemini.GetProviders() {
+			if strings.EqualFold(strings.TrimSpace(p.Name), name) {
+				return fmt.Errorf("供应商名称已存在: %s", name)
+			}
+		}
+		p := GeminiProvider{Name: name, Enabled: enabled, Category: "custom"}
+		applyGeminiEdit(&p, edit)
+		if err := c.gemini.AddProvider(p); err != nil {
+			return err
+		}
+		return c.done("已添加 gemini 供应商 %s", name)
+	}
+
+	var added Provider
+	err := c.providers.mutateProviders(kind, func(providers []Provider) ([]Provider, error) {
+		maxID := int64(0)
+		for _, p := range providers {
+			if strings.EqualFold(strings.TrimSpace(p.Name), name) {
+				return nil, fmt.Errorf("供应商名称已存在: %s", name)
+			}
+			if p.ID > maxID {
+				maxID = p.ID
+			}
+		}
+		added = Provider{ID: maxID + 1, Name: name, Enabled: enabled}
+		if err := applyProviderEdit(&added, edit); err != nil {
+			return nil, err
+		}
+		return append(providers, added), nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.done("已添加 %s 供应商 %s（ID %d）", kind, name, added.ID)
+}
+
+// applyProviderEdit 把指定的字段写入供应商（改名走 RenameProvider，不在此处理）
+func applyProviderEdit(p *Provider, edit providerEdit) error {
+	if edit.model != nil {
+		return fmt.Errorf("--model 仅适用于 gemini 供应商")
+	}
+	if edit.url != nil {
+		p.APIURL = strings.TrimSpace(*edit.url)
+	}
+	if edit.key != nil {
+		p.APIKey = strings.TrimSpace(*edit.key)
+	}
+	if edit.endpoint != nil {
+		p.APIEndpoint = strings.TrimSpace(*edit.endpoint)
+	}
+	if edit.protocol != nil {
+		p.UpstreamProtocol = strings.TrimSpace(*edit.protocol)
+	}
+	if edit.proxy != nil {
+		p.ProxyURL = strings.TrimSpace(*edit.proxy)
+	}
+	if edit.level != nil {
+		p.Level = *edit.level
+	}
+	return nil
+}
+
+func applyGeminiEdit(p *GeminiProvider, edit providerEdit) {
+	if edit.rename != nil {
+		p.Name = strings.TrimSpace(*edit.rename)
+	}
+	if edit.url != nil {
+		p.BaseURL = strings.TrimSpace(*edit.url)
+	}
+	if edit.key != nil {
+		p.APIKey = strings.TrimSpace(*edit.key)
+	}
+	if edit.proxy != nil {
+		p.ProxyURL = strings.TrimSpace(*edit.proxy)
+	}
+	if edit.model != nil {
+		p.Model = strings.TrimSpace(*edit.model)
+	}
+	if edit.level != nil {
+		p.Level = *edit.level
+	}
+}
+
+func (c *cliContext) editProvider(kind, ref string, edit providerEdit) error {
+	if kind == "gemini" {
+		if edit.endpoint != nil || edit.protocol != nil {
+			return fmt.Errorf("gemini 供应商不支持 --endpoint / --protocol")
+		}
+		providers := c.gemini.GetProviders()
+		i, err := findProviderIndex(len(providers),
+			func(i int) string { return providers[i].ID }, func(i int) string { return providers[i].Name }, ref)
+		if err != nil {
+			return err
+		}
+		p := providers[i]
+		applyGeminiEdit(&p, edit)
+		if err := c.gemini.UpdateProvider(p); err != nil {
+			return err
+		}
+		return c.done("已更新 gemini 供应商 %s", p.Name)
+	}
+
+	var target Provider
+	err := c.providers.mutateProviders(kind, func(providers []Provider) ([]Provider, error) {
+		i, err := findProviderIndex(len(providers),
+			func(i int) string { return strconv.FormatInt(providers[i].ID, 10) }, func(i int) string { return providers[i].Name }, ref)
+		if err != nil {
+			return nil, err
+		}
+		if err := applyProviderEdit(&providers[i], edit); err != nil {
+			return nil, err
+		}
+		target = providers[i]
+		return providers, nil
+	})
+	if err != nil {
+		return err
+	}
+	// 名称是黑名单、用量统计与别名迁移的键，与界面一样走独立的改名路径
+	if edit.rename != nil && strings.TrimSpace(*edit.rename) != target.Name {
+		if err := c.providers.RenameProvider(kind, target.ID, *edit.rename); err != nil {
+			return err
+		}
+		target.Name = strings.TrimSpace(*edit.rename)
+	}
+	return c.done("已更新 %s 供应商 %s", kind, target.Name)
+}
+
+func (c *cliContext) setProvidersEnabled(kind string, refs []string, enabled bool) error {
+	state := "禁用"
+	if enabled {
+		state = "启用"
+	}
+	if kind == "gemini" {
+		providers := c.gemini.GetProviders()
+		for _, ref := range refs {
+			i, err := findProviderIndex(len(providers),
+				func(i int) string { return providers[i].ID }, func(i int) string { return providers[i].Name }, ref)
+			if err != nil {
+				return err
+			}
+			p := providers[i]
+			p.Enabled = enabled
+			if err := c.gemini.UpdateProvider(p); err != nil {
+				return err
+			}
+		}
+		return c.done("已%s gemini 供应商: %s", state, strings.Join(refs, ", "))
+	}
+
+	err := c.providers.mutateProviders(kind, func(providers []Provider) ([]Provider, error) {
+		for _, ref := range refs {
+			i, err := findProviderIndex(len(providers),
+				func(i int) string { return strconv.FormatInt(providers[i].ID, 10) }, func(i int) string { return providers[i].Name }, ref)
+			if err != nil {
+				return nil, err
+			}
+			providers[i].Enabled = enabled
+		}
+		return providers, nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.done("已%s %s 供应商: %s", state, kind, strings.Join(refs, ", "))
+}
+
+// reorderIndexes 给定的供应商按顺序排到最前，其余保持原相对顺序
+func reorderIndexes(n int, idOf, nameOf func(int) string, refs []string) ([]int, error) {
+	picked := make(map[int]bool, len(refs))
+	order := make([]int, 0, n)
+	for _, ref := range refs {
+		i, err := findProviderIndex(n, idOf, nameOf, ref)
+		if err != nil {
+			return nil, err
+		}
+		if picked[i] {
+			return nil, fmt.Errorf("供应商重复指定: %s", ref)
+		}
+		picked[i] = true
+		order = append(order, i)
+	}
+	for i := 0; i < n; i++ {
+		if !picked[i] {
+			order = append(order, i)
+		}
+	}
+	return order, nil
+}
+
+func (c *cliContext) reorderProviders(kind string, refs []string) error {
+	if kind == "gemini" {
+		providers := c.gemini.GetProviders()
+		order, err := reorderIndexes(len(providers),
+			func(i int) string { return providers[i].ID }, func(i int) string { return providers[i].Name }, refs)
+		if err != nil {
+			return err
+		}
+		ids := make([]string, 0, len(order))
+		for _, i := range order {
+			ids = append(ids, providers[i].ID)
+		}
+		if err := c.gemini.ReorderProviders(ids); err != nil {
+			return err
+		}
+		return c.done("已调整 gemini 供应商顺序")
+	}
+
+	err := c.providers.mutateProviders(kind, func(providers []Provider) ([]Provider, error) {
+		order, err := reorderIndexes(len(providers),
+			func(i int) string { return strconv.FormatInt(providers[i].ID, 10) }, func(i int) string { return providers[i].Name }, refs)
+		if err != nil {
+			return nil, err
+		}
+		reordered := make([]Provider, 0, len(providers))
+		for _, i := range order {
+			reordered = append(reordered, providers[i])
+		}
+		return reordered, nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.done("已调整 %s 供应商顺序", kind)
+}
+
+// ========== proxy ==========
+
+// cliProxyStatus 代理开关状态
+type cliProxyStatus struct {
+	Platform string `json:"platform"`
+	Enabled  bool   `json:"enabled"`
+	BaseURL  string `json:"baseUrl"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (c *cliContext) runProxy(args []string) error {
+	sub, args, err := subcommand("proxy", args, "status", "enable", "disable")
+	if err != nil {
+		return err
+	}
+	fs := c.flagSet("proxy " + sub)
+	rest, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if sub == "status" {
+		return c.proxyStatus()
+	}
+	if len(rest) != 1 {
+		return fmt.Errorf("proxy %s 需要指定一个平台（claude / codex / gemini / custom:<工具ID>）", sub)
+	}
+	platform := strings.TrimSpace(rest[0])
+	enable := sub == "enable"
+	if err := c.toggleProxy(platform, enable); err != nil {
+		return err
+	}
+	if enable {
+		return c.done("已为 %s 开启代理", platform)
+	}
+	return c.done("已为 %s 关闭代理", platform)
+}
+
+func (c *cliContext) toggleProxy(platform string, enable bool) error {
+	pick := func(on, off func() error) error {
+		if enable {
+			return on()
+		}
+		return off()
+	}
+	switch {
+	case platform == "claude":
+		s := NewClaudeSettingsService(c.relayAddr)
+		return pick(s.EnableProxy, s.DisableProxy)
+	case platform == "codex":
+		s := NewCodexSettingsService(c.relayAddr, c.policy)
+		return pick(s.EnableProxy, s.DisableProxy)
+	case platform == "gemini":
+		return pick(c.gemini.EnableProxy, c.gemini.DisableProxy)
+	case strings.HasPrefix(platform, "custom:"):
+		toolID := strings.TrimPrefix(platform, "custom:")
+		s := NewCustomCliService(c.relayAddr, c.policy)
+		return pick(func() error { return s.EnableProxy(toolID) }, func() error { return s.DisableProxy(toolID) })
+	}
+	return fmt.Errorf("不支持的平台: %s", platform)
+}
+
+func (c *cliContext) proxyStatus() error {
+	statuses := make([]cliProxyStatus, 0, 4)
+	add := func(platform string, enabled bool, baseURL string, err error) {
+		s := cliProxyStatus{Platform: platform, Enabled: enabled, BaseURL: baseURL}
+		if err != nil {
+			s.Error = err.Error()
+		}
+		statuses = append(statuses, s)
+	}
+	claude, err := NewClaudeSettingsService(c.relayAddr).ProxyStatus()
+	add("claude", claude.Enabled, claude.BaseURL, err)
+	codex, err := NewCodexSettingsService(c.relayAddr, c.policy).ProxyStatus()
+	add("codex", codex.Enabled, codex.BaseURL, err)
+	if gemini, err := c.gemini.ProxyStatus(); gemini != nil {
+		add("gemini", gemini.Enabled, gemini.BaseURL, err)
+	}
+	custom := NewCustomCliService(c.relayAddr, c.policy)
+	tools, err := custom.ListTools()
+	if err != nil {
+		return err
+	}
+	for _, tool := range tools {
+		if s, err := custom.ProxyStatus(tool.ID); s != nil {
+			add("custom:"+tool.ID, s.Enabled, s.BaseURL, err)
+		}
+	}
+	return c.emit(statuses, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "PLATFORM\tPROXY\tBASE URL")
+		for _, s := range statuses {
+			state := "off"
+			if s.Enabled {
+				state = "on"
+			}
+			if s.Error != "" {
+				state = "error: " + s.Error
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.Platform, state, s.BaseURL)
+		}
+	})
+}
+
+// ========== blacklist ==========
+
+func (c *cliContext) runBlacklist(args []string) error {
+	sub, args, err := subcommand("blacklist", args, "list", "unblock")
+	if err != nil {
+		return err
+	}
+	fs := c.flagSet("blacklist " + sub)
+	platform := fs.String("platform", "", "平台")
+	rest, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if sub == "unblock" {
+		if *platform == "" || len(rest) != 1 {
+			return fmt.Errorf("blacklist unblock 需要 --platform 与一个供应商名称")
+		}
+		// 与主界面的"解除拉黑"相同：保留等级，重新开始降级计时
+		if err := c.blacklist.ManualUnblockAndReset(*platform, rest[0]); err != nil {
+			return err
+		}
+		return c.done("已解除 %s/%s 的拉黑", *platform, rest[0])
+	}
+
+	platforms := blacklistPlatforms
+	if *platform != "" {
+		platforms = []string{*platform}
+	}
+	statuses := []BlacklistStatus{}
+	for _, p := range platforms {
+		list, err := c.blacklist.GetBlacklistStatus(p)
+		if err != nil {
+			return err
+		}
+		statuses = append(statuses, list...)
+	}
+	return c.emit(statuses, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "PLATFORM\tPROVIDER\tBLACKLISTED\tREMAINING\tFAILURES\tLEVEL")
+		for _, s := range statuses {
+			remaining := "-"
+			if s.IsBlacklisted {
+				remaining = (time.Duration(s.RemainingSeconds) * time.Second).String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%d\t%d\n", s.Platform, s.ProviderName, s.IsBlacklisted, remaining, s.FailureCount, s.BlacklistLevel)
+		}
+	})
+}
+
+// ========== logs / stats ==========
+
+func (c *cliContext) runLogs(args []string) error {
+	fs := c.flagSet("logs")
+	platform := fs.String("platform", "", "平台")
+	provider := fs.String("provider", "", "供应商名称")
+	limit := fs.Int("n", 20, "显示最近的条数")
+	follow := fs.Bool("follow", false, "持续输出新请求")
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+
+	logs, err := c.logs.ListRequestLogs(*platform, *provider, *limit)
+	if err != nil {
+		return err
+	}
+	// 查询按 ID 倒序，输出改为时间正序，与 tail 一致
+	sort.Slice(logs, func(i, j int) bool { return logs[i].ID < logs[j].ID })
+	if err := c.printLogs(logs, true); err != nil {
+		return err
+	}
+	if !*follow {
+		return nil
+	}
+
+	var lastID int64
+	if len(logs) > 0 {
+		lastID = logs[len(logs)-1].ID
+	}
+	ticker := time.NewTicker(c.followEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		logs, err := c.logs.ListRequestLogs(*platform, *provider, 1000)
+		if err != nil {
+			return err
+		}
+		fresh := logs[:0]
+		for _, l := range logs {
+			if l.ID > lastID {
+				fresh = append(fresh, l)
+			}
+		}
+		if len(fresh) == 0 {
+			continue
+		}
+		sort.Slice(fresh, func(i, j int) bool { return fresh[i].ID < fresh[j].ID })
+		lastID = fresh[len(fresh)-1].ID
+		if err := c.printLogs(fresh, false); err != nil {
+			return err
+		}
+	}
+}
+
+// printLogs 表格模式逐批输出（header 仅首批），JSON 模式每条一行（JSON Lines，便于管道处理）
+func (c *cliContext) printLogs(logs []ReqeustLog, header bool) error {
+	if c.jsonOutput {
+		enc := json.NewEncoder(c.out)
+		for _, l := range logs {
+			if err := enc.Encode(l); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	if header {
+		fmt.Fprintln(w, "ID\tTIME\tPLATFORM\tPROVIDER\tMODEL\tCODE\tIN\tOUT\tCACHE R\tCOST\tDURATION\tCLIENT")
+	}
+	for _, l := range logs {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t$%.4f\t%.2fs\t%s\n",
+			l.ID, l.CreatedAt, l.Platform, l.Provider, l.Model, l.HttpCode,
+			l.InputTokens, l.OutputTokens, l.CacheReadTokens, l.TotalCost, l.DurationSec, l.ClientName)
+	}
+	return w.Flush()
+}
+
+// cliStats stats 命令的输出
+type cliStats struct {
+	Platform  string              `json:"platform"`
+	Today     LogStats            `json:"today"`
+	Providers []ProviderDailyStat `json:"providers"`
+}
+
+func (c *cliContext) runStats(args []string) error {
+	fs := c.flagSet("stats")
+	platform := fs.String("platform", "", "平台")
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+	today, err := c.logs.StatsSince(*platform)
+	if err != nil {
+		return err
+	}
+	providers, err := c.logs.ProviderDailyStats(*platform)
+	if err != nil {
+		return err
+	}
+	if providers == nil {
+		providers = []ProviderDailyStat{}
+	}
+	today.Series = nil
+	stats := cliStats{Platform: *platform, Today: today, Providers: providers}
+	return c.emit(stats, func(w *tabwriter.Writer) {
+		scope := *platform
+		if scope == "" {
+			scope = "全部平台"
+		}
+		fmt.Fprintf(w, "今日（%s）: 请求 %d  输入 %d  输出 %d  缓存写 %d  缓存读 %d  费用 $%.4f\n\n",
+			scope, today.TotalRequests, today.InputTokens, today.OutputTokens, today.CacheCreateTokens, today.CacheReadTokens, today.CostTotal)
+		fmt.Fprintln(w, "PROVIDER\tREQUESTS\tSUCCESS\tFAILED\tRATE\tIN\tOUT\tCOST")
+		for _, p := range providers {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.1f%%\t%d\t%d\t$%.4f\n",
+				p.Provider, p.TotalRequests, p.SuccessfulRequests, p.FailedRequests, p.SuccessRate*100, p.InputTokens, p.OutputTokens, p.CostTotal)
+		}
+	})
+}
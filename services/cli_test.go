@@ -0,0 +1,202 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+)
+
+// runCLIForTest 执行一条命令并返回标准输出
+func runCLIForTest(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := RunCLI(context.Background(), args, &out, io.Discard)
+	return out.String(), err
+}
+
+func TestCLIProviderCommands(t *testing.T) {
+	setupBlacklistFixEnv(t)
+
+	for _, name := range []string{"alpha", "beta", "gamma"} {
+		if _, err := runCLIForTest(t, "provider", "add", "--name", name, "--url", "https://"+name+".example.com", "--key", "sk-"+name+"-0123456789"); err != nil {
+			t.Fatalf("添加 %s 失败: %v", name, err)
+		}
+	}
+	if _, err := runCLIForTest(t, "provider", "add", "--name", "ALPHA", "--url", "https://x.example.com"); err == nil {
+		t.Error("重名（不区分大小写）应被拒绝")
+	}
+	if _, err := runCLIForTest(t, "provider", "add", "--name", "bad", "--url", "https://x.example.com", "--proxy", "ftp://proxy:21"); err == nil {
+		t.Error("应沿用保存时的配置校验")
+	}
+	if _, err := runCLIForTest(t, "provider", "add", "--name", "bad", "--url", "https://x.example.com", "--level", "99"); !IsCLIUsageError(err) {
+		t.Errorf("优先级超出 1-10 应为用法错误: %v", err)
+	}
+
+	if _, err := runCLIForTest(t, "provider", "disable", "beta", "3"); err != nil {
+		t.Fatalf("禁用失败: %v", err)
+	}
+	if _, err := runCLIForTest(t, "provider", "reorder", "gamma", "--platform", "claude"); err != nil {
+		t.Fatalf("排序失败: %v", err)
+	}
+	if _, err := runCLIForTest(t, "provider", "edit", "1", "--url", "https://new.example.com", "--rename", "alpha2"); err != nil {
+		t.Fatalf("编辑失败: %v", err)
+	}
+
+	out, err := runCLIForTest(t, "provider", "list", "--json")
+	if err != nil {
+		t.Fatalf("列出失败: %v", err)
+	}
+	var list []cliProvider
+	if err := json.Unmarshal([]byte(out), &list); err != nil {
+		t.Fatalf("JSON 输出无法解析: %v\n%s", err, out)
+	}
+	var got []string
+	for _, p := range list {
+		got = append(got, p.Name)
+	}
+	if strings.Join(got, ",") != "gamma,alpha2,beta" {
+		t.Fatalf("顺序不符: %v", got)
+	}
+	if list[0].Enabled || !list[1].Enabled || list[2].Enabled {
+		t.Errorf("启用状态不符: %+v", list)
+	}
+	if list[1].URL != "https://new.example.com" || list[1].ID != "1" {
+		t.Errorf("编辑未生效: %+v", list[1])
+	}
+	if strings.Contains(out, "sk-alpha-0123456789") {
+		t.Error("默认应隐藏 API Key")
+	}
+
+	providers, _ := NewProviderService().LoadProviders("claude")
+	if len(providers) != 3 || providers[1].Name != "alpha2" || providers[1].APIKey != "sk-alpha-0123456789" {
+		t.Errorf("磁盘配置不符: %+v", providers)
+	}
+
+	if out, err := runCLIForTest(t, "provider", "list"); err != nil || !strings.Contains(out, "NAME") || !strings.Contains(out, "gamma") {
+		t.Errorf("表格输出不符: %v\n%s", err, out)
+	}
+	if _, err := runCLIForTest(t, "provider", "enable", "missing"); err == nil {
+		t.Error("未知供应商应报错")
+	}
+	if _, err := runCLIForTest(t, "provider", "list", "--bogus"); !IsCLIUsageError(err) {
+		t.Errorf("未知参数应为用法错误: %v", err)
+	}
+}
+
+func TestCLIGeminiProvidersAndProxy(t *testing.T) {
+	setupBlacklistFixEnv(t)
+
+	if _, err := runCLIForTest(t, "provider", "add", "--platform", "gemini", "--name", "g1", "--url", "https://g1.example.com", "--key", "k1"); err != nil {
+		t.Fatalf("添加 gemini 供应商失败: %v", err)
+	}
+	if _, err := runCLIForTest(t, "provider", "add", "--platform", "gemini", "--name", "g2", "--url", "https://g2.example.com", "--key", "k2"); err != nil {
+		t.Fatalf("添加 gemini 供应商失败: %v", err)
+	}
+	if _, err := runCLIForTest(t, "provider", "reorder", "--platform", "gemini", "g2"); err != nil {
+		t.Fatalf("gemini 排序失败: %v", err)
+	}
+	if _, err := runCLIForTest(t, "provider", "disable", "--platform", "gemini", "g1"); err != nil {
+		t.Fatalf("gemini 禁用失败: %v", err)
+	}
+	providers := NewGeminiService("127.0.0.1:18100", nil).GetProviders()
+	if len(providers) != 2 || providers[0].Name != "g2" || providers[1].Enabled {
+		t.Errorf("gemini 配置不符: %+v", providers)
+	}
+
+	if _, err := runCLIForTest(t, "proxy", "enable", "claude"); err != nil {
+		t.Fatalf("开启代理失败: %v", err)
+	}
+	out, err := runCLIForTest(t, "proxy", "status", "--json")
+	if err != nil {
+		t.Fatalf("查询代理状态失败: %v", err)
+	}
+	var statuses []cliProxyStatus
+	if err := json.Unmarshal([]byte(out), &statuses); err != nil || len(statuses) < 3 {
+		t.Fatalf("代理状态输出不符: %v\n%s", err, out)
+	}
+	if !statuses[0].Enabled || statuses[1].Enabled {
+		t.Errorf("仅 claude 应开启代理: %+v", statuses)
+	}
+	if _, err := runCLIForTest(t, "proxy", "disable", "claude"); err != nil {
+		t.Fatalf("关闭代理失败: %v", err)
+	}
+	if status, _ := NewClaudeSettingsService("127.0.0.1:18100").ProxyStatus(); status.Enabled {
+		t.Error("关闭后 claude 不应再指向代理")
+	}
+	if _, err := runCLIForTest(t, "proxy", "enable", "unknown"); err == nil {
+		t.Error("未知平台应报错")
+	}
+}
+
+func TestCLIBlacklistAndLogs(t *testing.T) {
+	setupBlacklistFixEnv(t)
+	db, _ := xdb.DB("default")
+	now := time.Now()
+	if _, err := db.Exec(`
+		INSERT INTO provider_blacklist (platform, provider_name, failure_count, blacklisted_at, blacklisted_until, last_failure_at, blacklist_level)
+		VALUES ('codex', 'p1', 3, ?, ?, ?, 2)
+	`, now, now.Add(10*time.Minute), now); err != nil {
+		t.Fatalf("seed 失败: %v", err)
+	}
+	if err := ensureRequestLogTableWithDB(db); err != nil {
+		t.Fatalf("建表失败: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := db.Exec(`INSERT INTO request_log (platform, model, provider, http_code, input_tokens, output_tokens, created_at) VALUES ('claude', 'claude-sonnet-4-5', 'p1', 200, 10, 5, ?)`,
+			now.UTC().Format(timeLayout)); err != nil {
+			t.Fatalf("写入请求日志失败: %v", err)
+		}
+	}
+
+	out, err := runCLIForTest(t, "blacklist", "list", "--json")
+	if err != nil {
+		t.Fatalf("列出黑名单失败: %v", err)
+	}
+	var statuses []BlacklistStatus
+	if err := json.Unmarshal([]byte(out), &statuses); err != nil || len(statuses) != 1 || !statuses[0].IsBlacklisted {
+		t.Fatalf("黑名单输出不符: %v\n%s", err, out)
+	}
+	if _, err := runCLIForTest(t, "blacklist", "unblock", "p1"); err == nil {
+		t.Error("缺少平台应报错")
+	}
+	if _, err := runCLIForTest(t, "blacklist", "unblock", "--platform", "codex", "p1"); err != nil {
+		t.Fatalf("解除拉黑失败: %v", err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if blocked, _ := NewBlacklistService(NewSettingsService(), nil).GetBlacklistStatus("codex"); len(blocked) == 1 && !blocked[0].IsBlacklisted {
+			if blocked[0].BlacklistLevel != 2 {
+				t.Errorf("解除拉黑应保留等级: %+v", blocked[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("解除拉黑未生效")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	out, err = runCLIForTest(t, "logs", "-n", "2", "--json")
+	if err != nil {
+		t.Fatalf("读取日志失败: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"id":2`) || !strings.Contains(lines[1], `"id":3`) {
+		t.Errorf("应按时间正序输出最近 2 条: %s", out)
+	}
+
+	out, err = runCLIForTest(t, "stats", "--platform", "claude", "--json")
+	if err != nil {
+		t.Fatalf("统计失败: %v", err)
+	}
+	var stats cliStats
+	if err := json.Unmarshal([]byte(out), &stats); err != nil || stats.Today.TotalRequests != 3 || len(stats.Providers) != 1 {
+		t.Errorf("统计输出不符: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,531 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 客户端访问令牌：代理监听到局域网/WSL 可达地址后，网络上任何人都能借它消耗供应商额度。
+// 开启后代理对每个路由校验客户端携带的令牌（Authorization Bearer / x-api-key /
+// x-goog-api-key / ?key=，即各 CLI 原本发送占位 token 的位置），令牌名记入请求日志。
+// 配置存于 ~/.code-switch/client-tokens.json（0600），手工编辑同样生效。
+
+const (
+	clientTokensFile = "client-tokens.json"
+	// relayPlaceholderToken 未开启客户端认证时注入 CLI 配置的占位 token
+	relayPlaceholderToken = "code-switch-r"
+	clientTokenPrefix     = "csr-"
+	clientTokenNameLimit  = 64
+	// relayClientNameKey 存于 gin.Context：通过认证的客户端令牌名
+	relayClientNameKey = "relayClientName"
+)
+
+// ClientToken 客户端访问令牌
+type ClientToken struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	// Platforms 可访问的平台（claude/codex/openai/gemini/custom:<工具ID>，支持通配符），空为全部
+	Platforms []string   `json:"platforms,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"` // 过期时间，空为永不过期
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// ClientAuthConfig 客户端认证配置
+type ClientAuthConfig struct {
+	Enabled bool `json:"enabled"`
+	// CLIToken 自动注入本机与 WSL 中 CLI 配置的令牌名
+	CLIToken string        `json:"cliToken,omitempty"`
+	Tokens   []ClientToken `json:"tokens"`
+	// Quotas 按客户端的每日配额（见 client_quota.go）
+	Quotas []ClientQuota `json:"quotas,omitempty"`
+}
+
+// expired 令牌在 now 时刻是否已过期
+func (t *ClientToken) expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// allows 令牌是否可访问该平台；platform 为空（如 /v1/models）只校验身份
+func (t *ClientToken) allows(platform string) bool {
+	if len(t.Platforms) == 0 || platform == "" {
+		return true
+	}
+	return matchAnyWildcard(t.Platforms, platform)
+}
+
+// find 按令牌值查找（常量时间比较，避免逐字节计时泄露）
+func (cfg *ClientAuthConfig) find(token string) *ClientToken {
+	if token == "" {
+		return nil
+	}
+	var found *ClientToken
+	for i := range cfg.Tokens {
+		if subtle.ConstantTimeCompare([]byte(cfg.Tokens[i].Token), []byte(token)) == 1 {
+			found = &cfg.Tokens[i]
+		}
+	}
+	return found
+}
+
+// tokenByName 按名称查找令牌
+func (cfg *ClientAuthConfig) tokenByName(name string) *ClientToken {
+	for i := range cfg.Tokens {
+		if cfg.Tokens[i].Name == name {
+			return &cfg.Tokens[i]
+		}
+	}
+	return nil
+}
+
+// validateClientToken 校验单个令牌的名称、平台范围与令牌值
+func validateClientToken(t *ClientToken) []string {
+	var errs []string
+	name := strings.TrimSpace(t.Name)
+	if name == "" {
+		errs = append(errs, "令牌名称不能为空")
+	} else if len([]rune(name)) > clientTokenNameLimit {
+		errs = append(errs, fmt.Sprintf("令牌名称最多 %d 个字符: %s", clientTokenNameLimit, name))
+	}
+	if len(t.Token) < 16 {
+		errs = append(errs, fmt.Sprintf("令牌 %s 的值过短（至少 16 个字符）", name))
+	}
+	for _, p := range t.Platforms {
+		if strings.TrimSpace(p) == "" {
+			errs = append(errs, fmt.Sprintf("令牌 %s 的平台范围包含空值", name))
+		}
+	}
+	return errs
+}
+
+// validateClientAuthConfig 校验整份配置：名称与令牌值均须唯一，CLI 令牌须存在
+func validateClientAuthConfig(cfg *ClientAuthConfig) []string {
+	var errs []string
+	names := make(map[string]bool, len(cfg.Tokens))
+	values := make(map[string]bool, len(cfg.Tokens))
+	for i := range cfg.Tokens {
+		t := &cfg.Tokens[i]
+		errs = append(errs, validateClientToken(t)...)
+		if names[t.Name] {
+			errs = append(errs, fmt.Sprintf("令牌名称重复: %s", t.Name))
+		}
+		if values[t.Token] {
+			errs = append(errs, fmt.Sprintf("令牌值重复: %s", t.Name))
+		}
+		names[t.Name], values[t.Token] = true, true
+	}
+	if cfg.CLIToken != "" && !names[cfg.CLIToken] {
+		errs = append(errs, fmt.Sprintf("CLI 注入令牌不存在: %s", cfg.CLIToken))
+	}
+	errs = append(errs, validateClientQuotas(cfg.Quotas)...)
+	return errs
+}
+
+// generateClientToken 生成随机令牌值
+func generateClientToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成令牌失败: %w", err)
+	}
+	return clientTokenPrefix + hex.EncodeToString(buf), nil
+}
+
+// ---------- 存储 ----------
+
+// clientAuthStore 令牌文件的进程内缓存：按文件修改时间失效（与路由规则同一机制）
+type clientAuthStore struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	size    int64
+	cfg     *ClientAuthConfig
+}
+
+var clientAuth = &clientAuthStore{}
+
+func clientTokensPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("获取用户目录失败: %w", err)
+	}
+	return filepath.Join(home, appSettingsDir, clientTokensFile), nil
+}
+
+// load 返回当前配置（调用方不得修改返回值）；文件不存在视为未开启
+func (s *clientAuthStore) load() (*ClientAuthConfig, error) {
+	path, err := clientTokensPath()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loadLocked(path)
+}
+
+// loadLocked load 的实现，调用方须持有 s.mu
+func (s *clientAuthStore) loadLocked(path string) (*ClientAuthConfig, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.path, s.cfg = path, &ClientAuthConfig{}
+		s.modTime, s.size = time.Time{}, 0
+		return s.cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg != nil && s.path == path && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取客户端令牌失败: %w", err)
+	}
+	cfg := &ClientAuthConfig{}
+	if len(strings.TrimSpace(string(data))) > 0 {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("解析客户端令牌失败: %w", err)
+		}
+	}
+	s.path, s.cfg, s.modTime, s.size = path, cfg, info.ModTime(), info.Size()
+	return cfg, nil
+}
+
+// update 在最新配置的副本上执行 mutate，校验通过后落盘。
+// 读-改-写全程持锁，并发更新不会互相覆盖
+func (s *clientAuthStore) update(mutate func(cfg *ClientAuthConfig) error) (*ClientAuthConfig, error) {
+	path, err := clientTokensPath()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, err := s.loadLocked(path)
+	if err != nil {
+		return nil, err
+	}
+	next := &ClientAuthConfig{
+		Enabled:  current.Enabled,
+		CLIToken: current.CLIToken,
+		Tokens:   append([]ClientToken(nil), current.Tokens...),
+		Quotas:   append([]ClientQuota(nil), current.Quotas...),
+	}
+	if err := mutate(next); err != nil {
+		return nil, err
+	}
+	if errs := validateClientAuthConfig(next); len(errs) > 0 {
+		return nil, fmt.Errorf("客户端令牌校验失败: %s", strings.Join(errs, "；"))
+	}
+	if next.Enabled && len(next.Tokens) == 0 {
+		return nil, errors.New("开启客户端认证前至少需要一个令牌")
+	}
+	data, err := json.MarshalIndent(next, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("序列化客户端令牌失败: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("创建配置目录失败: %w", err)
+	}
+	// 文件含明文令牌，仅当前用户可读
+	if err := atomicWriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("写入客户端令牌失败: %w", err)
+	}
+	s.cfg = nil // 下次读取按新文件重建缓存
+	return next, nil
+}
+
+// cliAuthToken 返回写入 CLI 配置的认证 token：开启客户端认证且指定了 CLI 令牌时
+// 注入该令牌（仅当它覆盖该平台），否则沿用占位 token
+func cliAuthToken(platform string) string {
+	cfg, err := clientAuth.load()
+	if err != nil || !cfg.Enabled || cfg.CLIToken == "" {
+		return relayPlaceholderToken
+	}
+	if t := cfg.tokenByName(cfg.CLIToken); t != nil && t.allows(platform) {
+		return t.Token
+	}
+	return relayPlaceholderToken
+}
+
+// isRelayAuthToken 判断 CLI 配置里的 token 是否由代理注入（占位值、旧版占位值或任一客户端令牌），
+// 关闭代理时据此决定能否删除该字段
+func isRelayAuthToken(value string) bool {
+	if value == relayPlaceholderToken || value == "code-switch" {
+		return true
+	}
+	cfg, err := clientAuth.load()
+	return err == nil && cfg.find(value) != nil
+}
+
+// ---------- 代理侧校验 ----------
+
+// clientCredentialOf 取客户端携带的凭据：各 CLI 的占位 token 所在位置即令牌位置
+func clientCredentialOf(r *http.Request) string {
+	if v := strings.TrimSpace(r.Header.Get("Authorization")); v != "" {
+		if len(v) > 7 && strings.EqualFold(v[:7], "bearer ") {
+			return strings.TrimSpace(v[7:])
+		}
+		return v
+	}
+	for _, name := range []string{"x-api-key", "x-goog-api-key", "api-key"} {
+		if v := strings.TrimSpace(r.Header.Get(name)); v != "" {
+			return v
+		}
+	}
+	return r.URL.Query().Get("key")
+}
+
+// clientAuthPlatform 按命中的路由推断平台；/v1/models 等跨平台路由返回空
+func clientAuthPlatform(c *gin.Context) string {
+	switch path := c.FullPath(); {
+	case path == "/v1/messages":
+		return "claude"
+	case path == "/responses":
+		return "codex"
+	case path == "/v1/chat/completions":
+		return "openai"
+	case strings.HasPrefix(path, "/gemini/"):
+		return "gemini"
+	case strings.HasPrefix(path, "/custom/:toolId/"):
+		return "custom:" + c.Param("toolId")
+	default:
+		return ""
+	}
+}
+
+// clientNameOf 取本次请求的客户端标识：认证令牌名，未开启认证时为自报标识，无法识别时为空
+func clientNameOf(c *gin.Context) string {
+	if c == nil {
+		return ""
+	}
+	return c.GetString(relayClientNameKey)
+}
+
+// clientAuthMiddleware 开启客户端认证时校验每个请求的令牌：缺失/无效/过期 401，平台越权 403。
+// 配置读取失败时拒绝服务（不能因文件损坏而放开认证）
+func clientAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 管理路由与 /metrics 由管理令牌单独认证（见 admin_api.go）
+		if usesAdminToken(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		cfg, err := clientAuth.load()
+		if err != nil {
+			fmt.Printf("[ClientAuth] 读取客户端令牌失败，拒绝请求: %v\n", err)
+			respondClientAuthError(c, clientAuthPlatform(c), http.StatusServiceUnavailable, "客户端认证配置读取失败")
+			return
+		}
+		if !cfg.Enabled {
+			// 未开启认证时客户端可自报标识，仅用于用量归属与配额
+			if name := clientNameFromHeader(c.GetHeader(clientNameHeader)); name != "" {
+				c.Set(relayClientNameKey, name)
+			}
+			c.Next()
+			return
+		}
+		platform := clientAuthPlatform(c)
+		token := cfg.find(clientCredentialOf(c.Request))
+		switch {
+		case token == nil:
+			respondClientAuthError(c, platform, http.StatusUnauthorized, "缺少或无效的客户端访问令牌")
+			return
+		case token.expired(time.Now()):
+			respondClientAuthError(c, platform, http.StatusUnauthorized, fmt.Sprintf("客户端访问令牌 %s 已过期", token.Name))
+			return
+		case !token.allows(platform):
+			respondClientAuthError(c, platform, http.StatusForbidden, fmt.Sprintf("客户端访问令牌 %s 无权访问 %s", token.Name, platform))
+			return
+		}
+		c.Set(relayClientNameKey, token.Name)
+		c.Next()
+	}
+}
+
+// respondClientAuthError 按平台给各自协议兼容的认证错误结构
+func respondClientAuthError(c *gin.Context, platform string, status int, msg string) {
+	switch {
+	case isOpenAIClientPlatform(platform):
+		c.AbortWithStatusJSON(status, gin.H{
+			"error": gin.H{
+				"type":    "invalid_request_error",
+				"code":    "invalid_client_token",
+				"message": msg,
+			},
+		})
+	case platform == "gemini":
+		statusText := "UNAUTHENTICATED"
+		if status == http.StatusForbidden {
+			statusText = "PERMISSION_DENIED"
+		}
+		c.AbortWithStatusJSON(status, gin.H{
+			"error": gin.H{
+				"code":    status,
+				"status":  statusText,
+				"message": msg,
+			},
+		})
+	default:
+		errType := "authentication_error"
+		if status == http.StatusForbidden {
+			errType = "permission_error"
+		}
+		c.AbortWithStatusJSON(status, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"type":    errType,
+				"code":    "invalid_client_token",
+				"message": msg,
+			},
+			"message": msg,
+		})
+	}
+}
+
+// ---------- 管理接口 ----------
+
+// GetClientAuthConfig 读取客户端认证配置
+func (ns *NetworkService) GetClientAuthConfig() (ClientAuthConfig, error) {
+	cfg, err := clientAuth.load()
+	if err != nil {
+		return ClientAuthConfig{}, err
+	}
+	out := *cfg
+	out.Tokens = append([]ClientToken{}, cfg.Tokens...)
+	out.Quotas = append([]ClientQuota{}, cfg.Quotas...)
+	return out, nil
+}
+
+// CreateClientToken 生成新令牌；expiresAt 为 RFC3339 时间，空为永不过期。
+// 第一个令牌自动设为 CLI 注入令牌
+func (ns *NetworkService) CreateClientToken(name string, platforms []string, expiresAt string) (*ClientToken, error) {
+	value, err := generateClientToken()
+	if err != nil {
+		return nil, err
+	}
+	token := ClientToken{Name: strings.TrimSpace(name), Token: value, CreatedAt: time.Now()}
+	for _, p := range platforms {
+		if p = strings.TrimSpace(p); p != "" {
+			token.Platforms = append(token.Platforms, p)
+		}
+	}
+	if expiresAt = strings.TrimSpace(expiresAt); expiresAt != "" {
+		t, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("过期时间格式无效: %w", err)
+		}
+		if !t.After(time.Now()) {
+			return nil, errors.New("过期时间必须晚于当前时间")
+		}
+		token.ExpiresAt = &t
+	}
+	cfg, err := clientAuth.update(func(cfg *ClientAuthConfig) error {
+		if cfg.tokenByName(token.Name) != nil {
+			return fmt.Errorf("令牌名称已存在: %s", token.Name)
+		}
+		cfg.Tokens = append(cfg.Tokens, token)
+		if cfg.CLIToken == "" {
+			cfg.CLIToken = token.Name
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ns.refreshCLIAuthTokens(cfg)
+	return &token, nil
+}
+
+// DeleteClientToken 删除令牌；删除 CLI 注入令牌时改用剩余的第一个，删光则自动关闭认证
+func (ns *NetworkService) DeleteClientToken(name string) error {
+	cfg, err := clientAuth.update(func(cfg *ClientAuthConfig) error {
+		kept := cfg.Tokens[:0]
+		for _, t := range cfg.Tokens {
+			if t.Name != name {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == len(cfg.Tokens) {
+			return fmt.Errorf("令牌不存在: %s", name)
+		}
+		cfg.Tokens = kept
+		if cfg.CLIToken == name {
+			cfg.CLIToken = ""
+			if len(kept) > 0 {
+				cfg.CLIToken = kept[0].Name
+			}
+		}
+		if len(kept) == 0 {
+			cfg.Enabled = false
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	ns.refreshCLIAuthTokens(cfg)
+	return nil
+}
+
+// SetClientAuthEnabled 开关客户端认证
+func (ns *NetworkService) SetClientAuthEnabled(enabled bool) error {
+	cfg, err := clientAuth.update(func(cfg *ClientAuthConfig) error {
+		cfg.Enabled = enabled
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	ns.refreshCLIAuthTokens(cfg)
+	return nil
+}
+
+// SetCLIClientToken 指定注入 CLI 配置的令牌
+func (ns *NetworkService) SetCLIClientToken(name string) error {
+	cfg, err := clientAuth.update(func(cfg *ClientAuthConfig) error {
+		cfg.CLIToken = strings.TrimSpace(name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	ns.refreshCLIAuthTokens(cfg)
+	return nil
+}
+
+// refreshCLIAuthTokens 注入令牌变化后，为已开启代理的本机 CLI 重写认证字段，
+// 否则它们仍带着旧 token 被拒。WSL 中的配置需重新执行"配置 WSL 客户端"
+func (ns *NetworkService) refreshCLIAuthTokens(cfg *ClientAuthConfig) {
+	fmt.Printf("[ClientAuth] 客户端认证: enabled=%v tokens=%d cli=%s\n", cfg.Enabled, len(cfg.Tokens), cfg.CLIToken)
+	if ns.claudeService != nil {
+		if status, err := ns.claudeService.ProxyStatus(); err == nil && status.Enabled {
+			if err := ns.claudeService.EnableProxy(); err != nil {
+				fmt.Printf("[ClientAuth] 更新 Claude Code 令牌失败: %v\n", err)
+			}
+		}
+	}
+	if ns.codexService != nil {
+		if status, err := ns.codexService.ProxyStatus(); err == nil && status.Enabled {
+			if err := ns.codexService.EnableProxy(); err != nil {
+				fmt.Printf("[ClientAuth] 更新 Codex 令牌失败: %v\n", err)
+			}
+		}
+	}
+	if ns.geminiService != nil {
+		if status, err := ns.geminiService.ProxyStatus(); err == nil && status != nil && status.Enabled {
+			if err := ns.geminiService.EnableProxy(); err != nil {
+				fmt.Printf("[ClientAuth] 更新 Gemini CLI 令牌失败: %v\n", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestClientCredentialOf(t *testing.T) {
+	cases := []struct {
+		header, value, query, want string
+	}{
+		{"Authorization", "Bearer csr-a", "", "csr-a"},
+		{"Authorization", "bearer  csr-b ", "", "csr-b"},
+		{"x-api-key", "csr-c", "", "csr-c"},
+		{"x-goog-api-key", "csr-d", "", "csr-d"},
+		{"api-key", "csr-e", "", "csr-e"},
+		{"", "", "key=csr-f", "csr-f"},
+		{"", "", "", ""},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest("POST", "/v1/messages?"+tc.query, nil)
+		if tc.header != "" {
+			r.Header.Set(tc.header, tc.value)
+		}
+		if got := clientCredentialOf(r); got != tc.want {
+			t.Errorf("%s=%q query=%q: 期望 %q，实际 %q", tc.header, tc.value, tc.query, tc.want, got)
+		}
+	}
+}
+
+// cliAuthToken 仅在开启认证且 CLI 令牌覆盖该平台时注入真实令牌
+func TestCLIAuthTokenInjection(t *testing.T) {
+	setupRenameTestEnv(t)
+	ns := &NetworkService{}
+
+	if got := cliAuthToken("claude"); got != relayPlaceholderToken {
+		t.Fatalf("未配置令牌应使用占位值: %s", got)
+	}
+	token, err := ns.CreateClientToken("laptop", []string{"claude", "custom:*"}, "")
+	if err != nil {
+		t.Fatalf("创建令牌失败: %v", err)
+	}
+	if !strings.HasPrefix(token.Token, clientTokenPrefix) {
+		t.Fatalf("令牌前缀不符: %s", token.Token)
+	}
+	if got := cliAuthToken("claude"); got != relayPlaceholderToken {
+		t.Fatalf("未开启认证时仍应使用占位值: %s", got)
+	}
+	if err := ns.SetClientAuthEnabled(true); err != nil {
+		t.Fatalf("开启认证失败: %v", err)
+	}
+	if got := cliAuthToken("claude"); got != token.Token {
+		t.Errorf("开启认证后应注入 CLI 令牌: %s", got)
+	}
+	if got := cliAuthToken("custom:opencode"); got != token.Token {
+		t.Errorf("通配平台应注入 CLI 令牌: %s", got)
+	}
+	if got := cliAuthToken("codex"); got != relayPlaceholderToken {
+		t.Errorf("令牌未覆盖的平台应回退占位值: %s", got)
+	}
+	if !isRelayAuthToken(token.Token) || !isRelayAuthToken(relayPlaceholderToken) || isRelayAuthToken("sk-user") {
+		t.Error("isRelayAuthToken 判定不符")
+	}
+
+	if _, err := ns.CreateClientToken("laptop", nil, ""); err == nil {
+		t.Error("重名令牌应被拒绝")
+	}
+	if _, err := ns.CreateClientToken("old", nil, time.Now().Add(-time.Hour).Format(time.RFC3339)); err == nil {
+		t.Error("过期时间早于当前应被拒绝")
+	}
+	if err := ns.SetCLIClientToken("missing"); err == nil {
+		t.Error("指定不存在的 CLI 令牌应被拒绝")
+	}
+	if err := ns.DeleteClientToken("laptop"); err != nil {
+		t.Fatalf("删除令牌失败: %v", err)
+	}
+	cfg, err := ns.GetClientAuthConfig()
+	if err != nil || cfg.Enabled || cfg.CLIToken != "" || len(cfg.Tokens) != 0 {
+		t.Errorf("删光令牌后应自动关闭认证: %+v %v", cfg, err)
+	}
+}
+
+// 开启认证后所有路由校验令牌：缺失/无效/过期 401，平台越权 403，通过后令牌名记入请求日志
+func TestClientAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+	db, err := xdb.DB("default")
+	if err != nil {
+		t.Fatalf("获取数据库失败: %v", err)
+	}
+	if err := ensureRequestLogTableWithDB(db); err != nil {
+		t.Fatalf("建表失败: %v", err)
+	}
+	oldQueue := GlobalDBQueueLogs
+	GlobalDBQueueLogs = NewDBWriteQueue(db, 100, true)
+	t.Cleanup(func() {
+		_ = GlobalDBQueueLogs.Shutdown(time.Second)
+		GlobalDBQueueLogs = oldQueue
+	})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer csr-") || strings.HasPrefix(r.Header.Get("x-api-key"), "csr-") {
+			t.Error("客户端令牌不应转发给上游")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"usage":{"input_tokens":3,"output_tokens":1}}`))
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{{ID: 1, Name: "p", APIURL: upstream.URL, APIKey: "k", Enabled: true}}); err != nil {
+		t.Fatalf("保存供应商失败: %v", err)
+	}
+	router := gin.New()
+	newTestRelayService(ps).registerRoutes(router)
+	send := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(`{"model":"claude-sonnet-4-5","messages":[{"role":"user","content":"hi"}]}`))
+		if token != "" {
+			req.Header.Set("x-api-key", token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send("/v1/messages", ""); w.Code != http.StatusOK {
+		t.Fatalf("未开启认证应放行: %d %s", w.Code, w.Body.String())
+	}
+
+	ns := &NetworkService{}
+	full, err := ns.CreateClientToken("desktop", nil, "")
+	if err != nil {
+		t.Fatalf("创建令牌失败: %v", err)
+	}
+	codexOnly, err := ns.CreateClientToken("ci", []string{"codex"}, "")
+	if err != nil {
+		t.Fatalf("创建令牌失败: %v", err)
+	}
+	if err := ns.SetClientAuthEnabled(true); err != nil {
+		t.Fatalf("开启认证失败: %v", err)
+	}
+
+	w := send("/v1/messages", "")
+	if w.Code != http.StatusUnauthorized || gjson.Get(w.Body.String(), "error.type").String() != "authentication_error" {
+		t.Errorf("缺少令牌应 401: %d %s", w.Code, w.Body.String())
+	}
+	if w := send("/v1/messages", relayPlaceholderToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("占位令牌应 401: %d", w.Code)
+	}
+	if w := send("/v1/messages", codexOnly.Token); w.Code != http.StatusForbidden {
+		t.Errorf("越权平台应 403: %d", w.Code)
+	}
+	if w := send("/custom/tool/v1/messages", codexOnly.Token); w.Code != http.StatusForbidden {
+		t.Errorf("自定义 CLI 路由同样校验平台: %d", w.Code)
+	}
+	w = send("/responses", "bad-token")
+	if w.Code != http.StatusUnauthorized || gjson.Get(w.Body.String(), "error.code").String() != "invalid_client_token" {
+		t.Errorf("Codex 路由应返回 OpenAI 风格 401: %d %s", w.Code, w.Body.String())
+	}
+
+	if _, err := clientAuth.update(func(cfg *ClientAuthConfig) error {
+		past := time.Now().Add(-time.Minute)
+		cfg.Tokens = append(cfg.Tokens, ClientToken{Name: "expired", Token: "csr-expired-token-value", ExpiresAt: &past})
+		return nil
+	}); err != nil {
+		t.Fatalf("写入过期令牌失败: %v", err)
+	}
+	if w := send("/v1/messages", "csr-expired-token-value"); w.Code != http.StatusUnauthorized {
+		t.Errorf("过期令牌应 401: %d", w.Code)
+	}
+
+	if w := send("/v1/messages", full.Token); w.Code != http.StatusOK {
+		t.Fatalf("有效令牌应放行: %d %s", w.Code, w.Body.String())
+	}
+
+	var names []string
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		names = names[:0]
+		rows, err := db.Query(`SELECT client_name FROM request_log ORDER BY id`)
+		if err != nil {
+			t.Fatalf("查询请求日志失败: %v", err)
+		}
+		for rows.Next() {
+			var name string
+			_ = rows.Scan(&name)
+			names = append(names, name)
+		}
+		rows.Close()
+		if len(names) >= 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(names) != 2 || names[0] != "" || names[1] != "desktop" {
+		t.Errorf("请求日志应记录客户端令牌名: %v", names)
+	}
+}
+
+func TestClientAuthStoreConcurrentUpdate(t *testing.T) {
+	setupRenameTestEnv(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := clientAuth.update(func(cfg *ClientAuthConfig) error {
+				time.Sleep(time.Millisecond) // 放大读与写之间的窗口
+				cfg.Tokens = append(cfg.Tokens, ClientToken{Name: fmt.Sprintf("t%d", i), Token: fmt.Sprintf("csr-concurrent-token-%d", i)})
+				return nil
+			})
+			if err != nil {
+				t.Errorf("更新失败: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	cfg, err := clientAuth.load()
+	if err != nil {
+		t.Fatalf("读取失败: %v", err)
+	}
+	if len(cfg.Tokens) != 8 {
+		t.Fatalf("并发更新丢失了令牌: %d", len(cfg.Tokens))
+	}
+}
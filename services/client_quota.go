@@ -0,0 +1,228 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+)
+
+// 按客户端的用量归属与每日配额：一个 Code Switch 实例供多名成员或多台机器共用时，
+// 请求日志的 client_name 记录客户端标识——开启客户端认证时为令牌名（不可伪造），
+// 否则取请求头 X-Code-Switch-Client（自报，仅用于归属统计）。
+// 配额与令牌同存于 client-tokens.json，按自然日限制 token 或费用，
+// 用量与消费上限共用 spendTracker 的近似统计（每分钟按日志库校准）。
+
+const (
+	// clientNameHeader 未开启客户端认证时客户端自报标识的请求头（不转发给上游）
+	clientNameHeader = "X-Code-Switch-Client"
+	// clientQuotaDefault 配额的客户端为 * 时作为每个客户端各自的默认配额
+	clientQuotaDefault = "*"
+)
+
+// ClientQuota 客户端每日配额，两项均为 0 表示不限
+type ClientQuota struct {
+	// Client 客户端标识（令牌名或自报名），* 为未单独配置的客户端的默认配额
+	Client      string  `json:"client"`
+	DailyTokens int64   `json:"dailyTokens,omitempty"` // 每日 token 上限（输入 + 输出 + 缓存写入）
+	DailyCost   float64 `json:"dailyCost,omitempty"`   // 每日费用上限（美元，含价格倍率）
+}
+
+// ClientDailyStat 客户端今日用量
+type ClientDailyStat struct {
+	Client             string  `json:"client"`
+	TotalRequests      int64   `json:"total_requests"`
+	SuccessfulRequests int64   `json:"successful_requests"`
+	FailedRequests     int64   `json:"failed_requests"`
+	SuccessRate        float64 `json:"success_rate"`
+	InputTokens        int64   `json:"input_tokens"`
+	OutputTokens       int64   `json:"output_tokens"`
+	ReasoningTokens    int64   `json:"reasoning_tokens"`
+	CacheCreateTokens  int64   `json:"cache_create_tokens"`
+	CacheReadTokens    int64   `json:"cache_read_tokens"`
+	CostTotal          float64 `json:"cost_total"`
+}
+
+// quotaTokens 计入配额的 token：缓存读取按极低价计费且量级远大于其余各项，不计入
+func quotaTokens(input, output, cacheCreate int64) int64 {
+	return input + output + cacheCreate
+}
+
+// clientNameFromHeader 取客户端自报标识；含控制字符或超长视为无效
+func clientNameFromHeader(value string) string {
+	name := strings.TrimSpace(value)
+	if name == "" || len([]rune(name)) > clientTokenNameLimit {
+		return ""
+	}
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return ""
+	}
+	return name
+}
+
+// quotaFor 客户端生效的配额：单独配置优先，其次 *；都没有返回 nil
+func (cfg *ClientAuthConfig) quotaFor(client string) *ClientQuota {
+	var fallback *ClientQuota
+	for i := range cfg.Quotas {
+		switch cfg.Quotas[i].Client {
+		case client:
+			return &cfg.Quotas[i]
+		case clientQuotaDefault:
+			fallback = &cfg.Quotas[i]
+		}
+	}
+	return fallback
+}
+
+// validateClientQuotas 校验配额：客户端必填且唯一，上限不能为负
+func validateClientQuotas(quotas []ClientQuota) []string {
+	var errs []string
+	seen := make(map[string]bool, len(quotas))
+	for _, q := range quotas {
+		client := strings.TrimSpace(q.Client)
+		if client == "" {
+			errs = append(errs, "配额的客户端不能为空")
+			continue
+		}
+		if seen[client] {
+			errs = append(errs, fmt.Sprintf("客户端配额重复: %s", client))
+		}
+		seen[client] = true
+		if q.DailyTokens < 0 {
+			errs = append(errs, fmt.Sprintf("客户端 %s 的每日 token 上限不能为负", client))
+		}
+		if q.DailyCost < 0 || math.IsNaN(q.DailyCost) || math.IsInf(q.DailyCost, 0) {
+			errs = append(errs, fmt.Sprintf("客户端 %s 的每日费用上限必须是非负数", client))
+		}
+	}
+	return errs
+}
+
+// clientUsageSince 统计客户端 startTime 起的费用与计入配额的 token（仅 request_log，心跳不归属客户端）
+func (ls *LogService) clientUsageSince(startTime time.Time, client string) (float64, int64, error) {
+	records, err := xdb.New("request_log").Selects(
+		xdb.WhereGte("created_at", startTime.UTC().Format(timeLayout)),
+		xdb.WhereEq("client_name", client),
+		xdb.Field(
+			"model",
+			"input_tokens",
+			"output_tokens",
+			"reasoning_tokens",
+			"cache_create_tokens",
+			"cache_read_tokens",
+			"ephemeral_5m_tokens",
+			"ephemeral_1h_tokens",
+			"service_tier",
+			"price_multiplier",
+		),
+	)
+	if err != nil {
+		if errors.Is(err, xdb.ErrNotFound) || isNoSuchTableErr(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	var cost float64
+	var tokens int64
+	for _, record := range records {
+		usage := buildSnapshotFromRecord(record)
+		cost += ls.calculateCost(record.GetString("model"), usage, recordPriceMultiplier(record)).TotalCost
+		tokens += quotaTokens(int64(usage.InputTokens), int64(usage.OutputTokens), int64(usage.CacheCreateTokens))
+	}
+	return cost, tokens, nil
+}
+
+// ClientDailyStats 按客户端汇总今日用量；未识别客户端的请求记为 (unknown)
+func (ls *LogService) ClientDailyStats(platform string) ([]ClientDailyStat, error) {
+	stats, err := ls.dailyStatsBy(platform, "client_name")
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ClientDailyStat, 0, len(stats))
+	for _, s := range stats {
+		out = append(out, ClientDailyStat{
+			Client:             s.Provider,
+			TotalRequests:      s.TotalRequests,
+			SuccessfulRequests: s.SuccessfulRequests,
+			FailedRequests:     s.FailedRequests,
+			SuccessRate:        s.SuccessRate,
+			InputTokens:        s.InputTokens,
+			OutputTokens:       s.OutputTokens,
+			ReasoningTokens:    s.ReasoningTokens,
+			CacheCreateTokens:  s.CacheCreateTokens,
+			CacheReadTokens:    s.CacheReadTokens,
+			CostTotal:          s.CostTotal,
+		})
+	}
+	return out, nil
+}
+
+// clientQuotaExceeded 当前客户端今日用量达到配额时返回提示与重置时刻（次日 0 点）。
+// 未识别客户端不受配额限制；统计失败时放行（与消费上限一致）
+func (prs *ProviderRelayService) clientQuotaExceeded(c *gin.Context) (bool, string, time.Time) {
+	client := clientNameOf(c)
+	if client == "" || prs.spend == nil {
+		return false, "", time.Time{}
+	}
+	cfg, err := clientAuth.load()
+	if err != nil {
+		return false, "", time.Time{}
+	}
+	quota := cfg.quotaFor(client)
+	if quota == nil || (quota.DailyTokens <= 0 && quota.DailyCost <= 0) {
+		return false, "", time.Time{}
+	}
+	start, next := spendCapWindow(prs.spend.now(), "daily")
+	cost, tokens, err := prs.spend.clientUsage(client, start)
+	if err != nil {
+		fmt.Printf("[WARN] 统计客户端 %s 用量失败，本次不限制: %v\n", client, err)
+		return false, "", time.Time{}
+	}
+	var msg string
+	switch {
+	case quota.DailyTokens > 0 && tokens >= quota.DailyTokens:
+		msg = fmt.Sprintf("客户端 %s 今日 token 用量 %d 已达配额 %d，将于 %s 重置",
+			client, tokens, quota.DailyTokens, next.Format("2006-01-02 15:04"))
+	case quota.DailyCost > 0 && cost >= quota.DailyCost:
+		msg = fmt.Sprintf("客户端 %s 今日费用 $%.2f 已达配额 $%.2f，将于 %s 重置",
+			client, cost, quota.DailyCost, next.Format("2006-01-02 15:04"))
+	default:
+		return false, "", time.Time{}
+	}
+	return true, msg, next
+}
+
+// rejectOverQuotaClient 客户端超出每日配额时回 429 并返回 true
+func (prs *ProviderRelayService) rejectOverQuotaClient(c *gin.Context, kind string) bool {
+	exceeded, msg, resetAt := prs.clientQuotaExceeded(c)
+	if !exceeded {
+		return false
+	}
+	fmt.Printf("[WARN] %s\n", msg)
+	respondBudgetExhausted(c, kind, "client_quota_exhausted", msg, resetAt)
+	return true
+}
+
+// SetClientQuota 设置客户端每日配额（client 为 * 即默认配额）；两项均为 0 时删除该配额
+func (ns *NetworkService) SetClientQuota(client string, dailyTokens int64, dailyCost float64) error {
+	client = strings.TrimSpace(client)
+	_, err := clientAuth.update(func(cfg *ClientAuthConfig) error {
+		kept := cfg.Quotas[:0]
+		for _, q := range cfg.Quotas {
+			if q.Client != client {
+				kept = append(kept, q)
+			}
+		}
+		cfg.Quotas = kept
+		if dailyTokens != 0 || dailyCost != 0 {
+			cfg.Quotas = append(cfg.Quotas, ClientQuota{Client: client, DailyTokens: dailyTokens, DailyCost: dailyCost})
+		}
+		return nil
+	})
+	return err
+}
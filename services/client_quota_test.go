@@ -0,0 +1,126 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestClientQuotaResolution(t *testing.T) {
+	cfg := &ClientAuthConfig{Quotas: []ClientQuota{
+		{Client: "*", DailyCost: 5},
+		{Client: "alice", DailyTokens: 100},
+	}}
+	if q := cfg.quotaFor("alice"); q == nil || q.DailyTokens != 100 {
+		t.Errorf("单独配置应优先于默认配额: %+v", q)
+	}
+	if q := cfg.quotaFor("bob"); q == nil || q.DailyCost != 5 {
+		t.Errorf("未单独配置的客户端应使用默认配额: %+v", q)
+	}
+	if q := (&ClientAuthConfig{}).quotaFor("bob"); q != nil {
+		t.Errorf("未配置配额应返回 nil: %+v", q)
+	}
+
+	if errs := validateClientQuotas([]ClientQuota{{Client: "a", DailyTokens: -1}, {Client: "a"}, {Client: " "}}); len(errs) != 3 {
+		t.Errorf("应报告负数、重复与空客户端: %v", errs)
+	}
+	if got := clientNameFromHeader("  laptop  "); got != "laptop" {
+		t.Errorf("自报标识应去除空白: %q", got)
+	}
+	if clientNameFromHeader("a\x00b") != "" || clientNameFromHeader(strings.Repeat("x", 65)) != "" {
+		t.Error("含控制字符或超长的自报标识应无效")
+	}
+}
+
+// 客户端按自报标识归属用量；超出每日 token 配额后 429，其余客户端不受影响
+func TestClientQuotaEnforcedInRelay(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+	db, err := xdb.DB("default")
+	if err != nil {
+		t.Fatalf("获取数据库失败: %v", err)
+	}
+	if err := ensureRequestLogTableWithDB(db); err != nil {
+		t.Fatalf("建表失败: %v", err)
+	}
+	oldQueue := GlobalDBQueueLogs
+	GlobalDBQueueLogs = NewDBWriteQueue(db, 100, true)
+	t.Cleanup(func() {
+		_ = GlobalDBQueueLogs.Shutdown(time.Second)
+		GlobalDBQueueLogs = oldQueue
+	})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(clientNameHeader) != "" {
+			t.Error("客户端标识头不应转发给上游")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"usage":{"input_tokens":30,"output_tokens":5,"cache_read_input_tokens":1000}}`))
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{{ID: 1, Name: "p", APIURL: upstream.URL, APIKey: "k", Enabled: true}}); err != nil {
+		t.Fatalf("保存供应商失败: %v", err)
+	}
+	if err := (&NetworkService{}).SetClientQuota("alice", 30, 0); err != nil {
+		t.Fatalf("设置配额失败: %v", err)
+	}
+	router := gin.New()
+	newTestRelayService(ps).registerRoutes(router)
+	send := func(client string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5","messages":[{"role":"user","content":"hi"}]}`))
+		if client != "" {
+			req.Header.Set(clientNameHeader, client)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send("alice"); w.Code != http.StatusOK {
+		t.Fatalf("配额内应放行: %d %s", w.Code, w.Body.String())
+	}
+	w := send("alice")
+	if w.Code != http.StatusTooManyRequests || gjson.Get(w.Body.String(), "error.code").String() != "client_quota_exhausted" {
+		t.Fatalf("超出配额应 429: %d %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("配额用尽应带 Retry-After")
+	}
+	if w := send("bob"); w.Code != http.StatusOK {
+		t.Errorf("其他客户端不受影响: %d", w.Code)
+	}
+	if w := send(""); w.Code != http.StatusOK {
+		t.Errorf("未识别客户端不受配额限制: %d", w.Code)
+	}
+
+	var stats []ClientDailyStat
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if stats, err = NewLogService().ClientDailyStats("claude"); err == nil && len(stats) == 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	byClient := map[string]ClientDailyStat{}
+	for _, s := range stats {
+		byClient[s.Client] = s
+	}
+	if byClient["alice"].TotalRequests != 1 || byClient["bob"].InputTokens != 30 || byClient["(unknown)"].TotalRequests != 1 {
+		t.Errorf("按客户端汇总不符: %+v", stats)
+	}
+
+	if err := (&NetworkService{}).SetClientQuota("alice", 0, 0); err != nil {
+		t.Fatalf("删除配额失败: %v", err)
+	}
+	if cfg, _ := (&NetworkService{}).GetClientAuthConfig(); len(cfg.Quotas) != 0 {
+		t.Errorf("两项为 0 应删除配额: %+v", cfg.Quotas)
+	}
+}
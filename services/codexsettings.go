@@ -22,7 +22,6 @@ const (
 	codexProviderKey      = "code-switch-r"
 	codexEnvKey           = "OPENAI_API_KEY"
 	codexWireAPI          = "responses"
-	codexTokenValue       = "code-switch-r"
 )
 
 type CodexSettingsService struct {
@@ -172,7 +171,7 @@ func (css *CodexSettingsService) EnableProxy() error {
 			TargetPath:               settingsPath,
 			FileExisted:              fileExisted,
 			InjectedBaseURL:          css.baseURL(),
-			InjectedAuthToken:        codexTokenValue,
+			InjectedAuthToken:        cliAuthToken("codex"),
 			AuthFilePath:             authPath,
 			AuthFileExisted:          authFileExisted,
 			InjectedProviderKey:      codexProviderKey,
@@ -439,7 +438,7 @@ func (css *CodexSettingsService) surgicalRestoreAuthFile(state *ProxyState) erro
 
 	if state == nil {
 		// 兜底模式：仅删除代理 token
-		if currentKey == codexTokenValue {
+		if isRelayAuthToken(currentKey) {
 			delete(payload, codexEnvKey)
 			if len(payload) == 0 {
 				// 文件变空，删除文件
@@ -607,8 +606,8 @@ func (css *CodexSettingsService) writeAuthFile() error {
 		payload = make(map[string]any)
 	}
 
-	// 仅更新代理专用的 API Key
-	payload[codexEnvKey] = codexTokenValue
+	// 仅更新代理专用的 API Key（开启客户端认证时为 CLI 注入令牌）
+	payload[codexEnvKey] = cliAuthToken("codex")
 
 	return AtomicWriteJSON(authPath, payload)
 }
@@ -662,6 +661,10 @@ func (css *CodexSettingsService) ApplySingleProvider(providerID int) error {
 	if provider.APIKey == "" {
 		return fmt.Errorf("供应商 '%s' 未配置 API 密钥", provider.Name)
 	}
+	// Anthropic Messages 上游依赖代理做 Responses 协议转换，Codex 无法直连
+	if provider.ResolveUpstreamProtocol(provider.GetEffectiveEndpoint("/responses")) == UpstreamProtocolAnthropicMessages {
+		return fmt.Errorf("供应商 '%s' 的上游为 Anthropic Messages 协议，需通过本地代理转换，无法直连应用", provider.Name)
+	}
 
 	// 5. 获取配置文件路径
 	configPath, _, err := css.paths()
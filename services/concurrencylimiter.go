@@ -3,6 +3,8 @@ package services
 import (
 	"context"
 	"errors"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -28,6 +30,13 @@ type concurrencyEntry struct {
 	limit    int
 	gen      int64
 	inFlight int
+
+	// 速率令牌桶（见 ratelimit.go），与并发配额共用条目、锁和配置代数
+	rpm rateBucket
+	tpm rateBucket
+	// wakeTimer 令牌恢复时广播释放信号，唤醒因限速进入等待阶段的请求
+	wakeTimer *time.Timer
+	wakeAt    time.Time
 }
 
 // concurrencyLimiter 供应商并发配额的进程内登记表。
@@ -40,6 +49,8 @@ type concurrencyLimiter struct {
 	releaseGen chan struct{}
 	waiters    int
 	waitBudget time.Duration
+	// now 令牌桶计时用，测试可替换
+	now func() time.Time
 }
 
 func newConcurrencyLimiter() *concurrencyLimiter {
@@ -47,6 +58,7 @@ func newConcurrencyLimiter() *concurrencyLimiter {
 		entries:    make(map[string]*concurrencyEntry),
 		releaseGen: make(chan struct{}),
 		waitBudget: concurrencyWaitBudget,
+		now:        time.Now,
 	}
 }
 
@@ -62,24 +74,32 @@ func concurrencyKey(platform string, providerKey string) string {
 // 以便之后从不限改为有限时知道当前在途量）。gen 为调用方装载配置时的
 // 配置代数：更高代数才允许更新容量，防止在途旧副本把新容量改回去。
 func (l *concurrencyLimiter) TryAcquire(platform string, providerKey string, limit int, gen int64) bool {
-	key := concurrencyKey(platform, providerKey)
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	entry, _ := l.entryLocked(platform, providerKey, limit, gen)
+	if entry.limit > 0 && entry.inFlight >= entry.limit {
+		return false
+	}
+	entry.inFlight++
+	return true
+}
+
+// entryLocked 取（必要时新建）条目并按配置代数更新容量；返回本次是否持有最新配置
+func (l *concurrencyLimiter) entryLocked(platform string, providerKey string, limit int, gen int64) (*concurrencyEntry, bool) {
+	key := concurrencyKey(platform, providerKey)
 	entry, ok := l.entries[key]
 	if !ok {
 		entry = &concurrencyEntry{limit: limit, gen: gen}
 		l.entries[key] = entry
-	} else if gen >= entry.gen {
+		return entry, true
+	}
+	if gen >= entry.gen {
 		entry.limit = limit
 		entry.gen = gen
+		return entry, true
 	}
-
-	if entry.limit > 0 && entry.inFlight >= entry.limit {
-		return false
-	}
-	entry.inFlight++
-	return true
+	return entry, false
 }
 
 // Release 归还配额并广播"有释放发生"（换代唤醒全部等待者重扫）
@@ -98,6 +118,11 @@ func (l *concurrencyLimiter) Release(platform string, providerKey string) {
 	// 依据，删掉后携带旧代配置的在途请求会用旧容量重建条目。
 	// 条目数量以供应商数为界，常驻内存可忽略。
 
+	l.broadcastLocked()
+}
+
+// broadcastLocked 换代唤醒全部等待者重扫（调用方持有 l.mu）
+func (l *concurrencyLimiter) broadcastLocked() {
 	close(l.releaseGen)
 	l.releaseGen = make(chan struct{})
 }
@@ -157,15 +182,17 @@ type concurrencyBusyRef struct {
 	Gen   int64
 }
 
-// anyCapacity 只读检查：忙候选中是否有任一供应商已腾出空位。
+// anyCapacity 只读检查：忙候选中是否有任一供应商已腾出空位（并发有空且未限速）。
 // 等待阶段必须用它做唤醒门控——全局释放信号会被本轮实际尝试供应商的
 // 正常释放触发，不加门控直接重扫会形成自唤醒重试风暴。
+// 限速中的候选会确保挂着一个令牌恢复时刻的唤醒定时器。
 func (l *concurrencyLimiter) anyCapacity(platform string, pending map[string]concurrencyBusyRef) bool {
 	if len(pending) == 0 {
 		return false
 	}
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	now := l.now()
 	for _, ref := range pending {
 		entry, ok := l.entries[concurrencyKey(platform, ref.Key)]
 		if !ok {
@@ -176,7 +203,10 @@ func (l *concurrencyLimiter) anyCapacity(platform string, pending map[string]con
 		if ref.Gen >= entry.gen {
 			limit = ref.Limit
 		}
-		if limit <= 0 || entry.inFlight < limit {
+		if limit > 0 && entry.inFlight >= limit {
+			continue
+		}
+		if l.rateReadyLocked(entry, now) {
 			return true
 		}
 	}
@@ -192,3 +222,29 @@ func (l *concurrencyLimiter) snapshotInFlight(platform string, providerKey strin
 	}
 	return 0
 }
+
+// concurrencySnapshot 单个供应商配额条目的即时状态（/metrics 导出用）
+type concurrencySnapshot struct {
+	Platform    string
+	ProviderKey string
+	Limit       int
+	InFlight    int
+}
+
+// snapshot 读取全部条目与当前等待者数，按平台、供应商排序
+func (l *concurrencyLimiter) snapshot() ([]concurrencySnapshot, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]concurrencySnapshot, 0, len(l.entries))
+	for key, entry := range l.entries {
+		platform, providerKey, _ := strings.Cut(key, "\x00")
+		out = append(out, concurrencySnapshot{Platform: platform, ProviderKey: providerKey, Limit: entry.limit, InFlight: entry.inFlight})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Platform != out[j].Platform {
+			return out[i].Platform < out[j].Platform
+		}
+		return out[i].ProviderKey < out[j].ProviderKey
+	})
+	return out, l.waiters
+}
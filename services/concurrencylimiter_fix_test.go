@@ -441,3 +441,57 @@ func TestConcurrencyLimiterTombstoneKeepsGeneration(t *testing.T) {
 		t.Fatal("旧代容量 8 不得回写，第二个应被拒")
 	}
 }
+
+// 等待阶段重扫不应重复推进轮询：整个请求只排序一次，下一请求从紧接的供应商开始
+func TestConcurrencyWaitPhaseKeepsRoundRobinOrder(t *testing.T) {
+	setupRenameTestEnv(t)
+	gin.SetMode(gin.TestMode)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"ok"}`))
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	providers := []Provider{
+		{ID: 1, Name: "A", APIURL: upstream.URL, APIKey: "k", Enabled: true, Level: 1, MaxConcurrency: 1},
+		{ID: 2, Name: "B", APIURL: upstream.URL, APIKey: "k", Enabled: true, Level: 1, MaxConcurrency: 1},
+		{ID: 3, Name: "C", APIURL: upstream.URL, APIKey: "k", Enabled: true, Level: 1, MaxConcurrency: 1},
+	}
+	if err := ps.SaveProviders("claude", providers); err != nil {
+		t.Fatalf("预置供应商失败: %v", err)
+	}
+	prs := newTestRelayService(ps)
+	settings, _ := prs.appSettings.GetAppSettings()
+	settings.EnableRoundRobin = true
+	if _, err := prs.appSettings.SaveAppSettings(settings); err != nil {
+		t.Fatalf("开启轮询失败: %v", err)
+	}
+	// 三家全部占满，请求进入等待阶段
+	for _, key := range []string{"1", "2", "3"} {
+		if err := prs.concurrency.Acquire("claude", key, 1, 0, 0, 0); err != nil {
+			t.Fatalf("预占配额失败: %v", err)
+		}
+	}
+	router := gin.New()
+	router.POST("/v1/messages", prs.proxyHandler("claude", "/v1/messages"))
+
+	done := make(chan int, 1)
+	go func() {
+		req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model":"m"}`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		done <- w.Code
+	}()
+	time.Sleep(100 * time.Millisecond)
+	prs.concurrency.Release("claude", "3")
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("释放配额后请求应成功: %d", code)
+	}
+
+	// 本请求排序一次（A 起始），下一次应轮到 B；重扫多推进一次就会变成 C
+	if got := prs.roundRobinOrder("claude", 1, providers)[0].Name; got != "B" {
+		t.Errorf("等待阶段重扫不应推进轮询, 下一次起始 = %s, want B", got)
+	}
+}
@@ -86,6 +86,7 @@ func NewConnectivityTestService(
 		results: map[string]map[int64]*ConnectivityResult{
 			"claude": {},
 			"codex":  {},
+			"openai": {},
 			"gemini": {},
 		},
 		autoTestEnabled: false,
@@ -150,22 +151,22 @@ func (cts *ConnectivityTestService) TestProvider(ctx context.Context, provider P
 
 	// 设置 Headers
 	req.Header.Set("Content-Type", "application/json")
-	if provider.APIKey != "" {
+	if apiKey := provider.probeAPIKey(); apiKey != "" {
 		// authType 已在上方获取
 		authTypeLower := strings.ToLower(authType)
 		switch authTypeLower {
 		case "x-api-key":
-			req.Header.Set("x-api-key", provider.APIKey)
+			req.Header.Set("x-api-key", apiKey)
 			req.Header.Set("anthropic-version", "2023-06-01")
 		case "bearer":
-			req.Header.Set("Authorization", "Bearer "+provider.APIKey)
+			req.Header.Set("Authorization", "Bearer "+apiKey)
 		default:
 			// 自定义 Header 名
 			headerName := strings.TrimSpace(authType)
 			if headerName == "" || strings.EqualFold(headerName, "custom") {
 				headerName = "Authorization"
 			}
-			req.Header.Set(headerName, provider.APIKey)
+			req.Header.Set(headerName, apiKey)
 		}
 	}
 
@@ -176,6 +177,7 @@ func (cts *ConnectivityTestService) TestProvider(ctx context.Context, provider P
 		warnInsecureProviderOnce(provider.Name)
 		httpClient = cts.clientInsecure
 	}
+	httpClient = clientWithProxy(httpClient, provider.ProxyURL)
 	resp, err := httpClient.Do(req)
 	latencyMs := int(time.Since(start).Milliseconds())
 	result.LatencyMs = latencyMs
@@ -256,13 +258,13 @@ func (cts *ConnectivityTestService) getEffectiveAuthType(provider *Provider, pla
 func (cts *ConnectivityTestService) buildTestRequest(platform string, provider *Provider) ([]byte, string) {
 	model := strings.TrimSpace(provider.ConnectivityTestModel)
 	if model == "" {
-		// Claude/Codex 提供默认探测模型(动态解析+白名单交集);
+		// Claude/Codex/OpenAI 提供默认探测模型(动态解析+白名单交集);
 		// Gemini 平台默认端点为 OpenAI 兼容格式,原生 Google 端点形态各异,仍需用户显式配置
 		var fallback string
 		switch strings.ToLower(platform) {
 		case "claude":
 			fallback = FallbackClaudeProbeModel
-		case "codex":
+		case "codex", "openai":
 			fallback = FallbackCodexProbeModel
 		default:
 			return nil, ""
@@ -667,8 +669,7 @@ func (cts *ConnectivityTestService) stopAutoTest() {
 func (cts *ConnectivityTestService) runAllPlatformTests() {
 	// 仅轮询 ProviderService 支持的平台，避免无意义的错误日志
 	// Gemini 使用独立的 GeminiService，暂未接入
-	platforms := []string{"claude", "codex"}
-	for _, platform := range platforms {
+	for _, platform := range providerPlatforms {
 		cts.TestAll(platform)
 	}
 }
@@ -706,6 +707,7 @@ func (cts *ConnectivityTestService) TestProviderManual(
 	endpoint string,
 	authType string,
 	insecureSkipVerify bool,
+	proxyURL string,
 ) ManualTestResult {
 	// 调试日志：打印前端传递的参数
 	fmt.Printf("[DEBUG] TestProviderManual 收到参数:\n")
@@ -729,6 +731,7 @@ func (cts *ConnectivityTestService) TestProviderManual(
 		ConnectivityTestEndpoint: endpoint,
 		ConnectivityAuthType:     authType,
 		InsecureSkipVerify:       insecureSkipVerify, // 与保存后的探测/转发同策略，避免自签名供应商在弹窗里误报失败
+		ProxyURL:                 proxyURL,           // 同理，只能经代理访问的供应商按同一出站路径测试
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
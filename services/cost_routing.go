@@ -0,0 +1,136 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/tidwall/gjson"
+)
+
+// ========== 同 Level 最低成本优先 ==========
+//
+// 开启后，同一 Level 内的候选按本次请求的预估费用从低到高排序：用映射后的实际
+// 模型（GetEffectiveModel）经 modelpricing 的 CalculateCost 按官方价估算，再乘以
+// 供应商对该模型的价格倍率（GetPriceMultiplier）。开启后取代最快优先与轮询。
+//
+// 请求的 token 量只能粗估：输入按请求体字节数 / 4，输出取请求里 max_tokens 类字段与
+// costEstimateOutputTokens 的较小值。同一请求对所有候选用同一组估值，排序只依赖
+// 相对价格，粗估不影响结论。定价库里查不到的模型无法估价，这些候选排在可估价者
+// 之后（保持用户排序）。
+
+// costEstimateOutputTokens 预估输出 token 数的上限（请求未声明更小的上限时使用）
+const costEstimateOutputTokens = 1000
+
+// costEstimateMaxTokenPaths 各协议声明输出上限的字段
+var costEstimateMaxTokenPaths = []string{
+	"max_tokens",                       // Anthropic Messages / OpenAI Chat（旧）
+	"max_completion_tokens",            // OpenAI Chat
+	"max_output_tokens",                // OpenAI Responses
+	"generationConfig.maxOutputTokens", // Gemini
+}
+
+// estimateRequestUsage 粗估一次请求的 token 用量（仅用于候选间比价）
+func estimateRequestUsage(body []byte) modelpricing.UsageSnapshot {
+	output := costEstimateOutputTokens
+	for _, path := range costEstimateMaxTokenPaths {
+		if v := gjson.GetBytes(body, path).Int(); v > 0 {
+			if int(v) < output {
+				output = int(v)
+			}
+			break
+		}
+	}
+	return modelpricing.UsageSnapshot{
+		InputTokens:  len(body) / 4,
+		OutputTokens: output,
+	}
+}
+
+// costCandidate 一个候选的估价输入
+type costCandidate struct {
+	model      string  // 映射后的实际模型
+	multiplier float64 // 供应商对该模型的价格倍率
+}
+
+// costOrderIndices 返回按预估费用升序的下标；无法估价的候选保持原顺序排在最后
+func costOrderIndices(pricing *modelpricing.Service, candidates []costCandidate, usage modelpricing.UsageSnapshot) []int {
+	costs := make([]float64, len(candidates))
+	priced := make([]bool, len(candidates))
+	for i, cand := range candidates {
+		cost := pricing.CalculateCost(cand.model, usage)
+		if cost.HasPricing {
+			priced[i] = true
+			costs[i] = cost.TotalCost * cand.multiplier
+		}
+	}
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ia, ib := order[a], order[b]
+		if priced[ia] != priced[ib] {
+			return priced[ia]
+		}
+		return priced[ia] && costs[ia] < costs[ib]
+	})
+	return order
+}
+
+// isCostRoutingEnabled 检查最低成本优先是否启用（开启后取代最快优先与轮询）
+func (prs *ProviderRelayService) isCostRoutingEnabled() bool {
+	if prs.appSettings == nil {
+		return false
+	}
+	settings, err := prs.appSettings.GetAppSettings()
+	if err != nil {
+		return false
+	}
+	return settings.EnableCostRouting
+}
+
+// costOrder 对同 Level 的 providers 按本次请求的预估费用排序（返回新切片，不修改原切片）
+func (prs *ProviderRelayService) costOrder(providers []Provider, requestedModel string, body []byte) []Provider {
+	if len(providers) <= 1 {
+		return providers
+	}
+	pricing, err := modelpricing.DefaultService()
+	if err != nil || pricing == nil {
+		fmt.Printf("[WARN] 定价服务不可用，最低成本优先按原顺序: %v\n", err)
+		return providers
+	}
+	candidates := make([]costCandidate, len(providers))
+	for i, p := range providers {
+		model := p.GetEffectiveModel(requestedModel)
+		candidates[i] = costCandidate{model: model, multiplier: p.GetPriceMultiplier(model)}
+	}
+	result := make([]Provider, 0, len(providers))
+	for _, idx := range costOrderIndices(pricing, candidates, estimateRequestUsage(body)) {
+		result = append(result, providers[idx])
+	}
+	return result
+}
+
+// costOrderGemini 对 Gemini providers 按预估费用排序（复用相同逻辑）
+func (prs *ProviderRelayService) costOrderGemini(providers []GeminiProvider, requestedModel string, body []byte) []GeminiProvider {
+	if len(providers) <= 1 {
+		return providers
+	}
+	pricing, err := modelpricing.DefaultService()
+	if err != nil || pricing == nil {
+		fmt.Printf("[Gemini] ⚠️ 定价服务不可用，最低成本优先按原顺序: %v\n", err)
+		return providers
+	}
+	candidates := make([]costCandidate, len(providers))
+	for i, p := range providers {
+		model := p.GetEffectiveModel(requestedModel)
+		candidates[i] = costCandidate{model: model, multiplier: p.GetPriceMultiplier(model)}
+	}
+	result := make([]GeminiProvider, 0, len(providers))
+	for _, idx := range costOrderIndices(pricing, candidates, estimateRequestUsage(body)) {
+		result = append(result, providers[idx])
+	}
+	return result
+}
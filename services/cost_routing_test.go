@@ -0,0 +1,83 @@
+package services
+
+import (
+	"testing"
+
+	modelpricing "codeswitch/resources/model-pricing"
+)
+
+// TestPriceMultiplierFor 模型倍率精确优先、通配符取字面量最长，未命中回退统一倍率，未配置按 1
+func TestPriceMultiplierFor(t *testing.T) {
+	perModel := map[string]float64{
+		"claude-*":          2,
+		"claude-opus-*":     3,
+		"claude-sonnet-4-5": 0.5,
+	}
+	cases := []struct {
+		base  float64
+		model string
+		want  float64
+	}{
+		{0, "claude-sonnet-4-5", 0.5},
+		{0, "claude-opus-4-1", 3},
+		{0, "claude-haiku-4-5", 2},
+		{1.2, "gpt-5", 1.2},
+		{0, "gpt-5", 1},
+	}
+	for _, tc := range cases {
+		if got := priceMultiplierFor(tc.base, perModel, tc.model); got != tc.want {
+			t.Errorf("priceMultiplierFor(%v, %s) = %v, want %v", tc.base, tc.model, got, tc.want)
+		}
+	}
+
+	p := Provider{PriceMultiplier: -1, ModelPriceMultipliers: map[string]float64{"x": 0}}
+	if errs := p.ValidateConfiguration(); len(errs) != 2 {
+		t.Errorf("负统一倍率与非正模型倍率都应报错: %v", errs)
+	}
+}
+
+// TestCostOrderIndices 按"官方价 × 倍率"升序，无法估价的候选保持原顺序排在最后
+func TestCostOrderIndices(t *testing.T) {
+	pricing, err := modelpricing.NewService()
+	if err != nil {
+		t.Fatalf("加载内置定价失败: %v", err)
+	}
+	usage := modelpricing.UsageSnapshot{InputTokens: 1000, OutputTokens: 1000}
+	candidates := []costCandidate{
+		{model: "unknown-model-a", multiplier: 1},
+		{model: "claude-opus-4-1", multiplier: 1},
+		{model: "claude-sonnet-4-5", multiplier: 1},
+		{model: "claude-opus-4-1", multiplier: 0.1}, // 打一折的 opus 比官方 sonnet 还便宜
+		{model: "unknown-model-b", multiplier: 1},
+	}
+	got := costOrderIndices(pricing, candidates, usage)
+	want := []int{3, 2, 1, 0, 4}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("排序 = %v, want %v", got, want)
+		}
+	}
+}
+
+// TestEstimateRequestUsage 输出按请求声明的上限与默认上限取小
+func TestEstimateRequestUsage(t *testing.T) {
+	body := []byte(`{"model":"m","max_tokens":200,"messages":[]}`)
+	if u := estimateRequestUsage(body); u.OutputTokens != 200 || u.InputTokens != len(body)/4 {
+		t.Errorf("estimate = %+v", u)
+	}
+	if u := estimateRequestUsage([]byte(`{"max_output_tokens":64000}`)); u.OutputTokens != costEstimateOutputTokens {
+		t.Errorf("超过默认上限时应取 %d, 实际 %d", costEstimateOutputTokens, u.OutputTokens)
+	}
+}
+
+// TestScaleCostBreakdown 日志费用按倍率缩放，倍率 1 原样返回
+func TestScaleCostBreakdown(t *testing.T) {
+	cost := modelpricing.CostBreakdown{InputCost: 1, OutputCost: 2, TotalCost: 3, HasPricing: true}
+	scaled := scaleCostBreakdown(cost, 1.5)
+	if scaled.InputCost != 1.5 || scaled.OutputCost != 3 || scaled.TotalCost != 4.5 || !scaled.HasPricing {
+		t.Errorf("scaled = %+v", scaled)
+	}
+	if scaleCostBreakdown(cost, 1) != cost {
+		t.Errorf("倍率 1 应原样返回")
+	}
+}
@@ -392,10 +392,10 @@ func (s *CustomCliService) ProxyStatus(toolId string) (*CustomCliProxyStatus, er
 		}
 
 		// 校验可选的鉴权字段，避免误判为已启用
-		// 向后兼容：同时检查 code-switch-r（新）和 code-switch（旧）两个 token
+		// 向后兼容：同时检查当前注入 token、code-switch-r（新）和 code-switch（旧）
 		if injection.AuthTokenField != "" {
 			authOk := false
-			for _, token := range []string{"code-switch-r", "code-switch"} {
+			for _, token := range []string{cliAuthToken("custom:" + toolId), relayPlaceholderToken, "code-switch"} {
 				authEnabled, err := s.checkProxyField(content, targetFile.Format, injection.AuthTokenField, token)
 				if err == nil && authEnabled {
 					authOk = true
@@ -465,7 +465,7 @@ func (s *CustomCliService) EnableProxy(toolId string) error {
 			// 不能走 parseEnvFile+serializeEnvFile 的 map 重建（会整体丢弃）
 			updates := map[string]string{}
 			for _, injection := range g.injections {
-				collectEnvInjection(updates, injection, s.baseURLWithToolPath(toolId))
+				collectEnvInjection(updates, injection, s.baseURLWithToolPath(toolId), cliAuthToken("custom:"+toolId))
 			}
 			plans = append(plans, plannedWrite{group: g, envText: upsertEnvContent(string(content), updates), existed: existed})
 			continue
@@ -479,7 +479,7 @@ func (s *CustomCliService) EnableProxy(toolId string) error {
 				return fmt.Errorf("注入 %s 失败: %w", g.file.Label, err)
 			}
 			if injection.AuthTokenField != "" {
-				if err := setNestedValueStrict(data, injection.AuthTokenField, "code-switch-r"); err != nil {
+				if err := setNestedValueStrict(data, injection.AuthTokenField, cliAuthToken("custom:"+toolId)); err != nil {
 					return fmt.Errorf("注入 %s 失败: %w", g.file.Label, err)
 				}
 			}
@@ -820,7 +820,7 @@ func (s *CustomCliService) conditionalCleanup(g injectionGroup, toolId string) e
 		// 注释/export/用户手改的行原样保留
 		expected := map[string]string{}
 		for _, injection := range g.injections {
-			collectEnvInjection(expected, injection, s.baseURLWithToolPath(toolId))
+			collectEnvInjection(expected, injection, s.baseURLWithToolPath(toolId), cliAuthToken("custom:"+toolId))
 		}
 		return AtomicWriteText(configPath, removeEnvLinesIfEquals(string(content), expected))
 	}
@@ -832,7 +832,8 @@ func (s *CustomCliService) conditionalCleanup(g injectionGroup, toolId string) e
 	for _, injection := range g.injections {
 		deleteNestedValueIfEquals(data, injection.BaseUrlField, s.baseURLWithToolPath(toolId))
 		if injection.AuthTokenField != "" {
-			deleteNestedValueIfEquals(data, injection.AuthTokenField, "code-switch-r")
+			deleteNestedValueIfEquals(data, injection.AuthTokenField, relayPlaceholderToken)
+			deleteNestedValueIfEquals(data, injection.AuthTokenField, cliAuthToken("custom:"+toolId))
 		}
 		for _, sf := range injection.SeedFields {
 			deleteNestedValueIfEquals(data, sf.Path, sf.Value)
@@ -1124,7 +1125,7 @@ func (s *CustomCliService) checkProxyField(content []byte, format, fieldPath, ex
 }
 
 // collectEnvInjection 收集 env 注入键值（键取字段路径最后一段）
-func collectEnvInjection(updates map[string]string, injection ProxyInjection, baseURL string) {
+func collectEnvInjection(updates map[string]string, injection ProxyInjection, baseURL, authToken string) {
 	baseUrlKey := injection.BaseUrlField
 	if idx := strings.LastIndex(baseUrlKey, "."); idx >= 0 {
 		baseUrlKey = baseUrlKey[idx+1:]
@@ -1135,7 +1136,7 @@ func collectEnvInjection(updates map[string]string, injection ProxyInjection, ba
 		if idx := strings.LastIndex(authKey, "."); idx >= 0 {
 			authKey = authKey[idx+1:]
 		}
-		updates[authKey] = "code-switch-r"
+		updates[authKey] = authToken
 	}
 }
 
@@ -3,6 +3,7 @@ package services
 import (
 	"database/sql"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -55,7 +56,7 @@ func InitDatabase() error {
 	}
 	// 已存在的旧目录一并收紧
 	if err := os.Chmod(configDir, 0700); err != nil {
-		fmt.Printf("[DB] 收紧配置目录权限失败（不影响运行）: %v\n", err)
+		log.Printf("[DB] 收紧配置目录权限失败（不影响运行）: %v", err)
 	}
 
 	// 2. 初始化 xdb 连接池
@@ -98,12 +99,12 @@ func InitDatabase() error {
 	if err := db.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil {
 		return fmt.Errorf("读取 journal_mode 失败: %w", err)
 	}
-	fmt.Printf("✅ SQLite PRAGMA 已设置: journal_mode=%s, busy_timeout=30000ms\n", journalMode)
+	log.Printf("✅ SQLite PRAGMA 已设置: journal_mode=%s, busy_timeout=30000ms", journalMode)
 
 	// 库文件收敛为 0600：抓包全量模式的明文内容都落在这里。
 	// 必须在首次查询之后——sql.Open 是惰性的，文件到此才真正存在
 	if err := os.Chmod(filepath.Join(configDir, "app.db"), 0600); err != nil {
-		fmt.Printf("[DB] 收紧库文件权限失败（不影响运行）: %v\n", err)
+		log.Printf("[DB] 收紧库文件权限失败（不影响运行）: %v", err)
 	}
 
 	// 4. 确保表结构存在
@@ -126,9 +127,9 @@ func InitDatabase() error {
 	// 5. 预热连接池：强制建立数据库连接，避免首次写入时失败
 	var count int
 	if err := db.QueryRow("SELECT COUNT(*) FROM request_log").Scan(&count); err != nil {
-		fmt.Printf("⚠️  连接池预热查询失败: %v\n", err)
+		log.Printf("⚠️  连接池预热查询失败: %v", err)
 	} else {
-		fmt.Printf("✅ 数据库连接已预热（request_log 记录数: %d）\n", count)
+		log.Printf("✅ 数据库连接已预热（request_log 记录数: %d）", count)
 	}
 
 	return nil
@@ -182,11 +183,11 @@ func ensureRequestLogPerfIndexes(db *sql.DB) {
 	for _, ddl := range indexes {
 		start := time.Now()
 		if _, err := db.Exec(ddl); err != nil {
-			fmt.Printf("⚠️  request_log 性能索引创建失败（降级为全表扫描，不影响启动）: %v\n", err)
+			log.Printf("⚠️  request_log 性能索引创建失败（降级为全表扫描，不影响启动）: %v", err)
 			continue
 		}
 		if cost := time.Since(start); cost > time.Second {
-			fmt.Printf("✅ request_log 性能索引就绪（耗时 %v）\n", cost.Round(time.Millisecond))
+			log.Printf("✅ request_log 性能索引就绪（耗时 %v）", cost.Round(time.Millisecond))
 		}
 	}
 }
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"errors"
+	"sync"
+)
+
+// DesktopApp 桌面外壳（Wails）提供给服务层的能力：向前端推送事件、退出应用、弹保存对话框。
+// 服务层只依赖这个接口而不直接引用 Wails，无界面入口（cmd/codeswitch-headless）
+// 因而不链接 GTK/WebKit，可以 CGO_ENABLED=0 构建；实现见根目录 desktop.go
+type DesktopApp interface {
+	// Emit 向前端广播事件
+	Emit(name string, data any)
+	// Quit 退出应用
+	Quit()
+	// SaveFileDialog 弹出绑定当前窗口的保存对话框，返回用户选中的路径（取消为空串）
+	SaveFileDialog(filename, filterName, filterPattern string) (string, error)
+}
+
+// errNoDesktop 无界面进程中调用需要窗口的功能
+var errNoDesktop = errors.New("当前为无界面模式，不支持打开对话框")
+
+var (
+	desktopAppMu sync.RWMutex
+	desktopApp   DesktopApp
+)
+
+// SetDesktopApp 注册桌面外壳（应用创建后由桌面入口调用一次），供导出等需要对话框的功能使用
+func SetDesktopApp(app DesktopApp) {
+	desktopAppMu.Lock()
+	desktopApp = app
+	desktopAppMu.Unlock()
+}
+
+// promptSaveFile 弹出保存对话框；未注册桌面外壳（无界面模式）时报错
+func promptSaveFile(filename, filterName, filterPattern string) (string, error) {
+	desktopAppMu.RLock()
+	app := desktopApp
+	desktopAppMu.RUnlock()
+	if app == nil {
+		return "", errNoDesktop
+	}
+	return app.SaveFileDialog(filename, filterName, filterPattern)
+}
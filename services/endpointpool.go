@@ -76,6 +76,7 @@ func addressSwitchableError(err error) bool {
 		return false
 	}
 	if errors.Is(err, errClientAbort) ||
+		errors.Is(err, errHedgeLost) ||
 		errors.Is(err, errUpstreamStreamAborted) ||
 		errors.Is(err, errUpstreamClientError) {
 		return false
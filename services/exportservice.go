@@ -10,8 +10,6 @@ import (
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/wailsapp/wails/v3/pkg/application"
 )
 
 // exportAppID / exportSchemaVersion 是导入端识别自有导出包的门禁：
@@ -34,12 +32,13 @@ type exportMeta struct {
 
 // exportBundle 导出包。基础段（claude/codex/gemini/mcp）是 cc-switch 兼容形态，
 // 现有导入不识别 exportMeta 也能按 legacy 路径吃掉基础字段；
-// prompts/skills 与各条目的 extra 为自有扩展。
+// openai/prompts/skills 与各条目的 extra 为自有扩展。
 type exportBundle struct {
 	ExportMeta exportMeta          `json:"exportMeta"`
 	Claude     ccProviderSection   `json:"claude"`
 	Codex      ccProviderSection   `json:"codex"`
 	Gemini     ccProviderSection   `json:"gemini"`
+	OpenAI     ccProviderSection   `json:"openai"`
 	MCP        ccMCPSection        `json:"mcp"`
 	Prompts    map[string][]Prompt `json:"prompts,omitempty"`
 	// Skills 仅为元数据附件（安装状态+仓库列表，不含技能文件），导入不消费
@@ -50,6 +49,7 @@ type exportBundle struct {
 type exportSnapshot struct {
 	claudeProviders []Provider
 	codexProviders  []Provider
+	openaiProviders []Provider
 	geminiProviders []GeminiProvider
 	mcpServers      []MCPServer
 	prompts         map[string][]Prompt
@@ -109,17 +109,9 @@ func (es *ExportService) ExportWithDialog(includeSecrets bool) (ExportResult, er
 		return ExportResult{}, err
 	}
 
-	dialog := application.SaveFileDialog().
-		SetFilename(fmt.Sprintf("code-switch-export-%s.json", es.now().Format("20060102"))).
-		AddFilter("JSON 配置包 (*.json)", "*.json").
-		CanCreateDirectories(true)
-	// 绑定当前（发起调用的）窗口，避免对话框失焦或弹到别的窗口后面
-	if app := application.Get(); app != nil {
-		if w := app.Window.Current(); w != nil {
-			dialog.AttachToWindow(w)
-		}
-	}
-	path, err := dialog.PromptForSingleSelection()
+	path, err := promptSaveFile(
+		fmt.Sprintf("code-switch-export-%s.json", es.now().Format("20060102")),
+		"JSON 配置包 (*.json)", "*.json")
 	if err != nil {
 		return ExportResult{}, fmt.Errorf("打开保存对话框失败: %w", err)
 	}
@@ -134,7 +126,7 @@ func (es *ExportService) ExportWithDialog(includeSecrets bool) (ExportResult, er
 	}
 	return ExportResult{
 		Path:           path,
-		Providers:      len(snap.claudeProviders) + len(snap.codexProviders) + len(snap.geminiProviders),
+		Providers:      len(snap.claudeProviders) + len(snap.codexProviders) + len(snap.openaiProviders) + len(snap.geminiProviders),
 		MCP:            len(snap.mcpServers),
 		Prompts:        countPrompts(snap.prompts),
 		Redacted:       !includeSecrets,
@@ -160,7 +152,7 @@ func (es *ExportService) writeBundleFile(path string, bundle *exportBundle) erro
 func (es *ExportService) collectSnapshot() (*exportSnapshot, error) {
 	snap := &exportSnapshot{prompts: map[string][]Prompt{}}
 
-	for _, kind := range []string{"claude", "codex"} {
+	for _, kind := range providerPlatforms {
 		providers, err := es.providerService.LoadProviders(kind)
 		if err != nil {
 			return nil, fmt.Errorf("读取 %s 供应商失败: %w", kind, err)
@@ -169,10 +161,13 @@ func (es *ExportService) collectSnapshot() (*exportSnapshot, error) {
 		for i := range providers {
 			copied[i] = deepCopyProvider(providers[i])
 		}
-		if kind == "claude" {
+		switch kind {
+		case "claude":
 			snap.claudeProviders = copied
-		} else {
+		case "codex":
 			snap.codexProviders = copied
+		case "openai":
+			snap.openaiProviders = copied
 		}
 	}
 
@@ -259,6 +254,7 @@ func buildExportBundle(snap *exportSnapshot, includeSecrets bool, appVersion str
 		Claude: ccProviderSection{Providers: map[string]ccProviderEntry{}},
 		Codex:  ccProviderSection{Providers: map[string]ccProviderEntry{}},
 		Gemini: ccProviderSection{Providers: map[string]ccProviderEntry{}},
+		OpenAI: ccProviderSection{Providers: map[string]ccProviderEntry{}},
 		MCP: ccMCPSection{
 			Claude: ccMCPPlatform{Servers: map[string]ccMCPServerEntry{}},
 			Codex:  ccMCPPlatform{Servers: map[string]ccMCPServerEntry{}},
@@ -274,8 +270,16 @@ func buildExportBundle(snap *exportSnapshot, includeSecrets bool, appVersion str
 			p.APIKey = ""
 			track(fmt.Sprintf("claude.%s.apiKey", p.Name))
 		}
+		if !includeSecrets && len(p.ExtraAPIKeys) > 0 {
+			p.ExtraAPIKeys = nil
+			track(fmt.Sprintf("claude.%s.extraApiKeys", p.Name))
+		}
+		if !includeSecrets {
+			scrubRewriteRuleSecrets(p.RewriteRules, "claude."+p.Name, track)
+		}
 		if !includeSecrets {
 			p.APIURL = scrubURLCredentials(p.APIURL, fmt.Sprintf("claude.%s.apiUrl", p.Name), track)
+			p.ProxyURL = scrubURLCredentials(p.ProxyURL, fmt.Sprintf("claude.%s.proxyUrl", p.Name), track)
 		}
 		entry, err := claudeProviderToEntry(p)
 		if err != nil {
@@ -289,8 +293,16 @@ func buildExportBundle(snap *exportSnapshot, includeSecrets bool, appVersion str
 			p.APIKey = ""
 			track(fmt.Sprintf("codex.%s.apiKey", p.Name))
 		}
+		if !includeSecrets && len(p.ExtraAPIKeys) > 0 {
+			p.ExtraAPIKeys = nil
+			track(fmt.Sprintf("codex.%s.extraApiKeys", p.Name))
+		}
+		if !includeSecrets {
+			scrubRewriteRuleSecrets(p.RewriteRules, "codex."+p.Name, track)
+		}
 		if !includeSecrets {
 			p.APIURL = scrubURLCredentials(p.APIURL, fmt.Sprintf("codex.%s.apiUrl", p.Name), track)
+			p.ProxyURL = scrubURLCredentials(p.ProxyURL, fmt.Sprintf("codex.%s.proxyUrl", p.Name), track)
 		}
 		entry, err := codexProviderToEntry(p)
 		if err != nil {
@@ -298,6 +310,29 @@ func buildExportBundle(snap *exportSnapshot, includeSecrets bool, appVersion str
 		}
 		bundle.Codex.Providers[strconv.FormatInt(p.ID, 10)] = entry
 	}
+	for i := range snap.openaiProviders {
+		p := &snap.openaiProviders[i]
+		if !includeSecrets && p.APIKey != "" {
+			p.APIKey = ""
+			track(fmt.Sprintf("openai.%s.apiKey", p.Name))
+		}
+		if !includeSecrets && len(p.ExtraAPIKeys) > 0 {
+			p.ExtraAPIKeys = nil
+			track(fmt.Sprintf("openai.%s.extraApiKeys", p.Name))
+		}
+		if !includeSecrets {
+			scrubRewriteRuleSecrets(p.RewriteRules, "openai."+p.Name, track)
+		}
+		if !includeSecrets {
+			p.APIURL = scrubURLCredentials(p.APIURL, fmt.Sprintf("openai.%s.apiUrl", p.Name), track)
+			p.ProxyURL = scrubURLCredentials(p.ProxyURL, fmt.Sprintf("openai.%s.proxyUrl", p.Name), track)
+		}
+		entry, err := openaiProviderToEntry(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		bundle.OpenAI.Providers[strconv.FormatInt(p.ID, 10)] = entry
+	}
 	for i := range snap.geminiProviders {
 		p := &snap.geminiProviders[i]
 		if !includeSecrets {
@@ -321,6 +356,7 @@ func buildExportBundle(snap *exportSnapshot, includeSecrets bool, appVersion str
 				}
 			}
 			p.BaseURL = scrubURLCredentials(p.BaseURL, fmt.Sprintf("gemini.%s.baseUrl", p.Name), track)
+			p.ProxyURL = scrubURLCredentials(p.ProxyURL, fmt.Sprintf("gemini.%s.proxyUrl", p.Name), track)
 			redactAnyMap(p.SettingsConfig, fmt.Sprintf("gemini.%s.settingsConfig", p.Name), track)
 		}
 		entry, err := geminiProviderToEntry(p)
@@ -455,6 +491,28 @@ func codexProviderToEntry(p *Provider) (ccProviderEntry, error) {
 	return entry, nil
 }
 
+// openaiProviderToEntry openai 平台没有对应的 CLI 配置形态，基础字段沿用 OPENAI_* 环境变量名
+func openaiProviderToEntry(p *Provider) (ccProviderEntry, error) {
+	entry := ccProviderEntry{
+		ID:         strconv.FormatInt(p.ID, 10),
+		Name:       p.Name,
+		WebsiteURL: p.Site,
+		Settings: ccProviderSetting{
+			Env: stringMap{
+				"OPENAI_BASE_URL": p.APIURL,
+				"OPENAI_API_KEY":  p.APIKey,
+			},
+			Auth: stringMap{},
+		},
+	}
+	extra, err := marshalProviderExtra(*p)
+	if err != nil {
+		return ccProviderEntry{}, fmt.Errorf("序列化供应商 %s 失败: %w", p.Name, err)
+	}
+	entry.Extra = extra
+	return entry, nil
+}
+
 func marshalProviderExtra(p Provider) (json.RawMessage, error) {
 	p.ID = 0
 	return json.Marshal(p)
@@ -621,11 +679,26 @@ func sensitiveKeyName(name string) bool {
 	return false
 }
 
+// scrubRewriteRuleSecrets 清空凭据类请求头改写规则的值（如自定义认证头、组织密钥）。
+// rules 须为深拷贝后的切片，原地修改不影响内存中的配置
+func scrubRewriteRuleSecrets(rules []RewriteRule, prefix string, track func(string)) {
+	for i := range rules {
+		r := &rules[i]
+		if r.Target == RewriteTargetHeader && r.Value != "" && sensitiveKeyName(r.Path) {
+			r.Value = ""
+			track(fmt.Sprintf("%s.rewriteRules.%s", prefix, r.Path))
+		}
+	}
+}
+
 func deepCopyProvider(p Provider) Provider {
 	out := p
 	if len(p.FallbackAPIURLs) > 0 {
 		out.FallbackAPIURLs = append([]string(nil), p.FallbackAPIURLs...)
 	}
+	if len(p.ExtraAPIKeys) > 0 {
+		out.ExtraAPIKeys = append([]string(nil), p.ExtraAPIKeys...)
+	}
 	if p.SupportedModels != nil {
 		out.SupportedModels = make(map[string]bool, len(p.SupportedModels))
 		for k, v := range p.SupportedModels {
@@ -649,6 +722,9 @@ func deepCopyProvider(p Provider) Provider {
 			BlockedBetaValues: cloneStringListPtr(p.SanitizeConfig.BlockedBetaValues),
 		}
 	}
+	if len(p.RewriteRules) > 0 {
+		out.RewriteRules = append([]RewriteRule(nil), p.RewriteRules...)
+	}
 	return out
 }
 
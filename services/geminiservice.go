@@ -36,7 +36,15 @@ type GeminiProvider struct {
 	Enabled             bool              `json:"enabled"`
 	Level               int               `json:"level,omitempty"`               // 优先级分组 (1-10, 默认 1)
 	MaxConcurrency      int               `json:"maxConcurrency,omitempty"`      // 最大并发请求数（0=不限，仅代理转发，单进程）
+	RPMLimit            int               `json:"rpmLimit,omitempty"`            // 每分钟请求数上限（0=不限）
+	TPMLimit            int               `json:"tpmLimit,omitempty"`            // 每分钟 token 数上限（输入+输出，0=不限）
+	SpendCap            float64           `json:"spendCap,omitempty"`            // 消费上限（USD，0=不限），达到后退出调度直到周期重置
+	SpendCapPeriod      string            `json:"spendCapPeriod,omitempty"`      // 消费上限周期：daily/weekly/monthly（默认 daily）
+	Weight              int               `json:"weight,omitempty"`              // 负载权重（0=默认 1，开启轮询时同 Level 内平滑加权轮询）
+	PriceMultiplier     float64           `json:"priceMultiplier,omitempty"`     // 价格倍率（0=按官方价），用于日志费用与最低成本优先排序
+	ModelPriceMultipliers map[string]float64 `json:"modelPriceMultipliers,omitempty"` // 按模型覆盖价格倍率（精确或通配符）
 	InsecureSkipVerify  bool              `json:"insecureSkipVerify,omitempty"`  // 跳过上游 TLS 证书验证（仅该供应商，存在中间人风险）
+	ProxyURL            string            `json:"proxyUrl,omitempty"`            // 出站代理（http/https/socks5/socks5h，空为直连）
 	SupportedModels     map[string]bool   `json:"supportedModels,omitempty"`     // 模型白名单（精确或通配符），空表示不限制
 	ModelMapping        map[string]string `json:"modelMapping,omitempty"`        // 模型映射：外部模型名 -> 供应商内部模型名（支持通配符）
 	EnvConfig           map[string]string `json:"envConfig,omitempty"`           // .env 配置
@@ -53,12 +61,24 @@ func (p *GeminiProvider) GetEffectiveModel(requestedModel string) string {
 	return effectiveModelFor(p.ModelMapping, requestedModel)
 }
 
-// ValidateConfiguration 验证模型白名单/映射与并发配置
+// GetPriceMultiplier 获取指定模型的价格倍率（与 claude/codex 的 Provider 同一套逻辑）
+func (p *GeminiProvider) GetPriceMultiplier(model string) float64 {
+	return priceMultiplierFor(p.PriceMultiplier, p.ModelPriceMultipliers, model)
+}
+
+// ValidateConfiguration 验证模型白名单/映射、并发、速率、消费上限、价格倍率与代理配置
 func (p *GeminiProvider) ValidateConfiguration() []string {
 	errs := validateModelConfig(p.SupportedModels, p.ModelMapping)
 	if p.MaxConcurrency < 0 {
 		errs = append(errs, "最大并发数不能为负（0 表示不限）")
 	}
+	errs = append(errs, validateRateLimits(p.RPMLimit, p.TPMLimit)...)
+	errs = append(errs, validateSpendCap(p.SpendCap, p.SpendCapPeriod)...)
+	if p.Weight < 0 {
+		errs = append(errs, "负载权重不能为负（0 表示默认权重 1）")
+	}
+	errs = append(errs, validatePriceMultipliers(p.PriceMultiplier, p.ModelPriceMultipliers)...)
+	errs = append(errs, validateProxyURL(p.ProxyURL)...)
 	return errs
 }
 
@@ -852,7 +872,6 @@ func (s *GeminiService) ProxyStatus() (*GeminiProxyStatus, error) {
 const (
 	geminiBaseURLKey    = "GOOGLE_GEMINI_BASE_URL"
 	geminiAPIKeyKey     = "GEMINI_API_KEY"
-	geminiProxyTokenVal = "code-switch-r"
 )
 
 // EnableProxy 启用代理
@@ -923,7 +942,7 @@ func (s *GeminiService) EnableProxy() error {
 			FileExisted:       fileExisted,
 			EnvExisted:        len(existingEnv) > 0,
 			InjectedBaseURL:   buildProxyURL(s.relayAddr),
-			InjectedAuthToken: geminiProxyTokenVal,
+			InjectedAuthToken: cliAuthToken("gemini"),
 		}
 
 		// 记录原始 BASE_URL（如果 key 存在，即使是空值也记录）
@@ -941,9 +960,9 @@ func (s *GeminiService) EnableProxy() error {
 		}
 	}
 
-	// 设置代理 URL 和占位 API Key（与 Claude/Codex 保持一致）
+	// 设置代理 URL 和占位 API Key（与 Claude/Codex 保持一致，开启客户端认证时为 CLI 注入令牌）
 	existingEnv[geminiBaseURLKey] = buildProxyURL(s.relayAddr)
-	existingEnv[geminiAPIKeyKey] = geminiProxyTokenVal
+	existingEnv[geminiAPIKeyKey] = cliAuthToken("gemini")
 
 	// 写入 .env
 	if err := writeGeminiEnv(existingEnv); err != nil {
@@ -1036,7 +1055,7 @@ func (s *GeminiService) fallbackCleanupEnv(envConfig map[string]string) bool {
 	}
 
 	// 检查 GEMINI_API_KEY 是否为代理占位值
-	if v, ok := envConfig[geminiAPIKeyKey]; ok && v == geminiProxyTokenVal {
+	if v, ok := envConfig[geminiAPIKeyKey]; ok && isRelayAuthToken(v) {
 		delete(envConfig, geminiAPIKeyKey)
 		changed = true
 	}
@@ -1102,7 +1121,14 @@ func (s *GeminiService) DuplicateProvider(sourceID string) (*GeminiProvider, err
 		Enabled:             false, // 默认禁用，避免与源供应商冲突
 		Level:               source.Level,
 		MaxConcurrency:      source.MaxConcurrency,
+		RPMLimit:            source.RPMLimit,
+		TPMLimit:            source.TPMLimit,
+		SpendCap:            source.SpendCap,
+		SpendCapPeriod:      source.SpendCapPeriod,
+		Weight:              source.Weight,
+		PriceMultiplier:     source.PriceMultiplier,
 		InsecureSkipVerify:  source.InsecureSkipVerify, // 复制 TLS 跳验开关
+		ProxyURL:            source.ProxyURL,           // 复制出站代理
 	}
 
 	// 4. 深拷贝 map（避免共享引用）
@@ -1127,6 +1153,13 @@ func (s *GeminiService) DuplicateProvider(sourceID string) (*GeminiProvider, err
 		}
 	}
 
+	if source.ModelPriceMultipliers != nil {
+		cloned.ModelPriceMultipliers = make(map[string]float64, len(source.ModelPriceMultipliers))
+		for k, v := range source.ModelPriceMultipliers {
+			cloned.ModelPriceMultipliers[k] = v
+		}
+	}
+
 	if source.SettingsConfig != nil {
 		cloned.SettingsConfig = make(map[string]any, len(source.SettingsConfig))
 		for k, v := range source.SettingsConfig {
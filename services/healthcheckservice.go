@@ -126,6 +126,7 @@ func NewHealthCheckService(
 			"claude": {},
 			"codex":  {},
 			"gemini": {},
+			"openai": {},
 		},
 		pollInterval: time.Duration(DefaultPollIntervalSeconds) * time.Second,
 		client: &http.Client{
@@ -207,7 +208,7 @@ func (hcs *HealthCheckService) GetLatestResults() (map[string][]ProviderTimeline
 	results := make(map[string][]ProviderTimeline)
 
 	// 遍历所有平台
-	for _, platform := range []string{"claude", "codex"} {
+	for _, platform := range providerPlatforms {
 		providers, err := hcs.providerService.LoadProviders(platform)
 		if err != nil {
 			log.Printf("[HealthCheck] 加载 %s 供应商失败: %v", platform, err)
@@ -470,7 +471,7 @@ func (hcs *HealthCheckService) RunSingleCheck(platform string, providerID int64)
 func (hcs *HealthCheckService) RunAllChecks() (map[string][]HealthCheckResult, error) {
 	results := make(map[string][]HealthCheckResult)
 
-	for _, platform := range []string{"claude", "codex"} {
+	for _, platform := range providerPlatforms {
 		platformResults := hcs.checkAllProviders(platform)
 		results[platform] = platformResults
 	}
@@ -644,7 +645,7 @@ func (hcs *HealthCheckService) probeAddress(ctx context.Context, provider *Provi
 
 	// 设置 Headers
 	req.Header.Set("Content-Type", "application/json")
-	if provider.APIKey != "" {
+	if apiKey := provider.probeAPIKey(); apiKey != "" {
 		// 根据认证方式设置请求头
 		authTypeRaw := strings.TrimSpace(provider.ConnectivityAuthType)
 		authType := strings.ToLower(authTypeRaw)
@@ -658,17 +659,17 @@ func (hcs *HealthCheckService) probeAddress(ctx context.Context, provider *Provi
 		}
 		switch authType {
 		case "x-api-key":
-			req.Header.Set("x-api-key", provider.APIKey)
+			req.Header.Set("x-api-key", apiKey)
 			req.Header.Set("anthropic-version", "2023-06-01")
 		case "bearer":
-			req.Header.Set("Authorization", "Bearer "+provider.APIKey)
+			req.Header.Set("Authorization", "Bearer "+apiKey)
 		default:
 			// 自定义 Header 名
 			headerName := authTypeRaw
 			if headerName == "" || strings.EqualFold(headerName, "custom") {
 				headerName = "Authorization"
 			}
-			req.Header.Set(headerName, provider.APIKey)
+			req.Header.Set(headerName, apiKey)
 		}
 	}
 
@@ -687,6 +688,7 @@ func (hcs *HealthCheckService) probeAddress(ctx context.Context, provider *Provi
 		warnInsecureProviderOnce(provider.Name)
 		httpClient = hcs.clientInsecure
 	}
+	httpClient = clientWithProxy(httpClient, provider.ProxyURL)
 	resp, err := httpClient.Do(req)
 	latencyMs := int(time.Since(start).Milliseconds())
 	result.LatencyMs = latencyMs
@@ -858,7 +860,10 @@ func (hcs *HealthCheckService) getEffectiveEndpoint(provider *Provider, platform
 		return provider.GetEffectiveEndpoint("")
 	}
 
-	// 优先级 3：平台默认端点
+	// 优先级 3：平台默认端点（codex/openai 配置为 anthropic_messages 时转发走 /v1/messages，探测保持一致）
+	if isOpenAIClientPlatform(platform) && provider.GetUpstreamProtocol() == UpstreamProtocolAnthropicMessages {
+		return "/v1/messages"
+	}
 	switch strings.ToLower(platform) {
 	case "claude":
 		return "/v1/messages"
@@ -1135,8 +1140,7 @@ func (hcs *HealthCheckService) SetAutoAvailabilityPolling(enabled bool) {
 
 // runAllPlatformChecks 执行所有平台的检测
 func (hcs *HealthCheckService) runAllPlatformChecks() {
-	platforms := []string{"claude", "codex"}
-	for _, platform := range platforms {
+	for _, platform := range providerPlatforms {
 		hcs.checkAllProviders(platform)
 	}
 }
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========== 对冲请求（首响迟迟不来时并发试下一个供应商）==========
+//
+// 默认情况下，上游卡在响应头之前只能等到首响预算（relayFirstByteBudget）或
+// ResponseHeaderTimeout 才降级。开启对冲后，流式请求的主尝试若在 HedgeDelayMs
+// 内仍没有拿到响应头，就把同一请求并发发给同 Level 中下一个可用的供应商，
+// 谁先拿到 2xx 响应头谁向客户端转发（claim），另一方立即取消。
+//
+//   - 只对流式请求生效：非流式请求的响应头要等整段生成完才到，按延迟触发会让
+//     几乎每个长请求都双发计费；
+//   - 每次调度尝试至多对冲一个供应商，且只在同 Level 内找（Level 是用户给的
+//     优先级，低 Level 通常是更贵或更不稳定的兜底）；
+//   - 落败方的 context 被取消、请求立即返回，forwardRequestAttempt 的 defer 随之
+//     归还并发配额；落败方照常写一条请求日志并标记 hedged，不计入黑名单失败；
+//   - forwardHedged 等两边都结束才返回，调度循环只看到一个结果：对冲胜出时
+//     返回对冲供应商，成功/失败的记账与普通尝试完全一致。
+
+const (
+	defaultHedgeDelayMs = 5000
+	minHedgeDelayMs     = 200
+	maxHedgeDelayMs     = 120000
+)
+
+// errHedgeLost 表示本次尝试在对冲竞速中落败（另一方先拿到了响应头）被取消。
+// 不是供应商故障：不计失败、不拉黑
+var errHedgeLost = errors.New("hedged attempt lost the race")
+
+// hedgeRace 一次主尝试与其对冲尝试的竞速裁决
+type hedgeRace struct {
+	mu       sync.Mutex
+	winner   *hedgeAttempt
+	attempts []*hedgeAttempt
+	closed   bool // 主尝试已结束，不再发起对冲
+}
+
+// hedgeAttempt 竞速中的一方。nil 表示未参与对冲（普通转发），各方法均按普通路径处理
+type hedgeAttempt struct {
+	race   *hedgeRace
+	ctx    context.Context
+	cancel context.CancelFunc
+	lost   atomic.Bool
+}
+
+// newAttemptLocked 派生一个可被裁决取消的尝试（调用方持有 r.mu）
+func (r *hedgeRace) newAttemptLocked(parent context.Context) *hedgeAttempt {
+	ctx, cancel := context.WithCancel(parent)
+	a := &hedgeAttempt{race: r, ctx: ctx, cancel: cancel}
+	r.attempts = append(r.attempts, a)
+	return a
+}
+
+// requestContext 上游请求使用的 context；普通转发直接用客户端 context
+func (a *hedgeAttempt) requestContext(c *gin.Context) context.Context {
+	if a == nil {
+		return c.Request.Context()
+	}
+	return a.ctx
+}
+
+// claim 拿到 2xx 响应头、写客户端之前调用：只有第一个 claim 的一方可以写，
+// 同时取消其余各方
+func (a *hedgeAttempt) claim() bool {
+	if a == nil {
+		return true
+	}
+	r := a.race
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.winner != nil {
+		return r.winner == a
+	}
+	r.winner = a
+	for _, other := range r.attempts {
+		if other != a {
+			other.lost.Store(true)
+			other.cancel()
+		}
+	}
+	return true
+}
+
+// isLost 本尝试是否已在竞速中落败
+func (a *hedgeAttempt) isLost() bool {
+	return a != nil && a.lost.Load()
+}
+
+// isHedge 本尝试是否为对冲发起的一方（竞速中的第一方是主尝试）
+func (a *hedgeAttempt) isHedge() bool {
+	if a == nil {
+		return false
+	}
+	a.race.mu.Lock()
+	defer a.race.mu.Unlock()
+	return len(a.race.attempts) > 0 && a.race.attempts[0] != a
+}
+
+// responseStarted 本尝试是否已向客户端写出响应。未胜出的一方从不写，
+// 也不能去读正被胜者并发写入的 c.Writer
+func (a *hedgeAttempt) responseStarted(c *gin.Context) bool {
+	if a == nil {
+		return c.Writer.Written()
+	}
+	a.race.mu.Lock()
+	won := a.race.winner == a
+	a.race.mu.Unlock()
+	return won && c.Writer.Written()
+}
+
+// hedgeCall 在给定尝试上执行一次转发
+type hedgeCall func(attempt *hedgeAttempt) (bool, error)
+
+// hedgePlan 单次客户端请求的对冲配置；nil 表示不对冲
+type hedgePlan struct {
+	delay time.Duration
+	// forward 把同一客户端请求转发给任意供应商（模型映射/端点在内部解析）
+	forward func(p Provider, attempt *hedgeAttempt) (bool, error)
+}
+
+// isHedgingEnabled 检查对冲请求是否启用
+func (prs *ProviderRelayService) isHedgingEnabled() bool {
+	if prs.appSettings == nil {
+		return false
+	}
+	settings, err := prs.appSettings.GetAppSettings()
+	if err != nil {
+		return false
+	}
+	return settings.EnableHedging
+}
+
+// hedgeDelay 读取对冲触发延迟，非法值回退默认
+func (prs *ProviderRelayService) hedgeDelay() time.Duration {
+	ms := defaultHedgeDelayMs
+	if prs.appSettings != nil {
+		if settings, err := prs.appSettings.GetAppSettings(); err == nil {
+			ms = normalizeHedgeDelayMs(settings.HedgeDelayMs)
+		}
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// normalizeHedgeDelayMs 对冲延迟：未配置/非正数取默认，限制在 [200ms, 120s]
+func normalizeHedgeDelayMs(ms int) int {
+	if ms <= 0 {
+		return defaultHedgeDelayMs
+	}
+	if ms < minHedgeDelayMs {
+		return minHedgeDelayMs
+	}
+	if ms > maxHedgeDelayMs {
+		return maxHedgeDelayMs
+	}
+	return ms
+}
+
+// newHedgePlan 按设置为本次请求构造对冲配置；未开启或非流式请求返回 nil
+func (prs *ProviderRelayService) newHedgePlan(isStream bool, forward func(p Provider, attempt *hedgeAttempt) (bool, error)) *hedgePlan {
+	if !isStream || !prs.isHedgingEnabled() {
+		return nil
+	}
+	return &hedgePlan{delay: prs.hedgeDelay(), forward: forward}
+}
+
+// hedgeCandidate 在同 Level 排在当前供应商之后的成员中挑第一个可对冲的：
+// 本次请求未实际尝试过、且未被拉黑
+func (prs *ProviderRelayService) hedgeCandidate(kind string, rest []Provider, attempted map[string]bool) *Provider {
+	for i := range rest {
+		p := rest[i]
+		if attempted[strconv.FormatInt(p.ID, 10)] {
+			continue
+		}
+		if prs.blacklistService != nil {
+			if blacklisted, _ := prs.blacklistService.IsBlacklisted(kind, p.Name); blacklisted {
+				continue
+			}
+		}
+		return &p
+	}
+	return nil
+}
+
+// hedgeResult 对冲尝试的结果
+type hedgeResult struct {
+	ok  bool
+	err error
+}
+
+// forwardHedged 执行一次调度尝试。plan 非空且 rest 中有候选时，主尝试 delay 内
+// 未拿到响应头就并发发出对冲；返回实际完成本次尝试的供应商及其结果。
+// 非胜者一方的真实失败在这里记账（计失败、标记已尝试），调度循环只处理返回的这一个结果
+func (prs *ProviderRelayService) forwardHedged(
+	c *gin.Context,
+	kind string,
+	plan *hedgePlan,
+	primary Provider,
+	call hedgeCall,
+	rest []Provider,
+	attempted map[string]bool,
+) (Provider, bool, error) {
+	if plan == nil {
+		ok, err := call(nil)
+		return primary, ok, err
+	}
+	next := prs.hedgeCandidate(kind, rest, attempted)
+	if next == nil {
+		ok, err := call(nil)
+		return primary, ok, err
+	}
+
+	race := &hedgeRace{}
+	race.mu.Lock()
+	main := race.newAttemptLocked(c.Request.Context())
+	race.mu.Unlock()
+	defer main.cancel()
+
+	var hedge *hedgeAttempt
+	var hedgeDone chan hedgeResult
+	timer := time.AfterFunc(plan.delay, func() {
+		race.mu.Lock()
+		defer race.mu.Unlock()
+		if race.closed || race.winner != nil || c.Request.Context().Err() != nil {
+			return
+		}
+		hedge = race.newAttemptLocked(c.Request.Context())
+		hedgeDone = make(chan hedgeResult, 1)
+		fmt.Printf("[INFO] ⚡ Provider %s %dms 内未返回响应头，对冲请求 %s\n",
+			primary.Name, plan.delay.Milliseconds(), next.Name)
+		go func(a *hedgeAttempt, done chan<- hedgeResult) {
+			defer a.cancel()
+			ok, err := plan.forward(*next, a)
+			done <- hedgeResult{ok: ok, err: err}
+		}(hedge, hedgeDone)
+	})
+
+	ok, err := call(main)
+
+	race.mu.Lock()
+	timer.Stop()
+	race.closed = true
+	launched, done := hedge, hedgeDone
+	race.mu.Unlock()
+	if launched == nil {
+		return primary, ok, err
+	}
+
+	// 对冲已发出：等它结束（落败方被取消后很快返回），保证并发配额与日志都已收尾
+	hr := <-done
+	race.mu.Lock()
+	hedgeWon := race.winner == launched
+	race.mu.Unlock()
+
+	if hedgeWon {
+		fmt.Printf("[INFO] ⚡ 对冲胜出: %s 先于 %s 返回响应头\n", next.Name, primary.Name)
+		prs.recordHedgeSideFailure(kind, primary, err, attempted)
+		return *next, hr.ok, hr.err
+	}
+	prs.recordHedgeSideFailure(kind, *next, hr.err, attempted)
+	return primary, ok, err
+}
+
+// recordHedgeSideFailure 为竞速中未被调度循环处理的一方记账：
+// 落败取消、并发满、客户端断开、首响预算耗尽都不算它的失败
+func (prs *ProviderRelayService) recordHedgeSideFailure(kind string, p Provider, err error, attempted map[string]bool) {
+	if err == nil ||
+		errors.Is(err, errHedgeLost) ||
+		errors.Is(err, errProviderBusy) ||
+		errors.Is(err, errClientAbort) ||
+		errors.Is(err, errFirstByteBudget) {
+		return
+	}
+	attempted[strconv.FormatInt(p.ID, 10)] = true
+	fmt.Printf("[WARN] ✗ 对冲一方失败: %s | 错误: %v\n", p.Name, err)
+	if errors.Is(err, errUpstreamClientError) || errors.Is(err, ErrClientRequestRejected) {
+		return
+	}
+	if prs.blacklistService != nil {
+		if err := prs.blacklistService.RecordFailure(kind, p.Name); err != nil {
+			fmt.Printf("[ERROR] 记录失败到黑名单失败: %v\n", err)
+		}
+	}
+}
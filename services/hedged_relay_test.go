@@ -0,0 +1,195 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hedgeTestCall 构造 forwardHedged 的主尝试
+func hedgeTestCall(prs *ProviderRelayService, c *gin.Context, p Provider) hedgeCall {
+	return func(attempt *hedgeAttempt) (bool, error) {
+		return prs.forwardRequestAttempt(c, attempt, "claude", p, "/v1/messages",
+			map[string]string{}, map[string]string{}, []byte(`{"model":"m","stream":true}`), true, "m", 0)
+	}
+}
+
+// TestForwardHedgedFasterHedgeWins 主供应商迟迟不回响应头时对冲胜出：
+// 客户端拿到对冲方的响应，主尝试被取消并归还并发配额
+func TestForwardHedgedFasterHedgeWins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+
+	slowCanceled := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 读完请求体，服务端才会在连接断开时取消 r.Context()
+		_, _ = io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+			close(slowCanceled)
+		case <-time.After(5 * time.Second):
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte("data: slow\n\n"))
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("data: fast\n\n"))
+	}))
+	defer fast.Close()
+
+	prs := newTestRelayService(NewProviderService())
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("POST", "/v1/messages", strings.NewReader(`{}`))
+
+	primary := Provider{ID: 1, Name: "slow", APIURL: slow.URL, APIKey: "k", Enabled: true, MaxConcurrency: 1}
+	backup := Provider{ID: 2, Name: "fast", APIURL: fast.URL, APIKey: "k", Enabled: true}
+	plan := &hedgePlan{delay: 50 * time.Millisecond, forward: func(p Provider, attempt *hedgeAttempt) (bool, error) {
+		return hedgeTestCall(prs, c, p)(attempt)
+	}}
+
+	attempted := map[string]bool{}
+	served, ok, err := prs.forwardHedged(c, "claude", plan, primary, hedgeTestCall(prs, c, primary), []Provider{backup}, attempted)
+	if !ok || served.Name != "fast" {
+		t.Fatalf("对冲方应胜出: served=%s ok=%v err=%v", served.Name, ok, err)
+	}
+	if !strings.Contains(recorder.Body.String(), "fast") || strings.Contains(recorder.Body.String(), "slow") {
+		t.Errorf("客户端只应收到对冲方的响应, 实际 %q", recorder.Body.String())
+	}
+	select {
+	case <-slowCanceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("落败的主尝试应被取消")
+	}
+	if attempted[strconv.FormatInt(primary.ID, 10)] {
+		t.Error("落败方不应被当作失败记为已尝试")
+	}
+	key := strconv.FormatInt(primary.ID, 10)
+	if !prs.concurrency.TryAcquire("claude", key, primary.MaxConcurrency, 0) {
+		t.Fatal("落败方应已归还并发配额")
+	}
+	prs.concurrency.Release("claude", key)
+}
+
+// TestForwardHedgedPrimaryWinsBeforeDelay 主尝试在延迟内拿到响应头时不发对冲
+func TestForwardHedgedPrimaryWinsBeforeDelay(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+
+	var backupHits atomic.Int32
+	primarySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("data: primary\n\n"))
+	}))
+	defer primarySrv.Close()
+	backupSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backupHits.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backupSrv.Close()
+
+	prs := newTestRelayService(NewProviderService())
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("POST", "/v1/messages", strings.NewReader(`{}`))
+
+	primary := Provider{ID: 1, Name: "primary", APIURL: primarySrv.URL, APIKey: "k", Enabled: true}
+	backup := Provider{ID: 2, Name: "backup", APIURL: backupSrv.URL, APIKey: "k", Enabled: true}
+	plan := &hedgePlan{delay: 2 * time.Second, forward: func(p Provider, attempt *hedgeAttempt) (bool, error) {
+		return hedgeTestCall(prs, c, p)(attempt)
+	}}
+
+	served, ok, err := prs.forwardHedged(c, "claude", plan, primary, hedgeTestCall(prs, c, primary), []Provider{backup}, map[string]bool{})
+	if !ok || served.Name != "primary" {
+		t.Fatalf("主尝试应直接成功: served=%s ok=%v err=%v", served.Name, ok, err)
+	}
+	if n := backupHits.Load(); n != 0 {
+		t.Errorf("延迟内已有响应头，不应发出对冲，实际 %d 次", n)
+	}
+}
+
+// TestHedgeWinnerFailureNotRetriedWithPrimaryRequest 拉黑模式下对冲方抢到响应头后在前导阶段断流：
+// 不能拿主供应商的端点/请求体原地重试对冲方，而是直接降级到下一个供应商
+func TestHedgeWinnerFailureNotRetriedWithPrimaryRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupBlacklistFixEnv(t)
+	setAppSetting(t, "enable_blacklist", "true")
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	var flakyPaths []string
+	var flakyMu sync.Mutex
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flakyMu.Lock()
+		flakyPaths = append(flakyPaths, r.URL.Path)
+		flakyMu.Unlock()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(strings.ReplaceAll(testMessageStart, "%s", "flaky")))
+	}))
+	defer flaky.Close()
+	healthy := sseTestUpstream(t, false, strings.ReplaceAll(testMessageStart, "%s", "ok"), testContentDelta, testMessageStop)
+	defer healthy.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "slow", APIURL: slow.URL, APIEndpoint: "/primary/v1/messages", APIKey: "k", Enabled: true, Level: 1},
+		{ID: 2, Name: "flaky", APIURL: flaky.URL, APIKey: "k", Enabled: true, Level: 1},
+		{ID: 3, Name: "healthy", APIURL: healthy.URL, APIKey: "k", Enabled: true, Level: 1},
+	}); err != nil {
+		t.Fatalf("保存供应商失败: %v", err)
+	}
+	relay := newTestRelayService(ps)
+	settings, _ := relay.appSettings.GetAppSettings()
+	settings.EnableHedging = true
+	settings.HedgeDelayMs = minHedgeDelayMs
+	if _, err := relay.appSettings.SaveAppSettings(settings); err != nil {
+		t.Fatalf("保存设置失败: %v", err)
+	}
+	router := gin.New()
+	relay.registerRoutes(router)
+
+	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	w := httptest.NewRecorder()
+	start := time.Now()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "msg_ok") || strings.Contains(w.Body.String(), "msg_flaky") {
+		t.Fatalf("应降级到 healthy: %d %s", w.Code, w.Body.String())
+	}
+	flakyMu.Lock()
+	defer flakyMu.Unlock()
+	if len(flakyPaths) != 1 || flakyPaths[0] != "/v1/messages" {
+		t.Errorf("对冲方只应按自己的端点收到一次请求, 实际 %v", flakyPaths)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("不应等待原地重试间隔, 耗时 %v", elapsed)
+	}
+}
+
+// TestNormalizeHedgeDelayMs 未配置取默认，超界截断
+func TestNormalizeHedgeDelayMs(t *testing.T) {
+	cases := map[int]int{0: defaultHedgeDelayMs, -1: defaultHedgeDelayMs, 50: minHedgeDelayMs, 1500: 1500, 999999: maxHedgeDelayMs}
+	for in, want := range cases {
+		if got := normalizeHedgeDelayMs(in); got != want {
+			t.Errorf("normalizeHedgeDelayMs(%d) = %d, want %d", in, got, want)
+		}
+	}
+}
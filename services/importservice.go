@@ -171,15 +171,12 @@ func (is *ImportService) ImportFromPath(path string) (ConfigImportResult, error)
 	}
 	result.Errors = append(result.Errors, cfg.LoadWarnings...)
 
-	// 阶段 1：claude/codex 供应商。
+	// 阶段 1：claude/codex/openai 供应商。
 	// 本应用导出包走原生 extra 恢复路径（字段全量保真）；
 	// cc-switch 源维持 legacy 候选解析
 	if cfg.BundleMeta != nil {
-		for _, kind := range []string{"claude", "codex"} {
-			section := cfg.Claude
-			if kind == "codex" {
-				section = cfg.Codex
-			}
+		for _, kind := range providerPlatforms {
+			section := cfg.providerSection(kind)
 			candidates, err := is.pendingNativeProviders(kind, section, cfg.BundleMeta.Redacted)
 			if err != nil {
 				result.Errors = append(result.Errors, fmt.Sprintf("读取现有 %s 供应商失败: %v", kind, err))
@@ -263,11 +260,8 @@ func (is *ImportService) evaluateStatus(cfg *ccSwitchConfig) (ConfigImportStatus
 	status := ConfigImportStatus{ConfigExists: true}
 	providerCount := 0
 	if cfg.BundleMeta != nil {
-		for _, kind := range []string{"claude", "codex"} {
-			section := cfg.Claude
-			if kind == "codex" {
-				section = cfg.Codex
-			}
+		for _, kind := range providerPlatforms {
+			section := cfg.providerSection(kind)
 			candidates, err := is.pendingNativeProviders(kind, section, cfg.BundleMeta.Redacted)
 			if err != nil {
 				return status, err
@@ -404,6 +398,7 @@ func loadOwnExportBundle(data []byte, path string) (*ccSwitchConfig, bool, error
 		Claude:  bundle.Claude,
 		Codex:   bundle.Codex,
 		Gemini:  bundle.Gemini,
+		OpenAI:  bundle.OpenAI,
 		MCP:     bundle.MCP,
 		Prompts: map[string][]Prompt{},
 	}
@@ -821,6 +816,8 @@ type ccSwitchConfig struct {
 	Claude ccProviderSection `json:"claude"`
 	Codex  ccProviderSection `json:"codex"`
 	Gemini ccProviderSection `json:"gemini"`
+	// OpenAI 仅本应用导出包携带（cc-switch 没有该平台）
+	OpenAI ccProviderSection `json:"openai"`
 	MCP    ccMCPSection      `json:"mcp"`
 	// 提示词快照：键为平台（claude/codex/gemini）。仅 SQLite 数据源填充
 	// （cc-switch 的旧 JSON 配置没有提示词），json:"-" 确保不会从 JSON 读入
@@ -833,6 +830,18 @@ type ccSwitchConfig struct {
 	BundleMeta *exportMeta `json:"-"`
 }
 
+// providerSection 按 ProviderService 平台取对应的供应商段
+func (cfg *ccSwitchConfig) providerSection(kind string) ccProviderSection {
+	switch kind {
+	case "codex":
+		return cfg.Codex
+	case "openai":
+		return cfg.OpenAI
+	default:
+		return cfg.Claude
+	}
+}
+
 type ccProviderSection struct {
 	Providers map[string]ccProviderEntry `json:"providers"`
 }
@@ -909,7 +918,7 @@ func (is *ImportService) pendingProviders(cfg *ccSwitchConfig) (map[string][]pro
 	return result, nil
 }
 
-// pendingNativeProviders 从本应用导出包的 extra 段恢复 claude/codex 原生供应商。
+// pendingNativeProviders 从本应用导出包的 extra 段恢复 claude/codex/openai 原生供应商。
 // extra 缺失或损坏的条目回退 legacy 基础字段解析；脱敏包或无密钥条目
 // 导入为禁用待补状态，而不是静默丢弃。
 func (is *ImportService) pendingNativeProviders(kind string, section ccProviderSection, redacted bool) ([]Provider, error) {
@@ -962,6 +971,9 @@ func (is *ImportService) pendingNativeProviders(kind string, section ccProviderS
 					entry.Settings.Auth["OPENAI_API_KEY"],
 					entry.Settings.Env["OPENAI_API_KEY"],
 				)
+			case "openai":
+				apiURL = strings.TrimSpace(entry.Settings.Env["OPENAI_BASE_URL"])
+				apiKey = strings.TrimSpace(entry.Settings.Env["OPENAI_API_KEY"])
 			}
 			if name == "" || apiURL == "" {
 				continue
@@ -0,0 +1,312 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========== 供应商多密钥池 ==========
+//
+// 与多地址池（endpointpool.go）同一套约定：
+// - APIKey 保持主密钥，ExtraAPIKeys 为额外密钥（存储上限 16），按声明序去重组成密钥池；
+// - 选择策略 fill_first（默认）：总从池首用起，失败冷却后才轮到下一把；
+//   round_robin：每个请求从下一把开始，均摊各密钥的额度；
+// - 密钥循环包在地址循环外层：401/402/403/429 以及上游明确报额度耗尽的错误
+//   视为"这把密钥不行"，冷却后在同一请求内换下一把，全部失败才算供应商一次失败；
+// - 冷却与统计为进程内状态，应用重启即清零。
+
+// errAPIKeyPoolExhausted 表示多密钥供应商的全部密钥在本次请求内都已失败。
+// 对调度的含义与地址池耗尽相同（记一次失败、立即换供应商），因此包装
+// errEndpointPoolExhausted 复用调用方的处理分支
+var errAPIKeyPoolExhausted = fmt.Errorf("all API keys of provider exhausted: %w", errEndpointPoolExhausted)
+
+// extraAPIKeyLimit 额外密钥存储上限
+const extraAPIKeyLimit = 16
+
+// 密钥选择策略
+const (
+	KeySelectionFillFirst  = "fill_first"
+	KeySelectionRoundRobin = "round_robin"
+)
+
+// 密钥失败后的冷却时长：凭据失效要人工处理，冷得久一些；额度耗尽通常按小时/天恢复
+const (
+	apiKeyAuthCooldown  = 10 * time.Minute
+	apiKeyQuotaCooldown = 30 * time.Minute
+)
+
+// quotaErrorMarkers 上游以 400/403 等状态返回"额度耗尽"时错误体里的常见标记（小写比对）
+var quotaErrorMarkers = []string{
+	"insufficient_quota",
+	"exceeded your current quota",
+	"quota exceeded",
+	"quota_exceeded",
+	"credit balance",
+	"余额不足",
+	"额度不足",
+	"额度已用尽",
+}
+
+// KeyPool 返回按声明序、去重后的完整密钥池（主密钥在首位）。
+// 长度 >1 即"多密钥供应商"，转发走密钥轮换路径。
+func (p *Provider) KeyPool() []string {
+	pool := make([]string, 0, 1+len(p.ExtraAPIKeys))
+	seen := make(map[string]bool, 1+len(p.ExtraAPIKeys))
+	for _, raw := range append([]string{p.APIKey}, p.ExtraAPIKeys...) {
+		key := strings.TrimSpace(raw)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		pool = append(pool, key)
+	}
+	return pool
+}
+
+// probeAPIKey 可用性监控与连通性测试使用的密钥：密钥池首把。
+// 只配置了额外密钥的供应商同样要带凭据探测，否则 401 会把可用的供应商误拉黑
+func (p *Provider) probeAPIKey() string {
+	if pool := p.KeyPool(); len(pool) > 0 {
+		return pool[0]
+	}
+	return ""
+}
+
+// validateExtraAPIKeys 校验额外密钥（供 ValidateConfiguration 复用）
+func validateExtraAPIKeys(keys []string, selection string) []string {
+	errs := make([]string, 0)
+	if len(keys) > extraAPIKeyLimit {
+		errs = append(errs, fmt.Sprintf("额外密钥最多 %d 个（当前 %d 个）", extraAPIKeyLimit, len(keys)))
+	}
+	switch selection {
+	case "", KeySelectionFillFirst, KeySelectionRoundRobin:
+	default:
+		errs = append(errs, fmt.Sprintf("未知的密钥选择策略: %s（可选 fill_first / round_robin）", selection))
+	}
+	return errs
+}
+
+// apiKeySwitchableError 判断失败是否值得换下一把密钥重试：
+// 凭据被拒（401/403）、需付费（402）、限流（429）以及错误体明确报额度耗尽。
+// 客户端取消、响应已提交、对冲落败不换——换密钥也无济于事
+func apiKeySwitchableError(err error) bool {
+	if err == nil ||
+		errors.Is(err, errClientAbort) ||
+		errors.Is(err, errHedgeLost) ||
+		errors.Is(err, errUpstreamStreamAborted) {
+		return false
+	}
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.status {
+		case http.StatusUnauthorized, http.StatusPaymentRequired, http.StatusForbidden, http.StatusTooManyRequests:
+			return true
+		}
+	}
+	return isQuotaError(err)
+}
+
+// countsAsAPIKeyFailure 失败是否计入密钥统计：客户端取消、对冲落败、
+// 首字节预算耗尽、请求内容被拒（额度耗尽除外）与密钥无关，不计
+func countsAsAPIKeyFailure(err error) bool {
+	if errors.Is(err, errClientAbort) || errors.Is(err, errHedgeLost) || errors.Is(err, errFirstByteBudget) {
+		return false
+	}
+	return !errors.Is(err, errUpstreamClientError) || isQuotaError(err)
+}
+
+// isQuotaError 错误文本是否为额度耗尽（部分中转以 400 返回，错误串里带上游原文）
+func isQuotaError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, marker := range quotaErrorMarkers {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyCooldownOf 按失败类型给出密钥冷却时长
+func apiKeyCooldownOf(err error) time.Duration {
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.status {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			if !isQuotaError(err) {
+				return apiKeyAuthCooldown
+			}
+		case http.StatusTooManyRequests:
+			if !isQuotaError(err) {
+				return retryAfterOf(err)
+			}
+		}
+	}
+	return apiKeyQuotaCooldown
+}
+
+// APIKeyStat 单把密钥的进程内统计（密钥已打码）
+type APIKeyStat struct {
+	Index         int    `json:"index"` // 在密钥池中的位置，0 为主密钥
+	MaskedKey     string `json:"maskedKey"`
+	Success       int64  `json:"success"`
+	Failure       int64  `json:"failure"`
+	LastError     string `json:"lastError,omitempty"`
+	LastUsedAt    int64  `json:"lastUsedAt,omitempty"`    // Unix 毫秒
+	CooldownUntil int64  `json:"cooldownUntil,omitempty"` // Unix 毫秒，0 表示未冷却
+}
+
+// apiKeyState 单把密钥的冷却与计数
+type apiKeyState struct {
+	until     time.Time
+	success   int64
+	failure   int64
+	lastError string
+	lastUsed  time.Time
+}
+
+// apiKeyStore 密钥冷却、统计与轮询游标的进程内存储。
+// 键为 (platform, providerID, key)：供应商可改名，不能用 name
+type apiKeyStore struct {
+	mu      sync.Mutex
+	states  map[string]*apiKeyState
+	cursors map[string]uint64
+	nowFn   func() time.Time
+}
+
+func newAPIKeyStore() *apiKeyStore {
+	return &apiKeyStore{
+		states:  make(map[string]*apiKeyState),
+		cursors: make(map[string]uint64),
+		nowFn:   time.Now,
+	}
+}
+
+func apiKeyProviderKey(platform string, providerID int64) string {
+	return platform + "\x00" + strconv.FormatInt(providerID, 10)
+}
+
+func (s *apiKeyStore) stateLocked(platform string, providerID int64, key string) *apiKeyState {
+	k := apiKeyProviderKey(platform, providerID) + "\x00" + key
+	st, ok := s.states[k]
+	if !ok {
+		st = &apiKeyState{}
+		s.states[k] = st
+	}
+	return st
+}
+
+// MarkSuccess 记录成功并清除冷却
+func (s *apiKeyStore) MarkSuccess(platform string, providerID int64, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stateLocked(platform, providerID, key)
+	st.success++
+	st.until = time.Time{}
+	st.lastUsed = s.nowFn()
+}
+
+// MarkFailure 记录失败；d>0 时进入冷却（单密钥供应商只计数不冷却）
+func (s *apiKeyStore) MarkFailure(platform string, providerID int64, key string, d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.nowFn()
+	st := s.stateLocked(platform, providerID, key)
+	st.failure++
+	st.lastUsed = now
+	if err != nil {
+		st.lastError = truncateUTF8(err.Error(), 200)
+	}
+	if d > 0 {
+		st.until = now.Add(d)
+	}
+}
+
+// Order 按选择策略与冷却状态排序密钥池：未冷却的在前（round_robin 时从游标处轮转），
+// 冷却中的按到期时间升序排队尾；全部冷却时只返回最早到期的一把（half-open 探测）
+func (s *apiKeyStore) Order(platform string, providerID int64, pool []string, selection string) []string {
+	if len(pool) <= 1 {
+		return pool
+	}
+	now := s.nowFn()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if selection == KeySelectionRoundRobin {
+		pk := apiKeyProviderKey(platform, providerID)
+		start := int(s.cursors[pk] % uint64(len(pool)))
+		s.cursors[pk]++
+		rotated := make([]string, 0, len(pool))
+		rotated = append(rotated, pool[start:]...)
+		pool = append(rotated, pool[:start]...)
+	}
+
+	type coolingKey struct {
+		key   string
+		until time.Time
+	}
+	active := make([]string, 0, len(pool))
+	cooling := make([]coolingKey, 0, len(pool))
+	for _, key := range pool {
+		st := s.stateLocked(platform, providerID, key)
+		if st.until.After(now) {
+			cooling = append(cooling, coolingKey{key: key, until: st.until})
+			continue
+		}
+		active = append(active, key)
+	}
+	sort.SliceStable(cooling, func(i, j int) bool { return cooling[i].until.Before(cooling[j].until) })
+
+	if len(active) == 0 {
+		return []string{cooling[0].key}
+	}
+	for _, c := range cooling {
+		active = append(active, c.key)
+	}
+	return active
+}
+
+// Stats 按密钥池声明序返回各密钥的统计
+func (s *apiKeyStore) Stats(platform string, providerID int64, pool []string) []APIKeyStat {
+	now := s.nowFn()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make([]APIKeyStat, 0, len(pool))
+	for i, key := range pool {
+		st := s.stateLocked(platform, providerID, key)
+		stat := APIKeyStat{
+			Index:     i,
+			MaskedKey: maskAPIKey(key),
+			Success:   st.success,
+			Failure:   st.failure,
+			LastError: st.lastError,
+		}
+		if !st.lastUsed.IsZero() {
+			stat.LastUsedAt = st.lastUsed.UnixMilli()
+		}
+		if st.until.After(now) {
+			stat.CooldownUntil = st.until.UnixMilli()
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// GetAPIKeyStats 返回供应商各密钥的成功/失败次数与冷却状态（进程内统计，重启清零）
+func (prs *ProviderRelayService) GetAPIKeyStats(platform string, providerID int64) ([]APIKeyStat, error) {
+	providers, err := prs.providerService.LoadProviders(platform)
+	if err != nil {
+		return nil, err
+	}
+	for i := range providers {
+		if providers[i].ID == providerID {
+			return prs.apiKeys.Stats(platform, providerID, providers[i].KeyPool()), nil
+		}
+	}
+	return nil, fmt.Errorf("未找到供应商: %s/%d", platform, providerID)
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestAPIKeyStoreOrder fill_first 按声明序，round_robin 逐请求轮转；冷却中的排队尾，全冷却只放最早到期的一把
+func TestAPIKeyStoreOrder(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := newAPIKeyStore()
+	s.nowFn = func() time.Time { return now }
+	pool := []string{"k1", "k2", "k3"}
+
+	if got := s.Order("claude", 1, pool, ""); strings.Join(got, ",") != "k1,k2,k3" {
+		t.Errorf("fill_first 应按声明序: %v", got)
+	}
+	first := s.Order("claude", 1, pool, KeySelectionRoundRobin)
+	second := s.Order("claude", 1, pool, KeySelectionRoundRobin)
+	if first[0] == second[0] {
+		t.Errorf("round_robin 相邻请求应从不同密钥开始: %v / %v", first, second)
+	}
+
+	s.MarkFailure("claude", 1, "k1", time.Minute, nil)
+	if got := s.Order("claude", 1, pool, ""); strings.Join(got, ",") != "k2,k3,k1" {
+		t.Errorf("冷却中的密钥应排队尾: %v", got)
+	}
+	s.MarkFailure("claude", 1, "k2", 2*time.Minute, nil)
+	s.MarkFailure("claude", 1, "k3", 3*time.Minute, nil)
+	if got := s.Order("claude", 1, pool, ""); len(got) != 1 || got[0] != "k1" {
+		t.Errorf("全冷却应只放最早到期的一把: %v", got)
+	}
+
+	s.MarkSuccess("claude", 1, "k1")
+	stats := s.Stats("claude", 1, pool)
+	if stats[0].Success != 1 || stats[0].Failure != 1 || stats[0].CooldownUntil != 0 || stats[1].CooldownUntil == 0 {
+		t.Errorf("统计不符: %+v", stats)
+	}
+}
+
+// TestAPIKeySwitchableError 凭据、限流与额度类错误换密钥；请求内容与客户端取消不换
+func TestAPIKeySwitchableError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&upstreamStatusError{status: http.StatusUnauthorized}, true},
+		{&upstreamStatusError{status: http.StatusTooManyRequests}, true},
+		{&upstreamStatusError{status: http.StatusBadGateway}, false},
+		{fmt.Errorf("%w: upstream status 400: insufficient_quota", errUpstreamClientError), true},
+		{fmt.Errorf("%w: upstream status 400: bad field", errUpstreamClientError), false},
+		{fmt.Errorf("%w: x", errClientAbort), false},
+		{fmt.Errorf("%w: %w", errEndpointPoolExhausted, &upstreamStatusError{status: http.StatusTooManyRequests}), true},
+	}
+	for i, tc := range cases {
+		if got := apiKeySwitchableError(tc.err); got != tc.want {
+			t.Errorf("#%d %v: 得到 %v, 期望 %v", i, tc.err, got, tc.want)
+		}
+	}
+	if d := apiKeyCooldownOf(&upstreamStatusError{status: http.StatusForbidden}); d != apiKeyAuthCooldown {
+		t.Errorf("403 应按凭据失效冷却: %v", d)
+	}
+}
+
+// TestProxyHandlerRotatesAPIKeys 主密钥被拒时同一请求内改用下一把，不计供应商失败
+func TestProxyHandlerRotatesAPIKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+
+	var badHits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good-key" {
+			badHits.Add(1)
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid key"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"usage":{"input_tokens":1,"output_tokens":1}}`))
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{{
+		ID: 1, Name: "pool", APIURL: upstream.URL, APIKey: "revoked-key", Enabled: true,
+		ExtraAPIKeys: []string{"good-key"},
+	}}); err != nil {
+		t.Fatalf("保存供应商失败: %v", err)
+	}
+	prs := newTestRelayService(ps)
+
+	serve := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5"}`))
+		prs.proxyHandler("claude", "/v1/messages")(c)
+		return recorder
+	}
+	for i := 0; i < 2; i++ {
+		if rec := serve(); rec.Code != http.StatusOK {
+			t.Fatalf("第 %d 次应换密钥后成功: %d %s", i+1, rec.Code, rec.Body.String())
+		}
+	}
+	if n := badHits.Load(); n != 1 {
+		t.Errorf("被拒密钥冷却后不应再被先用: 命中 %d 次", n)
+	}
+
+	stats, err := prs.GetAPIKeyStats("claude", 1)
+	if err != nil || len(stats) != 2 {
+		t.Fatalf("读取密钥统计失败: %v %+v", err, stats)
+	}
+	if stats[0].Failure != 1 || stats[0].CooldownUntil == 0 || stats[1].Success != 2 {
+		t.Errorf("密钥统计不符: %+v", stats)
+	}
+	if strings.Contains(stats[0].MaskedKey, "revoked-key") {
+		t.Errorf("统计中的密钥应打码: %s", stats[0].MaskedKey)
+	}
+}
+
+// TestProxyHandlerExtraKeysOnly 主密钥留空、只配置额外密钥的供应商同样参与路由
+func TestProxyHandlerExtraKeysOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer extra-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"usage":{"input_tokens":1,"output_tokens":1}}`))
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{{
+		ID: 1, Name: "extra-only", APIURL: upstream.URL, Enabled: true,
+		ExtraAPIKeys: []string{"extra-key"},
+	}}); err != nil {
+		t.Fatalf("保存供应商失败: %v", err)
+	}
+	prs := newTestRelayService(ps)
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5"}`))
+	prs.proxyHandler("claude", "/v1/messages")(c)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("只有额外密钥的供应商应可用: %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+// TestValidateExtraAPIKeys 数量上限与未知选择策略报错
+func TestValidateExtraAPIKeys(t *testing.T) {
+	p := Provider{ExtraAPIKeys: make([]string, extraAPIKeyLimit+1), KeySelection: "random"}
+	if errs := p.ValidateConfiguration(); len(errs) != 2 {
+		t.Errorf("超量与未知策略都应报错: %v", errs)
+	}
+	p = Provider{APIKey: "a", ExtraAPIKeys: []string{" b ", "a", ""}}
+	if pool := p.KeyPool(); strings.Join(pool, ",") != "a,b" {
+		t.Errorf("密钥池应去重去空: %v", pool)
+	}
+}
+
+// TestProbeUsesExtraKeysOnly 只配置额外密钥的供应商，健康检查与连通性测试同样带上密钥池首把
+func TestProbeUsesExtraKeysOnly(t *testing.T) {
+	var unauthorized atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "extra-key" {
+			unauthorized.Add(1)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"model":"test-model"}`))
+	}))
+	defer upstream.Close()
+
+	provider := Provider{
+		ID: 1, Name: "extra-only", APIURL: upstream.URL, Enabled: true,
+		ExtraAPIKeys: []string{"extra-key"},
+		AvailabilityConfig: &AvailabilityConfig{
+			TestModel:    "test-model",
+			TestEndpoint: "/v1/messages",
+			Timeout:      5000,
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if got := NewHealthCheckService(nil, nil, nil, nil).checkProvider(ctx, provider, "claude"); got.Status == HealthStatusFailed {
+		t.Errorf("健康检查应带额外密钥探测成功: %s", got.ErrorMessage)
+	}
+	if got := NewConnectivityTestService(nil, nil, nil, nil).TestProvider(ctx, provider, "claude"); got.Status != StatusAvailable {
+		t.Errorf("连通性测试应带额外密钥探测成功: status=%d msg=%s", got.Status, got.Message)
+	}
+	if n := unauthorized.Load(); n != 0 {
+		t.Errorf("探测不应缺少凭据: 401 %d 次", n)
+	}
+}
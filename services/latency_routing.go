@@ -0,0 +1,293 @@
+package services
+
+import (
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========== 同 Level 延迟感知排序（最快优先）==========
+//
+// 开启后，同一 Level 内的候选按真实转发流量统计的延迟从快到慢排序，
+// 取代拖拽顺序与轮询；Level 之间的降级顺序不变。
+//
+// 指标只从成功的流式请求采样（非流式请求的"首字节"已包含整段生成时间，没有可比性）：
+//   - 首字节耗时（TTFB）：从发出请求到读到上游响应体第一个字节；
+//   - 输出速率：输出 token 数 / 首字节之后的传输时长（token 太少的样本不计速率）。
+//
+// 二者各自取 EWMA。排序分数为"首字节 + 生成 latencyRefOutputTokens 个 token 的预计耗时"，
+// 即一个典型回答的预计完成时间；速率未知时只按首字节计。
+//
+// 没有样本的供应商排在有样本者之前（保持用户排序），尽快摸底；另有
+// LatencyExplorePercent% 的请求会把样本最旧的非首位供应商提到首位探测，
+// 避免慢供应商的统计永远停留在过去。统计为进程内状态，重启即清零。
+
+const (
+	latencyEWMAAlpha       = 0.3 // EWMA 新样本权重
+	latencyRefOutputTokens = 500 // 排序分数按生成多少 token 估算完成时间
+	latencyMinOutputTokens = 20  // 输出 token 少于此值的样本不计速率（时长太短，噪声大）
+
+	defaultLatencyExplorePercent = 5
+	maxLatencyExplorePercent     = 50
+)
+
+// latencyStat 单个供应商的延迟统计
+type latencyStat struct {
+	ttfb       float64 // 首字节耗时 EWMA（秒）
+	tps        float64 // 输出速率 EWMA（token/s），0=暂无速率样本
+	samples    int64
+	lastSample time.Time
+}
+
+// score 预计完成一个典型回答的耗时（秒），越小越快
+func (s *latencyStat) score() float64 {
+	if s.tps <= 0 {
+		return s.ttfb
+	}
+	return s.ttfb + latencyRefOutputTokens/s.tps
+}
+
+// latencyTracker 按 "platform:providerName" 维护延迟统计（进程内）
+type latencyTracker struct {
+	mu    sync.Mutex
+	stats map[string]*latencyStat
+	// rand 探测抽样用，测试可替换
+	rand func() float64
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{
+		stats: make(map[string]*latencyStat),
+		rand:  rand.Float64,
+	}
+}
+
+func latencyKey(platform, providerName string) string {
+	return platform + ":" + providerName
+}
+
+// observe 记录一次样本。genDuration 为首字节之后的传输时长，配合 outputTokens 计算速率
+func (t *latencyTracker) observe(platform, providerName string, ttfb time.Duration, outputTokens int, genDuration time.Duration) {
+	if t == nil || ttfb <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := latencyKey(platform, providerName)
+	st := t.stats[key]
+	if st == nil {
+		st = &latencyStat{}
+		t.stats[key] = st
+	}
+
+	ttfbSec := ttfb.Seconds()
+	if st.samples == 0 {
+		st.ttfb = ttfbSec
+	} else {
+		st.ttfb = latencyEWMAAlpha*ttfbSec + (1-latencyEWMAAlpha)*st.ttfb
+	}
+	if outputTokens >= latencyMinOutputTokens && genDuration > 0 {
+		tps := float64(outputTokens) / genDuration.Seconds()
+		if st.tps <= 0 {
+			st.tps = tps
+		} else {
+			st.tps = latencyEWMAAlpha*tps + (1-latencyEWMAAlpha)*st.tps
+		}
+	}
+	st.samples++
+	st.lastSample = time.Now()
+}
+
+// observeStream 流式请求成功后记录样本；探针没读到任何数据时忽略
+func (t *latencyTracker) observeStream(platform, providerName string, start time.Time, probe *firstByteReader, outputTokens int) {
+	if probe == nil || probe.first.IsZero() {
+		return
+	}
+	t.observe(platform, providerName, probe.first.Sub(start), outputTokens, time.Since(probe.first))
+}
+
+// order 返回同组成员按延迟排序后的下标：无样本者在前（保持原顺序），
+// 有样本者按 score 升序；按 explorePercent 概率把样本最旧的非首位成员提到首位
+func (t *latencyTracker) order(platform string, names []string, explorePercent int) []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var unsampled, sampled []int
+	for i, name := range names {
+		if st := t.stats[latencyKey(platform, name)]; st != nil && st.samples > 0 {
+			sampled = append(sampled, i)
+		} else {
+			unsampled = append(unsampled, i)
+		}
+	}
+	sort.SliceStable(sampled, func(a, b int) bool {
+		return t.stats[latencyKey(platform, names[sampled[a]])].score() <
+			t.stats[latencyKey(platform, names[sampled[b]])].score()
+	})
+	order := append(unsampled, sampled...)
+
+	// 还有未摸底的成员时它们本就排在前面，无需额外探测
+	if len(unsampled) == 0 && len(order) > 1 && explorePercent > 0 &&
+		t.rand()*100 < float64(explorePercent) {
+		stalest := 1
+		for i := 2; i < len(order); i++ {
+			if t.stats[latencyKey(platform, names[order[i]])].lastSample.Before(
+				t.stats[latencyKey(platform, names[order[stalest]])].lastSample) {
+				stalest = i
+			}
+		}
+		picked := order[stalest]
+		copy(order[1:stalest+1], order[:stalest])
+		order[0] = picked
+	}
+	return order
+}
+
+// firstByteReader 包裹上游响应体，记录第一次读到数据的时刻（单协程读取）
+type firstByteReader struct {
+	io.ReadCloser
+	first time.Time
+}
+
+func (r *firstByteReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 && r.first.IsZero() {
+		r.first = time.Now()
+	}
+	return n, err
+}
+
+// latencyRoutingScope 最快优先的生效范围：单次请求读取一次，逐 Level 判断
+type latencyRoutingScope struct {
+	enabled bool
+	levels  map[int]bool // 为空表示所有 Level
+}
+
+// appliesTo 指定 Level 是否按最快优先排序（不在范围内的 Level 沿用轮询或用户排序）
+func (s latencyRoutingScope) appliesTo(level int) bool {
+	return s.enabled && (len(s.levels) == 0 || s.levels[level])
+}
+
+// latencyScope 读取延迟感知排序的开关与生效 Level（开启后在这些 Level 内取代轮询）
+func (prs *ProviderRelayService) latencyScope() latencyRoutingScope {
+	if prs.appSettings == nil {
+		return latencyRoutingScope{}
+	}
+	settings, err := prs.appSettings.GetAppSettings()
+	if err != nil || !settings.EnableLatencyRouting {
+		return latencyRoutingScope{}
+	}
+	scope := latencyRoutingScope{enabled: true}
+	if len(settings.LatencyRoutingLevels) > 0 {
+		scope.levels = make(map[int]bool, len(settings.LatencyRoutingLevels))
+		for _, level := range settings.LatencyRoutingLevels {
+			scope.levels[level] = true
+		}
+	}
+	return scope
+}
+
+// latencyExplorePercent 读取探测比例，非法值回退默认
+func (prs *ProviderRelayService) latencyExplorePercent() int {
+	if prs.appSettings == nil {
+		return defaultLatencyExplorePercent
+	}
+	settings, err := prs.appSettings.GetAppSettings()
+	if err != nil {
+		return defaultLatencyExplorePercent
+	}
+	return normalizeLatencyExplorePercent(settings.LatencyExplorePercent)
+}
+
+// normalizeLatencyExplorePercent 探测比例：负数视为默认，0 关闭探测，上限 50
+func normalizeLatencyExplorePercent(percent int) int {
+	if percent < 0 {
+		return defaultLatencyExplorePercent
+	}
+	if percent > maxLatencyExplorePercent {
+		return maxLatencyExplorePercent
+	}
+	return percent
+}
+
+// latencyOrder 对同 Level 的 providers 按延迟排序（返回新切片，不修改原切片）
+func (prs *ProviderRelayService) latencyOrder(platform string, providers []Provider) []Provider {
+	if len(providers) <= 1 || prs.latency == nil {
+		return providers
+	}
+	names := make([]string, len(providers))
+	for i, p := range providers {
+		names[i] = p.Name
+	}
+	result := make([]Provider, 0, len(providers))
+	for _, idx := range prs.latency.order(platform, names, prs.latencyExplorePercent()) {
+		result = append(result, providers[idx])
+	}
+	return result
+}
+
+// latencyOrderGemini 对 Gemini providers 按延迟排序（复用相同逻辑）
+func (prs *ProviderRelayService) latencyOrderGemini(providers []GeminiProvider) []GeminiProvider {
+	if len(providers) <= 1 || prs.latency == nil {
+		return providers
+	}
+	names := make([]string, len(providers))
+	for i, p := range providers {
+		names[i] = p.Name
+	}
+	result := make([]GeminiProvider, 0, len(providers))
+	for _, idx := range prs.latency.order("gemini", names, prs.latencyExplorePercent()) {
+		result = append(result, providers[idx])
+	}
+	return result
+}
+
+// ProviderLatencyStat 供应商延迟统计（供前端展示）
+type ProviderLatencyStat struct {
+	Platform     string  `json:"platform"`
+	ProviderName string  `json:"provider_name"`
+	TTFBMs       float64 `json:"ttfb_ms"`        // 首字节耗时 EWMA（毫秒）
+	TokensPerSec float64 `json:"tokens_per_sec"` // 输出速率 EWMA，0=暂无样本
+	Samples      int64   `json:"samples"`
+	UpdatedAt    int64   `json:"updated_at"` // 最近一次采样时间（毫秒）
+}
+
+// GetProviderLatencyStats 获取各平台供应商的延迟统计（按平台分组，组内从快到慢）
+func (prs *ProviderRelayService) GetProviderLatencyStats() map[string][]ProviderLatencyStat {
+	result := make(map[string][]ProviderLatencyStat)
+	t := prs.latency
+	if t == nil {
+		return result
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	scores := make(map[string]float64)
+	for key, st := range t.stats {
+		sep := strings.Index(key, ":")
+		// custom:<id> 平台本身带冒号，供应商名在第二个冒号之后
+		if strings.HasPrefix(key, "custom:") {
+			sep = strings.Index(key[len("custom:"):], ":") + len("custom:")
+		}
+		platform, name := key[:sep], key[sep+1:]
+		scores[key] = st.score()
+		result[platform] = append(result[platform], ProviderLatencyStat{
+			Platform:     platform,
+			ProviderName: name,
+			TTFBMs:       st.ttfb * 1000,
+			TokensPerSec: st.tps,
+			Samples:      st.samples,
+			UpdatedAt:    st.lastSample.UnixMilli(),
+		})
+	}
+	for platform, list := range result {
+		sort.SliceStable(list, func(a, b int) bool {
+			return scores[latencyKey(platform, list[a].ProviderName)] < scores[latencyKey(platform, list[b].ProviderName)]
+		})
+	}
+	return result
+}
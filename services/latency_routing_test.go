@@ -0,0 +1,98 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestLatencyTrackerOrderFastestFirst 有样本者按"首字节 + 典型回答生成耗时"升序，
+// 无样本者排在最前以便尽快摸底
+func TestLatencyTrackerOrderFastestFirst(t *testing.T) {
+	tr := newLatencyTracker()
+	tr.rand = func() float64 { return 1 } // 关闭探测
+
+	// A：首字节快但输出慢；B：首字节稍慢但输出快，典型回答更早完成
+	tr.observe("claude", "A", 500*time.Millisecond, 100, 10*time.Second) // 10 tok/s → 0.5+50s
+	tr.observe("claude", "B", 2*time.Second, 1000, 10*time.Second)       // 100 tok/s → 2+5s
+
+	names := []string{"A", "B", "C"}
+	got := tr.order("claude", names, 5)
+	if names[got[0]] != "C" || names[got[1]] != "B" || names[got[2]] != "A" {
+		t.Errorf("排序 = %v, want [C B A]", []string{names[got[0]], names[got[1]], names[got[2]]})
+	}
+
+	// 其它平台的同名供应商互不影响
+	if got := tr.order("codex", []string{"A", "B"}, 5); got[0] != 0 || got[1] != 1 {
+		t.Errorf("无样本平台应保持原顺序: %v", got)
+	}
+}
+
+// TestLatencyTrackerEWMA 首个样本直接取值，之后按 EWMA 平滑；token 过少不计速率
+func TestLatencyTrackerEWMA(t *testing.T) {
+	tr := newLatencyTracker()
+	tr.observe("codex", "P", time.Second, 5, time.Second) // 输出过少：只计首字节
+	tr.observe("codex", "P", 2*time.Second, 200, 2*time.Second)
+
+	st := tr.stats[latencyKey("codex", "P")]
+	if st.samples != 2 {
+		t.Fatalf("samples = %d, want 2", st.samples)
+	}
+	if want := latencyEWMAAlpha*2 + (1-latencyEWMAAlpha)*1; math.Abs(st.ttfb-want) > 1e-9 {
+		t.Errorf("ttfb = %v, want %v", st.ttfb, want)
+	}
+	if st.tps != 100 {
+		t.Errorf("tps = %v, want 100（首个有效速率样本直接取值）", st.tps)
+	}
+}
+
+// TestLatencyTrackerExploration 命中探测比例时，样本最旧的非首位供应商提到首位
+func TestLatencyTrackerExploration(t *testing.T) {
+	tr := newLatencyTracker()
+	tr.observe("claude", "slow-old", 5*time.Second, 0, 0)
+	tr.observe("claude", "slow-new", 4*time.Second, 0, 0)
+	tr.observe("claude", "fast", time.Second, 0, 0)
+	now := time.Now()
+	tr.stats[latencyKey("claude", "slow-old")].lastSample = now.Add(-time.Hour)
+	tr.stats[latencyKey("claude", "slow-new")].lastSample = now.Add(-time.Minute)
+
+	names := []string{"fast", "slow-new", "slow-old"}
+	tr.rand = func() float64 { return 0.01 } // 1% < 5%：命中探测
+	got := tr.order("claude", names, 5)
+	if names[got[0]] != "slow-old" || names[got[1]] != "fast" || names[got[2]] != "slow-new" {
+		t.Errorf("探测排序 = %v, want [slow-old fast slow-new]", []string{names[got[0]], names[got[1]], names[got[2]]})
+	}
+
+	// 探测比例为 0 时不探测
+	got = tr.order("claude", names, 0)
+	if names[got[0]] != "fast" {
+		t.Errorf("探测关闭时应选最快者, 实际 %s", names[got[0]])
+	}
+}
+
+// TestLatencyScopePerLevel 配置生效 Level 后只有这些 Level 按最快优先排序，留空表示所有 Level
+func TestLatencyScopePerLevel(t *testing.T) {
+	setupRenameTestEnv(t)
+	relay := newTestRelayService(NewProviderService())
+	if scope := relay.latencyScope(); scope.appliesTo(1) {
+		t.Fatal("默认关闭时不应生效")
+	}
+
+	settings, _ := relay.appSettings.GetAppSettings()
+	settings.EnableLatencyRouting = true
+	if _, err := relay.appSettings.SaveAppSettings(settings); err != nil {
+		t.Fatalf("保存设置失败: %v", err)
+	}
+	if scope := relay.latencyScope(); !scope.appliesTo(1) || !scope.appliesTo(5) {
+		t.Error("未指定 Level 时应对所有 Level 生效")
+	}
+
+	settings.LatencyRoutingLevels = []int{2, 3}
+	if _, err := relay.appSettings.SaveAppSettings(settings); err != nil {
+		t.Fatalf("保存设置失败: %v", err)
+	}
+	scope := relay.latencyScope()
+	if scope.appliesTo(1) || !scope.appliesTo(2) || !scope.appliesTo(3) || scope.appliesTo(4) {
+		t.Errorf("应只对 Level 2、3 生效: %+v", scope)
+	}
+}
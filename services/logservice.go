@@ -24,7 +24,26 @@ func (ls *LogService) CostSince(start string, platform string) (float64, error)
 	if err != nil {
 		return 0, err
 	}
-	model := xdb.New("request_log")
+	return ls.costSince(startTime, platform, "")
+}
+
+// costSince 统计 startTime 起的费用；platform/provider 为空表示不按该维度过滤。
+// 缓存保活心跳记在独立的 cache_heartbeat_log，费用同样计入（消费上限与预算都按此口径）
+func (ls *LogService) costSince(startTime time.Time, platform string, provider string) (float64, error) {
+	total := 0.0
+	for _, table := range []string{"request_log", "cache_heartbeat_log"} {
+		cost, err := ls.tableCostSince(table, startTime, platform, provider)
+		if err != nil {
+			return 0, err
+		}
+		total += cost
+	}
+	return total, nil
+}
+
+// tableCostSince 统计单张日志表 startTime 起的费用（两张表的计费列同名）
+func (ls *LogService) tableCostSince(table string, startTime time.Time, platform string, provider string) (float64, error) {
+	model := xdb.New(table)
 	// created_at 由 DEFAULT CURRENT_TIMESTAMP 落库,存的是 UTC 文本,
 	// 查询边界必须先转 UTC 再格式化,否则非 UTC 时区下与存储口径错位
 	options := []xdb.Option{
@@ -39,11 +58,15 @@ func (ls *LogService) CostSince(start string, platform string) (float64, error)
 			"ephemeral_5m_tokens",
 			"ephemeral_1h_tokens",
 			"service_tier",
+			"price_multiplier",
 		),
 	}
 	if platform != "" {
 		options = append(options, xdb.WhereEq("platform", platform))
 	}
+	if provider != "" {
+		options = append(options, xdb.WhereEq("provider", provider))
+	}
 	records, err := model.Selects(options...)
 	if err != nil {
 		if errors.Is(err, xdb.ErrNotFound) || isNoSuchTableErr(err) {
@@ -54,7 +77,7 @@ func (ls *LogService) CostSince(start string, platform string) (float64, error)
 	total := 0.0
 	for _, record := range records {
 		usage := buildSnapshotFromRecord(record)
-		cost := ls.calculateCost(record.GetString("model"), usage)
+		cost := ls.calculateCost(record.GetString("model"), usage, recordPriceMultiplier(record))
 		total += cost.TotalCost
 	}
 	return total, nil
@@ -104,7 +127,7 @@ func (ls *LogService) ListRequestLogs(platform string, provider string, limit in
 		// 谓词须与 capturesession.go 的 captureRowPredicate 覆盖同一批列
 		xdb.Field("id, platform, model, provider, http_code, input_tokens, output_tokens, " +
 			"cache_create_tokens, cache_read_tokens, reasoning_tokens, is_stream, duration_sec, " +
-			"created_at, ephemeral_5m_tokens, ephemeral_1h_tokens, service_tier, " +
+			"created_at, ephemeral_5m_tokens, ephemeral_1h_tokens, service_tier, price_multiplier, hedged, session_key, sticky_hit, client_name, " +
 			"(request_url != '' OR request_headers != '' OR request_body != '' OR response_headers != '' OR response_body != '' OR body_truncated != 0 OR body_bytes != 0 OR response_truncated != 0 OR response_bytes != 0 OR budget_skipped != 0) AS has_capture"),
 		xdb.OrderByDesc("id"),
 		xdb.Limit(limit),
@@ -138,6 +161,11 @@ func (ls *LogService) ListRequestLogs(platform string, provider string, limit in
 			IsStream:          record.GetBool("is_stream"),
 			DurationSec:       record.GetFloat64("duration_sec"),
 			ServiceTier:       record.GetString("service_tier"),
+			PriceMultiplier:   recordPriceMultiplier(record),
+			Hedged:            record.GetBool("hedged"),
+			SessionKey:        record.GetString("session_key"),
+			StickyHit:         record.GetBool("sticky_hit"),
+			ClientName:        record.GetString("client_name"),
 			HasCapture:        record.GetBool("has_capture"),
 		}
 		ls.decorateCost(&logEntry)
@@ -261,6 +289,7 @@ func (ls *LogService) HeatmapStats(days int) ([]HeatmapStat, error) {
 			"ephemeral_5m_tokens",
 			"ephemeral_1h_tokens",
 			"service_tier",
+			"price_multiplier",
 			"created_at",
 		),
 		xdb.OrderByDesc("created_at"),
@@ -294,7 +323,7 @@ func (ls *LogService) HeatmapStats(days int) ([]HeatmapStat, error) {
 		bucket.InputTokens += int64(usage.InputTokens)
 		bucket.OutputTokens += int64(usage.OutputTokens)
 		bucket.ReasoningTokens += int64(usage.ReasoningTokens)
-		cost := ls.calculateCost(record.GetString("model"), usage)
+		cost := ls.calculateCost(record.GetString("model"), usage, recordPriceMultiplier(record))
 		bucket.TotalCost += cost.TotalCost
 	}
 	if len(hourBuckets) == 0 {
@@ -338,6 +367,7 @@ func (ls *LogService) StatsSince(platform string) (LogStats, error) {
 			"ephemeral_5m_tokens",
 			"ephemeral_1h_tokens",
 			"service_tier",
+			"price_multiplier",
 			"created_at",
 		),
 		xdb.OrderByAsc("created_at"),
@@ -389,7 +419,7 @@ func (ls *LogService) StatsSince(platform string) (LogStats, error) {
 		}
 		bucket := seriesBuckets[bucketIndex]
 		usage := buildSnapshotFromRecord(record)
-		cost := ls.calculateCost(record.GetString("model"), usage)
+		cost := ls.calculateCost(record.GetString("model"), usage, recordPriceMultiplier(record))
 
 		bucket.TotalRequests++
 		bucket.InputTokens += int64(usage.InputTokens)
@@ -430,6 +460,12 @@ func (ls *LogService) StatsSince(platform string) (LogStats, error) {
 }
 
 func (ls *LogService) ProviderDailyStats(platform string) ([]ProviderDailyStat, error) {
+	return ls.dailyStatsBy(platform, "provider")
+}
+
+// dailyStatsBy 按 request_log 的某一列（provider / client_name）汇总今日统计，
+// 分组值写在结果的 Provider 字段，空值记为 (unknown)
+func (ls *LogService) dailyStatsBy(platform string, column string) ([]ProviderDailyStat, error) {
 	start := startOfDay(time.Now())
 	end := start.Add(24 * time.Hour)
 	queryStart := start.Add(-24 * time.Hour)
@@ -437,7 +473,7 @@ func (ls *LogService) ProviderDailyStats(platform string) ([]ProviderDailyStat,
 	options := []xdb.Option{
 		xdb.WhereGte("created_at", queryStart.Format(timeLayout)),
 		xdb.Field(
-			"provider",
+			column,
 			"model",
 			"http_code",
 			"input_tokens",
@@ -448,6 +484,8 @@ func (ls *LogService) ProviderDailyStats(platform string) ([]ProviderDailyStat,
 			"ephemeral_5m_tokens",
 			"ephemeral_1h_tokens",
 			"service_tier",
+			"price_multiplier",
+			"hedged",
 			"created_at",
 		),
 	}
@@ -463,7 +501,7 @@ func (ls *LogService) ProviderDailyStats(platform string) ([]ProviderDailyStat,
 	}
 	statMap := map[string]*ProviderDailyStat{}
 	for _, record := range records {
-		provider := strings.TrimSpace(record.GetString("provider"))
+		provider := strings.TrimSpace(record.GetString(column))
 		if provider == "" {
 			provider = "(unknown)"
 		}
@@ -478,6 +516,10 @@ func (ls *LogService) ProviderDailyStats(platform string) ([]ProviderDailyStat,
 				continue
 			}
 		}
+		// 对冲落败被取消的尝试既不是成功也不是失败，不计入供应商统计
+		if record.GetBool("hedged") {
+			continue
+		}
 		stat := statMap[provider]
 		if stat == nil {
 			stat = &ProviderDailyStat{Provider: provider}
@@ -485,7 +527,7 @@ func (ls *LogService) ProviderDailyStats(platform string) ([]ProviderDailyStat,
 		}
 		httpCode := record.GetInt("http_code")
 		usage := buildSnapshotFromRecord(record)
-		cost := ls.calculateCost(record.GetString("model"), usage)
+		cost := ls.calculateCost(record.GetString("model"), usage, recordPriceMultiplier(record))
 		stat.TotalRequests++
 		// 只有 HTTP 200-299 才算成功，其他（包括 0）都算失败
 		if httpCode >= 200 && httpCode < 300 {
@@ -534,7 +576,8 @@ func (ls *LogService) decorateCost(logEntry *ReqeustLog) {
 			Ephemeral1hTokens: logEntry.Ephemeral1hTokens,
 		}
 	}
-	cost := ls.pricing.CalculateCost(logEntry.Model, usage)
+	logEntry.PriceMultiplier = effectiveLogPriceMultiplier(logEntry.PriceMultiplier)
+	cost := scaleCostBreakdown(ls.pricing.CalculateCost(logEntry.Model, usage), logEntry.PriceMultiplier)
 	logEntry.HasPricing = cost.HasPricing
 	logEntry.InputCost = cost.InputCost
 	logEntry.OutputCost = cost.OutputCost
@@ -546,11 +589,33 @@ func (ls *LogService) decorateCost(logEntry *ReqeustLog) {
 	logEntry.TotalCost = cost.TotalCost
 }
 
-func (ls *LogService) calculateCost(model string, usage modelpricing.UsageSnapshot) modelpricing.CostBreakdown {
+// calculateCost 按官方价计算费用后乘以请求时记录的供应商价格倍率
+func (ls *LogService) calculateCost(model string, usage modelpricing.UsageSnapshot, multiplier float64) modelpricing.CostBreakdown {
 	if ls == nil || ls.pricing == nil {
 		return modelpricing.CostBreakdown{}
 	}
-	return ls.pricing.CalculateCost(model, usage)
+	return scaleCostBreakdown(ls.pricing.CalculateCost(model, usage), multiplier)
+}
+
+// recordPriceMultiplier 读取日志行的价格倍率；旧数据或异常值按 1 处理
+func recordPriceMultiplier(record xdb.Record) float64 {
+	return effectiveLogPriceMultiplier(record.GetFloat64("price_multiplier"))
+}
+
+// scaleCostBreakdown 把费用明细按倍率缩放（倍率为 1 时原样返回）
+func scaleCostBreakdown(cost modelpricing.CostBreakdown, multiplier float64) modelpricing.CostBreakdown {
+	if multiplier <= 0 || multiplier == 1 {
+		return cost
+	}
+	cost.InputCost *= multiplier
+	cost.OutputCost *= multiplier
+	cost.ReasoningCost *= multiplier
+	cost.CacheCreateCost *= multiplier
+	cost.CacheReadCost *= multiplier
+	cost.Ephemeral5mCost *= multiplier
+	cost.Ephemeral1hCost *= multiplier
+	cost.TotalCost *= multiplier
+	return cost
 }
 
 func parseCreatedAt(record xdb.Record) (time.Time, bool) {
@@ -56,6 +56,10 @@ func setupLogFixTestDB(t *testing.T) *sql.DB {
 		ephemeral_5m_tokens INTEGER DEFAULT 0,
 		ephemeral_1h_tokens INTEGER DEFAULT 0,
 		service_tier TEXT DEFAULT '',
+		price_multiplier REAL DEFAULT 1,
+		hedged INTEGER DEFAULT 0,
+		session_key TEXT DEFAULT '',
+		sticky_hit INTEGER DEFAULT 0,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	)`
 	if _, err := db.Exec(schema); err != nil {
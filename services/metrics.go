@@ -0,0 +1,447 @@
+package services
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+)
+
+// ========== Prometheus 指标（/metrics）==========
+//
+// 代理监听器上的 GET /metrics 以 Prometheus 文本格式（0.0.4）导出代理内部状态，
+// 不引入客户端库，手写最小的计数器/直方图与文本编码。默认关闭，在「设置 → 网络」
+// 开启后与管理 API 共用管理令牌认证（Prometheus 的 authorization.credentials），
+// 关闭时返回 404，同样不受客户端访问令牌约束。
+//
+//   - 请求类指标在请求日志落库前记录，一条请求日志对应一次观测：claude / codex /
+//     自定义 CLI 按供应商尝试计，gemini 按整次请求计；status 取上游 HTTP 状态码
+//     （0 = 未拿到响应），对冲落败方记为 hedged；
+//   - 供应商切换（failover）按同一客户端请求内先后尝试的供应商变化计数，
+//     对冲发起的并发尝试不算切换；
+//   - 黑名单、并发与数据库写入队列是抓取时读取的即时值。
+//
+// 计数器保存在进程内，重启归零（Prometheus 的 rate/increase 可正确处理重置）。
+
+const (
+	metricsPath = "/metrics"
+	// metricsAttemptKey gin.Context 中记录本次请求上一次尝试的供应商
+	metricsAttemptKey = "codeswitch.metrics.provider"
+)
+
+// requestDurationBuckets 请求耗时直方图分桶（秒）：流式长请求常见数十秒到数分钟
+var requestDurationBuckets = []float64{0.5, 1, 2, 5, 10, 30, 60, 120, 300, 600}
+
+// metricsTokenTypes 按类型导出的 token 计数
+var metricsTokenTypes = []string{"input", "output", "cache_create", "cache_read", "reasoning"}
+
+type metricsRequestKey struct {
+	platform, provider, status string
+}
+
+type metricsProviderKey struct {
+	platform, provider string
+}
+
+type metricsFailoverKey struct {
+	platform, from, to string
+}
+
+// metricsHistogram 累积直方图：counts[i] 为落入第 i 个上界（含）的观测数，不含 +Inf
+type metricsHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *metricsHistogram) observe(v float64) {
+	for i, bound := range requestDurationBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// relayMetrics 代理请求类指标的进程内累积值
+type relayMetrics struct {
+	mu        sync.Mutex
+	logs      *LogService
+	requests  map[metricsRequestKey]*metricsHistogram
+	tokens    map[metricsProviderKey]map[string]float64
+	cost      map[metricsProviderKey]float64
+	failovers map[metricsFailoverKey]uint64
+}
+
+func newRelayMetrics(logs *LogService) *relayMetrics {
+	return &relayMetrics{
+		logs:      logs,
+		requests:  make(map[metricsRequestKey]*metricsHistogram),
+		tokens:    make(map[metricsProviderKey]map[string]float64),
+		cost:      make(map[metricsProviderKey]float64),
+		failovers: make(map[metricsFailoverKey]uint64),
+	}
+}
+
+// observeRequest 记录一条即将落库的请求日志（调用方已完成供应商改名兑换）
+func (m *relayMetrics) observeRequest(requestLog *ReqeustLog) {
+	if m == nil || requestLog == nil {
+		return
+	}
+	status := strconv.Itoa(requestLog.HttpCode)
+	if requestLog.Hedged {
+		status = "hedged"
+	}
+	entry := *requestLog
+	entry.respBuf = nil
+	if m.logs != nil {
+		m.logs.decorateCost(&entry)
+	}
+	tokens := map[string]int{
+		"input":        entry.InputTokens,
+		"output":       entry.OutputTokens,
+		"cache_create": entry.CacheCreateTokens,
+		"cache_read":   entry.CacheReadTokens,
+		"reasoning":    entry.ReasoningTokens,
+	}
+
+	key := metricsRequestKey{platform: entry.Platform, provider: entry.Provider, status: status}
+	pkey := metricsProviderKey{platform: entry.Platform, provider: entry.Provider}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.requests[key]
+	if h == nil {
+		h = &metricsHistogram{counts: make([]uint64, len(requestDurationBuckets))}
+		m.requests[key] = h
+	}
+	h.observe(entry.DurationSec)
+	byType := m.tokens[pkey]
+	if byType == nil {
+		byType = make(map[string]float64, len(metricsTokenTypes))
+		m.tokens[pkey] = byType
+	}
+	for name, n := range tokens {
+		if n > 0 {
+			byType[name] += float64(n)
+		}
+	}
+	if entry.TotalCost > 0 {
+		m.cost[pkey] += entry.TotalCost
+	}
+}
+
+// noteAttempt 记录本次客户端请求正在尝试的供应商；与上一次尝试的供应商不同即计一次切换
+func (m *relayMetrics) noteAttempt(c *gin.Context, platform, provider string) {
+	if m == nil || c == nil {
+		return
+	}
+	prev := c.GetString(metricsAttemptKey)
+	c.Set(metricsAttemptKey, provider)
+	if prev == "" || prev == provider {
+		return
+	}
+	m.mu.Lock()
+	m.failovers[metricsFailoverKey{platform: platform, from: prev, to: provider}]++
+	m.mu.Unlock()
+}
+
+// ---------- 文本编码 ----------
+
+// metricsWriter Prometheus 文本格式的最小编码器
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+func (mw *metricsWriter) header(name, kind, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample 写一行样本；labels 为 name, value 交替排列
+func (mw *metricsWriter) sample(name string, value float64, labels ...string) {
+	mw.w.WriteString(name)
+	if len(labels) > 0 {
+		mw.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				mw.w.WriteByte(',')
+			}
+			mw.w.WriteString(labels[i])
+			mw.w.WriteString(`="`)
+			mw.w.WriteString(escapeMetricLabel(labels[i+1]))
+			mw.w.WriteByte('"')
+		}
+		mw.w.WriteByte('}')
+	}
+	mw.w.WriteByte(' ')
+	mw.w.WriteString(formatMetricValue(value))
+	mw.w.WriteByte('\n')
+}
+
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeMetricLabel(v string) string {
+	return metricLabelEscaper.Replace(v)
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writeTo 导出请求类指标（按标签排序，保证输出稳定）
+func (m *relayMetrics) writeTo(mw *metricsWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reqKeys := make([]metricsRequestKey, 0, len(m.requests))
+	for k := range m.requests {
+		reqKeys = append(reqKeys, k)
+	}
+	sort.Slice(reqKeys, func(i, j int) bool {
+		a, b := reqKeys[i], reqKeys[j]
+		if a.platform != b.platform {
+			return a.platform < b.platform
+		}
+		if a.provider != b.provider {
+			return a.provider < b.provider
+		}
+		return a.status < b.status
+	})
+
+	mw.header("codeswitch_requests_total", "counter", "Relayed requests by platform, provider and upstream status (one per request log row).")
+	for _, k := range reqKeys {
+		mw.sample("codeswitch_requests_total", float64(m.requests[k].count), "platform", k.platform, "provider", k.provider, "status", k.status)
+	}
+	mw.header("codeswitch_request_duration_seconds", "histogram", "Relayed request duration in seconds.")
+	for _, k := range reqKeys {
+		h := m.requests[k]
+		for i, bound := range requestDurationBuckets {
+			mw.sample("codeswitch_request_duration_seconds_bucket", float64(h.counts[i]),
+				"platform", k.platform, "provider", k.provider, "status", k.status, "le", formatMetricValue(bound))
+		}
+		mw.sample("codeswitch_request_duration_seconds_bucket", float64(h.count),
+			"platform", k.platform, "provider", k.provider, "status", k.status, "le", "+Inf")
+		mw.sample("codeswitch_request_duration_seconds_sum", h.sum, "platform", k.platform, "provider", k.provider, "status", k.status)
+		mw.sample("codeswitch_request_duration_seconds_count", float64(h.count), "platform", k.platform, "provider", k.provider, "status", k.status)
+	}
+
+	providerKeys := make([]metricsProviderKey, 0, len(m.tokens))
+	for k := range m.tokens {
+		providerKeys = append(providerKeys, k)
+	}
+	sortProviderKeys(providerKeys)
+	mw.header("codeswitch_tokens_total", "counter", "Tokens reported by upstream usage, by type.")
+	for _, k := range providerKeys {
+		for _, name := range metricsTokenTypes {
+			if n, ok := m.tokens[k][name]; ok {
+				mw.sample("codeswitch_tokens_total", n, "platform", k.platform, "provider", k.provider, "type", name)
+			}
+		}
+	}
+
+	costKeys := make([]metricsProviderKey, 0, len(m.cost))
+	for k := range m.cost {
+		costKeys = append(costKeys, k)
+	}
+	sortProviderKeys(costKeys)
+	mw.header("codeswitch_cost_usd_total", "counter", "Estimated request cost in USD (same pricing and multiplier as the request log).")
+	for _, k := range costKeys {
+		mw.sample("codeswitch_cost_usd_total", m.cost[k], "platform", k.platform, "provider", k.provider)
+	}
+
+	failoverKeys := make([]metricsFailoverKey, 0, len(m.failovers))
+	for k := range m.failovers {
+		failoverKeys = append(failoverKeys, k)
+	}
+	sort.Slice(failoverKeys, func(i, j int) bool {
+		a, b := failoverKeys[i], failoverKeys[j]
+		if a.platform != b.platform {
+			return a.platform < b.platform
+		}
+		if a.from != b.from {
+			return a.from < b.from
+		}
+		return a.to < b.to
+	})
+	mw.header("codeswitch_failovers_total", "counter", "Provider switches within a single client request.")
+	for _, k := range failoverKeys {
+		mw.sample("codeswitch_failovers_total", float64(m.failovers[k]), "platform", k.platform, "from", k.from, "to", k.to)
+	}
+}
+
+func sortProviderKeys(keys []metricsProviderKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].platform != keys[j].platform {
+			return keys[i].platform < keys[j].platform
+		}
+		return keys[i].provider < keys[j].provider
+	})
+}
+
+// writeBlacklistMetrics 黑名单即时状态：每个有记录的供应商导出是否拉黑与当前等级
+func writeBlacklistMetrics(mw *metricsWriter) error {
+	db, err := xdb.DB("default")
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(`
+		SELECT platform, provider_name, blacklisted_until, blacklist_level
+		FROM provider_blacklist
+		ORDER BY platform, provider_name
+	`)
+	if err != nil {
+		return fmt.Errorf("查询黑名单失败: %w", err)
+	}
+	defer rows.Close()
+
+	type row struct {
+		platform, provider string
+		blacklisted        bool
+		level              int
+	}
+	var list []row
+	now := time.Now()
+	for rows.Next() {
+		var r row
+		var until sql.NullTime
+		if err := rows.Scan(&r.platform, &r.provider, &until, &r.level); err != nil {
+			return err
+		}
+		r.blacklisted = until.Valid && until.Time.After(now)
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	mw.header("codeswitch_provider_blacklisted", "gauge", "Whether the provider is currently blacklisted (1) or not (0).")
+	for _, r := range list {
+		v := 0.0
+		if r.blacklisted {
+			v = 1
+		}
+		mw.sample("codeswitch_provider_blacklisted", v, "platform", r.platform, "provider", r.provider)
+	}
+	mw.header("codeswitch_provider_blacklist_level", "gauge", "Current blacklist level of the provider (0-5).")
+	for _, r := range list {
+		mw.sample("codeswitch_provider_blacklist_level", float64(r.level), "platform", r.platform, "provider", r.provider)
+	}
+	return nil
+}
+
+// writeConcurrencyMetrics 并发配额即时状态。配额登记表以 ID 为键，provider_id 沿用该口径；
+// provider 为按当前配置解析出的名称，与其它指标的 provider 标签对得上（已删除的供应商为空）
+func writeConcurrencyMetrics(mw *metricsWriter, l *concurrencyLimiter, nameOf func(platform, providerKey string) string) {
+	if l == nil {
+		return
+	}
+	entries, waiters := l.snapshot()
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = nameOf(e.Platform, e.ProviderKey)
+	}
+	mw.header("codeswitch_provider_in_flight", "gauge", "Relayed requests currently in flight per provider.")
+	for i, e := range entries {
+		mw.sample("codeswitch_provider_in_flight", float64(e.InFlight), "platform", e.Platform, "provider", names[i], "provider_id", e.ProviderKey)
+	}
+	mw.header("codeswitch_provider_max_concurrency", "gauge", "Configured max concurrency per provider (only providers with a limit).")
+	for i, e := range entries {
+		if e.Limit > 0 {
+			mw.sample("codeswitch_provider_max_concurrency", float64(e.Limit), "platform", e.Platform, "provider", names[i], "provider_id", e.ProviderKey)
+		}
+	}
+	mw.header("codeswitch_concurrency_waiters", "gauge", "Requests waiting for a provider to free up capacity.")
+	mw.sample("codeswitch_concurrency_waiters", float64(waiters))
+}
+
+// writeDBQueueMetrics 两条数据库写入队列的深度、累计写入与延迟
+func writeDBQueueMetrics(mw *metricsWriter) {
+	queues := []struct {
+		name  string
+		stats QueueStats
+	}{
+		{"main", GetGlobalDBQueueStats()},
+		{"logs", GetGlobalDBQueueLogsStats()},
+	}
+	mw.header("codeswitch_db_queue_depth", "gauge", "Pending tasks in the database write queue.")
+	for _, q := range queues {
+		mw.sample("codeswitch_db_queue_depth", float64(q.stats.QueueLength), "queue", q.name, "mode", "single")
+		mw.sample("codeswitch_db_queue_depth", float64(q.stats.BatchQueueLength), "queue", q.name, "mode", "batch")
+	}
+	mw.header("codeswitch_db_queue_writes_total", "counter", "Database writes executed by the queue.")
+	for _, q := range queues {
+		mw.sample("codeswitch_db_queue_writes_total", float64(q.stats.SuccessWrites), "queue", q.name, "result", "success")
+		mw.sample("codeswitch_db_queue_writes_total", float64(q.stats.FailedWrites), "queue", q.name, "result", "failed")
+	}
+	mw.header("codeswitch_db_queue_batch_commits_total", "counter", "Batch transactions committed by the queue.")
+	for _, q := range queues {
+		mw.sample("codeswitch_db_queue_batch_commits_total", float64(q.stats.BatchCommits), "queue", q.name)
+	}
+	mw.header("codeswitch_db_queue_write_latency_seconds", "gauge", "Database write latency: running average and P99 of the last 1000 writes.")
+	for _, q := range queues {
+		mw.sample("codeswitch_db_queue_write_latency_seconds", q.stats.AvgLatencyMs/1000, "queue", q.name, "stat", "avg")
+		mw.sample("codeswitch_db_queue_write_latency_seconds", q.stats.P99LatencyMs/1000, "queue", q.name, "stat", "p99")
+	}
+}
+
+// concurrencyProviderNames 返回按平台 + 配额键（供应商 ID）查名称的函数；
+// 每个平台的配置在一次抓取内只读一遍
+func (prs *ProviderRelayService) concurrencyProviderNames() func(platform, providerKey string) string {
+	byPlatform := make(map[string]map[string]string)
+	return func(platform, providerKey string) string {
+		names, ok := byPlatform[platform]
+		if !ok {
+			names = make(map[string]string)
+			if platform == "gemini" {
+				if prs.geminiService != nil {
+					for _, p := range prs.geminiService.GetProviders() {
+						names[p.ID] = p.Name
+					}
+				}
+			} else if providers, err := prs.providerService.LoadProviders(platform); err == nil {
+				for _, p := range providers {
+					names[strconv.FormatInt(p.ID, 10)] = p.Name
+				}
+			}
+			byPlatform[platform] = names
+		}
+		return names[providerKey]
+	}
+}
+
+// metricsHandler GET /metrics
+func (prs *ProviderRelayService) metricsHandler(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	mw := &metricsWriter{w: bufio.NewWriter(c.Writer)}
+	prs.metrics.writeTo(mw)
+	if err := writeBlacklistMetrics(mw); err != nil {
+		// 黑名单读取失败只缺这一组，其余指标照常导出
+		fmt.Printf("[Metrics] 读取黑名单失败: %v\n", err)
+	}
+	writeConcurrencyMetrics(mw, prs.concurrency, prs.concurrencyProviderNames())
+	writeDBQueueMetrics(mw)
+	_ = mw.w.Flush()
+}
+
+// registerMetricsRoute 注册 /metrics（与管理 API 共用令牌，单独开关）
+func (prs *ProviderRelayService) registerMetricsRoute(router gin.IRouter) {
+	router.GET(metricsPath, adminTokenMiddleware(func(cfg *AdminAPIConfig) bool { return cfg.MetricsEnabled }), prs.metricsHandler)
+}
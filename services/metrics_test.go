@@ -0,0 +1,153 @@
+package services
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRelayMetricsEncoding(t *testing.T) {
+	m := newRelayMetrics(nil)
+	m.observeRequest(&ReqeustLog{Platform: "claude", Provider: `a"b`, HttpCode: 200, DurationSec: 1.5, InputTokens: 10, OutputTokens: 3})
+	m.observeRequest(&ReqeustLog{Platform: "claude", Provider: `a"b`, HttpCode: 200, DurationSec: 0.2})
+	m.observeRequest(&ReqeustLog{Platform: "claude", Provider: "c", HttpCode: 0, Hedged: true})
+
+	var sb strings.Builder
+	mw := &metricsWriter{w: bufio.NewWriter(&sb)}
+	m.writeTo(mw)
+	_ = mw.w.Flush()
+	out := sb.String()
+	for _, want := range []string{
+		`codeswitch_requests_total{platform="claude",provider="a\"b",status="200"} 2`,
+		`codeswitch_requests_total{platform="claude",provider="c",status="hedged"} 1`,
+		`codeswitch_request_duration_seconds_bucket{platform="claude",provider="a\"b",status="200",le="0.5"} 1`,
+		`codeswitch_request_duration_seconds_bucket{platform="claude",provider="a\"b",status="200",le="2"} 2`,
+		`codeswitch_request_duration_seconds_bucket{platform="claude",provider="a\"b",status="200",le="+Inf"} 2`,
+		`codeswitch_request_duration_seconds_sum{platform="claude",provider="a\"b",status="200"} 1.7`,
+		`codeswitch_tokens_total{platform="claude",provider="a\"b",type="input"} 10`,
+		"# TYPE codeswitch_request_duration_seconds histogram",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("缺少 %s\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `type="cache_read"`) {
+		t.Error("未出现过的 token 类型不应导出")
+	}
+}
+
+// /metrics 默认关闭；开启后凭管理令牌抓取，请求、切换、黑名单与队列指标均可见
+func TestMetricsEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+	db, err := xdb.DB("default")
+	if err != nil {
+		t.Fatalf("获取数据库失败: %v", err)
+	}
+	if err := ensureRequestLogTableWithDB(db); err != nil {
+		t.Fatalf("建表失败: %v", err)
+	}
+	oldQueue := GlobalDBQueueLogs
+	GlobalDBQueueLogs = NewDBWriteQueue(db, 100, true)
+	t.Cleanup(func() {
+		_ = GlobalDBQueueLogs.Shutdown(time.Second)
+		GlobalDBQueueLogs = oldQueue
+	})
+	now := time.Now()
+	if _, err := db.Exec(`
+		INSERT INTO provider_blacklist (platform, provider_name, failure_count, blacklisted_at, blacklisted_until, last_failure_at, blacklist_level)
+		VALUES ('codex', 'x', 3, ?, ?, ?, 2)
+	`, now, now.Add(10*time.Minute), now); err != nil {
+		t.Fatalf("seed 失败: %v", err)
+	}
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"usage":{"input_tokens":30,"output_tokens":5}}`))
+	}))
+	defer healthy.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "first", APIURL: broken.URL, APIKey: "k", Enabled: true},
+		{ID: 2, Name: "second", APIURL: healthy.URL, APIKey: "k", Enabled: true, MaxConcurrency: 4},
+	}); err != nil {
+		t.Fatalf("保存供应商失败: %v", err)
+	}
+	router := gin.New()
+	newTestRelayService(ps).registerRoutes(router)
+	scrape := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", metricsPath, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := scrape(""); w.Code != http.StatusNotFound {
+		t.Fatalf("默认关闭应 404: %d", w.Code)
+	}
+	// 只开启管理 API 不会暴露 /metrics
+	if _, err := (&NetworkService{}).SetAdminAPIEnabled(true); err != nil {
+		t.Fatalf("开启管理 API 失败: %v", err)
+	}
+	cfg, err := (&NetworkService{}).SetMetricsEnabled(true)
+	if err != nil {
+		t.Fatalf("开启指标失败: %v", err)
+	}
+	if w := scrape("csa-wrong-token-value"); w.Code != http.StatusUnauthorized {
+		t.Errorf("错误令牌应 401: %d", w.Code)
+	}
+
+	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5","messages":[{"role":"user","content":"hi"}]}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("应降级到 second: %d %s", w.Code, w.Body.String())
+	}
+
+	w = scrape(cfg.Token)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("抓取失败: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	out := w.Body.String()
+	for _, want := range []string{
+		`codeswitch_requests_total{platform="claude",provider="first",status="503"} 1`,
+		`codeswitch_requests_total{platform="claude",provider="second",status="200"} 1`,
+		`codeswitch_tokens_total{platform="claude",provider="second",type="output"} 5`,
+		`codeswitch_failovers_total{platform="claude",from="first",to="second"} 1`,
+		`codeswitch_provider_blacklisted{platform="codex",provider="x"} 1`,
+		`codeswitch_provider_blacklist_level{platform="codex",provider="x"} 2`,
+		`codeswitch_provider_in_flight{platform="claude",provider="second",provider_id="2"} 0`,
+		`codeswitch_provider_max_concurrency{platform="claude",provider="second",provider_id="2"} 4`,
+		`codeswitch_concurrency_waiters 0`,
+		`codeswitch_db_queue_depth{queue="logs",mode="batch"}`,
+		`codeswitch_db_queue_write_latency_seconds{queue="logs",stat="p99"}`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("缺少 %s", want)
+		}
+	}
+	if t.Failed() {
+		t.Logf("抓取结果:\n%s", out)
+	}
+
+	if _, err := (&NetworkService{}).SetMetricsEnabled(false); err != nil {
+		t.Fatalf("关闭指标失败: %v", err)
+	}
+	if w := scrape(cfg.Token); w.Code != http.StatusNotFound {
+		t.Errorf("关闭后应 404: %d", w.Code)
+	}
+}
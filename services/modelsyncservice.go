@@ -19,8 +19,6 @@ import (
 	"time"
 
 	modelpricing "codeswitch/resources/model-pricing"
-
-	"github.com/wailsapp/wails/v3/pkg/application"
 )
 
 // ModelSyncService 从 llm-metadata(basellm.github.io,镜像 llm-metadata.pages.dev)
@@ -128,7 +126,7 @@ type ModelSyncService struct {
 	appSettings *AppSettingsService
 	policy      *DefaultModelPolicy
 	pricing     *modelpricing.Service
-	app         *application.App
+	app         DesktopApp
 
 	baseURLs   []string
 	httpClient *http.Client
@@ -185,9 +183,9 @@ func NewModelSyncService(appSettings *AppSettingsService, policy *DefaultModelPo
 	return s
 }
 
-// SetApp 注入 Wails App 引用,用于向前端广播同步事件。
+// SetApp 注入桌面外壳引用,用于向前端广播同步事件。
 // 注册为 Wails 服务后该方法可被前端 RPC 调用，传 nil 会让同步完成事件广播失效，忽略之。
-func (s *ModelSyncService) SetApp(app *application.App) {
+func (s *ModelSyncService) SetApp(app DesktopApp) {
 	if app == nil {
 		return
 	}
@@ -767,7 +765,7 @@ func (s *ModelSyncService) emitUpdated() {
 	app := s.app
 	s.mu.Unlock()
 	if app != nil {
-		app.Event.Emit("model-sync:updated", nil)
+		app.Emit("model-sync:updated", nil)
 	}
 }
 
@@ -625,7 +625,7 @@ if [ -L "$config_path" ]; then
 fi
 
 base_url=%s
-auth_token='code-switch-r'
+auth_token=%s
 
 ts="$(date +%%s)"
 if [ -f "$config_path" ]; then
@@ -736,7 +736,7 @@ fi
 
 mv -f "$tmp_path" "$config_path"
 trap - EXIT
-`, bashSingleQuote(proxyURL))
+`, bashSingleQuote(proxyURL), bashSingleQuote(cliAuthToken("claude")))
 
 	return ns.runWSLCommand(distro, script)
 }
@@ -770,7 +770,7 @@ fi
 
 base_url=%s
 provider_key='code-switch-r'
-api_key='code-switch-r'
+api_key=%s
 
 ts="$(date +%%s)"
 [ -f "$config_path" ] && cp -a "$config_path" "$config_path.bak.$ts"
@@ -1004,7 +1004,7 @@ fi
 
 echo "Failed to write $config_path" >&2
 exit 1
-`, bashSingleQuote(proxyURL))
+`, bashSingleQuote(proxyURL), bashSingleQuote(cliAuthToken("codex")))
 
 	return ns.runWSLCommand(distro, script)
 }
@@ -1033,7 +1033,7 @@ if [ -L "$env_path" ]; then
 fi
 
 gemini_base_url=%s
-api_key='code-switch-r'
+api_key=%s
 
 ts="$(date +%%s)"
 [ -f "$env_path" ] && cp -a "$env_path" "$env_path.bak.$ts"
@@ -1168,7 +1168,7 @@ fi
 
 mv -f "$tmp_path" "$env_path"
 trap - EXIT
-`, bashSingleQuote(geminiURL))
+`, bashSingleQuote(geminiURL), bashSingleQuote(cliAuthToken("gemini")))
 
 	return ns.runWSLCommand(distro, script)
 }
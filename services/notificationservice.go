@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/gen2brain/beeep"
-	"github.com/wailsapp/wails/v3/pkg/application"
 )
 
 //go:embed assets/icon.png
@@ -20,7 +19,7 @@ var notifyIconFS embed.FS
 // @author sm
 type NotificationService struct {
 	appSettings    *AppSettingsService
-	app            *application.App // Wails 应用实例，用于发送事件
+	app            DesktopApp // 桌面外壳，用于发送事件（无界面模式为 nil）
 	mu             sync.RWMutex
 	lastNotifyTime time.Time
 	minInterval    time.Duration // 通知最小间隔，防止刷屏
@@ -46,12 +45,12 @@ func NewNotificationService(appSettings *AppSettingsService) *NotificationServic
 	return ns
 }
 
-// SetApp 设置 Wails 应用实例（用于发送事件到前端）
+// SetApp 设置桌面外壳（用于发送事件到前端）
 // @author sm
 // 该服务注册为 Wails 服务后导出方法均可被前端 RPC 调用，
 // 传 nil 会让所有事件广播失效，因此忽略 nil 入参。
 // app 由主协程写入、由代理转发协程读取，必须走锁。
-func (ns *NotificationService) SetApp(app *application.App) {
+func (ns *NotificationService) SetApp(app DesktopApp) {
 	if app == nil {
 		return
 	}
@@ -61,7 +60,7 @@ func (ns *NotificationService) SetApp(app *application.App) {
 }
 
 // currentApp 读取 App 引用（与 SetApp 的写入互斥）
-func (ns *NotificationService) currentApp() *application.App {
+func (ns *NotificationService) currentApp() DesktopApp {
 	ns.mu.RLock()
 	defer ns.mu.RUnlock()
 	return ns.app
@@ -169,7 +168,7 @@ func (ns *NotificationService) emitSwitchEvent(info SwitchNotification) {
 	if app == nil {
 		return
 	}
-	app.Event.Emit("provider:switched", map[string]interface{}{
+	app.Emit("provider:switched", map[string]interface{}{
 		"platform":     info.Platform,
 		"fromProvider": info.FromProvider,
 		"toProvider":   info.ToProvider,
@@ -208,7 +207,7 @@ func (ns *NotificationService) emitBlacklistEvent(platform, providerName string,
 	if app == nil {
 		return
 	}
-	app.Event.Emit("provider:blacklisted", map[string]interface{}{
+	app.Emit("provider:blacklisted", map[string]interface{}{
 		"platform":        platform,
 		"providerName":    providerName,
 		"level":           level,
@@ -216,3 +215,42 @@ func (ns *NotificationService) emitBlacklistEvent(platform, providerName string,
 		"timestamp":       time.Now().UnixMilli(),
 	})
 }
+
+// NotifySpendCapReached 发送消费上限触达通知：providerName 为空表示平台总预算用尽
+// （已开启硬性停止），否则为单个供应商达到消费上限、已退出调度
+func (ns *NotificationService) NotifySpendCapReached(platform, providerName string, spent, limit float64) {
+	if !ns.isEnabled() {
+		return
+	}
+
+	SafeGo("notify-spend-cap", func() {
+		title := "Code Switch"
+		body := fmt.Sprintf("%s 已达消费上限 $%.2f，暂停调度至周期重置", providerName, limit)
+		if providerName == "" {
+			body = fmt.Sprintf("%s 预算 $%.2f 已用尽，代理暂停转发至周期重置", platform, limit)
+		}
+
+		ns.emitSpendCapEvent(platform, providerName, spent, limit)
+
+		if err := beeep.Notify(title, body, ns.iconPath); err != nil {
+			log.Printf("[Notification] 发送消费上限通知失败: %v", err)
+		} else {
+			log.Printf("[Notification] 已发送消费上限通知: %s/%s ($%.4f / $%.2f)", platform, providerName, spent, limit)
+		}
+	})
+}
+
+// emitSpendCapEvent 发送消费上限事件到前端
+func (ns *NotificationService) emitSpendCapEvent(platform, providerName string, spent, limit float64) {
+	app := ns.currentApp()
+	if app == nil {
+		return
+	}
+	app.Emit("provider:spend-capped", map[string]interface{}{
+		"platform":     platform,
+		"providerName": providerName,
+		"spent":        spent,
+		"limit":        limit,
+		"timestamp":    time.Now().UnixMilli(),
+	})
+}
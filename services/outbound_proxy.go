@@ -0,0 +1,112 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// 供应商出站代理：部分上游只能经企业 HTTP 代理或 SOCKS5 隧道访问，其余直连。
+// 未配置代理的供应商沿用各服务原有的共享客户端（仍遵循 HTTP(S)_PROXY 环境变量）；
+// 配置了代理的按 (基础客户端, 代理地址) 缓存一个克隆 Transport 的客户端，
+// 转发、健康检查、连通性测试与 /v1/models 共用同一套选择逻辑。
+
+// proxySchemes 支持的代理协议；socks5h 由代理端解析域名
+var proxySchemes = map[string]bool{
+	"http":    true,
+	"https":   true,
+	"socks5":  true,
+	"socks5h": true,
+}
+
+// parseProxyURL 解析供应商代理地址，认证信息写在 userinfo 中（user:pass@host:port）
+func parseProxyURL(raw string) (*url.URL, error) {
+	raw = strings.TrimSpace(raw)
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("代理地址无法解析")
+	}
+	if !proxySchemes[strings.ToLower(parsed.Scheme)] {
+		return nil, fmt.Errorf("代理协议仅支持 http/https/socks5/socks5h")
+	}
+	if parsed.Hostname() == "" {
+		return nil, fmt.Errorf("代理地址缺少主机名")
+	}
+	if (parsed.Path != "" && parsed.Path != "/") || parsed.RawQuery != "" {
+		return nil, fmt.Errorf("代理地址不能包含路径或查询参数")
+	}
+	return parsed, nil
+}
+
+// validateProxyURL 校验供应商代理配置；空值表示直连
+func validateProxyURL(raw string) []string {
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	if _, err := parseProxyURL(raw); err != nil {
+		return []string{fmt.Sprintf("代理地址无效（%v）: %s", err, redactProxyURL(raw))}
+	}
+	return nil
+}
+
+// redactProxyURL 隐去代理地址中的密码，用于日志与错误提示
+func redactProxyURL(raw string) string {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || parsed.User == nil {
+		return raw
+	}
+	return parsed.Redacted()
+}
+
+// proxiedClientKey 缓存键：基础客户端决定超时与 TLS 策略，代理地址决定出站路径
+type proxiedClientKey struct {
+	base  *http.Client
+	proxy string
+}
+
+// proxiedClients 进程级缓存：同一供应商每次请求拿到同一个客户端，连接池得以复用
+var proxiedClients = struct {
+	mu      sync.Mutex
+	clients map[proxiedClientKey]*http.Client
+}{clients: make(map[proxiedClientKey]*http.Client)}
+
+// clientWithProxy 返回 base 经指定代理出站的变体；proxyURL 为空时原样返回 base。
+// 代理地址非法时不静默直连（内网供应商直连可能泄露流量或长时间挂起），
+// 返回的客户端每次请求都以解析错误失败，错误会进入请求日志与探测结果。
+func clientWithProxy(base *http.Client, proxyURL string) *http.Client {
+	proxyURL = strings.TrimSpace(proxyURL)
+	if proxyURL == "" {
+		return base
+	}
+	key := proxiedClientKey{base: base, proxy: proxyURL}
+
+	proxiedClients.mu.Lock()
+	defer proxiedClients.mu.Unlock()
+	if client, ok := proxiedClients.clients[key]; ok {
+		return client
+	}
+
+	var transport *http.Transport
+	if t, ok := base.Transport.(*http.Transport); ok && t != nil {
+		transport = t.Clone()
+	} else {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	if parsed, err := parseProxyURL(proxyURL); err != nil {
+		proxyErr := fmt.Errorf("供应商代理地址无效（%v）: %s", err, redactProxyURL(proxyURL))
+		transport.Proxy = func(*http.Request) (*url.URL, error) { return nil, proxyErr }
+	} else {
+		transport.Proxy = http.ProxyURL(parsed)
+	}
+
+	client := &http.Client{
+		Timeout:       base.Timeout,
+		Transport:     transport,
+		CheckRedirect: base.CheckRedirect,
+		Jar:           base.Jar,
+	}
+	proxiedClients.clients[key] = client
+	return client
+}
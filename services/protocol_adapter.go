@@ -42,36 +42,42 @@ type ConvertInfo struct {
 	MappedUser          string   // 映射到 OpenAI user 字段的值
 	InjectedStreamOpts  bool     // 是否注入了 stream_options
 	DroppedFields       []string // 被丢弃的顶层字段
+	// CustomTools Responses 请求中的 custom 工具名（响应侧需还原为 custom_tool_call）
+	CustomTools map[string]bool
+	// IncludeUsage Chat 请求带 stream_options.include_usage（响应侧需在流末输出 usage chunk）
+	IncludeUsage bool
+}
+
+// ========== 响应转换器 ==========
+
+// protocolResponseConverter 上游响应的协议转换器：把上游协议的响应转换回客户端协议。
+// 有状态，每次尝试（含地址兜底切换）都要新建。
+type protocolResponseConverter interface {
+	// ProcessLine 转换一行上游 SSE，返回要写给客户端的内容（空串表示不输出）
+	ProcessLine(line string) string
+	// FinalizeIfUnterminated 上游未正常结束就断流时补齐终止事件（已结束时返回空串）
+	FinalizeIfUnterminated() string
+	// ConvertResponse 转换非流式响应体
+	ConvertResponse(body []byte) ([]byte, error)
+	// ParseUsage 从上游原始数据（upstream）或转换结果（converted）中提取 usage 写入日志，
+	// 按哪一侧计费由转换器决定（取信息更完整的一侧）
+	ParseUsage(upstream, converted string, usage *ReqeustLog)
 }
 
 // ========== 请求转换：Anthropic → OpenAI ==========
 
 // ConvertAnthropicToOpenAI 将 Anthropic Messages 请求转换为 OpenAI Chat Completions 请求
-// 第一期限制：
-// - 只支持文本内容（不支持 tools、多模态）
-// - 只支持 stream=true
+// 支持范围：
+// - 文本内容与 tools 双向转换（tool_use → tool_calls，tool_result → role=tool）
+// - image 块（base64 / url）转为 image_url 内容片段
+// - thinking/redacted_thinking 历史块在 OpenAI 协议下没有对应物，丢弃
+// - stream=true 与 stream=false 均支持；非流式响应由 ConvertOpenAIToAnthropicResponse 转回
 func ConvertAnthropicToOpenAI(body []byte, opts ConvertOptions) ([]byte, ConvertInfo, error) {
 	info := ConvertInfo{}
 
 	// 解析 Anthropic 请求
 	parsed := gjson.ParseBytes(body)
 
-	// ========== 前置校验 ==========
-
-	// 检查 stream（第一期只支持流式）
-	streamVal := parsed.Get("stream")
-	if !streamVal.Exists() || !streamVal.Bool() {
-		return nil, info, NewClientRequestRejectedError("第一期仅支持 stream=true 的请求")
-	}
-
-	// 检查 tools（第一期不支持）
-	if parsed.Get("tools").Exists() && parsed.Get("tools").IsArray() && len(parsed.Get("tools").Array()) > 0 {
-		return nil, info, NewClientRequestRejectedError("第一期不支持 tools 功能")
-	}
-	if parsed.Get("tool_choice").Exists() {
-		return nil, info, NewClientRequestRejectedError("第一期不支持 tool_choice 功能")
-	}
-
 	// ========== 构建 OpenAI 请求 ==========
 
 	openAIReq := make(map[string]interface{})
@@ -86,11 +92,13 @@ func ConvertAnthropicToOpenAI(body []byte, opts ConvertOptions) ([]byte, Convert
 		openAIReq["max_tokens"] = maxTokens.Int()
 	}
 
-	// stream
-	openAIReq["stream"] = true
+	// stream（缺省按 Anthropic 语义视为非流式）
+	isStream := parsed.Get("stream").Bool()
+	openAIReq["stream"] = isStream
 
-	// stream_options（用于获取 usage）
-	if opts.IncludeUsage {
+	// stream_options（用于获取 usage；非流式响应自带 usage，且部分上游对
+	// stream=false 携带 stream_options 直接报 400）
+	if opts.IncludeUsage && isStream {
 		openAIReq["stream_options"] = map[string]interface{}{
 			"include_usage": true,
 		}
@@ -135,13 +143,38 @@ func ConvertAnthropicToOpenAI(body []byte, opts ConvertOptions) ([]byte, Convert
 	}
 
 	// 记录被丢弃的顶层字段
-	droppedTopLevel := []string{"betas", "anthropic_version"}
+	droppedTopLevel := []string{"betas", "anthropic_version", "thinking"}
 	for _, field := range droppedTopLevel {
 		if parsed.Get(field).Exists() {
 			info.DroppedFields = append(info.DroppedFields, field)
 		}
 	}
 
+	// ========== 转换 tools / tool_choice ==========
+
+	if tools := parsed.Get("tools"); tools.Exists() && tools.IsArray() && len(tools.Array()) > 0 {
+		openAITools, err := convertAnthropicTools(tools)
+		if err != nil {
+			return nil, info, err
+		}
+		openAIReq["tools"] = openAITools
+	}
+
+	if toolChoice := parsed.Get("tool_choice"); toolChoice.Exists() {
+		choice, parallelDisabled, err := convertAnthropicToolChoice(toolChoice)
+		if err != nil {
+			return nil, info, err
+		}
+		if choice != nil {
+			openAIReq["tool_choice"] = choice
+		}
+		// disable_parallel_tool_use 只有在声明了 tools 时才有意义，
+		// 部分上游对无 tools 的 parallel_tool_calls 直接报 400
+		if parallelDisabled && openAIReq["tools"] != nil {
+			openAIReq["parallel_tool_calls"] = false
+		}
+	}
+
 	// ========== 转换 messages ==========
 
 	messages := make([]map[string]interface{}, 0)
@@ -163,24 +196,11 @@ func ConvertAnthropicToOpenAI(body []byte, opts ConvertOptions) ([]byte, Convert
 	// messages[]
 	if msgArray := parsed.Get("messages"); msgArray.Exists() && msgArray.IsArray() {
 		for i, msg := range msgArray.Array() {
-			role := msg.Get("role").String()
-
-			// 校验 role（第一期只支持 user/assistant）
-			if role != "user" && role != "assistant" {
-				return nil, info, NewClientRequestRejectedError(
-					fmt.Sprintf("messages[%d].role='%s' 不支持，第一期仅支持 user/assistant", i, role))
-			}
-
-			content := msg.Get("content")
-			contentText, err := extractTextContent(content)
+			converted, err := convertAnthropicMessage(msg)
 			if err != nil {
-				return nil, info, fmt.Errorf("messages[%d].content: %w", i, err)
+				return nil, info, fmt.Errorf("messages[%d]: %w", i, err)
 			}
-
-			messages = append(messages, map[string]interface{}{
-				"role":    role,
-				"content": contentText,
-			})
+			messages = append(messages, converted...)
 		}
 	}
 
@@ -195,6 +215,241 @@ func ConvertAnthropicToOpenAI(body []byte, opts ConvertOptions) ([]byte, Convert
 	return result, info, nil
 }
 
+// convertAnthropicTools 把 Anthropic 工具定义转成 OpenAI function 工具。
+// input_schema 原样作为 parameters；Anthropic 服务端工具（web_search 等带 type
+// 的内置工具）在 OpenAI 协议下没有对应实现，直接拒绝而不是静默丢弃。
+func convertAnthropicTools(tools gjson.Result) ([]map[string]interface{}, error) {
+	result := make([]map[string]interface{}, 0, len(tools.Array()))
+	for i, tool := range tools.Array() {
+		if toolType := tool.Get("type").String(); toolType != "" && toolType != "custom" {
+			return nil, NewClientRequestRejectedError(
+				fmt.Sprintf("tools[%d].type='%s' 是 Anthropic 内置工具，OpenAI Chat 协议不支持", i, toolType))
+		}
+		name := tool.Get("name").String()
+		if name == "" {
+			return nil, NewClientRequestRejectedError(fmt.Sprintf("tools[%d] 缺少 name", i))
+		}
+		function := map[string]interface{}{
+			"name": name,
+		}
+		if desc := tool.Get("description").String(); desc != "" {
+			function["description"] = desc
+		}
+		if schema := tool.Get("input_schema"); schema.Exists() && schema.IsObject() {
+			function["parameters"] = json.RawMessage(schema.Raw)
+		} else {
+			// OpenAI 要求 parameters 为 JSON Schema 对象，缺省给一个空对象 schema
+			function["parameters"] = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		result = append(result, map[string]interface{}{
+			"type":     "function",
+			"function": function,
+		})
+	}
+	return result, nil
+}
+
+// convertAnthropicToolChoice 映射 tool_choice：
+// auto → "auto"，any → "required"，none → "none"，tool → 指定 function。
+// 第二个返回值表示 disable_parallel_tool_use=true（需转成 parallel_tool_calls=false）。
+func convertAnthropicToolChoice(toolChoice gjson.Result) (interface{}, bool, error) {
+	parallelDisabled := toolChoice.Get("disable_parallel_tool_use").Bool()
+	switch toolChoice.Get("type").String() {
+	case "auto", "":
+		return "auto", parallelDisabled, nil
+	case "any":
+		return "required", parallelDisabled, nil
+	case "none":
+		return "none", false, nil
+	case "tool":
+		name := toolChoice.Get("name").String()
+		if name == "" {
+			return nil, false, NewClientRequestRejectedError("tool_choice.type='tool' 缺少 name")
+		}
+		return map[string]interface{}{
+			"type":     "function",
+			"function": map[string]interface{}{"name": name},
+		}, parallelDisabled, nil
+	default:
+		return nil, false, NewClientRequestRejectedError(
+			fmt.Sprintf("tool_choice.type='%s' 不支持", toolChoice.Get("type").String()))
+	}
+}
+
+// convertAnthropicMessage 把一条 Anthropic 消息转换为一条或多条 OpenAI 消息。
+//   - assistant：text 块合并为 content，tool_use 块转为 tool_calls；
+//   - user：tool_result 块各自拆成一条 role=tool 消息，且必须排在同条消息
+//     其余内容之前——OpenAI 要求 tool 消息紧跟发起调用的 assistant 消息；
+//     含 image 块时 content 改用 text/image_url 片段数组。
+func convertAnthropicMessage(msg gjson.Result) ([]map[string]interface{}, error) {
+	role := msg.Get("role").String()
+	if role != "user" && role != "assistant" {
+		return nil, NewClientRequestRejectedError(
+			fmt.Sprintf("role='%s' 不支持，仅支持 user/assistant", role))
+	}
+
+	content := msg.Get("content")
+	if content.Type == gjson.String || !content.Exists() {
+		return []map[string]interface{}{{
+			"role":    role,
+			"content": content.String(),
+		}}, nil
+	}
+	if !content.IsArray() {
+		return nil, NewClientRequestRejectedError("content 格式无效，必须是 string 或 content block 数组")
+	}
+
+	var texts []string
+	var parts []map[string]interface{} // user 消息按原顺序保留的 text/image_url 片段
+	hasImage := false
+	var toolCalls []map[string]interface{}
+	var toolMessages []map[string]interface{}
+	var toolImages []map[string]interface{} // tool_result 中的图片，OpenAI tool 消息无法承载
+	for i, block := range content.Array() {
+		blockType := block.Get("type").String()
+		switch {
+		case blockType == "text":
+			texts = append(texts, block.Get("text").String())
+			parts = append(parts, map[string]interface{}{"type": "text", "text": block.Get("text").String()})
+		case blockType == "thinking" || blockType == "redacted_thinking":
+			// 思考块只对 Anthropic 上游有意义（签名校验），OpenAI 协议下丢弃
+			continue
+		case blockType == "image" && role == "user":
+			part, err := convertAnthropicImage(block)
+			if err != nil {
+				return nil, fmt.Errorf("content[%d]: %w", i, err)
+			}
+			parts = append(parts, part)
+			hasImage = true
+		case blockType == "tool_use" && role == "assistant":
+			arguments := "{}"
+			if input := block.Get("input"); input.Exists() {
+				arguments = input.Raw
+			}
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"id":   block.Get("id").String(),
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      block.Get("name").String(),
+					"arguments": arguments,
+				},
+			})
+		case blockType == "tool_result" && role == "user":
+			resultText, images, err := extractToolResultContent(block.Get("content"))
+			if err != nil {
+				return nil, fmt.Errorf("content[%d]: %w", i, err)
+			}
+			if resultText == "" && len(images) > 0 {
+				resultText = "[图片见下一条用户消息]"
+			}
+			// OpenAI tool 消息没有错误状态字段，is_error 只能体现在内容里，
+			// 否则模型会把失败的工具调用当成功结果继续推理
+			if block.Get("is_error").Bool() {
+				resultText = toolResultErrorPrefix + resultText
+			}
+			toolMessages = append(toolMessages, map[string]interface{}{
+				"role":         "tool",
+				"tool_call_id": block.Get("tool_use_id").String(),
+				"content":      resultText,
+			})
+			toolImages = append(toolImages, images...)
+		default:
+			return nil, NewClientRequestRejectedError(
+				fmt.Sprintf("content[%d].type='%s' 在 %s 消息中不支持", i, blockType, role))
+		}
+	}
+
+	if role == "assistant" {
+		assistant := map[string]interface{}{"role": "assistant"}
+		if len(texts) > 0 {
+			assistant["content"] = strings.Join(texts, "\n")
+		} else {
+			// 仅有 tool_calls 时 OpenAI 期望 content 为 null 而不是空串
+			assistant["content"] = nil
+		}
+		if len(toolCalls) > 0 {
+			assistant["tool_calls"] = toolCalls
+		}
+		return []map[string]interface{}{assistant}, nil
+	}
+
+	result := toolMessages
+	switch {
+	case hasImage || len(toolImages) > 0:
+		// 工具返回的图片放在紧随 tool 消息的 user 消息里，保证模型仍能看到截图
+		result = append(result, map[string]interface{}{
+			"role":    "user",
+			"content": append(toolImages, parts...),
+		})
+	case len(texts) > 0 || len(toolMessages) == 0:
+		result = append(result, map[string]interface{}{
+			"role":    "user",
+			"content": strings.Join(texts, "\n"),
+		})
+	}
+	return result, nil
+}
+
+// convertAnthropicImage 把 Anthropic image 块转为 OpenAI image_url 片段。
+// base64 源拼成 data URL；url 源原样透传。
+func convertAnthropicImage(block gjson.Result) (map[string]interface{}, error) {
+	source := block.Get("source")
+	var url string
+	switch source.Get("type").String() {
+	case "base64":
+		mediaType := source.Get("media_type").String()
+		data := source.Get("data").String()
+		if mediaType == "" || data == "" {
+			return nil, NewClientRequestRejectedError("image.source 缺少 media_type 或 data")
+		}
+		url = "data:" + mediaType + ";base64," + data
+	case "url":
+		url = source.Get("url").String()
+		if url == "" {
+			return nil, NewClientRequestRejectedError("image.source 缺少 url")
+		}
+	default:
+		return nil, NewClientRequestRejectedError(
+			fmt.Sprintf("image.source.type='%s' 不支持，仅支持 base64/url", source.Get("type").String()))
+	}
+	return map[string]interface{}{
+		"type":      "image_url",
+		"image_url": map[string]interface{}{"url": url},
+	}, nil
+}
+
+// toolResultErrorPrefix 标记失败的工具结果（is_error=true）
+const toolResultErrorPrefix = "[tool error] "
+
+// extractToolResultContent 提取 tool_result 的内容：string，或 text/image block 数组。
+// 文本合并返回，图片转成 image_url 片段单独返回。
+func extractToolResultContent(content gjson.Result) (string, []map[string]interface{}, error) {
+	if !content.Exists() || content.Type == gjson.String {
+		return content.String(), nil, nil
+	}
+	if !content.IsArray() {
+		return "", nil, NewClientRequestRejectedError("tool_result.content 格式无效，必须是 string 或 content block 数组")
+	}
+	var texts []string
+	var images []map[string]interface{}
+	for i, block := range content.Array() {
+		switch blockType := block.Get("type").String(); blockType {
+		case "text":
+			texts = append(texts, block.Get("text").String())
+		case "image":
+			part, err := convertAnthropicImage(block)
+			if err != nil {
+				return "", nil, err
+			}
+			images = append(images, part)
+		default:
+			return "", nil, NewClientRequestRejectedError(
+				fmt.Sprintf("tool_result.content[%d].type='%s' 不支持", i, blockType))
+		}
+	}
+	return strings.Join(texts, "\n"), images, nil
+}
+
 // extractTextContent 从 Anthropic content 字段提取纯文本
 // content 可能是 string 或 [{type:"text",text:"..."},...] 数组
 func extractTextContent(content gjson.Result) (string, error) {
@@ -214,7 +469,7 @@ func extractTextContent(content gjson.Result) (string, error) {
 			blockType := block.Get("type").String()
 			if blockType != "text" {
 				return "", NewClientRequestRejectedError(
-					fmt.Sprintf("content[%d].type='%s' 不支持，第一期仅支持 text 类型", i, blockType))
+					fmt.Sprintf("content[%d].type='%s' 不支持，此处仅支持 text 类型", i, blockType))
 			}
 			texts = append(texts, block.Get("text").String())
 		}
@@ -224,28 +479,119 @@ func extractTextContent(content gjson.Result) (string, error) {
 	return "", NewClientRequestRejectedError("content 格式无效，必须是 string 或 text block 数组")
 }
 
+// ========== 非流式响应转换：OpenAI → Anthropic ==========
+
+// ConvertOpenAIToAnthropicResponse 将 OpenAI chat.completion 响应转换为 Anthropic Message。
+// model 为客户端请求的模型名（与流式转换器一致，不用上游回报的映射后名称）。
+// usage 按 Anthropic 语义拆分：input_tokens 不含缓存命中部分，命中部分记为 cache_read_input_tokens。
+func ConvertOpenAIToAnthropicResponse(body []byte, model string) ([]byte, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, fmt.Errorf("上游响应不是合法 JSON")
+	}
+	parsed := gjson.ParseBytes(body)
+	choice := parsed.Get("choices.0")
+	if !choice.Exists() {
+		return nil, fmt.Errorf("上游响应缺少 choices")
+	}
+	if model == "" {
+		model = parsed.Get("model").String()
+	}
+
+	content := make([]map[string]interface{}, 0)
+	if text := choice.Get("message.content").String(); text != "" {
+		content = append(content, map[string]interface{}{
+			"type": "text",
+			"text": text,
+		})
+	}
+	sawToolUse := false
+	for _, tc := range choice.Get("message.tool_calls").Array() {
+		id := tc.Get("id").String()
+		if id == "" {
+			id = "toolu_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24]
+		}
+		// arguments 是 JSON 字符串；上游偶尔给出非法 JSON，此时退化为空对象，
+		// 避免整条响应因单个工具参数无法解析而失败
+		var input interface{} = map[string]interface{}{}
+		if args := tc.Get("function.arguments").String(); args != "" && gjson.Valid(args) {
+			input = json.RawMessage(args)
+		}
+		content = append(content, map[string]interface{}{
+			"type":  "tool_use",
+			"id":    id,
+			"name":  tc.Get("function.name").String(),
+			"input": input,
+		})
+		sawToolUse = true
+	}
+
+	finishReason := choice.Get("finish_reason").String()
+	stopReason := mapOpenAIFinishReason(finishReason)
+	if sawToolUse && (finishReason == "" || finishReason == "stop") {
+		stopReason = "tool_use"
+	}
+
+	usage := parsed.Get("usage")
+	promptTokens := usage.Get("prompt_tokens").Int()
+	cachedTokens := usage.Get("prompt_tokens_details.cached_tokens").Int()
+	if cachedTokens > promptTokens {
+		cachedTokens = promptTokens
+	}
+
+	message := map[string]interface{}{
+		"id":            GenerateAnthropicMessageID(),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         model,
+		"content":       content,
+		"stop_reason":   stopReason,
+		"stop_sequence": nil,
+		"usage": map[string]interface{}{
+			"input_tokens":            promptTokens - cachedTokens,
+			"output_tokens":           usage.Get("completion_tokens").Int(),
+			"cache_read_input_tokens": cachedTokens,
+		},
+	}
+
+	result, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("序列化 Anthropic 响应失败: %w", err)
+	}
+	return result, nil
+}
+
 // ========== SSE 转换状态机：OpenAI → Anthropic ==========
 
 // OpenAIToAnthropicSSEConverter OpenAI SSE 到 Anthropic SSE 的转换器
 // 设计为支持逐行输入（适配 xrequest 的 hook 行为）
+//
+// Anthropic 的 content block 按 index 顺序依次打开/关闭，而 OpenAI 的文本与
+// tool_calls 增量可能交错到达。转换器同一时刻只保持一个打开的 block：
+// 新类型（或新的 tool call）到达时先关闭当前 block 再打开下一个。
 type OpenAIToAnthropicSSEConverter struct {
-	messageID           string // Anthropic message ID
-	model               string // 模型名（用于 message_start）
-	startedMessage      bool   // 是否已输出 message_start
-	startedContentBlock bool   // 是否已输出 content_block_start
-	stopped             bool   // 是否已输出 message_stop
-	finishReason        string // 捕获的 finish_reason
-	inputTokens         int64  // 捕获的 input tokens
-	outputTokens        int64  // 捕获的 output tokens
-	cacheReadTokens     int64
-	usageCaptured       bool // 是否已捕获 usage
+	messageID       string // Anthropic message ID
+	model           string // 模型名（用于 message_start）
+	startedMessage  bool   // 是否已输出 message_start
+	stopped         bool   // 是否已输出 message_stop
+	finishReason    string // 捕获的 finish_reason
+	inputTokens     int64  // 捕获的 input tokens
+	outputTokens    int64  // 捕获的 output tokens
+	cacheReadTokens int64
+	usageCaptured   bool // 是否已捕获 usage
+
+	nextBlockIndex int         // 下一个 content block 的 index
+	openBlockIndex int         // 当前打开的 block index（openBlockType 为空时无效）
+	openBlockType  string      // 当前打开的 block 类型：text / tool_use，空表示没有
+	toolBlocks     map[int]int // OpenAI tool_calls[].index → Anthropic block index
+	sawToolUse     bool        // 是否输出过 tool_use block
 }
 
 // NewOpenAIToAnthropicSSEConverter 创建新的 SSE 转换器
 func NewOpenAIToAnthropicSSEConverter(model string) *OpenAIToAnthropicSSEConverter {
 	return &OpenAIToAnthropicSSEConverter{
-		messageID: "msg_" + uuid.New().String()[:24],
-		model:     model,
+		messageID:  "msg_" + uuid.New().String()[:24],
+		model:      model,
+		toolBlocks: make(map[int]int),
 	}
 }
 
@@ -308,6 +654,8 @@ func (c *OpenAIToAnthropicSSEConverter) ProcessLine(line string) string {
 		c.finishReason = fr.String()
 	}
 
+	var output strings.Builder
+
 	// 提取 content delta
 	contentDelta := choice.Get("delta.content").String()
 	// 兼容：有些上游用 message.content
@@ -316,10 +664,17 @@ func (c *OpenAIToAnthropicSSEConverter) ProcessLine(line string) string {
 	}
 
 	if contentDelta != "" {
-		return c.outputContentDelta(contentDelta)
+		output.WriteString(c.outputContentDelta(contentDelta))
+	}
+
+	// 提取 tool_calls delta（同一 chunk 可能携带多个 tool call 的增量）
+	if toolCalls := choice.Get("delta.tool_calls"); toolCalls.Exists() && toolCalls.IsArray() {
+		for _, tc := range toolCalls.Array() {
+			output.WriteString(c.outputToolCallDelta(tc))
+		}
 	}
 
-	return ""
+	return output.String()
 }
 
 // formatAnthropicSSEEvent 按 Anthropic 流式规范输出一个事件。
@@ -342,24 +697,45 @@ func (c *OpenAIToAnthropicSSEConverter) FinalizeIfUnterminated() string {
 	return c.outputStopEvents()
 }
 
+// ConvertResponse 转换非流式响应
+func (c *OpenAIToAnthropicSSEConverter) ConvertResponse(body []byte) ([]byte, error) {
+	return ConvertOpenAIToAnthropicResponse(body, c.model)
+}
+
+// ParseUsage 从转换后的 Anthropic 事件中提取 usage（已拆分缓存命中，复用现有解析器）
+func (c *OpenAIToAnthropicSSEConverter) ParseUsage(_, converted string, usage *ReqeustLog) {
+	if converted != "" {
+		parseEventPayload(converted, ClaudeCodeParseTokenUsageFromResponse, usage)
+	}
+}
+
+// ensureMessageStart 首次输出前补发 message_start
+func (c *OpenAIToAnthropicSSEConverter) ensureMessageStart() string {
+	if c.startedMessage {
+		return ""
+	}
+	c.startedMessage = true
+	return c.outputMessageStart()
+}
+
 // outputContentDelta 输出内容增量事件
 func (c *OpenAIToAnthropicSSEConverter) outputContentDelta(text string) string {
 	var output strings.Builder
 
 	// 首次输出：先发送 message_start 和 content_block_start
-	if !c.startedMessage {
-		output.WriteString(c.outputMessageStart())
-		c.startedMessage = true
-	}
-	if !c.startedContentBlock {
-		output.WriteString(c.outputContentBlockStart())
-		c.startedContentBlock = true
+	output.WriteString(c.ensureMessageStart())
+	if c.openBlockType != "text" {
+		output.WriteString(c.closeOpenBlock())
+		output.WriteString(c.openBlock("text", map[string]interface{}{
+			"type": "text",
+			"text": "",
+		}))
 	}
 
 	// 输出 content_block_delta
 	output.WriteString(formatAnthropicSSEEvent("content_block_delta", map[string]interface{}{
 		"type":  "content_block_delta",
-		"index": 0,
+		"index": c.openBlockIndex,
 		"delta": map[string]interface{}{
 			"type": "text_delta",
 			"text": text,
@@ -369,6 +745,84 @@ func (c *OpenAIToAnthropicSSEConverter) outputContentDelta(text string) string {
 	return output.String()
 }
 
+// outputToolCallDelta 输出单个 tool call 增量。
+// OpenAI 约定首个分片携带 id/name，后续分片只有 index 与 arguments 片段；
+// arguments 片段原样作为 input_json_delta.partial_json 透传。
+func (c *OpenAIToAnthropicSSEConverter) outputToolCallDelta(tc gjson.Result) string {
+	var output strings.Builder
+
+	toolIndex := int(tc.Get("index").Int())
+	blockIndex, known := c.toolBlocks[toolIndex]
+	if !known {
+		output.WriteString(c.outputToolUseStart(tc.Get("id").String(), tc.Get("function.name").String()))
+		blockIndex = c.openBlockIndex
+		c.toolBlocks[toolIndex] = blockIndex
+	} else if c.openBlockType != "tool_use" || c.openBlockIndex != blockIndex {
+		// 已关闭的 tool block 又收到增量：Anthropic 协议无法重新打开，丢弃
+		return output.String()
+	}
+
+	output.WriteString(c.outputToolInputDelta(tc.Get("function.arguments").String()))
+	return output.String()
+}
+
+// outputToolUseStart 关闭当前 block 并打开一个 tool_use block；id 为空时生成
+func (c *OpenAIToAnthropicSSEConverter) outputToolUseStart(id, name string) string {
+	var output strings.Builder
+	output.WriteString(c.ensureMessageStart())
+	if id == "" {
+		id = "toolu_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24]
+	}
+	output.WriteString(c.closeOpenBlock())
+	output.WriteString(c.openBlock("tool_use", map[string]interface{}{
+		"type":  "tool_use",
+		"id":    id,
+		"name":  name,
+		"input": map[string]interface{}{},
+	}))
+	c.sawToolUse = true
+	return output.String()
+}
+
+// outputToolInputDelta 向当前打开的 tool_use block 输出参数 JSON 片段
+func (c *OpenAIToAnthropicSSEConverter) outputToolInputDelta(partialJSON string) string {
+	if partialJSON == "" || c.openBlockType != "tool_use" {
+		return ""
+	}
+	return formatAnthropicSSEEvent("content_block_delta", map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": c.openBlockIndex,
+		"delta": map[string]interface{}{
+			"type":         "input_json_delta",
+			"partial_json": partialJSON,
+		},
+	})
+}
+
+// openBlock 以下一个 index 打开新的 content block
+func (c *OpenAIToAnthropicSSEConverter) openBlock(blockType string, contentBlock map[string]interface{}) string {
+	c.openBlockIndex = c.nextBlockIndex
+	c.openBlockType = blockType
+	c.nextBlockIndex++
+	return formatAnthropicSSEEvent("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         c.openBlockIndex,
+		"content_block": contentBlock,
+	})
+}
+
+// closeOpenBlock 关闭当前打开的 content block（没有时返回空串）
+func (c *OpenAIToAnthropicSSEConverter) closeOpenBlock() string {
+	if c.openBlockType == "" {
+		return ""
+	}
+	c.openBlockType = ""
+	return formatAnthropicSSEEvent("content_block_stop", map[string]interface{}{
+		"type":  "content_block_stop",
+		"index": c.openBlockIndex,
+	})
+}
+
 // outputMessageStart 输出 message_start 事件
 func (c *OpenAIToAnthropicSSEConverter) outputMessageStart() string {
 	return formatAnthropicSSEEvent("message_start", map[string]interface{}{
@@ -389,18 +843,6 @@ func (c *OpenAIToAnthropicSSEConverter) outputMessageStart() string {
 	})
 }
 
-// outputContentBlockStart 输出 content_block_start 事件
-func (c *OpenAIToAnthropicSSEConverter) outputContentBlockStart() string {
-	return formatAnthropicSSEEvent("content_block_start", map[string]interface{}{
-		"type":  "content_block_start",
-		"index": 0,
-		"content_block": map[string]interface{}{
-			"type": "text",
-			"text": "",
-		},
-	})
-}
-
 // outputStopEvents 输出停止事件序列
 func (c *OpenAIToAnthropicSSEConverter) outputStopEvents() string {
 	if c.stopped {
@@ -410,27 +852,31 @@ func (c *OpenAIToAnthropicSSEConverter) outputStopEvents() string {
 
 	var output strings.Builder
 
-	// 确保已发送 start 事件
-	if !c.startedMessage {
-		output.WriteString(c.outputMessageStart())
-		c.startedMessage = true
-	}
-	if !c.startedContentBlock {
-		output.WriteString(c.outputContentBlockStart())
-		c.startedContentBlock = true
+	// 确保已发送 start 事件；一个 block 都没有时补一个空 text block，
+	// 保证客户端看到完整的 start/stop 序列
+	output.WriteString(c.ensureMessageStart())
+	if c.nextBlockIndex == 0 {
+		output.WriteString(c.openBlock("text", map[string]interface{}{
+			"type": "text",
+			"text": "",
+		}))
 	}
 
 	// content_block_stop
-	output.WriteString(formatAnthropicSSEEvent("content_block_stop", map[string]interface{}{
-		"type":  "content_block_stop",
-		"index": 0,
-	}))
+	output.WriteString(c.closeOpenBlock())
+
+	stopReason := c.mapFinishReason(c.finishReason)
+	// 部分 OpenAI 兼容上游调用工具时仍返回 finish_reason=stop；
+	// Claude Code 依据 stop_reason=tool_use 决定是否执行工具，这里以实际输出为准
+	if c.sawToolUse && (c.finishReason == "" || c.finishReason == "stop") {
+		stopReason = "tool_use"
+	}
 
 	// message_delta（包含 stop_reason 和 usage）
 	output.WriteString(formatAnthropicSSEEvent("message_delta", map[string]interface{}{
 		"type": "message_delta",
 		"delta": map[string]interface{}{
-			"stop_reason":   c.mapFinishReason(c.finishReason),
+			"stop_reason":   stopReason,
 			"stop_sequence": nil,
 		},
 		"usage": map[string]interface{}{
@@ -450,6 +896,11 @@ func (c *OpenAIToAnthropicSSEConverter) outputStopEvents() string {
 
 // mapFinishReason 映射 OpenAI finish_reason 到 Anthropic stop_reason
 func (c *OpenAIToAnthropicSSEConverter) mapFinishReason(finishReason string) string {
+	return mapOpenAIFinishReason(finishReason)
+}
+
+// mapOpenAIFinishReason 映射 OpenAI finish_reason 到 Anthropic stop_reason（流式与非流式共用）
+func mapOpenAIFinishReason(finishReason string) string {
 	switch finishReason {
 	case "stop":
 		return "end_turn"
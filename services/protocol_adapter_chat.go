@@ -0,0 +1,592 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tidwall/gjson"
+)
+
+// ========== 请求转换：OpenAI Chat Completions → Anthropic Messages ==========
+//
+// 供 openai 平台（/v1/chat/completions 入口）把请求转发到只支持 Anthropic 协议的上游。
+// 支持范围：
+// - system/developer 消息合并为 system
+// - user 消息的文本/图片片段，assistant 的文本与 tool_calls，role=tool 的工具结果
+// - function 工具与 tool_choice；n>1 与旧版 functions/function_call 拒绝
+// - logprobs、response_format 等 Anthropic 没有对应物的采样参数丢弃并记录
+
+// ConvertOpenAIChatToAnthropic 将 OpenAI Chat Completions 请求转换为 Anthropic Messages 请求
+func ConvertOpenAIChatToAnthropic(body []byte) ([]byte, ConvertInfo, error) {
+	info := ConvertInfo{}
+	parsed := gjson.ParseBytes(body)
+
+	if n := parsed.Get("n"); n.Exists() && n.Int() > 1 {
+		return nil, info, NewClientRequestRejectedError("n>1 不支持，Anthropic 上游每次只返回一个候选")
+	}
+	if parsed.Get("functions").Exists() || parsed.Get("function_call").Exists() {
+		return nil, info, NewClientRequestRejectedError("旧版 functions/function_call 不支持，请改用 tools/tool_choice")
+	}
+
+	anthropicReq := make(map[string]interface{})
+
+	// model（直接使用，已经过 ModelMapping 处理）
+	if model := parsed.Get("model").String(); model != "" {
+		anthropicReq["model"] = model
+	}
+
+	// max_completion_tokens 优先（新版字段），其次 max_tokens；都没有时与 Responses 转换同一默认值
+	switch {
+	case parsed.Get("max_completion_tokens").Int() > 0:
+		anthropicReq["max_tokens"] = parsed.Get("max_completion_tokens").Int()
+	case parsed.Get("max_tokens").Int() > 0:
+		anthropicReq["max_tokens"] = parsed.Get("max_tokens").Int()
+	default:
+		anthropicReq["max_tokens"] = defaultResponsesMaxTokens
+	}
+
+	anthropicReq["stream"] = parsed.Get("stream").Bool()
+	info.IncludeUsage = parsed.Get("stream_options.include_usage").Bool()
+
+	if temp := parsed.Get("temperature"); temp.Exists() {
+		anthropicReq["temperature"] = temp.Float()
+	}
+	if topP := parsed.Get("top_p"); topP.Exists() {
+		anthropicReq["top_p"] = topP.Float()
+	}
+
+	// stop: string 或数组 → stop_sequences
+	if stop := parsed.Get("stop"); stop.Exists() {
+		var sequences []string
+		if stop.IsArray() {
+			for _, s := range stop.Array() {
+				if s.String() != "" {
+					sequences = append(sequences, s.String())
+				}
+			}
+		} else if stop.String() != "" {
+			sequences = append(sequences, stop.String())
+		}
+		if len(sequences) > 0 {
+			anthropicReq["stop_sequences"] = sequences
+		}
+	}
+
+	// user → metadata.user_id
+	if user := parsed.Get("user").String(); user != "" {
+		anthropicReq["metadata"] = map[string]interface{}{"user_id": user}
+		info.MappedUser = user
+	}
+
+	// 记录被丢弃的顶层字段
+	for _, field := range []string{
+		"frequency_penalty", "presence_penalty", "logprobs", "top_logprobs", "logit_bias", "seed",
+		"response_format", "reasoning_effort", "modalities", "audio", "prediction", "store", "service_tier",
+	} {
+		if parsed.Get(field).Exists() {
+			info.DroppedFields = append(info.DroppedFields, field)
+		}
+	}
+
+	// ========== 转换 tools / tool_choice ==========
+
+	if tools := parsed.Get("tools"); tools.Exists() && tools.IsArray() {
+		anthropicTools := make([]map[string]interface{}, 0)
+		for i, tool := range tools.Array() {
+			if tool.Get("type").String() != "function" {
+				info.DroppedFields = append(info.DroppedFields, "tools:"+tool.Get("type").String())
+				continue
+			}
+			name := tool.Get("function.name").String()
+			if name == "" {
+				return nil, info, fmt.Errorf("tools[%d]: %w", i, NewClientRequestRejectedError("function 工具缺少 name"))
+			}
+			converted := map[string]interface{}{"name": name}
+			if desc := tool.Get("function.description").String(); desc != "" {
+				converted["description"] = desc
+			}
+			if params := tool.Get("function.parameters"); params.Exists() && params.IsObject() {
+				converted["input_schema"] = json.RawMessage(params.Raw)
+			} else {
+				converted["input_schema"] = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+			}
+			anthropicTools = append(anthropicTools, converted)
+		}
+		if len(anthropicTools) > 0 {
+			anthropicReq["tools"] = anthropicTools
+		}
+	}
+
+	if anthropicReq["tools"] != nil {
+		toolChoice := convertChatToolChoice(parsed.Get("tool_choice"))
+		if pc := parsed.Get("parallel_tool_calls"); pc.Exists() && !pc.Bool() {
+			if toolChoice == nil {
+				toolChoice = map[string]interface{}{"type": "auto"}
+			}
+			if toolChoice["type"] != "none" {
+				toolChoice["disable_parallel_tool_use"] = true
+			}
+		}
+		if toolChoice != nil {
+			anthropicReq["tool_choice"] = toolChoice
+		}
+	}
+
+	// ========== 转换 messages ==========
+
+	messages := parsed.Get("messages")
+	if !messages.IsArray() {
+		return nil, info, NewClientRequestRejectedError("messages 必须是数组")
+	}
+
+	var systemTexts []string
+	builder := &anthropicMessageBuilder{}
+	for i, msg := range messages.Array() {
+		texts, err := convertChatMessage(msg, builder)
+		if err != nil {
+			return nil, info, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		systemTexts = append(systemTexts, texts...)
+	}
+
+	if len(systemTexts) > 0 {
+		anthropicReq["system"] = strings.Join(systemTexts, "\n\n")
+	}
+	anthropicReq["messages"] = builder.messages
+
+	result, err := json.Marshal(anthropicReq)
+	if err != nil {
+		return nil, info, fmt.Errorf("序列化 Anthropic 请求失败: %w", err)
+	}
+	return result, info, nil
+}
+
+// convertChatMessage 转换单条 Chat 消息写入 builder；
+// system/developer 消息的文本通过返回值交给调用方合并进 system。
+// role=tool 的结果并入随后的 user 消息，保证 tool_result 紧跟对应的 tool_use。
+func convertChatMessage(msg gjson.Result, builder *anthropicMessageBuilder) ([]string, error) {
+	role := msg.Get("role").String()
+	switch role {
+	case "system", "developer":
+		blocks, err := convertChatContent(msg.Get("content"))
+		if err != nil {
+			return nil, err
+		}
+		var texts []string
+		for _, block := range blocks {
+			if block["type"] == "text" {
+				texts = append(texts, block["text"].(string))
+			}
+		}
+		return texts, nil
+
+	case "user":
+		blocks, err := convertChatContent(msg.Get("content"))
+		if err != nil {
+			return nil, err
+		}
+		builder.add("user", blocks...)
+		return nil, nil
+
+	case "assistant":
+		blocks, err := convertChatContent(msg.Get("content"))
+		if err != nil {
+			return nil, err
+		}
+		for _, tc := range msg.Get("tool_calls").Array() {
+			blocks = append(blocks, map[string]interface{}{
+				"type":  "tool_use",
+				"id":    tc.Get("id").String(),
+				"name":  tc.Get("function.name").String(),
+				"input": rawJSONObject(tc.Get("function.arguments").String()),
+			})
+		}
+		builder.add("assistant", blocks...)
+		return nil, nil
+
+	case "tool":
+		result := map[string]interface{}{
+			"type":        "tool_result",
+			"tool_use_id": msg.Get("tool_call_id").String(),
+		}
+		content := msg.Get("content")
+		if content.IsArray() {
+			blocks, err := convertChatContent(content)
+			if err != nil {
+				return nil, err
+			}
+			result["content"] = blocks
+		} else {
+			result["content"] = content.String()
+		}
+		builder.add("user", result)
+		return nil, nil
+
+	default:
+		return nil, NewClientRequestRejectedError(fmt.Sprintf("role='%s' 不支持", role))
+	}
+}
+
+// convertChatContent 转换 content（string 或内容片段数组）为 Anthropic content block，
+// 空文本跳过（Anthropic 拒绝空 text block）
+func convertChatContent(content gjson.Result) ([]map[string]interface{}, error) {
+	if !content.Exists() || content.Type == gjson.Null {
+		return nil, nil
+	}
+	if content.Type == gjson.String {
+		if content.String() == "" {
+			return nil, nil
+		}
+		return []map[string]interface{}{{"type": "text", "text": content.String()}}, nil
+	}
+	if !content.IsArray() {
+		return nil, NewClientRequestRejectedError("content 格式无效，必须是 string 或数组")
+	}
+
+	blocks := make([]map[string]interface{}, 0)
+	for i, part := range content.Array() {
+		switch partType := part.Get("type").String(); partType {
+		case "text":
+			if text := part.Get("text").String(); text != "" {
+				blocks = append(blocks, map[string]interface{}{"type": "text", "text": text})
+			}
+		case "refusal":
+			blocks = append(blocks, map[string]interface{}{"type": "text", "text": part.Get("refusal").String()})
+		case "image_url":
+			// image_url 既可能是 {url} 对象，也可能被简写为字符串
+			imageURL := part.Get("image_url.url").String()
+			if imageURL == "" && part.Get("image_url").Type == gjson.String {
+				imageURL = part.Get("image_url").String()
+			}
+			block, err := convertImageURLToAnthropic(imageURL, "image_url")
+			if err != nil {
+				return nil, fmt.Errorf("content[%d]: %w", i, err)
+			}
+			blocks = append(blocks, block)
+		default:
+			return nil, NewClientRequestRejectedError(fmt.Sprintf("content[%d].type='%s' 不支持", i, partType))
+		}
+	}
+	return blocks, nil
+}
+
+// convertChatToolChoice 映射 tool_choice：
+// "auto" → auto，"required" → any，"none" → none，{type:function,function:{name}} → tool
+func convertChatToolChoice(toolChoice gjson.Result) map[string]interface{} {
+	if !toolChoice.Exists() {
+		return nil
+	}
+	if toolChoice.Type == gjson.String {
+		switch toolChoice.String() {
+		case "required":
+			return map[string]interface{}{"type": "any"}
+		case "none":
+			return map[string]interface{}{"type": "none"}
+		default:
+			return map[string]interface{}{"type": "auto"}
+		}
+	}
+	if name := toolChoice.Get("function.name").String(); name != "" {
+		return map[string]interface{}{"type": "tool", "name": name}
+	}
+	return map[string]interface{}{"type": "auto"}
+}
+
+// ========== 响应转换：Anthropic Messages → OpenAI Chat Completions ==========
+
+// AnthropicToOpenAIChatSSEConverter Anthropic SSE 到 OpenAI Chat Completions SSE 的转换器
+// 设计为支持逐行输入（适配 xrequest 的 hook 行为）
+type AnthropicToOpenAIChatSSEConverter struct {
+	id           string
+	model        string
+	created      int64
+	includeUsage bool // 客户端要求流末输出 usage chunk
+	started      bool // 是否已输出 role chunk
+	stopped      bool // 是否已输出终止 chunk 与 [DONE]
+	stopReason   string
+	toolIndex    map[int]int // Anthropic block index → tool_calls index
+	nextTool     int
+
+	inputTokens       int64
+	outputTokens      int64
+	cacheReadTokens   int64
+	cacheCreateTokens int64
+}
+
+// NewAnthropicToOpenAIChatSSEConverter 创建新的 SSE 转换器
+func NewAnthropicToOpenAIChatSSEConverter(model string, includeUsage bool) *AnthropicToOpenAIChatSSEConverter {
+	return &AnthropicToOpenAIChatSSEConverter{
+		id:           "chatcmpl-" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		model:        model,
+		created:      time.Now().Unix(),
+		includeUsage: includeUsage,
+		toolIndex:    make(map[int]int),
+	}
+}
+
+// ProcessLine 处理单行 Anthropic SSE，返回转换后的 chat.completion.chunk
+func (c *AnthropicToOpenAIChatSSEConverter) ProcessLine(line string) string {
+	if c.stopped {
+		return ""
+	}
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "data:") {
+		return "" // event: 行与空行由 data 中的 type 字段代替
+	}
+	data := gjson.Parse(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+
+	var output strings.Builder
+	switch data.Get("type").String() {
+	case "message_start":
+		c.captureUsage(data.Get("message.usage"))
+		output.WriteString(c.ensureStarted())
+
+	case "content_block_start":
+		output.WriteString(c.ensureStarted())
+		block := data.Get("content_block")
+		if block.Get("type").String() == "tool_use" {
+			index := c.nextTool
+			c.nextTool++
+			c.toolIndex[int(data.Get("index").Int())] = index
+			output.WriteString(c.chunk(map[string]interface{}{
+				"tool_calls": []map[string]interface{}{{
+					"index": index,
+					"id":    block.Get("id").String(),
+					"type":  "function",
+					"function": map[string]interface{}{
+						"name":      block.Get("name").String(),
+						"arguments": "",
+					},
+				}},
+			}, nil))
+		}
+
+	case "content_block_delta":
+		delta := data.Get("delta")
+		switch delta.Get("type").String() {
+		case "text_delta":
+			if text := delta.Get("text").String(); text != "" {
+				output.WriteString(c.chunk(map[string]interface{}{"content": text}, nil))
+			}
+		case "input_json_delta":
+			index, ok := c.toolIndex[int(data.Get("index").Int())]
+			if partial := delta.Get("partial_json").String(); ok && partial != "" {
+				output.WriteString(c.chunk(map[string]interface{}{
+					"tool_calls": []map[string]interface{}{{
+						"index":    index,
+						"function": map[string]interface{}{"arguments": partial},
+					}},
+				}, nil))
+			}
+		}
+		// thinking_delta / signature_delta：Chat 协议没有标准字段，丢弃
+
+	case "message_delta":
+		if sr := data.Get("delta.stop_reason").String(); sr != "" {
+			c.stopReason = sr
+		}
+		c.captureUsage(data.Get("usage"))
+
+	case "message_stop":
+		output.WriteString(c.ensureStarted())
+		output.WriteString(c.outputStop())
+
+	case "error":
+		output.WriteString(c.outputError(data.Get("error.type").String(), data.Get("error.message").String()))
+	}
+	return output.String()
+}
+
+// FinalizeIfUnterminated 上游未发 message_stop 就断流时输出 error 事件且不发 [DONE]，
+// 让客户端识别为失败而不是把半截输出当成完整回答
+func (c *AnthropicToOpenAIChatSSEConverter) FinalizeIfUnterminated() string {
+	if c.stopped {
+		return ""
+	}
+	return c.outputError("server_error", "upstream stream ended before message_stop")
+}
+
+// ConvertResponse 转换非流式响应
+func (c *AnthropicToOpenAIChatSSEConverter) ConvertResponse(body []byte) ([]byte, error) {
+	return ConvertAnthropicToOpenAIChatResponse(body, c.model)
+}
+
+// ParseUsage 按上游原始 Anthropic 数据计费：Chat 的 usage 没有缓存写入字段，
+// 以转换结果计费会把 cache_creation 按普通输入计价
+func (c *AnthropicToOpenAIChatSSEConverter) ParseUsage(upstream, _ string, usage *ReqeustLog) {
+	if upstream != "" {
+		parseEventPayload(upstream, ClaudeCodeParseTokenUsageFromResponse, usage)
+	}
+}
+
+func (c *AnthropicToOpenAIChatSSEConverter) captureUsage(usage gjson.Result) {
+	if !usage.Exists() {
+		return
+	}
+	// message_delta 的 usage 为累计值，逐字段取 max
+	maxInt64Into(&c.inputTokens, usage.Get("input_tokens").Int())
+	maxInt64Into(&c.outputTokens, usage.Get("output_tokens").Int())
+	maxInt64Into(&c.cacheReadTokens, usage.Get("cache_read_input_tokens").Int())
+	maxInt64Into(&c.cacheCreateTokens, usage.Get("cache_creation_input_tokens").Int())
+}
+
+// chunk 格式化一个 chat.completion.chunk；finishReason 为 nil 时输出 null
+func (c *AnthropicToOpenAIChatSSEConverter) chunk(delta map[string]interface{}, finishReason interface{}) string {
+	return c.data(map[string]interface{}{
+		"id":      c.id,
+		"object":  "chat.completion.chunk",
+		"created": c.created,
+		"model":   c.model,
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"delta":         delta,
+			"finish_reason": finishReason,
+		}},
+	})
+}
+
+func (c *AnthropicToOpenAIChatSSEConverter) data(payload map[string]interface{}) string {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("data: %s\n\n", string(data))
+}
+
+func (c *AnthropicToOpenAIChatSSEConverter) ensureStarted() string {
+	if c.started {
+		return ""
+	}
+	c.started = true
+	return c.chunk(map[string]interface{}{"role": "assistant", "content": ""}, nil)
+}
+
+func (c *AnthropicToOpenAIChatSSEConverter) outputStop() string {
+	if c.stopped {
+		return ""
+	}
+	c.stopped = true
+
+	var output strings.Builder
+	output.WriteString(c.chunk(map[string]interface{}{}, mapAnthropicStopReason(c.stopReason)))
+	// 与 OpenAI 一致：usage 单独一个 choices 为空的 chunk，仅在客户端要求时输出
+	if c.includeUsage {
+		output.WriteString(c.data(map[string]interface{}{
+			"id":      c.id,
+			"object":  "chat.completion.chunk",
+			"created": c.created,
+			"model":   c.model,
+			"choices": []interface{}{},
+			"usage":   chatUsage(c.inputTokens, c.outputTokens, c.cacheReadTokens, c.cacheCreateTokens),
+		}))
+	}
+	output.WriteString("data: [DONE]\n\n")
+	return output.String()
+}
+
+func (c *AnthropicToOpenAIChatSSEConverter) outputError(errType, message string) string {
+	c.stopped = true
+	if errType == "" {
+		errType = "server_error"
+	}
+	return c.data(map[string]interface{}{
+		"error": map[string]interface{}{"type": errType, "message": message},
+	})
+}
+
+// mapAnthropicStopReason 映射 Anthropic stop_reason 到 OpenAI finish_reason（流式与非流式共用）
+func mapAnthropicStopReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
+
+// chatUsage 把 Anthropic usage 折算为 Chat usage：
+// prompt_tokens 含缓存部分，cached_tokens 为其中的缓存命中
+func chatUsage(input, output, cacheRead, cacheCreate int64) map[string]interface{} {
+	prompt := input + cacheRead + cacheCreate
+	return map[string]interface{}{
+		"prompt_tokens":         prompt,
+		"completion_tokens":     output,
+		"total_tokens":          prompt + output,
+		"prompt_tokens_details": map[string]interface{}{"cached_tokens": cacheRead},
+	}
+}
+
+// ConvertAnthropicToOpenAIChatResponse 将非流式 Anthropic Message 转换为 chat.completion 响应
+func ConvertAnthropicToOpenAIChatResponse(body []byte, model string) ([]byte, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, fmt.Errorf("上游响应不是合法 JSON")
+	}
+	parsed := gjson.ParseBytes(body)
+	if parsed.Get("type").String() != "message" {
+		return nil, fmt.Errorf("上游响应不是 Anthropic Message")
+	}
+	if model == "" {
+		model = parsed.Get("model").String()
+	}
+
+	var text strings.Builder
+	toolCalls := make([]map[string]interface{}, 0)
+	for _, block := range parsed.Get("content").Array() {
+		switch block.Get("type").String() {
+		case "text":
+			text.WriteString(block.Get("text").String())
+		case "tool_use":
+			arguments := "{}"
+			if input := block.Get("input"); input.Exists() {
+				arguments = input.Raw
+			}
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"id":   block.Get("id").String(),
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      block.Get("name").String(),
+					"arguments": arguments,
+				},
+			})
+		}
+	}
+
+	message := map[string]interface{}{"role": "assistant", "content": nil}
+	if text.Len() > 0 || len(toolCalls) == 0 {
+		message["content"] = text.String()
+	}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+
+	usage := parsed.Get("usage")
+	resp := map[string]interface{}{
+		"id":      "chatcmpl-" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"message":       message,
+			"finish_reason": mapAnthropicStopReason(parsed.Get("stop_reason").String()),
+		}},
+		"usage": chatUsage(
+			usage.Get("input_tokens").Int(),
+			usage.Get("output_tokens").Int(),
+			usage.Get("cache_read_input_tokens").Int(),
+			usage.Get("cache_creation_input_tokens").Int(),
+		),
+	}
+	result, err := json.Marshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("序列化 Chat 响应失败: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// TestConvertOpenAIChatToAnthropicRequest Chat 请求转 Messages:system 合并、stop 序列、
+// assistant tool_calls 转 tool_use,role=tool 的结果并入随后的 user 消息。
+func TestConvertOpenAIChatToAnthropicRequest(t *testing.T) {
+	body := []byte(`{
+		"model":"claude-sonnet-4-5","stream":true,"stream_options":{"include_usage":true},
+		"max_completion_tokens":2048,"stop":"END","seed":1,
+		"tools":[{"type":"function","function":{"name":"read_file","description":"读文件","parameters":{"type":"object","properties":{"path":{"type":"string"}}}}}],
+		"tool_choice":"required","parallel_tool_calls":false,
+		"messages":[
+			{"role":"system","content":"你是助手"},
+			{"role":"developer","content":[{"type":"text","text":"只读"}]},
+			{"role":"user","content":[{"type":"text","text":"读 a.go"},{"type":"image_url","image_url":{"url":"data:image/png;base64,AAA="}}]},
+			{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"read_file","arguments":"{\"path\":\"a.go\"}"}}]},
+			{"role":"tool","tool_call_id":"call_1","content":"package main"},
+			{"role":"user","content":"解释一下"}
+		]
+	}`)
+
+	out, info, err := ConvertOpenAIChatToAnthropic(body)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	req := gjson.ParseBytes(out)
+
+	if got := req.Get("system").String(); got != "你是助手\n\n只读" {
+		t.Errorf("system = %q", got)
+	}
+	if req.Get("max_tokens").Int() != 2048 || req.Get("stop_sequences.0").String() != "END" {
+		t.Errorf("max_tokens/stop 映射错误: %s", out)
+	}
+	if !info.IncludeUsage {
+		t.Errorf("应记录 stream_options.include_usage")
+	}
+	if len(info.DroppedFields) != 1 || info.DroppedFields[0] != "seed" {
+		t.Errorf("DroppedFields = %v", info.DroppedFields)
+	}
+	if req.Get("tool_choice.type").String() != "any" || !req.Get("tool_choice.disable_parallel_tool_use").Bool() {
+		t.Errorf("tool_choice 映射错误: %s", req.Get("tool_choice").Raw)
+	}
+	if req.Get("tools.0.input_schema.properties.path.type").String() != "string" {
+		t.Errorf("工具 schema 应原样作为 input_schema: %s", req.Get("tools").Raw)
+	}
+
+	msgs := req.Get("messages").Array()
+	if len(msgs) != 3 {
+		t.Fatalf("messages 数量 = %d, want 3: %s", len(msgs), req.Get("messages").Raw)
+	}
+	if msgs[0].Get("content.1.source.media_type").String() != "image/png" {
+		t.Errorf("图片转换错误: %s", msgs[0].Raw)
+	}
+	if msgs[1].Get("content.#").Int() != 1 || msgs[1].Get("content.0.input.path").String() != "a.go" {
+		t.Errorf("tool_calls 转换错误: %s", msgs[1].Raw)
+	}
+	results := msgs[2].Get("content").Array()
+	if len(results) != 2 || results[0].Get("tool_use_id").String() != "call_1" || results[1].Get("text").String() != "解释一下" {
+		t.Errorf("tool_result 应位于 user 消息开头: %s", msgs[2].Raw)
+	}
+}
+
+// TestConvertOpenAIChatRejectsMultipleChoices n>1 在 Anthropic 上游无法实现,按客户端错误拒绝
+func TestConvertOpenAIChatRejectsMultipleChoices(t *testing.T) {
+	_, _, err := ConvertOpenAIChatToAnthropic([]byte(`{"model":"m","n":2,"messages":[{"role":"user","content":"hi"}]}`))
+	if err == nil || !strings.Contains(err.Error(), ErrClientRequestRejected.Error()) {
+		t.Fatalf("n>1 应被拒绝, 实际 %v", err)
+	}
+}
+
+// TestAnthropicToOpenAIChatSSEConverter Anthropic 流转 chat.completion.chunk:
+// tool_use 变为带 index 的 tool_calls 增量,流末输出 finish_reason、usage chunk 与 [DONE]。
+func TestAnthropicToOpenAIChatSSEConverter(t *testing.T) {
+	conv := NewAnthropicToOpenAIChatSSEConverter("claude-sonnet-4-5", true)
+	usage := &ReqeustLog{}
+
+	var out strings.Builder
+	for _, line := range []string{
+		"event: message_start",
+		`data: {"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":10,"cache_read_input_tokens":90,"output_tokens":1}}}`,
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"好的"}}`,
+		`data: {"type":"content_block_stop","index":0}`,
+		`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"read_file","input":{}}}`,
+		`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"path\":\"a.go\"}"}}`,
+		`data: {"type":"content_block_stop","index":1}`,
+		`data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":20}}`,
+		`data: {"type":"message_stop"}`,
+	} {
+		converted := conv.ProcessLine(line)
+		conv.ParseUsage(line, converted, usage)
+		out.WriteString(converted)
+	}
+
+	var content, args, finish string
+	var usageChunk gjson.Result
+	blocks := strings.Split(strings.TrimSpace(out.String()), "\n\n")
+	if blocks[len(blocks)-1] != "data: [DONE]" {
+		t.Fatalf("流末应为 [DONE]:\n%s", out.String())
+	}
+	for _, block := range blocks[:len(blocks)-1] {
+		chunk := gjson.Parse(strings.TrimPrefix(block, "data: "))
+		if chunk.Get("object").String() != "chat.completion.chunk" {
+			t.Fatalf("非法 chunk: %s", block)
+		}
+		if chunk.Get("usage").Exists() {
+			usageChunk = chunk
+			continue
+		}
+		content += chunk.Get("choices.0.delta.content").String()
+		args += chunk.Get("choices.0.delta.tool_calls.0.function.arguments").String()
+		if fr := chunk.Get("choices.0.finish_reason").String(); fr != "" {
+			finish = fr
+		}
+	}
+
+	if content != "好的" || args != `{"path":"a.go"}` || finish != "tool_calls" {
+		t.Errorf("content=%q args=%q finish=%q", content, args, finish)
+	}
+	if !strings.Contains(out.String(), `"id":"toolu_1"`) {
+		t.Errorf("tool_calls 首个增量应带 id:\n%s", out.String())
+	}
+	if usageChunk.Get("usage.prompt_tokens").Int() != 100 || usageChunk.Get("usage.prompt_tokens_details.cached_tokens").Int() != 90 {
+		t.Errorf("usage chunk 错误: %s", usageChunk.Raw)
+	}
+	if usage.InputTokens != 10 || usage.CacheReadTokens != 90 || usage.OutputTokens != 20 {
+		t.Errorf("usage = %+v", usage)
+	}
+}
+
+// TestForwardOpenAIChatRequest openai 平台:默认原样转发 Chat 请求(流式补 include_usage 并按 Chat usage 记账),
+// anthropic_messages 供应商则转换为 Messages 请求、响应转回 chat.completion。
+func TestForwardOpenAIChatRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+
+	t.Run("native", func(t *testing.T) {
+		var gotPath string
+		var gotBody []byte
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.Path
+			gotBody, _ = io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"}}]}\n\n" +
+				"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":3,\"prompt_tokens_details\":{\"cached_tokens\":2}}}\n\n" +
+				"data: [DONE]\n\n"))
+		}))
+		defer upstream.Close()
+
+		prs := newTestRelayService(NewProviderService())
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		reqBody := `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hello"}]}`
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(reqBody))
+
+		provider := Provider{Name: "openai", APIURL: upstream.URL, APIKey: "k", Enabled: true}
+		ok, err := prs.forwardRequest(c, "openai", provider, "/v1/chat/completions",
+			map[string]string{}, map[string]string{}, []byte(reqBody), true, "gpt-4o", 0)
+		if !ok {
+			t.Fatalf("转发应成功, 实际失败: %v", err)
+		}
+		if gotPath != "/v1/chat/completions" {
+			t.Errorf("上游路径 = %q", gotPath)
+		}
+		if !gjson.GetBytes(gotBody, "stream_options.include_usage").Bool() {
+			t.Errorf("流式原样转发应补 include_usage: %s", gotBody)
+		}
+		if !strings.Contains(recorder.Body.String(), `"content":"hi"`) {
+			t.Errorf("客户端应原样收到 Chat 流:\n%s", recorder.Body.String())
+		}
+	})
+
+	t.Run("anthropic_messages", func(t *testing.T) {
+		var gotPath string
+		var gotBody []byte
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.Path
+			gotBody, _ = io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":2}}`))
+		}))
+		defer upstream.Close()
+
+		prs := newTestRelayService(NewProviderService())
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		reqBody := `{"model":"claude-sonnet-4-5","messages":[{"role":"user","content":"hello"}]}`
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(reqBody))
+
+		provider := Provider{Name: "anthropic", APIURL: upstream.URL, APIKey: "k", Enabled: true, UpstreamProtocol: "anthropic_messages"}
+		ok, err := prs.forwardRequest(c, "openai", provider, "/v1/chat/completions",
+			map[string]string{}, map[string]string{}, []byte(reqBody), false, "claude-sonnet-4-5", 0)
+		if !ok {
+			t.Fatalf("转发应成功, 实际失败: %v", err)
+		}
+		if gotPath != "/v1/messages" || gjson.GetBytes(gotBody, "messages.0.content.0.text").String() != "hello" {
+			t.Errorf("上游应收到 Messages 请求: path=%q body=%s", gotPath, gotBody)
+		}
+		resp := gjson.Parse(recorder.Body.String())
+		if resp.Get("object").String() != "chat.completion" || resp.Get("choices.0.message.content").String() != "hi" ||
+			resp.Get("choices.0.finish_reason").String() != "stop" || resp.Get("usage.total_tokens").Int() != 5 {
+			t.Errorf("客户端应收到 chat.completion, 实际 %s", recorder.Body.String())
+		}
+	})
+}
+
+// TestOpenAIChatParseTokenUsage prompt_tokens 含缓存命中、completion_tokens 含推理,入库时拆开
+func TestOpenAIChatParseTokenUsage(t *testing.T) {
+	usage := &ReqeustLog{}
+	OpenAIChatParseTokenUsageFromResponse(`{"usage":{"prompt_tokens":100,"completion_tokens":30,`+
+		`"prompt_tokens_details":{"cached_tokens":60},"completion_tokens_details":{"reasoning_tokens":10}}}`, usage)
+	if usage.InputTokens != 40 || usage.CacheReadTokens != 60 || usage.OutputTokens != 20 || usage.ReasoningTokens != 10 {
+		t.Errorf("usage = input %d cache %d output %d reasoning %d, want 40/60/20/10",
+			usage.InputTokens, usage.CacheReadTokens, usage.OutputTokens, usage.ReasoningTokens)
+	}
+
+	// 流式中间 chunk 的 usage 为 null,不应清零已记录的值
+	OpenAIChatParseTokenUsageFromResponse(`{"choices":[{"delta":{"content":"x"}}],"usage":null}`, usage)
+	if usage.InputTokens != 40 {
+		t.Errorf("usage:null 不应覆盖已解析的用量")
+	}
+}
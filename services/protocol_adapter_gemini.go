@@ -0,0 +1,538 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/tidwall/gjson"
+)
+
+// ========== 请求转换：Anthropic Messages → Gemini generateContent ==========
+//
+// 供 claude 平台把 /v1/messages 请求转发到 Gemini API（或 Gemini 兼容中转）。
+// 支持范围：
+// - system → systemInstruction；user/assistant → user/model
+// - 文本、base64 图片（inlineData）、tool_use → functionCall、tool_result → functionResponse
+// - tools → functionDeclarations（JSON Schema 裁剪到 Gemini 支持的子集），tool_choice → toolConfig
+// - thinking.budget_tokens → generationConfig.thinkingConfig.thinkingBudget
+// - thinking/redacted_thinking 历史块丢弃（签名只对 Anthropic 有效）
+// - functionCall 的 thoughtSignature 编码进 tool_use id 下发，下一轮从 id 还原回传；
+//   每轮首个调用取不到签名时（别的上游产生的历史）回传 Google 文档给出的跳过校验占位值
+
+// geminiSignatureMarker tool_use id 中签名段的分隔标记，其后为 base64url 编码的 thoughtSignature
+// （id 只允许 [a-zA-Z0-9_-]，base64url 无填充恰好满足）
+const geminiSignatureMarker = "_gsig_"
+
+// geminiSkipSignature 思考模型要求历史 functionCall 带签名，签名缺失时的官方占位值
+const geminiSkipSignature = "skip_thought_signature_validator"
+
+// geminiSchemaKeys Gemini Schema 支持的字段（OpenAPI 3.0 子集），其余字段会导致 400
+var geminiSchemaKeys = map[string]bool{
+	"type": true, "format": true, "title": true, "description": true, "nullable": true,
+	"enum": true, "items": true, "minItems": true, "maxItems": true,
+	"properties": true, "required": true, "minProperties": true, "maxProperties": true,
+	"minLength": true, "maxLength": true, "pattern": true, "minimum": true, "maximum": true,
+	"anyOf": true, "propertyOrdering": true, "default": true, "example": true,
+}
+
+// ConvertAnthropicToGemini 将 Anthropic Messages 请求转换为 Gemini generateContent 请求。
+// 模型名与流式与否体现在 URL 上，不写进请求体。
+func ConvertAnthropicToGemini(body []byte) ([]byte, ConvertInfo, error) {
+	info := ConvertInfo{}
+	parsed := gjson.ParseBytes(body)
+
+	geminiReq := make(map[string]interface{})
+
+	// ========== generationConfig ==========
+
+	genConfig := make(map[string]interface{})
+	if v := parsed.Get("max_tokens"); v.Exists() {
+		genConfig["maxOutputTokens"] = v.Int()
+	}
+	if v := parsed.Get("temperature"); v.Exists() {
+		genConfig["temperature"] = v.Float()
+	}
+	if v := parsed.Get("top_p"); v.Exists() {
+		genConfig["topP"] = v.Float()
+	}
+	if v := parsed.Get("top_k"); v.Exists() {
+		genConfig["topK"] = v.Int()
+	}
+	if stopSeqs := parsed.Get("stop_sequences"); stopSeqs.IsArray() && len(stopSeqs.Array()) > 0 {
+		stops := make([]string, 0)
+		for _, s := range stopSeqs.Array() {
+			stops = append(stops, s.String())
+		}
+		genConfig["stopSequences"] = stops
+	}
+	if thinking := parsed.Get("thinking"); thinking.Get("type").String() == "enabled" && thinking.Get("budget_tokens").Exists() {
+		genConfig["thinkingConfig"] = map[string]interface{}{"thinkingBudget": thinking.Get("budget_tokens").Int()}
+	} else if thinking.Exists() {
+		info.DroppedFields = append(info.DroppedFields, "thinking")
+	}
+	if len(genConfig) > 0 {
+		geminiReq["generationConfig"] = genConfig
+	}
+
+	for _, field := range []string{"metadata", "betas", "anthropic_version"} {
+		if parsed.Get(field).Exists() {
+			info.DroppedFields = append(info.DroppedFields, field)
+		}
+	}
+
+	// ========== system ==========
+
+	if system := parsed.Get("system"); system.Exists() {
+		systemText, err := extractTextContent(system)
+		if err != nil {
+			return nil, info, err
+		}
+		if systemText != "" {
+			geminiReq["systemInstruction"] = map[string]interface{}{
+				"parts": []map[string]interface{}{{"text": systemText}},
+			}
+		}
+	}
+
+	// ========== tools / tool_choice ==========
+
+	if tools := parsed.Get("tools"); tools.IsArray() && len(tools.Array()) > 0 {
+		declarations := make([]map[string]interface{}, 0)
+		for i, tool := range tools.Array() {
+			if toolType := tool.Get("type").String(); toolType != "" && toolType != "custom" {
+				return nil, info, NewClientRequestRejectedError(
+					fmt.Sprintf("tools[%d].type='%s' 是 Anthropic 内置工具，Gemini 协议不支持", i, toolType))
+			}
+			decl := map[string]interface{}{"name": tool.Get("name").String()}
+			if desc := tool.Get("description").String(); desc != "" {
+				decl["description"] = desc
+			}
+			// 无参数工具不能给空 properties 的 object schema（Gemini 报 400），直接省略
+			if schema := tool.Get("input_schema"); schema.IsObject() && len(schema.Get("properties").Map()) > 0 {
+				decl["parameters"] = sanitizeGeminiSchema(schema)
+			}
+			declarations = append(declarations, decl)
+		}
+		geminiReq["tools"] = []map[string]interface{}{{"functionDeclarations": declarations}}
+
+		if toolChoice := parsed.Get("tool_choice"); toolChoice.Exists() {
+			config := map[string]interface{}{}
+			switch toolChoice.Get("type").String() {
+			case "any":
+				config["mode"] = "ANY"
+			case "tool":
+				config["mode"] = "ANY"
+				config["allowedFunctionNames"] = []string{toolChoice.Get("name").String()}
+			case "none":
+				config["mode"] = "NONE"
+			default:
+				config["mode"] = "AUTO"
+			}
+			geminiReq["toolConfig"] = map[string]interface{}{"functionCallingConfig": config}
+		}
+	}
+
+	// ========== messages → contents ==========
+
+	contents := make([]map[string]interface{}, 0)
+	toolNames := make(map[string]string) // tool_use id → 工具名（functionResponse 需要 name）
+	for i, msg := range parsed.Get("messages").Array() {
+		role := msg.Get("role").String()
+		geminiRole := "user"
+		switch role {
+		case "user":
+		case "assistant":
+			geminiRole = "model"
+		default:
+			return nil, info, NewClientRequestRejectedError(
+				fmt.Sprintf("messages[%d]: role='%s' 不支持，仅支持 user/assistant", i, role))
+		}
+
+		parts, err := convertAnthropicContentToGeminiParts(msg.Get("content"), toolNames)
+		if err != nil {
+			return nil, info, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		if len(parts) == 0 {
+			continue
+		}
+		// 相邻同角色合并：Gemini 要求 functionResponse 与对应 functionCall 轮次交替
+		if n := len(contents); n > 0 && contents[n-1]["role"] == geminiRole {
+			contents[n-1]["parts"] = append(contents[n-1]["parts"].([]map[string]interface{}), parts...)
+			continue
+		}
+		contents = append(contents, map[string]interface{}{"role": geminiRole, "parts": parts})
+	}
+	geminiReq["contents"] = contents
+
+	result, err := json.Marshal(geminiReq)
+	if err != nil {
+		return nil, info, fmt.Errorf("序列化 Gemini 请求失败: %w", err)
+	}
+	return result, info, nil
+}
+
+// convertAnthropicContentToGeminiParts 把一条消息的 content 转为 Gemini parts
+func convertAnthropicContentToGeminiParts(content gjson.Result, toolNames map[string]string) ([]map[string]interface{}, error) {
+	if content.Type == gjson.String {
+		if content.String() == "" {
+			return nil, nil
+		}
+		return []map[string]interface{}{{"text": content.String()}}, nil
+	}
+	if !content.IsArray() {
+		return nil, NewClientRequestRejectedError("content 格式无效，必须是 string 或 content block 数组")
+	}
+
+	parts := make([]map[string]interface{}, 0)
+	firstCall := true // Gemini 只校验一轮中首个 functionCall 的签名（并行调用只有首个带签名）
+	for i, block := range content.Array() {
+		switch blockType := block.Get("type").String(); blockType {
+		case "text":
+			if text := block.Get("text").String(); text != "" {
+				parts = append(parts, map[string]interface{}{"text": text})
+			}
+		case "thinking", "redacted_thinking":
+			continue
+		case "image":
+			part, err := convertAnthropicImageToGemini(block)
+			if err != nil {
+				return nil, fmt.Errorf("content[%d]: %w", i, err)
+			}
+			parts = append(parts, part)
+		case "tool_use":
+			name := block.Get("name").String()
+			id := block.Get("id").String()
+			toolNames[id] = name
+			args := map[string]interface{}{}
+			if input := block.Get("input"); input.IsObject() {
+				_ = json.Unmarshal([]byte(input.Raw), &args)
+			}
+			part := map[string]interface{}{
+				"functionCall": map[string]interface{}{"name": name, "args": args},
+			}
+			if signature := geminiSignatureFromToolUseID(id); signature != "" {
+				part["thoughtSignature"] = signature
+			} else if firstCall {
+				part["thoughtSignature"] = geminiSkipSignature
+			}
+			firstCall = false
+			parts = append(parts, part)
+		case "tool_result":
+			resultText, images, err := extractToolResultContent(block.Get("content"))
+			if err != nil {
+				return nil, fmt.Errorf("content[%d]: %w", i, err)
+			}
+			key := "content"
+			if block.Get("is_error").Bool() {
+				key = "error"
+			}
+			parts = append(parts, map[string]interface{}{
+				"functionResponse": map[string]interface{}{
+					"name":     toolNames[block.Get("tool_use_id").String()],
+					"response": map[string]interface{}{key: resultText},
+				},
+			})
+			// tool_result 里的图片作为普通 inlineData 跟在 functionResponse 之后
+			for _, image := range images {
+				url, _ := image["image_url"].(map[string]interface{})["url"].(string)
+				mediaType, data, ok := parseBase64DataURL(url)
+				if !ok {
+					return nil, NewClientRequestRejectedError("tool_result 中的图片仅支持 base64")
+				}
+				parts = append(parts, map[string]interface{}{
+					"inlineData": map[string]interface{}{"mimeType": mediaType, "data": data},
+				})
+			}
+		default:
+			return nil, NewClientRequestRejectedError(
+				fmt.Sprintf("content[%d].type='%s' 不支持", i, blockType))
+		}
+	}
+	return parts, nil
+}
+
+// geminiToolUseID 生成下发给客户端的 tool_use id，并把 functionCall 的 thoughtSignature 编码在尾部
+func geminiToolUseID(id, signature string) string {
+	if id == "" {
+		id = "toolu_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24]
+	}
+	if signature == "" {
+		return id
+	}
+	return id + geminiSignatureMarker + base64.RawURLEncoding.EncodeToString([]byte(signature))
+}
+
+// geminiSignatureFromToolUseID 从 tool_use id 还原 thoughtSignature；不是本转换器签发的 id 返回空
+func geminiSignatureFromToolUseID(id string) string {
+	_, encoded, ok := strings.Cut(id, geminiSignatureMarker)
+	if !ok {
+		return ""
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ""
+	}
+	return string(signature)
+}
+
+// convertAnthropicImageToGemini 把 image 块转为 inlineData；
+// Gemini 的 fileData 只接受 Files API / GCS 地址，URL 图片无法直接传递
+func convertAnthropicImageToGemini(block gjson.Result) (map[string]interface{}, error) {
+	source := block.Get("source")
+	if source.Get("type").String() != "base64" {
+		return nil, NewClientRequestRejectedError("Gemini 上游仅支持 base64 图片")
+	}
+	return map[string]interface{}{
+		"inlineData": map[string]interface{}{
+			"mimeType": source.Get("media_type").String(),
+			"data":     source.Get("data").String(),
+		},
+	}, nil
+}
+
+// parseBase64DataURL 拆分 data:<mediaType>;base64,<data>
+func parseBase64DataURL(url string) (string, string, bool) {
+	if !strings.HasPrefix(url, "data:") {
+		return "", "", false
+	}
+	meta, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	mediaType, isBase64 := strings.CutSuffix(meta, ";base64")
+	return mediaType, data, ok && isBase64 && mediaType != ""
+}
+
+// sanitizeGeminiSchema 把 JSON Schema 裁剪到 Gemini 支持的子集：
+// 去掉 $schema/additionalProperties 等未知字段，type 数组（["string","null"]）折叠为 type + nullable
+func sanitizeGeminiSchema(schema gjson.Result) map[string]interface{} {
+	result := make(map[string]interface{})
+	schema.ForEach(func(key, value gjson.Result) bool {
+		k := key.String()
+		if !geminiSchemaKeys[k] {
+			return true
+		}
+		switch k {
+		case "type":
+			if value.IsArray() {
+				for _, t := range value.Array() {
+					if t.String() == "null" {
+						result["nullable"] = true
+					} else if _, set := result["type"]; !set {
+						result["type"] = t.String()
+					}
+				}
+			} else {
+				result["type"] = value.String()
+			}
+		case "properties":
+			props := make(map[string]interface{})
+			value.ForEach(func(name, prop gjson.Result) bool {
+				props[name.String()] = sanitizeGeminiSchema(prop)
+				return true
+			})
+			result["properties"] = props
+		case "items":
+			result["items"] = sanitizeGeminiSchema(value)
+		case "anyOf":
+			variants := make([]interface{}, 0)
+			for _, v := range value.Array() {
+				variants = append(variants, sanitizeGeminiSchema(v))
+			}
+			result["anyOf"] = variants
+		default:
+			result[k] = value.Value()
+		}
+		return true
+	})
+	return result
+}
+
+// ========== 响应转换：Gemini → Anthropic ==========
+
+// geminiFinishReasonToOpenAI 把 Gemini finishReason 折算成 OpenAI finish_reason，
+// 以复用 OpenAIToAnthropicSSEConverter 的 stop_reason 映射
+func geminiFinishReasonToOpenAI(reason string) string {
+	switch reason {
+	case "":
+		return ""
+	case "STOP":
+		return "stop"
+	case "MAX_TOKENS":
+		return "length"
+	default:
+		// SAFETY / RECITATION / BLOCKLIST / PROHIBITED_CONTENT 等
+		return "content_filter"
+	}
+}
+
+// GeminiToAnthropicSSEConverter Gemini SSE（streamGenerateContent?alt=sse）到 Anthropic SSE 的转换器。
+// Anthropic 事件的 block 状态机复用 OpenAIToAnthropicSSEConverter。
+// Gemini 流没有 [DONE]：带 finishReason 的 chunk 即为最后一个，处理完该 chunk 后输出终止事件。
+type GeminiToAnthropicSSEConverter struct {
+	*OpenAIToAnthropicSSEConverter
+}
+
+// NewGeminiToAnthropicSSEConverter 创建新的 SSE 转换器
+func NewGeminiToAnthropicSSEConverter(model string) *GeminiToAnthropicSSEConverter {
+	return &GeminiToAnthropicSSEConverter{NewOpenAIToAnthropicSSEConverter(model)}
+}
+
+// ProcessLine 处理单行 Gemini SSE，返回转换后的 Anthropic SSE 事件
+func (c *GeminiToAnthropicSSEConverter) ProcessLine(line string) string {
+	if c.stopped {
+		return ""
+	}
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "data:") {
+		return ""
+	}
+	chunk := gjson.Parse(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+
+	c.captureGeminiUsage(chunk.Get("usageMetadata"))
+
+	var output strings.Builder
+	output.WriteString(c.ensureMessageStart())
+
+	candidate := chunk.Get("candidates.0")
+	for _, part := range candidate.Get("content.parts").Array() {
+		switch {
+		case part.Get("thought").Bool():
+			// 思考摘要没有 Anthropic 签名，输出后客户端回传会被其他上游拒绝，丢弃
+			continue
+		case part.Get("functionCall").Exists():
+			args := part.Get("functionCall.args").Raw
+			if args == "" {
+				args = "{}"
+			}
+			id := geminiToolUseID(part.Get("functionCall.id").String(), part.Get("thoughtSignature").String())
+			output.WriteString(c.outputToolUseStart(id, part.Get("functionCall.name").String()))
+			output.WriteString(c.outputToolInputDelta(args))
+		case part.Get("text").String() != "":
+			output.WriteString(c.outputContentDelta(part.Get("text").String()))
+		}
+	}
+
+	if fr := candidate.Get("finishReason").String(); fr != "" {
+		c.finishReason = geminiFinishReasonToOpenAI(fr)
+		output.WriteString(c.outputStopEvents())
+	}
+	return output.String()
+}
+
+// ConvertResponse 转换非流式响应
+func (c *GeminiToAnthropicSSEConverter) ConvertResponse(body []byte) ([]byte, error) {
+	return ConvertGeminiToAnthropicResponse(body, c.model)
+}
+
+// ParseUsage 按上游原始 usageMetadata 计费（保留 thoughtsTokenCount 作为推理 token）
+func (c *GeminiToAnthropicSSEConverter) ParseUsage(upstream, _ string, usage *ReqeustLog) {
+	if upstream != "" {
+		parseEventPayload(upstream, GeminiParseTokenUsageFromResponse, usage)
+	}
+}
+
+// captureGeminiUsage 记录 message_delta 要回报给客户端的 usage（累计值，直接覆盖）。
+// Anthropic 的 output_tokens 含思考 token，这里把 thoughtsTokenCount 并入输出。
+func (c *GeminiToAnthropicSSEConverter) captureGeminiUsage(usage gjson.Result) {
+	if !usage.Exists() {
+		return
+	}
+	prompt := usage.Get("promptTokenCount").Int()
+	cached := usage.Get("cachedContentTokenCount").Int()
+	if cached > prompt {
+		cached = prompt
+	}
+	c.inputTokens = prompt - cached
+	c.cacheReadTokens = cached
+	c.outputTokens = usage.Get("candidatesTokenCount").Int() + usage.Get("thoughtsTokenCount").Int()
+	c.usageCaptured = true
+}
+
+// ConvertGeminiToAnthropicResponse 将 generateContent 非流式响应转换为 Anthropic Message
+func ConvertGeminiToAnthropicResponse(body []byte, model string) ([]byte, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, fmt.Errorf("上游响应不是合法 JSON")
+	}
+	parsed := gjson.ParseBytes(body)
+	candidate := parsed.Get("candidates.0")
+	if !candidate.Exists() {
+		// promptFeedback.blockReason 时没有 candidates
+		if reason := parsed.Get("promptFeedback.blockReason").String(); reason != "" {
+			return nil, fmt.Errorf("上游拒绝了请求: %s", reason)
+		}
+		return nil, fmt.Errorf("上游响应缺少 candidates")
+	}
+
+	content := make([]map[string]interface{}, 0)
+	sawToolUse := false
+	for _, part := range candidate.Get("content.parts").Array() {
+		switch {
+		case part.Get("thought").Bool():
+			continue
+		case part.Get("functionCall").Exists():
+			var input interface{} = map[string]interface{}{}
+			if args := part.Get("functionCall.args"); args.IsObject() {
+				input = json.RawMessage(args.Raw)
+			}
+			content = append(content, map[string]interface{}{
+				"type":  "tool_use",
+				"id":    geminiToolUseID(part.Get("functionCall.id").String(), part.Get("thoughtSignature").String()),
+				"name":  part.Get("functionCall.name").String(),
+				"input": input,
+			})
+			sawToolUse = true
+		case part.Get("text").String() != "":
+			content = append(content, map[string]interface{}{"type": "text", "text": part.Get("text").String()})
+		}
+	}
+
+	finishReason := geminiFinishReasonToOpenAI(candidate.Get("finishReason").String())
+	stopReason := mapOpenAIFinishReason(finishReason)
+	if sawToolUse && (finishReason == "" || finishReason == "stop") {
+		stopReason = "tool_use"
+	}
+
+	usage := parsed.Get("usageMetadata")
+	prompt := usage.Get("promptTokenCount").Int()
+	cached := usage.Get("cachedContentTokenCount").Int()
+	if cached > prompt {
+		cached = prompt
+	}
+
+	message := map[string]interface{}{
+		"id":            GenerateAnthropicMessageID(),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         model,
+		"content":       content,
+		"stop_reason":   stopReason,
+		"stop_sequence": nil,
+		"usage": map[string]interface{}{
+			"input_tokens":            prompt - cached,
+			"output_tokens":           usage.Get("candidatesTokenCount").Int() + usage.Get("thoughtsTokenCount").Int(),
+			"cache_read_input_tokens": cached,
+		},
+	}
+	result, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("序列化 Anthropic 响应失败: %w", err)
+	}
+	return result, nil
+}
+
+// geminiUpstreamEndpoint 计算 Gemini 上游端点。
+// 未配置 APIEndpoint 时用 /v1beta/models/{model}:generateContent；配置了则支持 {model} 占位。
+// 流式与否由方法名决定，这里按 isStream 统一改写。
+func geminiUpstreamEndpoint(configured, model string, isStream bool) string {
+	endpoint := strings.TrimSpace(configured)
+	if endpoint == "" {
+		endpoint = "/v1beta/models/{model}:generateContent"
+	}
+	endpoint = strings.ReplaceAll(endpoint, "{model}", model)
+	endpoint = strings.Replace(endpoint, ":streamGenerateContent", ":generateContent", 1)
+	if isStream {
+		endpoint = strings.Replace(endpoint, ":generateContent", ":streamGenerateContent", 1)
+	}
+	return endpoint
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// TestConvertAnthropicToGeminiRequest Messages 请求转 generateContent:system、工具 schema 裁剪、
+// tool_use/tool_result 与 functionCall/functionResponse 的对应(functionResponse 需带工具名)。
+func TestConvertAnthropicToGeminiRequest(t *testing.T) {
+	body := []byte(`{
+		"model":"gemini-2.5-pro","max_tokens":1024,"stream":true,"system":"你是助手",
+		"tools":[{"name":"read_file","description":"读文件","input_schema":{
+			"$schema":"http://json-schema.org/draft-07/schema#","type":"object","additionalProperties":false,
+			"properties":{"path":{"type":["string","null"]}},"required":["path"]}}],
+		"tool_choice":{"type":"tool","name":"read_file"},
+		"messages":[
+			{"role":"user","content":[{"type":"text","text":"读 a.go"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"AAA="}}]},
+			{"role":"assistant","content":[{"type":"tool_use","id":"toolu_1","name":"read_file","input":{"path":"a.go"}}]},
+			{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":"package main"}]}
+		]
+	}`)
+
+	out, _, err := ConvertAnthropicToGemini(body)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	req := gjson.ParseBytes(out)
+
+	if got := req.Get("systemInstruction.parts.0.text").String(); got != "你是助手" {
+		t.Errorf("systemInstruction = %q", got)
+	}
+	if got := req.Get("generationConfig.maxOutputTokens").Int(); got != 1024 {
+		t.Errorf("maxOutputTokens = %d", got)
+	}
+	params := req.Get("tools.0.functionDeclarations.0.parameters")
+	if params.Get("$schema").Exists() || params.Get("additionalProperties").Exists() {
+		t.Errorf("Gemini 不支持的 schema 字段应被裁剪: %s", params.Raw)
+	}
+	if params.Get("properties.path.type").String() != "string" || !params.Get("properties.path.nullable").Bool() {
+		t.Errorf("type 数组应折叠为 type+nullable: %s", params.Get("properties.path").Raw)
+	}
+	if req.Get("toolConfig.functionCallingConfig.mode").String() != "ANY" ||
+		req.Get("toolConfig.functionCallingConfig.allowedFunctionNames.0").String() != "read_file" {
+		t.Errorf("tool_choice 映射错误: %s", req.Get("toolConfig").Raw)
+	}
+
+	contents := req.Get("contents").Array()
+	if len(contents) != 3 {
+		t.Fatalf("contents 数量 = %d: %s", len(contents), req.Get("contents").Raw)
+	}
+	if contents[0].Get("parts.1.inlineData.mimeType").String() != "image/png" {
+		t.Errorf("图片应转为 inlineData: %s", contents[0].Raw)
+	}
+	if contents[1].Get("role").String() != "model" || contents[1].Get("parts.0.functionCall.args.path").String() != "a.go" {
+		t.Errorf("tool_use 转换错误: %s", contents[1].Raw)
+	}
+	if contents[2].Get("parts.0.functionResponse.name").String() != "read_file" ||
+		contents[2].Get("parts.0.functionResponse.response.content").String() != "package main" {
+		t.Errorf("tool_result 转换错误: %s", contents[2].Raw)
+	}
+}
+
+// TestGeminiToAnthropicSSEConverter Gemini 流没有 [DONE],带 finishReason 的 chunk 处理完即输出终止事件;
+// functionCall 一次给出完整参数,转成一个完整的 tool_use block。
+func TestGeminiToAnthropicSSEConverter(t *testing.T) {
+	conv := NewGeminiToAnthropicSSEConverter("gemini-2.5-pro")
+	usage := &ReqeustLog{}
+
+	var out strings.Builder
+	for _, line := range []string{
+		`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"思考中","thought":true},{"text":"我来读"}]}}],"usageMetadata":{"promptTokenCount":50}}`,
+		`data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"read_file","args":{"path":"a.go"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":50,"cachedContentTokenCount":20,"candidatesTokenCount":8,"thoughtsTokenCount":4,"totalTokenCount":62}}`,
+	} {
+		converted := conv.ProcessLine(line)
+		conv.ParseUsage(line, converted, usage)
+		out.WriteString(converted)
+	}
+	text := out.String()
+
+	if strings.Contains(text, "思考中") {
+		t.Errorf("thought 片段不应输出给客户端:\n%s", text)
+	}
+	for _, want := range []string{`"text":"我来读"`, `"type":"tool_use"`, `"name":"read_file"`, `"partial_json":"{\"path\":\"a.go\"}"`, `"stop_reason":"tool_use"`, "event: message_stop"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("输出缺少 %s\n%s", want, text)
+		}
+	}
+	if tail := conv.FinalizeIfUnterminated(); tail != "" {
+		t.Errorf("已收到 finishReason,不应再补终止事件:\n%s", tail)
+	}
+
+	// 计费走 mergeGeminiUsageMetadata:推理 token 单独入库
+	if usage.InputTokens != 30 || usage.CacheReadTokens != 20 || usage.OutputTokens != 8 || usage.ReasoningTokens != 4 {
+		t.Errorf("usage = input %d cache %d output %d reasoning %d, want 30/20/8/4",
+			usage.InputTokens, usage.CacheReadTokens, usage.OutputTokens, usage.ReasoningTokens)
+	}
+}
+
+// TestGeminiThoughtSignatureRoundTrip functionCall 的 thoughtSignature 经 tool_use id 带回下一轮；
+// 取不到签名的历史调用只给每轮首个调用补占位值；thinking.budget_tokens 映射为 thinkingBudget
+func TestGeminiThoughtSignatureRoundTrip(t *testing.T) {
+	conv := NewGeminiToAnthropicSSEConverter("gemini-3-pro")
+	streamed := conv.ProcessLine(`data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"read_file","args":{}},"thoughtSignature":"sig/stream+1=="}]},"finishReason":"STOP"}]}`)
+	streamID := ""
+	for _, line := range strings.Split(streamed, "\n") {
+		if id := gjson.Get(strings.TrimPrefix(line, "data: "), "content_block.id").String(); id != "" {
+			streamID = id
+		}
+	}
+	if geminiSignatureFromToolUseID(streamID) != "sig/stream+1==" {
+		t.Fatalf("流式 tool_use id 应携带签名: %q", streamID)
+	}
+
+	resp, err := ConvertGeminiToAnthropicResponse([]byte(`{"candidates":[{"content":{"parts":[
+		{"functionCall":{"name":"a","args":{}},"thoughtSignature":"sig-a"},
+		{"functionCall":{"name":"b","args":{}}}]},"finishReason":"STOP"}]}`), "gemini-3-pro")
+	if err != nil {
+		t.Fatalf("非流式转换失败: %v", err)
+	}
+	idA, idB := gjson.GetBytes(resp, "content.0.id").String(), gjson.GetBytes(resp, "content.1.id").String()
+	if geminiSignatureFromToolUseID(idA) != "sig-a" || geminiSignatureFromToolUseID(idB) != "" {
+		t.Fatalf("非流式 tool_use id 签名不符: %q %q", idA, idB)
+	}
+
+	body := `{"model":"gemini-3-pro","max_tokens":2048,"thinking":{"type":"enabled","budget_tokens":1024},"messages":[
+		{"role":"user","content":"读"},
+		{"role":"assistant","content":[{"type":"tool_use","id":"` + idA + `","name":"a","input":{}},{"type":"tool_use","id":"` + idB + `","name":"b","input":{}}]},
+		{"role":"user","content":[{"type":"tool_result","tool_use_id":"` + idA + `","content":"1"},{"type":"tool_result","tool_use_id":"` + idB + `","content":"2"}]},
+		{"role":"assistant","content":[{"type":"tool_use","id":"toolu_foreign","name":"a","input":{}},{"type":"tool_use","id":"toolu_foreign2","name":"b","input":{}}]},
+		{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_foreign","content":"3"},{"type":"tool_result","tool_use_id":"toolu_foreign2","content":"4"}]}
+	]}`
+	out, info, err := ConvertAnthropicToGemini([]byte(body))
+	if err != nil {
+		t.Fatalf("请求转换失败: %v", err)
+	}
+	req := gjson.ParseBytes(out)
+	if got := req.Get("generationConfig.thinkingConfig.thinkingBudget").Int(); got != 1024 {
+		t.Errorf("thinkingBudget = %d", got)
+	}
+	for _, f := range info.DroppedFields {
+		if f == "thinking" {
+			t.Error("已映射的 thinking 不应记为丢弃字段")
+		}
+	}
+	if got := req.Get("contents.1.parts.0.thoughtSignature").String(); got != "sig-a" {
+		t.Errorf("应还原签名: %q", got)
+	}
+	if req.Get("contents.1.parts.1.thoughtSignature").Exists() {
+		t.Errorf("并行调用的后续 functionCall 不应补签名: %s", req.Get("contents.1.parts.1").Raw)
+	}
+	if got := req.Get("contents.3.parts.0.thoughtSignature").String(); got != geminiSkipSignature {
+		t.Errorf("无签名的首个调用应补占位值: %q", got)
+	}
+	if req.Get("contents.3.parts.1.thoughtSignature").Exists() {
+		t.Errorf("占位值只补首个调用: %s", req.Get("contents.3.parts.1").Raw)
+	}
+	if got := req.Get("contents.2.parts.0.functionResponse.name").String(); got != "a" {
+		t.Errorf("带签名的 id 仍应对上工具名: %q", got)
+	}
+}
+
+// TestForwardClaudeRequestToGeminiUpstream 端到端:claude 供应商配置为 gemini 时,
+// 流式请求打到 :streamGenerateContent?alt=sse,凭据走 x-goog-api-key。
+func TestForwardClaudeRequestToGeminiUpstream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+
+	var gotPath, gotAlt, gotKey, gotAuth string
+	var gotBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAlt = r.URL.Query().Get("alt")
+		gotKey = r.Header.Get("X-Goog-Api-Key")
+		gotAuth = r.Header.Get("Authorization")
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"hi\"}]},\"finishReason\":\"STOP\"}]}\n\n"))
+	}))
+	defer upstream.Close()
+
+	prs := newTestRelayService(NewProviderService())
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	reqBody := `{"model":"gemini-2.5-flash","stream":true,"max_tokens":10,"messages":[{"role":"user","content":"hello"}]}`
+	c.Request = httptest.NewRequest("POST", "/v1/messages", strings.NewReader(reqBody))
+
+	provider := Provider{
+		Name:             "gemini",
+		APIURL:           upstream.URL,
+		APIKey:           "g-key",
+		Enabled:          true,
+		UpstreamProtocol: "gemini",
+	}
+	ok, err := prs.forwardRequest(c, "claude", provider, "/v1/messages",
+		map[string]string{}, map[string]string{"Authorization": "Bearer client"}, []byte(reqBody), true, "gemini-2.5-flash", 0)
+	if !ok {
+		t.Fatalf("转发应成功, 实际失败: %v", err)
+	}
+
+	if gotPath != "/v1beta/models/gemini-2.5-flash:streamGenerateContent" || gotAlt != "sse" {
+		t.Errorf("上游地址错误: path=%q alt=%q", gotPath, gotAlt)
+	}
+	if gotKey != "g-key" || gotAuth != "" {
+		t.Errorf("凭据应只走 x-goog-api-key: key=%q auth=%q", gotKey, gotAuth)
+	}
+	if gjson.GetBytes(gotBody, "contents.0.parts.0.text").String() != "hello" {
+		t.Errorf("上游应收到 generateContent 请求: %s", gotBody)
+	}
+	if !strings.Contains(recorder.Body.String(), "event: message_stop") || !strings.Contains(recorder.Body.String(), `"text":"hi"`) {
+		t.Errorf("客户端应收到 Anthropic SSE:\n%s", recorder.Body.String())
+	}
+}
+
+// TestGeminiUpstreamEndpoint 流式与否按请求改写方法名,配置的 {model} 占位替换为映射后的模型。
+func TestGeminiUpstreamEndpoint(t *testing.T) {
+	cases := []struct {
+		configured string
+		stream     bool
+		want       string
+	}{
+		{"", false, "/v1beta/models/m:generateContent"},
+		{"", true, "/v1beta/models/m:streamGenerateContent"},
+		{"/v1/models/{model}:streamGenerateContent", false, "/v1/models/m:generateContent"},
+	}
+	for _, tc := range cases {
+		if got := geminiUpstreamEndpoint(tc.configured, "m", tc.stream); got != tc.want {
+			t.Errorf("geminiUpstreamEndpoint(%q, stream=%v) = %q, want %q", tc.configured, tc.stream, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,789 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tidwall/gjson"
+)
+
+// ========== 请求转换：OpenAI Responses → Anthropic Messages ==========
+//
+// 供 codex 平台把 /responses 请求转发到 Anthropic 兼容上游（/v1/messages）。
+// 支持范围：
+// - instructions 与 system/developer 消息合并为 system
+// - message 的文本/图片内容，function_call / function_call_output，
+//   custom_tool_call / custom_tool_call_output（Codex 的 apply_patch 等自由格式工具）
+// - reasoning 与 web_search_call 历史项在 Anthropic 协议下没有对应物，丢弃
+// - function / custom 工具；其余内置工具（web_search、local_shell 等）丢弃并记录
+
+// defaultResponsesMaxTokens Responses 请求未指定 max_output_tokens 时的 max_tokens。
+// Anthropic 要求必填；Codex CLI 通常不传，取当前主流模型都支持的输出上限。
+const defaultResponsesMaxTokens = 32000
+
+// ConvertResponsesToAnthropic 将 OpenAI Responses 请求转换为 Anthropic Messages 请求
+func ConvertResponsesToAnthropic(body []byte) ([]byte, ConvertInfo, error) {
+	info := ConvertInfo{CustomTools: make(map[string]bool)}
+	parsed := gjson.ParseBytes(body)
+
+	anthropicReq := make(map[string]interface{})
+
+	// model（直接使用，已经过 ModelMapping 处理）
+	if model := parsed.Get("model").String(); model != "" {
+		anthropicReq["model"] = model
+	}
+
+	// max_output_tokens → max_tokens
+	if maxTokens := parsed.Get("max_output_tokens"); maxTokens.Exists() && maxTokens.Int() > 0 {
+		anthropicReq["max_tokens"] = maxTokens.Int()
+	} else {
+		anthropicReq["max_tokens"] = defaultResponsesMaxTokens
+	}
+
+	anthropicReq["stream"] = parsed.Get("stream").Bool()
+
+	if temp := parsed.Get("temperature"); temp.Exists() {
+		anthropicReq["temperature"] = temp.Float()
+	}
+	if topP := parsed.Get("top_p"); topP.Exists() {
+		anthropicReq["top_p"] = topP.Float()
+	}
+
+	// user → metadata.user_id
+	if user := parsed.Get("user").String(); user != "" {
+		anthropicReq["metadata"] = map[string]interface{}{"user_id": user}
+		info.MappedUser = user
+	}
+
+	// 记录被丢弃的顶层字段
+	for _, field := range []string{"reasoning", "store", "include", "text", "prompt_cache_key", "previous_response_id", "service_tier"} {
+		if parsed.Get(field).Exists() {
+			info.DroppedFields = append(info.DroppedFields, field)
+		}
+	}
+	// previous_response_id 依赖 OpenAI 服务端保存的上下文，无法在 Anthropic 上游还原
+	if parsed.Get("previous_response_id").String() != "" {
+		return nil, info, NewClientRequestRejectedError("previous_response_id 依赖 OpenAI 服务端会话状态，Anthropic 上游不支持")
+	}
+
+	// ========== 转换 tools / tool_choice ==========
+
+	if tools := parsed.Get("tools"); tools.Exists() && tools.IsArray() {
+		anthropicTools := make([]map[string]interface{}, 0)
+		for i, tool := range tools.Array() {
+			converted, custom, err := convertResponsesTool(tool)
+			if err != nil {
+				return nil, info, fmt.Errorf("tools[%d]: %w", i, err)
+			}
+			if converted == nil {
+				info.DroppedFields = append(info.DroppedFields, "tools:"+tool.Get("type").String())
+				continue
+			}
+			if custom {
+				info.CustomTools[tool.Get("name").String()] = true
+			}
+			anthropicTools = append(anthropicTools, converted)
+		}
+		if len(anthropicTools) > 0 {
+			anthropicReq["tools"] = anthropicTools
+		}
+	}
+
+	if anthropicReq["tools"] != nil {
+		toolChoice := convertResponsesToolChoice(parsed.Get("tool_choice"))
+		if pc := parsed.Get("parallel_tool_calls"); pc.Exists() && !pc.Bool() {
+			if toolChoice == nil {
+				toolChoice = map[string]interface{}{"type": "auto"}
+			}
+			if toolChoice["type"] != "none" {
+				toolChoice["disable_parallel_tool_use"] = true
+			}
+		}
+		if toolChoice != nil {
+			anthropicReq["tool_choice"] = toolChoice
+		}
+	}
+
+	// ========== 转换 instructions / input ==========
+
+	var systemTexts []string
+	if instructions := parsed.Get("instructions").String(); instructions != "" {
+		systemTexts = append(systemTexts, instructions)
+	}
+
+	builder := &anthropicMessageBuilder{}
+	input := parsed.Get("input")
+	switch {
+	case input.Type == gjson.String:
+		builder.add("user", map[string]interface{}{"type": "text", "text": input.String()})
+	case input.IsArray():
+		for i, item := range input.Array() {
+			texts, err := convertResponsesInputItem(item, builder)
+			if err != nil {
+				return nil, info, fmt.Errorf("input[%d]: %w", i, err)
+			}
+			systemTexts = append(systemTexts, texts...)
+		}
+	case input.Exists():
+		return nil, info, NewClientRequestRejectedError("input 格式无效，必须是 string 或数组")
+	}
+
+	if len(systemTexts) > 0 {
+		anthropicReq["system"] = strings.Join(systemTexts, "\n\n")
+	}
+	anthropicReq["messages"] = builder.messages
+
+	result, err := json.Marshal(anthropicReq)
+	if err != nil {
+		return nil, info, fmt.Errorf("序列化 Anthropic 请求失败: %w", err)
+	}
+	return result, info, nil
+}
+
+// anthropicMessageBuilder 按顺序拼装 Anthropic messages：
+// 相邻同角色的内容合并进同一条消息，保证连续的 function_call 落在同一条
+// assistant 消息里、其 tool_result 紧随其后的 user 消息里。
+type anthropicMessageBuilder struct {
+	messages []map[string]interface{}
+}
+
+func (b *anthropicMessageBuilder) add(role string, blocks ...map[string]interface{}) {
+	if len(blocks) == 0 {
+		return
+	}
+	if n := len(b.messages); n > 0 && b.messages[n-1]["role"] == role {
+		last := b.messages[n-1]
+		last["content"] = append(last["content"].([]map[string]interface{}), blocks...)
+		return
+	}
+	b.messages = append(b.messages, map[string]interface{}{
+		"role":    role,
+		"content": blocks,
+	})
+}
+
+// convertResponsesInputItem 转换单个 input 项写入 builder；
+// system/developer 消息的文本通过返回值交给调用方合并进 system。
+func convertResponsesInputItem(item gjson.Result, builder *anthropicMessageBuilder) ([]string, error) {
+	itemType := item.Get("type").String()
+	if itemType == "" && item.Get("role").Exists() {
+		itemType = "message" // EasyInputMessage 允许省略 type
+	}
+
+	switch itemType {
+	case "message":
+		role := item.Get("role").String()
+		blocks, err := convertResponsesContent(item.Get("content"))
+		if err != nil {
+			return nil, err
+		}
+		switch role {
+		case "system", "developer":
+			var texts []string
+			for _, block := range blocks {
+				if block["type"] == "text" {
+					texts = append(texts, block["text"].(string))
+				}
+			}
+			return texts, nil
+		case "user", "assistant":
+			builder.add(role, blocks...)
+			return nil, nil
+		default:
+			return nil, NewClientRequestRejectedError(fmt.Sprintf("role='%s' 不支持", role))
+		}
+
+	case "function_call":
+		builder.add("assistant", map[string]interface{}{
+			"type":  "tool_use",
+			"id":    item.Get("call_id").String(),
+			"name":  item.Get("name").String(),
+			"input": rawJSONObject(item.Get("arguments").String()),
+		})
+		return nil, nil
+
+	case "custom_tool_call":
+		builder.add("assistant", map[string]interface{}{
+			"type":  "tool_use",
+			"id":    item.Get("call_id").String(),
+			"name":  item.Get("name").String(),
+			"input": map[string]interface{}{"input": item.Get("input").String()},
+		})
+		return nil, nil
+
+	case "function_call_output", "custom_tool_call_output":
+		result := map[string]interface{}{
+			"type":        "tool_result",
+			"tool_use_id": item.Get("call_id").String(),
+		}
+		output := item.Get("output")
+		if output.IsArray() {
+			blocks, err := convertResponsesContent(output)
+			if err != nil {
+				return nil, err
+			}
+			result["content"] = blocks
+		} else {
+			result["content"] = output.String()
+		}
+		builder.add("user", result)
+		return nil, nil
+
+	case "reasoning", "web_search_call":
+		// 推理项带的是 OpenAI 加密内容，web_search_call 是自包含的内置工具记录，
+		// 二者在 Anthropic 上游都无意义，丢弃不影响 tool_use/tool_result 配对
+		return nil, nil
+
+	default:
+		return nil, NewClientRequestRejectedError(fmt.Sprintf("input 项 type='%s' 不支持", itemType))
+	}
+}
+
+// convertResponsesContent 转换 message content（string 或内容片段数组）为 Anthropic content block
+func convertResponsesContent(content gjson.Result) ([]map[string]interface{}, error) {
+	if !content.Exists() {
+		return nil, nil
+	}
+	if content.Type == gjson.String {
+		if content.String() == "" {
+			return nil, nil
+		}
+		return []map[string]interface{}{{"type": "text", "text": content.String()}}, nil
+	}
+	if !content.IsArray() {
+		return nil, NewClientRequestRejectedError("content 格式无效，必须是 string 或数组")
+	}
+
+	blocks := make([]map[string]interface{}, 0)
+	for i, part := range content.Array() {
+		switch partType := part.Get("type").String(); partType {
+		case "input_text", "output_text", "text":
+			if text := part.Get("text").String(); text != "" {
+				blocks = append(blocks, map[string]interface{}{"type": "text", "text": text})
+			}
+		case "refusal":
+			blocks = append(blocks, map[string]interface{}{"type": "text", "text": part.Get("refusal").String()})
+		case "input_image":
+			block, err := convertImageURLToAnthropic(part.Get("image_url").String(), "input_image")
+			if err != nil {
+				return nil, fmt.Errorf("content[%d]: %w", i, err)
+			}
+			blocks = append(blocks, block)
+		default:
+			return nil, NewClientRequestRejectedError(fmt.Sprintf("content[%d].type='%s' 不支持", i, partType))
+		}
+	}
+	return blocks, nil
+}
+
+// convertImageURLToAnthropic 把图片地址（data URL 或 http URL）转为 Anthropic image block，
+// partType 为客户端协议里的片段类型，仅用于错误信息。Responses 与 Chat 请求转换共用
+func convertImageURLToAnthropic(imageURL, partType string) (map[string]interface{}, error) {
+	if imageURL == "" {
+		return nil, NewClientRequestRejectedError(partType + " 缺少 image_url（不支持 file_id）")
+	}
+	if strings.HasPrefix(imageURL, "data:") {
+		meta, data, ok := strings.Cut(strings.TrimPrefix(imageURL, "data:"), ",")
+		mediaType, isBase64 := strings.CutSuffix(meta, ";base64")
+		if !ok || !isBase64 || mediaType == "" {
+			return nil, NewClientRequestRejectedError(partType + " 的 data URL 必须是 base64 编码")
+		}
+		return map[string]interface{}{
+			"type": "image",
+			"source": map[string]interface{}{
+				"type":       "base64",
+				"media_type": mediaType,
+				"data":       data,
+			},
+		}, nil
+	}
+	return map[string]interface{}{
+		"type":   "image",
+		"source": map[string]interface{}{"type": "url", "url": imageURL},
+	}, nil
+}
+
+// convertResponsesTool 转换单个工具定义。返回 nil 表示该工具在 Anthropic 上游
+// 没有对应实现、应丢弃；第二个返回值标记 custom 工具。
+// custom 工具（自由文本输入）包装成只有一个 input 字符串参数的普通工具，
+// 响应侧再按工具名还原为 custom_tool_call。
+func convertResponsesTool(tool gjson.Result) (map[string]interface{}, bool, error) {
+	name := tool.Get("name").String()
+	switch tool.Get("type").String() {
+	case "function":
+		if name == "" {
+			return nil, false, NewClientRequestRejectedError("function 工具缺少 name")
+		}
+		converted := map[string]interface{}{"name": name}
+		if desc := tool.Get("description").String(); desc != "" {
+			converted["description"] = desc
+		}
+		if params := tool.Get("parameters"); params.Exists() && params.IsObject() {
+			converted["input_schema"] = json.RawMessage(params.Raw)
+		} else {
+			converted["input_schema"] = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		return converted, false, nil
+
+	case "custom":
+		if name == "" {
+			return nil, false, NewClientRequestRejectedError("custom 工具缺少 name")
+		}
+		desc := tool.Get("description").String()
+		// 语法约束无法传给 Anthropic，把定义附在描述里让模型自行遵守
+		if definition := tool.Get("format.definition").String(); definition != "" {
+			desc += "\n\n`input` 必须符合以下语法（" + tool.Get("format.syntax").String() + "）：\n" + definition
+		}
+		return map[string]interface{}{
+			"name":        name,
+			"description": desc,
+			"input_schema": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"input": map[string]interface{}{"type": "string"},
+				},
+				"required": []string{"input"},
+			},
+		}, true, nil
+
+	default:
+		return nil, false, nil
+	}
+}
+
+// convertResponsesToolChoice 映射 tool_choice：
+// "auto" → auto，"required" → any，"none" → none，{type:function|custom,name} → tool
+func convertResponsesToolChoice(toolChoice gjson.Result) map[string]interface{} {
+	if !toolChoice.Exists() {
+		return nil
+	}
+	if toolChoice.Type == gjson.String {
+		switch toolChoice.String() {
+		case "required":
+			return map[string]interface{}{"type": "any"}
+		case "none":
+			return map[string]interface{}{"type": "none"}
+		default:
+			return map[string]interface{}{"type": "auto"}
+		}
+	}
+	if name := toolChoice.Get("name").String(); name != "" {
+		return map[string]interface{}{"type": "tool", "name": name}
+	}
+	return map[string]interface{}{"type": "auto"}
+}
+
+// rawJSONObject 把 JSON 字符串参数作为原始 JSON 嵌入；非法或非对象时退化为空对象
+func rawJSONObject(s string) interface{} {
+	if s != "" && gjson.Valid(s) && gjson.Parse(s).IsObject() {
+		return json.RawMessage(s)
+	}
+	return map[string]interface{}{}
+}
+
+// ========== 响应转换：Anthropic Messages → OpenAI Responses ==========
+
+// responsesBlockState 一个 Anthropic content block 对应的 Responses 输出项状态
+type responsesBlockState struct {
+	kind        string // message / function_call / custom_tool_call，空表示忽略（thinking 等）
+	itemID      string
+	outputIndex int
+	callID      string
+	name        string
+	buf         strings.Builder // 文本或工具参数 JSON 的累积
+}
+
+// AnthropicToResponsesSSEConverter Anthropic SSE 到 OpenAI Responses SSE 的转换器
+// 设计为支持逐行输入（适配 xrequest 的 hook 行为）
+type AnthropicToResponsesSSEConverter struct {
+	responseID  string
+	model       string
+	createdAt   int64
+	customTools map[string]bool // 需还原为 custom_tool_call 的工具名
+	seq         int             // sequence_number
+	started     bool            // 是否已输出 response.created
+	stopped     bool            // 是否已输出终止事件
+	stopReason  string
+	blocks      map[int]*responsesBlockState // Anthropic block index → 状态
+	output      []map[string]interface{}     // 已完成的输出项（response.completed 用）
+	nextOutput  int
+
+	inputTokens       int64
+	outputTokens      int64
+	cacheReadTokens   int64
+	cacheCreateTokens int64
+}
+
+// NewAnthropicToResponsesSSEConverter 创建新的 SSE 转换器
+func NewAnthropicToResponsesSSEConverter(model string, customTools map[string]bool) *AnthropicToResponsesSSEConverter {
+	return &AnthropicToResponsesSSEConverter{
+		responseID:  "resp_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		model:       model,
+		createdAt:   time.Now().Unix(),
+		customTools: customTools,
+		blocks:      make(map[int]*responsesBlockState),
+	}
+}
+
+// ProcessLine 处理单行 Anthropic SSE，返回转换后的 Responses SSE 事件
+func (c *AnthropicToResponsesSSEConverter) ProcessLine(line string) string {
+	if c.stopped {
+		return ""
+	}
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "data:") {
+		return "" // event: 行与空行由 data 中的 type 字段代替
+	}
+	data := gjson.Parse(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+
+	var output strings.Builder
+	switch data.Get("type").String() {
+	case "message_start":
+		c.captureUsage(data.Get("message.usage"))
+		output.WriteString(c.ensureStarted())
+
+	case "content_block_start":
+		output.WriteString(c.ensureStarted())
+		output.WriteString(c.openBlock(int(data.Get("index").Int()), data.Get("content_block")))
+
+	case "content_block_delta":
+		output.WriteString(c.blockDelta(int(data.Get("index").Int()), data.Get("delta")))
+
+	case "content_block_stop":
+		output.WriteString(c.closeBlock(int(data.Get("index").Int())))
+
+	case "message_delta":
+		if sr := data.Get("delta.stop_reason").String(); sr != "" {
+			c.stopReason = sr
+		}
+		c.captureUsage(data.Get("usage"))
+
+	case "message_stop":
+		output.WriteString(c.ensureStarted())
+		output.WriteString(c.outputCompleted())
+
+	case "error":
+		output.WriteString(c.ensureStarted())
+		output.WriteString(c.outputFailed(data.Get("error.type").String(), data.Get("error.message").String()))
+	}
+	return output.String()
+}
+
+// FinalizeIfUnterminated 上游未发 message_stop 就断流时输出 response.failed，
+// 让 Codex 按失败重试本轮，而不是把半截输出当成完整回答
+func (c *AnthropicToResponsesSSEConverter) FinalizeIfUnterminated() string {
+	if c.stopped {
+		return ""
+	}
+	return c.ensureStarted() + c.outputFailed("server_error", "upstream stream ended before message_stop")
+}
+
+// ConvertResponse 转换非流式响应
+func (c *AnthropicToResponsesSSEConverter) ConvertResponse(body []byte) ([]byte, error) {
+	return ConvertAnthropicToResponsesResponse(body, c.model, c.customTools)
+}
+
+// ParseUsage 按上游原始 Anthropic 数据计费：Responses 的 usage 没有缓存写入字段，
+// 以转换结果计费会把 cache_creation 按普通输入计价
+func (c *AnthropicToResponsesSSEConverter) ParseUsage(upstream, _ string, usage *ReqeustLog) {
+	if upstream != "" {
+		parseEventPayload(upstream, ClaudeCodeParseTokenUsageFromResponse, usage)
+	}
+}
+
+func (c *AnthropicToResponsesSSEConverter) captureUsage(usage gjson.Result) {
+	if !usage.Exists() {
+		return
+	}
+	// message_delta 的 usage 为累计值，逐字段取 max
+	maxInt64Into(&c.inputTokens, usage.Get("input_tokens").Int())
+	maxInt64Into(&c.outputTokens, usage.Get("output_tokens").Int())
+	maxInt64Into(&c.cacheReadTokens, usage.Get("cache_read_input_tokens").Int())
+	maxInt64Into(&c.cacheCreateTokens, usage.Get("cache_creation_input_tokens").Int())
+}
+
+func maxInt64Into(dst *int64, candidate int64) {
+	if candidate > *dst {
+		*dst = candidate
+	}
+}
+
+// event 格式化一个 Responses SSE 事件，自动填充 type 与 sequence_number
+func (c *AnthropicToResponsesSSEConverter) event(eventType string, payload map[string]interface{}) string {
+	payload["type"] = eventType
+	payload["sequence_number"] = c.seq
+	c.seq++
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, string(data))
+}
+
+func (c *AnthropicToResponsesSSEConverter) ensureStarted() string {
+	if c.started {
+		return ""
+	}
+	c.started = true
+	return c.event("response.created", map[string]interface{}{"response": c.responseObject("in_progress")}) +
+		c.event("response.in_progress", map[string]interface{}{"response": c.responseObject("in_progress")})
+}
+
+func (c *AnthropicToResponsesSSEConverter) openBlock(index int, block gjson.Result) string {
+	state := &responsesBlockState{}
+	c.blocks[index] = state
+
+	switch block.Get("type").String() {
+	case "text":
+		state.kind = "message"
+		state.itemID = "msg_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	case "tool_use":
+		state.callID = block.Get("id").String()
+		state.name = block.Get("name").String()
+		state.kind = "function_call"
+		if c.customTools[state.name] {
+			state.kind = "custom_tool_call"
+		}
+		state.itemID = "fc_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	default:
+		return "" // thinking / redacted_thinking 等：Responses 侧不输出
+	}
+	state.outputIndex = c.nextOutput
+	c.nextOutput++
+
+	var output strings.Builder
+	output.WriteString(c.event("response.output_item.added", map[string]interface{}{
+		"output_index": state.outputIndex,
+		"item":         c.itemObject(state, "in_progress"),
+	}))
+	if state.kind == "message" {
+		output.WriteString(c.event("response.content_part.added", map[string]interface{}{
+			"item_id":       state.itemID,
+			"output_index":  state.outputIndex,
+			"content_index": 0,
+			"part":          map[string]interface{}{"type": "output_text", "text": "", "annotations": []interface{}{}},
+		}))
+	}
+	return output.String()
+}
+
+func (c *AnthropicToResponsesSSEConverter) blockDelta(index int, delta gjson.Result) string {
+	state := c.blocks[index]
+	if state == nil || state.kind == "" {
+		return ""
+	}
+	switch delta.Get("type").String() {
+	case "text_delta":
+		text := delta.Get("text").String()
+		state.buf.WriteString(text)
+		return c.event("response.output_text.delta", map[string]interface{}{
+			"item_id":       state.itemID,
+			"output_index":  state.outputIndex,
+			"content_index": 0,
+			"delta":         text,
+		})
+	case "input_json_delta":
+		partial := delta.Get("partial_json").String()
+		state.buf.WriteString(partial)
+		// custom 工具的 input 需要完整 JSON 才能取出，只在 done 时整体输出
+		if state.kind == "function_call" && partial != "" {
+			return c.event("response.function_call_arguments.delta", map[string]interface{}{
+				"item_id":      state.itemID,
+				"output_index": state.outputIndex,
+				"delta":        partial,
+			})
+		}
+	}
+	return ""
+}
+
+func (c *AnthropicToResponsesSSEConverter) closeBlock(index int) string {
+	state := c.blocks[index]
+	delete(c.blocks, index)
+	if state == nil || state.kind == "" {
+		return ""
+	}
+
+	var output strings.Builder
+	item := c.itemObject(state, "completed")
+	switch state.kind {
+	case "message":
+		text := state.buf.String()
+		output.WriteString(c.event("response.output_text.done", map[string]interface{}{
+			"item_id":       state.itemID,
+			"output_index":  state.outputIndex,
+			"content_index": 0,
+			"text":          text,
+		}))
+		output.WriteString(c.event("response.content_part.done", map[string]interface{}{
+			"item_id":       state.itemID,
+			"output_index":  state.outputIndex,
+			"content_index": 0,
+			"part":          map[string]interface{}{"type": "output_text", "text": text, "annotations": []interface{}{}},
+		}))
+	case "function_call":
+		output.WriteString(c.event("response.function_call_arguments.done", map[string]interface{}{
+			"item_id":      state.itemID,
+			"output_index": state.outputIndex,
+			"arguments":    item["arguments"],
+		}))
+	}
+	output.WriteString(c.event("response.output_item.done", map[string]interface{}{
+		"output_index": state.outputIndex,
+		"item":         item,
+	}))
+	c.output = append(c.output, item)
+	return output.String()
+}
+
+// itemObject 构造输出项；status=completed 时带上累积的完整内容
+func (c *AnthropicToResponsesSSEConverter) itemObject(state *responsesBlockState, status string) map[string]interface{} {
+	completed := status == "completed"
+	switch state.kind {
+	case "message":
+		content := []interface{}{}
+		if completed {
+			content = append(content, map[string]interface{}{
+				"type": "output_text", "text": state.buf.String(), "annotations": []interface{}{},
+			})
+		}
+		return map[string]interface{}{
+			"id": state.itemID, "type": "message", "status": status, "role": "assistant", "content": content,
+		}
+	case "custom_tool_call":
+		input := ""
+		if completed {
+			input = gjson.Get(state.buf.String(), "input").String()
+		}
+		return map[string]interface{}{
+			"id": state.itemID, "type": "custom_tool_call", "status": status,
+			"call_id": state.callID, "name": state.name, "input": input,
+		}
+	default:
+		arguments := ""
+		if completed {
+			arguments = state.buf.String()
+			if arguments == "" {
+				arguments = "{}"
+			}
+		}
+		return map[string]interface{}{
+			"id": state.itemID, "type": "function_call", "status": status,
+			"call_id": state.callID, "name": state.name, "arguments": arguments,
+		}
+	}
+}
+
+func (c *AnthropicToResponsesSSEConverter) outputCompleted() string {
+	if c.stopped {
+		return ""
+	}
+	c.stopped = true
+	// 仍未关闭的 block 按已收到的内容收尾；按 block 序号关闭，保证 output 顺序稳定
+	indexes := make([]int, 0, len(c.blocks))
+	for index := range c.blocks {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	var output strings.Builder
+	for _, index := range indexes {
+		output.WriteString(c.closeBlock(index))
+	}
+
+	resp := c.responseObject("completed")
+	eventType := "response.completed"
+	if c.stopReason == "max_tokens" {
+		resp["status"] = "incomplete"
+		resp["incomplete_details"] = map[string]interface{}{"reason": "max_output_tokens"}
+		eventType = "response.incomplete"
+	}
+	output.WriteString(c.event(eventType, map[string]interface{}{"response": resp}))
+	return output.String()
+}
+
+func (c *AnthropicToResponsesSSEConverter) outputFailed(code, message string) string {
+	c.stopped = true
+	if code == "" {
+		code = "server_error"
+	}
+	resp := c.responseObject("failed")
+	resp["error"] = map[string]interface{}{"code": code, "message": message}
+	return c.event("response.failed", map[string]interface{}{"response": resp})
+}
+
+func (c *AnthropicToResponsesSSEConverter) responseObject(status string) map[string]interface{} {
+	output := c.output
+	if output == nil {
+		output = []map[string]interface{}{}
+	}
+	resp := map[string]interface{}{
+		"id":         c.responseID,
+		"object":     "response",
+		"created_at": c.createdAt,
+		"status":     status,
+		"model":      c.model,
+		"output":     output,
+	}
+	if status != "in_progress" {
+		resp["usage"] = responsesUsage(c.inputTokens, c.outputTokens, c.cacheReadTokens, c.cacheCreateTokens)
+	}
+	return resp
+}
+
+// responsesUsage 把 Anthropic usage 折算为 Responses usage：
+// Responses 的 input_tokens 含缓存部分，cached_tokens 为其中的缓存命中
+func responsesUsage(input, output, cacheRead, cacheCreate int64) map[string]interface{} {
+	totalInput := input + cacheRead + cacheCreate
+	return map[string]interface{}{
+		"input_tokens":          totalInput,
+		"input_tokens_details":  map[string]interface{}{"cached_tokens": cacheRead},
+		"output_tokens":         output,
+		"output_tokens_details": map[string]interface{}{"reasoning_tokens": 0},
+		"total_tokens":          totalInput + output,
+	}
+}
+
+// ConvertAnthropicToResponsesResponse 将非流式 Anthropic Message 转换为 Responses 响应对象
+func ConvertAnthropicToResponsesResponse(body []byte, model string, customTools map[string]bool) ([]byte, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, fmt.Errorf("上游响应不是合法 JSON")
+	}
+	parsed := gjson.ParseBytes(body)
+	if parsed.Get("type").String() != "message" {
+		return nil, fmt.Errorf("上游响应不是 Anthropic Message")
+	}
+
+	conv := NewAnthropicToResponsesSSEConverter(model, customTools)
+	conv.stopReason = parsed.Get("stop_reason").String()
+	conv.captureUsage(parsed.Get("usage"))
+	conv.started = true // 只复用块状态机，不需要流式事件
+	for i, block := range parsed.Get("content").Array() {
+		conv.openBlock(i, block)
+		if state := conv.blocks[i]; state != nil {
+			switch state.kind {
+			case "message":
+				state.buf.WriteString(block.Get("text").String())
+			case "function_call", "custom_tool_call":
+				if input := block.Get("input"); input.Exists() {
+					state.buf.WriteString(input.Raw)
+				}
+			}
+		}
+		conv.closeBlock(i)
+	}
+
+	resp := conv.responseObject("completed")
+	if conv.stopReason == "max_tokens" {
+		resp["status"] = "incomplete"
+		resp["incomplete_details"] = map[string]interface{}{"reason": "max_output_tokens"}
+	}
+	result, err := json.Marshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("序列化 Responses 响应失败: %w", err)
+	}
+	return result, nil
+}
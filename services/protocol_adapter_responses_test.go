@@ -0,0 +1,268 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// TestConvertResponsesToAnthropicRequest Codex 的典型请求:instructions + developer 消息并入 system,
+// 连续 function_call 合并进同一条 assistant 消息,其输出合并进随后的 user 消息。
+func TestConvertResponsesToAnthropicRequest(t *testing.T) {
+	body := []byte(`{
+		"model":"claude-sonnet-4-5","stream":true,"instructions":"你是 Codex",
+		"parallel_tool_calls":false,"tool_choice":"auto",
+		"tools":[
+			{"type":"function","name":"shell","description":"执行命令","parameters":{"type":"object","properties":{"command":{"type":"array"}}}},
+			{"type":"custom","name":"apply_patch","description":"打补丁","format":{"type":"grammar","syntax":"lark","definition":"start: patch"}},
+			{"type":"web_search"}
+		],
+		"input":[
+			{"type":"message","role":"developer","content":[{"type":"input_text","text":"沙箱只读"}]},
+			{"type":"message","role":"user","content":[{"type":"input_text","text":"列出文件"}]},
+			{"type":"reasoning","id":"rs_1","encrypted_content":"xxx"},
+			{"type":"function_call","call_id":"call_1","name":"shell","arguments":"{\"command\":[\"ls\"]}"},
+			{"type":"custom_tool_call","call_id":"call_2","name":"apply_patch","input":"*** Begin Patch"},
+			{"type":"function_call_output","call_id":"call_1","output":"a.go"},
+			{"type":"custom_tool_call_output","call_id":"call_2","output":"Done"}
+		]
+	}`)
+
+	out, info, err := ConvertResponsesToAnthropic(body)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	req := gjson.ParseBytes(out)
+
+	if got := req.Get("system").String(); got != "你是 Codex\n\n沙箱只读" {
+		t.Errorf("system = %q", got)
+	}
+	if req.Get("max_tokens").Int() != defaultResponsesMaxTokens {
+		t.Errorf("未指定 max_output_tokens 时应补默认 max_tokens, 实际 %s", req.Get("max_tokens").Raw)
+	}
+	if n := len(req.Get("tools").Array()); n != 2 {
+		t.Fatalf("web_search 应被丢弃, tools 数量 = %d", n)
+	}
+	if !info.CustomTools["apply_patch"] {
+		t.Errorf("apply_patch 应标记为 custom 工具")
+	}
+	if !strings.Contains(req.Get("tools.1.description").String(), "start: patch") {
+		t.Errorf("custom 工具的语法定义应附在描述里: %s", req.Get("tools.1").Raw)
+	}
+	if !req.Get("tool_choice.disable_parallel_tool_use").Bool() {
+		t.Errorf("parallel_tool_calls=false 应映射为 disable_parallel_tool_use: %s", req.Get("tool_choice").Raw)
+	}
+
+	msgs := req.Get("messages").Array()
+	if len(msgs) != 3 {
+		t.Fatalf("messages 数量 = %d, want 3: %s", len(msgs), req.Get("messages").Raw)
+	}
+	assistant := msgs[1].Get("content").Array()
+	if len(assistant) != 2 || assistant[0].Get("input.command.0").String() != "ls" || assistant[1].Get("input.input").String() != "*** Begin Patch" {
+		t.Errorf("assistant tool_use 转换错误: %s", msgs[1].Raw)
+	}
+	results := msgs[2].Get("content").Array()
+	if len(results) != 2 || results[0].Get("tool_use_id").String() != "call_1" || results[1].Get("content").String() != "Done" {
+		t.Errorf("tool_result 转换错误: %s", msgs[2].Raw)
+	}
+}
+
+// TestAnthropicToResponsesSSEConverter Anthropic 流转 Responses 流:文本走 output_text.delta,
+// tool_use 变成 function_call 项,custom 工具还原为 custom_tool_call,completed 带 usage。
+func TestAnthropicToResponsesSSEConverter(t *testing.T) {
+	conv := NewAnthropicToResponsesSSEConverter("claude-sonnet-4-5", map[string]bool{"apply_patch": true})
+
+	var out strings.Builder
+	usage := &ReqeustLog{}
+	for _, line := range []string{
+		"event: message_start",
+		`data: {"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":10,"cache_read_input_tokens":90,"cache_creation_input_tokens":5,"output_tokens":1}}}`,
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"好的"}}`,
+		`data: {"type":"content_block_stop","index":0}`,
+		`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"shell","input":{}}}`,
+		`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"command\":"}}`,
+		`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"[\"ls\"]}"}}`,
+		`data: {"type":"content_block_stop","index":1}`,
+		`data: {"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_2","name":"apply_patch","input":{}}}`,
+		`data: {"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"input\":\"*** Begin Patch\"}"}}`,
+		`data: {"type":"content_block_stop","index":2}`,
+		`data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":42}}`,
+		`data: {"type":"message_stop"}`,
+	} {
+		converted := conv.ProcessLine(line)
+		conv.ParseUsage(line, converted, usage)
+		out.WriteString(converted)
+	}
+
+	var types []string
+	var textDelta, argsDelta string
+	var completed gjson.Result
+	for _, block := range strings.Split(strings.TrimSpace(out.String()), "\n\n") {
+		lines := strings.Split(block, "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "event: ") {
+			t.Fatalf("事件块格式不符 SSE 规范: %q", block)
+		}
+		data := gjson.Parse(strings.TrimPrefix(lines[1], "data: "))
+		types = append(types, data.Get("type").String())
+		switch data.Get("type").String() {
+		case "response.output_text.delta":
+			textDelta += data.Get("delta").String()
+		case "response.function_call_arguments.delta":
+			argsDelta += data.Get("delta").String()
+		case "response.completed":
+			completed = data
+		}
+	}
+
+	if types[0] != "response.created" || types[len(types)-1] != "response.completed" {
+		t.Errorf("事件序列首尾错误: %v", types)
+	}
+	if textDelta != "好的" || argsDelta != `{"command":["ls"]}` {
+		t.Errorf("增量内容错误: text=%q args=%q", textDelta, argsDelta)
+	}
+
+	output := completed.Get("response.output").Array()
+	if len(output) != 3 {
+		t.Fatalf("completed.output 数量 = %d: %s", len(output), completed.Raw)
+	}
+	if output[1].Get("type").String() != "function_call" || output[1].Get("call_id").String() != "toolu_1" {
+		t.Errorf("function_call 项错误: %s", output[1].Raw)
+	}
+	if output[2].Get("type").String() != "custom_tool_call" || output[2].Get("input").String() != "*** Begin Patch" {
+		t.Errorf("custom_tool_call 项错误: %s", output[2].Raw)
+	}
+	if got := completed.Get("response.usage.input_tokens").Int(); got != 105 {
+		t.Errorf("Responses input_tokens 应含缓存部分, 实际 %d", got)
+	}
+
+	// 日志按上游 Anthropic usage 计费,保留缓存写入
+	if usage.InputTokens != 10 || usage.CacheReadTokens != 90 || usage.CacheCreateTokens != 5 || usage.OutputTokens != 42 {
+		t.Errorf("usage = %+v", usage)
+	}
+}
+
+// TestAnthropicToResponsesFinalizeOnAbruptEnd 上游断流时应输出 response.failed 让 Codex 重试,
+// 而不是把半截输出当作完整回答。
+func TestAnthropicToResponsesFinalizeOnAbruptEnd(t *testing.T) {
+	conv := NewAnthropicToResponsesSSEConverter("m", nil)
+	conv.ProcessLine(`data: {"type":"message_start","message":{"usage":{"input_tokens":1}}}`)
+	conv.ProcessLine(`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`)
+
+	tail := conv.FinalizeIfUnterminated()
+	if !strings.Contains(tail, "event: response.failed") {
+		t.Fatalf("断流后应输出 response.failed, 实际:\n%s", tail)
+	}
+	if again := conv.FinalizeIfUnterminated(); again != "" {
+		t.Errorf("重复 finalize 应返回空串, 实际:\n%s", again)
+	}
+}
+
+// 上游未发 content_block_stop 就结束消息：剩余 block 按序号收尾，output 顺序与上游一致
+func TestAnthropicToResponsesUnclosedBlocksOrder(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		conv := NewAnthropicToResponsesSSEConverter("m", nil)
+		conv.ProcessLine(`data: {"type":"message_start","message":{"usage":{"input_tokens":1}}}`)
+		conv.ProcessLine(`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`)
+		conv.ProcessLine(`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"a"}}`)
+		conv.ProcessLine(`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"t1","name":"f1","input":{}}}`)
+		conv.ProcessLine(`data: {"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"t2","name":"f2","input":{}}}`)
+		conv.ProcessLine(`data: {"type":"content_block_start","index":3,"content_block":{"type":"text","text":""}}`)
+		conv.ProcessLine(`data: {"type":"content_block_delta","index":3,"delta":{"type":"text_delta","text":"b"}}`)
+		out := conv.ProcessLine(`data: {"type":"message_stop"}`)
+
+		var completed string
+		for _, line := range strings.Split(out, "\n") {
+			if strings.HasPrefix(line, "data: ") && gjson.Get(line[6:], "type").String() == "response.completed" {
+				completed = line[6:]
+			}
+		}
+		items := gjson.Get(completed, "response.output").Array()
+		if len(items) != 4 {
+			t.Fatalf("应有 4 个输出项: %s", completed)
+		}
+		got := []string{
+			items[0].Get("content.0.text").String(),
+			items[1].Get("name").String(),
+			items[2].Get("name").String(),
+			items[3].Get("content.0.text").String(),
+		}
+		if strings.Join(got, ",") != "a,f1,f2,b" {
+			t.Fatalf("输出顺序不符: %v", got)
+		}
+	}
+}
+
+// TestForwardCodexRequestToAnthropicUpstream 端到端:codex 供应商配置为 anthropic_messages 时,
+// /responses 请求以 Messages 格式发到 /v1/messages,非流式响应转回 Responses 对象。
+func TestForwardCodexRequestToAnthropicUpstream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+
+	var gotPath, gotVersion string
+	var gotBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotVersion = r.Header.Get("Anthropic-Version")
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":2}}`))
+	}))
+	defer upstream.Close()
+
+	prs := newTestRelayService(NewProviderService())
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	reqBody := `{"model":"claude-sonnet-4-5","input":"hello"}`
+	c.Request = httptest.NewRequest("POST", "/responses", strings.NewReader(reqBody))
+
+	provider := Provider{
+		Name:             "anthropic-relay",
+		APIURL:           upstream.URL,
+		APIKey:           "k",
+		Enabled:          true,
+		UpstreamProtocol: "anthropic_messages",
+	}
+	ok, err := prs.forwardRequest(c, "codex", provider, "/responses",
+		map[string]string{}, map[string]string{}, []byte(reqBody), false, "claude-sonnet-4-5", 0)
+	if !ok {
+		t.Fatalf("转发应成功, 实际失败: %v", err)
+	}
+
+	if gotPath != "/v1/messages" {
+		t.Errorf("上游路径 = %q, want /v1/messages", gotPath)
+	}
+	if gotVersion == "" {
+		t.Errorf("应注入 anthropic-version")
+	}
+	if got := gjson.GetBytes(gotBody, "messages.0.content.0.text").String(); got != "hello" {
+		t.Errorf("上游应收到 Messages 请求, 实际 %s", gotBody)
+	}
+	resp := gjson.Parse(recorder.Body.String())
+	if resp.Get("object").String() != "response" || resp.Get("output.0.content.0.text").String() != "hi" {
+		t.Errorf("客户端应收到 Responses 对象, 实际 %s", recorder.Body.String())
+	}
+	if resp.Get("usage.input_tokens").Int() != 3 || resp.Get("usage.output_tokens").Int() != 2 {
+		t.Errorf("usage 错误: %s", resp.Get("usage").Raw)
+	}
+}
+
+// TestDetectUpstreamProtocolMessagesEndpoint auto 模式下 /messages 端点识别为 anthropic_messages,
+// 默认的 /responses 仍原样转发。
+func TestDetectUpstreamProtocolMessagesEndpoint(t *testing.T) {
+	cases := map[string]UpstreamProtocolType{
+		"/v1/messages":         UpstreamProtocolAnthropicMessages,
+		"/responses":           UpstreamProtocolAnthropic,
+		"/v1/chat/completions": UpstreamProtocolOpenAIChat,
+	}
+	for endpoint, want := range cases {
+		if got := DetectUpstreamProtocol(endpoint); got != want {
+			t.Errorf("DetectUpstreamProtocol(%q) = %q, want %q", endpoint, got, want)
+		}
+	}
+}
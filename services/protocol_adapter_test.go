@@ -0,0 +1,347 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/daodao97/xgo/xrequest"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// TestConvertAnthropicToolsToOpenAI tools/tool_choice 必须映射为 OpenAI function 工具,
+// 而不是像第一期那样直接拒绝——Claude Code 的每个请求都带 tools。
+func TestConvertAnthropicToolsToOpenAI(t *testing.T) {
+	body := []byte(`{
+		"model":"gpt-4o","stream":true,"max_tokens":100,
+		"tools":[{"name":"get_weather","description":"查天气","input_schema":{"type":"object","properties":{"city":{"type":"string"}},"required":["city"]}}],
+		"tool_choice":{"type":"any","disable_parallel_tool_use":true},
+		"messages":[{"role":"user","content":"北京天气"}]
+	}`)
+
+	out, _, err := ConvertAnthropicToOpenAI(body, DefaultConvertOptions())
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	parsed := gjson.ParseBytes(out)
+
+	if got := parsed.Get("tools.0.type").String(); got != "function" {
+		t.Errorf("tools[0].type = %q, want function", got)
+	}
+	if got := parsed.Get("tools.0.function.name").String(); got != "get_weather" {
+		t.Errorf("tools[0].function.name = %q, want get_weather", got)
+	}
+	if got := parsed.Get("tools.0.function.parameters.required.0").String(); got != "city" {
+		t.Errorf("input_schema 未原样映射为 parameters: %s", parsed.Get("tools.0.function.parameters").Raw)
+	}
+	if got := parsed.Get("tool_choice").String(); got != "required" {
+		t.Errorf("tool_choice = %q, want required", got)
+	}
+	if v := parsed.Get("parallel_tool_calls"); !v.Exists() || v.Bool() {
+		t.Errorf("disable_parallel_tool_use 应映射为 parallel_tool_calls=false, 实际 %s", v.Raw)
+	}
+
+	// 指定工具
+	named := []byte(`{"stream":true,"tools":[{"name":"a","input_schema":{"type":"object"}}],"tool_choice":{"type":"tool","name":"a"},"messages":[]}`)
+	out, _, err = ConvertAnthropicToOpenAI(named, DefaultConvertOptions())
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	if got := gjson.GetBytes(out, "tool_choice.function.name").String(); got != "a" {
+		t.Errorf("tool_choice.function.name = %q, want a", got)
+	}
+}
+
+// TestConvertAnthropicToolHistoryToOpenAI 多轮工具对话:assistant 的 tool_use 变成 tool_calls,
+// user 的 tool_result 拆成 role=tool 消息,且排在同条消息的文本之前。
+func TestConvertAnthropicToolHistoryToOpenAI(t *testing.T) {
+	body := []byte(`{
+		"stream":true,
+		"messages":[
+			{"role":"user","content":"北京天气"},
+			{"role":"assistant","content":[
+				{"type":"thinking","thinking":"...","signature":"sig"},
+				{"type":"text","text":"我查一下"},
+				{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{"city":"北京"}}
+			]},
+			{"role":"user","content":[
+				{"type":"tool_result","tool_use_id":"toolu_1","content":[{"type":"text","text":"晴"}]},
+				{"type":"text","text":"谢谢"}
+			]}
+		]
+	}`)
+
+	out, _, err := ConvertAnthropicToOpenAI(body, DefaultConvertOptions())
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	msgs := gjson.GetBytes(out, "messages").Array()
+	if len(msgs) != 4 {
+		t.Fatalf("messages 数量 = %d, want 4: %s", len(msgs), gjson.GetBytes(out, "messages").Raw)
+	}
+
+	assistant := msgs[1]
+	if got := assistant.Get("content").String(); got != "我查一下" {
+		t.Errorf("assistant.content = %q, thinking 块应被丢弃", got)
+	}
+	if got := assistant.Get("tool_calls.0.id").String(); got != "toolu_1" {
+		t.Errorf("tool_calls[0].id = %q, want toolu_1", got)
+	}
+	if got := gjson.Parse(assistant.Get("tool_calls.0.function.arguments").String()).Get("city").String(); got != "北京" {
+		t.Errorf("arguments 必须是 input 的 JSON 字符串, 实际 %s", assistant.Get("tool_calls.0.function.arguments").Raw)
+	}
+
+	if msgs[2].Get("role").String() != "tool" || msgs[2].Get("tool_call_id").String() != "toolu_1" || msgs[2].Get("content").String() != "晴" {
+		t.Errorf("tool_result 转换错误: %s", msgs[2].Raw)
+	}
+	if msgs[3].Get("role").String() != "user" || msgs[3].Get("content").String() != "谢谢" {
+		t.Errorf("tool_result 后的文本应成为独立 user 消息: %s", msgs[3].Raw)
+	}
+}
+
+// TestConvertAnthropicToolResultErrorToOpenAI is_error 的工具结果必须让上游模型看出失败
+func TestConvertAnthropicToolResultErrorToOpenAI(t *testing.T) {
+	body := []byte(`{
+		"messages":[
+			{"role":"user","content":"读文件"},
+			{"role":"assistant","content":[{"type":"tool_use","id":"toolu_1","name":"read","input":{}}]},
+			{"role":"user","content":[
+				{"type":"tool_result","tool_use_id":"toolu_1","is_error":true,"content":"ENOENT: no such file"},
+				{"type":"tool_result","tool_use_id":"toolu_2","content":"ok"}
+			]}
+		]
+	}`)
+	out, _, err := ConvertAnthropicToOpenAI(body, DefaultConvertOptions())
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	msgs := gjson.GetBytes(out, "messages").Array()
+	if len(msgs) != 4 {
+		t.Fatalf("messages 数量 = %d, want 4: %s", len(msgs), gjson.GetBytes(out, "messages").Raw)
+	}
+	if got := msgs[2].Get("content").String(); got != "[tool error] ENOENT: no such file" {
+		t.Errorf("失败的工具结果应带错误标记: %q", got)
+	}
+	if got := msgs[3].Get("content").String(); got != "ok" {
+		t.Errorf("成功的工具结果不应改动: %q", got)
+	}
+}
+
+// TestConvertAnthropicServerToolRejected Anthropic 内置工具在 OpenAI 上游无法执行,应按 400 拒绝。
+func TestConvertAnthropicServerToolRejected(t *testing.T) {
+	body := []byte(`{"stream":true,"tools":[{"type":"web_search_20250305","name":"web_search"}],"messages":[]}`)
+	_, _, err := ConvertAnthropicToOpenAI(body, DefaultConvertOptions())
+	if !errors.Is(err, ErrClientRequestRejected) {
+		t.Fatalf("内置工具应返回 ErrClientRequestRejected, 实际 %v", err)
+	}
+}
+
+// TestAnthropicSSEConverterStreamsToolCalls 流式 tool_calls 必须转成 tool_use block:
+// 文本 block 先关闭,tool_use 以新 index 打开,arguments 分片作为 input_json_delta 透传。
+func TestAnthropicSSEConverterStreamsToolCalls(t *testing.T) {
+	conv := NewOpenAIToAnthropicSSEConverter("test-model")
+
+	var out strings.Builder
+	for _, line := range []string{
+		`data: {"choices":[{"delta":{"content":"查询中"}}]}`,
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}`,
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}`,
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"北京\"}"}}]}}]}`,
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":1,"id":"call_2","type":"function","function":{"name":"get_time","arguments":"{}"}}]}}]}`,
+		`data: {"choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+		"data: [DONE]",
+	} {
+		out.WriteString(conv.ProcessLine(line))
+	}
+
+	var starts, stops []gjson.Result
+	var partial strings.Builder
+	var stopReason string
+	for _, block := range strings.Split(strings.TrimSpace(out.String()), "\n\n") {
+		lines := strings.Split(block, "\n")
+		if len(lines) != 2 {
+			t.Fatalf("事件块格式不符 SSE 规范: %q", block)
+		}
+		data := gjson.Parse(strings.TrimPrefix(lines[1], "data: "))
+		switch data.Get("type").String() {
+		case "content_block_start":
+			starts = append(starts, data)
+		case "content_block_stop":
+			stops = append(stops, data)
+		case "content_block_delta":
+			if data.Get("delta.type").String() == "input_json_delta" && data.Get("index").Int() == 1 {
+				partial.WriteString(data.Get("delta.partial_json").String())
+			}
+		case "message_delta":
+			stopReason = data.Get("delta.stop_reason").String()
+		}
+	}
+
+	if len(starts) != 3 || len(stops) != 3 {
+		t.Fatalf("应有 3 个 block(text + 2 个 tool_use), 实际 start=%d stop=%d\n%s", len(starts), len(stops), out.String())
+	}
+	for i, s := range starts {
+		if int(s.Get("index").Int()) != i || int(stops[i].Get("index").Int()) != i {
+			t.Errorf("block %d 的 index 不连续: start=%s stop=%s", i, s.Raw, stops[i].Raw)
+		}
+	}
+	if starts[1].Get("content_block.type").String() != "tool_use" || starts[1].Get("content_block.id").String() != "call_1" || starts[1].Get("content_block.name").String() != "get_weather" {
+		t.Errorf("tool_use block start 错误: %s", starts[1].Raw)
+	}
+	if got := partial.String(); got != `{"city":"北京"}` {
+		t.Errorf("拼接后的 partial_json = %q", got)
+	}
+	if stopReason != "tool_use" {
+		t.Errorf("stop_reason = %q, want tool_use", stopReason)
+	}
+}
+
+// TestAnthropicSSEConverterToolUseStopReasonFallback 部分上游调用工具后仍返回 finish_reason=stop,
+// stop_reason 必须仍为 tool_use,否则客户端不会执行工具。
+func TestAnthropicSSEConverterToolUseStopReasonFallback(t *testing.T) {
+	conv := NewOpenAIToAnthropicSSEConverter("test-model")
+	conv.ProcessLine(`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"name":"ls","arguments":"{}"}}]}}]}`)
+	conv.ProcessLine(`data: {"choices":[{"delta":{},"finish_reason":"stop"}]}`)
+	done := conv.ProcessLine("data: [DONE]")
+
+	if !strings.Contains(done, `"stop_reason":"tool_use"`) {
+		t.Errorf("应回退为 tool_use, 实际:\n%s", done)
+	}
+}
+
+// TestConvertAnthropicNonStreamRequest stream=false 的请求(标题生成等旁路调用)必须能转换,
+// 且不能注入 stream_options——部分上游对非流式请求携带它直接报 400。
+func TestConvertAnthropicNonStreamRequest(t *testing.T) {
+	for _, body := range []string{
+		`{"model":"gpt-4o","stream":false,"messages":[{"role":"user","content":"起个标题"}]}`,
+		`{"model":"gpt-4o","messages":[{"role":"user","content":"起个标题"}]}`,
+	} {
+		out, info, err := ConvertAnthropicToOpenAI([]byte(body), DefaultConvertOptions())
+		if err != nil {
+			t.Fatalf("非流式请求应能转换, 实际 %v", err)
+		}
+		if v := gjson.GetBytes(out, "stream"); !v.Exists() || v.Bool() {
+			t.Errorf("stream 应为 false, 实际 %s", v.Raw)
+		}
+		if gjson.GetBytes(out, "stream_options").Exists() || info.InjectedStreamOpts {
+			t.Errorf("非流式请求不应注入 stream_options: %s", out)
+		}
+	}
+}
+
+// TestConvertAnthropicImagesToOpenAI 粘贴截图(base64)与 URL 图片转成 image_url 片段,
+// tool_result 里的图片(Read 工具读图片文件)挪到紧随 tool 消息的 user 消息中。
+func TestConvertAnthropicImagesToOpenAI(t *testing.T) {
+	body := []byte(`{"stream":true,"messages":[
+		{"role":"user","content":[
+			{"type":"text","text":"看图"},
+			{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0KGgo="}},
+			{"type":"image","source":{"type":"url","url":"https://example.com/a.jpg"}}
+		]},
+		{"role":"assistant","content":[{"type":"tool_use","id":"toolu_1","name":"Read","input":{"file_path":"b.png"}}]},
+		{"role":"user","content":[
+			{"type":"tool_result","tool_use_id":"toolu_1","content":[{"type":"image","source":{"type":"base64","media_type":"image/jpeg","data":"/9j/"}}]}
+		]}
+	]}`)
+
+	out, _, err := ConvertAnthropicToOpenAI(body, DefaultConvertOptions())
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	msgs := gjson.GetBytes(out, "messages").Array()
+	if len(msgs) != 4 {
+		t.Fatalf("messages 数量 = %d, want 4: %s", len(msgs), gjson.GetBytes(out, "messages").Raw)
+	}
+
+	first := msgs[0].Get("content").Array()
+	if len(first) != 3 || first[0].Get("type").String() != "text" {
+		t.Fatalf("含图片的 user 消息应为按原顺序的片段数组: %s", msgs[0].Raw)
+	}
+	if got := first[1].Get("image_url.url").String(); got != "data:image/png;base64,iVBORw0KGgo=" {
+		t.Errorf("base64 图片 url = %q", got)
+	}
+	if got := first[2].Get("image_url.url").String(); got != "https://example.com/a.jpg" {
+		t.Errorf("url 图片 = %q", got)
+	}
+
+	if msgs[2].Get("role").String() != "tool" || msgs[2].Get("content").String() == "" {
+		t.Errorf("纯图片 tool_result 仍需一条非空 tool 消息: %s", msgs[2].Raw)
+	}
+	if got := msgs[3].Get("content.0.image_url.url").String(); got != "data:image/jpeg;base64,/9j/" {
+		t.Errorf("tool_result 图片应挪到随后的 user 消息, 实际 %s", msgs[3].Raw)
+	}
+}
+
+// TestConvertOpenAIToAnthropicResponse chat.completion 转 Anthropic Message:
+// 文本与工具调用各成一个 content block,usage 拆出缓存命中部分。
+func TestConvertOpenAIToAnthropicResponse(t *testing.T) {
+	body := []byte(`{
+		"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o-2024",
+		"choices":[{"index":0,"finish_reason":"tool_calls","message":{"role":"assistant","content":"我查一下",
+			"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"北京\"}"}}]}}],
+		"usage":{"prompt_tokens":100,"completion_tokens":20,"prompt_tokens_details":{"cached_tokens":30}}
+	}`)
+
+	out, err := ConvertOpenAIToAnthropicResponse(body, "claude-sonnet")
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	msg := gjson.ParseBytes(out)
+	if msg.Get("type").String() != "message" || msg.Get("model").String() != "claude-sonnet" {
+		t.Errorf("响应外壳错误: %s", out)
+	}
+	if msg.Get("content.0.type").String() != "text" || msg.Get("content.0.text").String() != "我查一下" {
+		t.Errorf("文本 block 错误: %s", msg.Get("content").Raw)
+	}
+	if msg.Get("content.1.type").String() != "tool_use" || msg.Get("content.1.input.city").String() != "北京" {
+		t.Errorf("tool_use block 错误: %s", msg.Get("content").Raw)
+	}
+	if msg.Get("stop_reason").String() != "tool_use" {
+		t.Errorf("stop_reason = %q, want tool_use", msg.Get("stop_reason").String())
+	}
+
+	usage := &ReqeustLog{}
+	parseEventPayload(string(out), ClaudeCodeParseTokenUsageFromResponse, usage)
+	if usage.InputTokens != 70 || usage.CacheReadTokens != 30 || usage.OutputTokens != 20 {
+		t.Errorf("usage = input %d / cache_read %d / output %d, want 70/30/20",
+			usage.InputTokens, usage.CacheReadTokens, usage.OutputTokens)
+	}
+
+	if _, err := ConvertOpenAIToAnthropicResponse([]byte(`<html>bad gateway</html>`), "m"); err == nil {
+		t.Error("非 JSON 响应应返回错误以便降级")
+	}
+}
+
+// TestWriteConvertedNonStreamResponse 非流式转换路径:客户端收到 Anthropic JSON,
+// Content-Length 不沿用上游值,usage 写入请求日志。
+func TestWriteConvertedNonStreamResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	upstreamBody := `{"choices":[{"finish_reason":"stop","message":{"role":"assistant","content":"标题"}}],"usage":{"prompt_tokens":12,"completion_tokens":3}}`
+	resp := xrequest.NewResponse(&http.Response{
+		StatusCode:    http.StatusOK,
+		ContentLength: int64(len(upstreamBody)),
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(strings.NewReader(upstreamBody)),
+	})
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	requestLog := &ReqeustLog{}
+	if err := writeConvertedNonStreamResponse(c, resp, NewOpenAIToAnthropicSSEConverter("claude-haiku"), requestLog); err != nil {
+		t.Fatalf("写出失败: %v", err)
+	}
+
+	if got := gjson.Get(recorder.Body.String(), "content.0.text").String(); got != "标题" {
+		t.Errorf("客户端应收到 Anthropic Message, 实际 %s", recorder.Body.String())
+	}
+	if got := gjson.Get(recorder.Body.String(), "stop_reason").String(); got != "end_turn" {
+		t.Errorf("stop_reason = %q, want end_turn", got)
+	}
+	if requestLog.InputTokens != 12 || requestLog.OutputTokens != 3 {
+		t.Errorf("usage 未写入日志: input=%d output=%d", requestLog.InputTokens, requestLog.OutputTokens)
+	}
+}
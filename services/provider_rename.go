@@ -147,6 +147,14 @@ func doRenameTx(tx *sql.Tx, platform string, providerID int64, oldName, newName
 		return fmt.Errorf("更新 request_log 失败: %w", err)
 	}
 
+	// 心跳日志同样按供应商名统计消费上限；表随 request_log 迁移创建，未建表的旧库跳过
+	if _, err := tx.Exec(
+		`UPDATE cache_heartbeat_log SET provider = ? WHERE platform = ? AND provider = ?`,
+		newName, platform, oldName,
+	); err != nil && !isNoSuchTableErr(err) {
+		return fmt.Errorf("更新 cache_heartbeat_log 失败: %w", err)
+	}
+
 	if _, err := tx.Exec(
 		`UPDATE provider_blacklist SET provider_name = ? WHERE platform = ? AND provider_name = ?`,
 		newName, platform, oldName,
@@ -356,6 +364,8 @@ func resolvePlatform(kind string) (string, error) {
 		return "claude", nil
 	case "codex":
 		return "codex", nil
+	case "openai":
+		return "openai", nil
 	default:
 		if strings.HasPrefix(kind, "custom:") {
 			return kind, nil
@@ -72,10 +72,25 @@ type ProviderRelayService struct {
 	lastUsedMu  sync.RWMutex                 // 保护 lastUsed 的锁
 	rrMu        sync.Mutex                   // 轮询状态锁
 	rrLastStart map[string]string            // 轮询状态：key="platform:level" → value=上次起始 Provider Name
+	wrrStates   map[string]*weightedRRState  // 加权轮询状态：key="platform:level"（rrMu 保护，懒初始化）
+	// latency 各供应商首字节耗时/输出速率统计（进程内），供同 Level 最快优先排序
+	latency *latencyTracker
+	// affinity 会话粘性绑定（进程内），同一会话优先发往上次成功的供应商以复用 prompt cache
+	affinity *sessionAffinity
+	// heartbeats Prompt Cache 保活心跳的会话状态与排期（进程内）
+	heartbeats *cacheHeartbeatScheduler
 	// endpointCooldowns 多地址供应商的地址冷却状态（进程内，issue #27）
 	endpointCooldowns *endpointCooldownStore
+	// apiKeys 多密钥供应商的密钥冷却、轮询游标与统计（进程内）
+	apiKeys *apiKeyStore
 	// concurrency 按供应商并发配额（进程内，issue #21）
 	concurrency *concurrencyLimiter
+	// spend 按供应商/平台的当前周期花费缓存，供消费上限与预算硬性停止判断
+	spend *spendTracker
+	// metrics /metrics 导出的请求类计数器（进程内，见 metrics.go）
+	metrics *relayMetrics
+	// healthCheckService 应用内唯一的健康检查服务，供管理 API 读取最新检查结果（启动时注入）
+	healthCheckService *HealthCheckService
 	// captureRequests 抓包模式开关（进程内状态，重启即关，issue #5）
 	captureRequests atomic.Bool
 	// captureClearGen "清除抓包数据"的代次。采集时记在 requestLog 上，落库前
@@ -223,16 +238,24 @@ func warnInsecureProviderOnce(name string) {
 	fmt.Printf("⚠️  Provider %s 已开启跳过 TLS 证书验证（insecureSkipVerify），存在中间人风险\n", name)
 }
 
-// relayClientFor 按供应商的 insecureSkipVerify 选择共享转发客户端。
+// relayClientFor 按供应商的 insecureSkipVerify 与出站代理选择共享转发客户端。
 // 返回的是共享实例，严禁在其上调 xrequest 的 SetTimeout（会写回 client，产生数据竞争）。
-func relayClientFor(insecure bool, providerName string) *http.Client {
+func relayClientFor(insecure bool, proxyURL, providerName string) *http.Client {
 	if !insecure {
-		return relayHTTPClient
+		return clientWithProxy(relayHTTPClient, proxyURL)
 	}
 	warnInsecureProviderOnce(providerName)
-	return relayHTTPClientInsecure
+	return clientWithProxy(relayHTTPClientInsecure, proxyURL)
 }
 
+// relayModelsClient / relayModelsClientInsecure 模型列表请求的轻量包装：
+// 挂在转发共享 Transport 上，client 级超时 30s（32h 不适合这类短请求）。
+// 包级实例保证按代理缓存的客户端键稳定
+var (
+	relayModelsClient         = &http.Client{Timeout: 30 * time.Second, Transport: relayHTTPClient.Transport}
+	relayModelsClientInsecure = &http.Client{Timeout: 30 * time.Second, Transport: relayHTTPClientInsecure.Transport}
+)
+
 // relayDispatchStartKey 存于 gin.Context：本次客户端请求进入调度的时刻，
 // 首响预算按它跨供应商/地址/重试累计
 const relayDispatchStartKey = "relayDispatchStart"
@@ -413,7 +436,7 @@ func setHeaderCanonical(headers map[string]string, name string, value string) {
 func sanitizeUpstreamHeaders(headers map[string]string) {
 	deleteHeaderFold(headers,
 		"authorization", "proxy-authorization", "x-api-key", "api-key", "x-goog-api-key",
-		"accept-encoding", "connection", "keep-alive", "transfer-encoding", "te", "upgrade")
+		"x-code-switch-client", "accept-encoding", "connection", "keep-alive", "transfer-encoding", "te", "upgrade")
 }
 
 // credentialQueryParams 查询串中承载凭据的参数名（小写比较）。
@@ -492,24 +515,43 @@ func checkNonStreamTruncated(resp *xrequest.Response, written int64) error {
 	return nil
 }
 
+// providerSkipCounts 初筛阶段按原因统计的跳过数
+type providerSkipCounts struct {
+	Model, Blacklist, Invalid, Spend, Rule int
+	RuleName                               string
+}
+
+// onlySpend 是否全部因消费上限被跳过（此时回 429 而非 404）
+func (s providerSkipCounts) onlySpend() bool {
+	return s.Spend > 0 && s.Model+s.Blacklist+s.Invalid+s.Rule == 0
+}
+
 // respondNoEligibleProviders 初筛后无可用供应商的 404 终态。
 // 把"为什么被跳过"按原因拆开讲清并给排查指引：白名单不匹配、临时拉黑与
 // 未启用是三种完全不同的处置方式，混在一个计数里用户无从下手（issue #29）。
 // 多种原因并存时全部列出，不做"选一个当代表"的省略
-func respondNoEligibleProviders(c *gin.Context, requestedModel string, skippedModel, skippedBlacklist, skippedInvalid int) {
+func respondNoEligibleProviders(c *gin.Context, requestedModel string, skipped providerSkipCounts) {
 	var reasons, hints []string
-	if skippedModel > 0 {
-		reasons = append(reasons, fmt.Sprintf("%d 个供应商的模型白名单/映射不包含该模型", skippedModel))
+	if skipped.Model > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d 个供应商的模型白名单/映射不包含该模型", skipped.Model))
 		hints = append(hints, "在主页打开对应供应商，确认\"支持的模型\"包含该模型或留空（留空=支持所有模型），\"模型映射\"的目标模型名必须在白名单内")
 	}
-	if skippedBlacklist > 0 {
-		reasons = append(reasons, fmt.Sprintf("%d 个正被临时拉黑", skippedBlacklist))
+	if skipped.Blacklist > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d 个正被临时拉黑", skipped.Blacklist))
 		hints = append(hints, "被拉黑的供应商可等待自动恢复，或到黑名单页手动解除")
 	}
-	if skippedInvalid > 0 {
-		reasons = append(reasons, fmt.Sprintf("%d 个配置校验失败（详见控制台日志）", skippedInvalid))
+	if skipped.Invalid > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d 个配置校验失败（详见控制台日志）", skipped.Invalid))
 		hints = append(hints, "配置校验失败的常见原因是模型映射的目标不在白名单内")
 	}
+	if skipped.Spend > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d 个已达消费上限", skipped.Spend))
+		hints = append(hints, "达到消费上限的供应商会在周期重置后恢复，或在主页调高其消费上限")
+	}
+	if skipped.Rule > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d 个被路由规则「%s」排除", skipped.Rule, skipped.RuleName))
+		hints = append(hints, "用路由规则演练（DryRunRouting）查看规则的目标供应商集合是否过窄")
+	}
 
 	var msg string
 	if len(reasons) == 0 {
@@ -546,6 +588,49 @@ func respondAllProvidersFailed(c *gin.Context, lastError error, allClientErrors
 	c.JSON(status, payload)
 }
 
+// waitForBusyCapacity 全部候选并发满载时的有界等待，直到任一忙候选腾出空位。
+// 唤醒以"忙候选真的有空位"为门控：本轮实际尝试供应商的正常释放
+// 也会触发全局信号，不加门控直接重扫会形成自唤醒重试风暴。
+// 返回 false 表示等待预算耗尽或客户端已断开
+func (prs *ProviderRelayService) waitForBusyCapacity(c *gin.Context, platform string, pending map[string]concurrencyBusyRef, deadline time.Time) bool {
+	span := startTraceSpan(c, "concurrency.wait")
+	span.set("platform", platform)
+	span.set("busy_providers", len(pending))
+	defer span.end()
+	for {
+		capSignal := prs.concurrency.releaseSignal()
+		if prs.concurrency.anyCapacity(platform, pending) {
+			return true
+		}
+		if !prs.concurrency.waitForRelease(c.Request.Context(), deadline, capSignal) {
+			span.fail("no capacity released before deadline")
+			return false
+		}
+	}
+}
+
+// waitBeforeRetry 拉黑模式同供应商原地重试前的等待；等待期间客户端断开返回 false
+func waitBeforeRetry(c *gin.Context, provider, reason string, wait time.Duration) bool {
+	span := startTraceSpan(c, "retry.wait")
+	span.set("provider", provider)
+	span.set("retry.reason", truncateTraceValue(reason))
+	span.set("wait_ms", wait.Milliseconds())
+	defer span.end()
+	select {
+	case <-time.After(wait):
+		return true
+	case <-c.Request.Context().Done():
+		span.fail("client disconnected")
+		return false
+	}
+}
+
+// isOpenAIClientPlatform 客户端说 OpenAI 协议的平台：codex（Responses）与 openai（Chat Completions）。
+// 这两个平台的 anthropic 上游协议表示原样转发，anthropic_messages 才转换为 Messages
+func isOpenAIClientPlatform(kind string) bool {
+	return kind == "codex" || kind == "openai"
+}
+
 // respondAllBusy 纯并发满载终态：503 + Retry-After，带稳定机器码
 // provider_concurrency_exhausted。按平台给各自协议兼容的错误结构；
 // 不用 502（那表示已联系上游失败）也不用 504（不是上游超时）。
@@ -553,7 +638,7 @@ func respondAllBusy(c *gin.Context, kind string) {
 	c.Header("Retry-After", "1")
 	msg := "所有可用供应商均已达到并发上限，请稍后重试"
 	switch {
-	case kind == "codex":
+	case isOpenAIClientPlatform(kind):
 		c.JSON(http.StatusServiceUnavailable, gin.H{
 			"error": gin.H{
 				"type":    "server_error",
@@ -632,7 +717,13 @@ func NewProviderRelayService(providerService *ProviderService, geminiService *Ge
 		},
 		rrLastStart:            make(map[string]string),
 		endpointCooldowns:      newEndpointCooldownStore(),
+		apiKeys:                newAPIKeyStore(),
 		concurrency:            newConcurrencyLimiter(),
+		latency:                newLatencyTracker(),
+		affinity:               newSessionAffinity(),
+		heartbeats:             newCacheHeartbeatScheduler(),
+		spend:                  newSpendTracker(NewLogService()),
+		metrics:                newRelayMetrics(NewLogService()),
 		captureDeletedSessions: make(map[int64]struct{}),
 	}
 }
@@ -693,7 +784,8 @@ func (prs *ProviderRelayService) isRoundRobinEnabled() bool {
 }
 
 // roundRobinOrder 对同 Level 的 providers 进行轮询排序
-// 算法：基于 name 追踪，将上次起始 provider 移到末尾，实现轮询效果
+// 算法：基于 name 追踪，将上次起始 provider 移到末尾，实现轮询效果；
+// 同组有供应商配置了权重时改用平滑加权轮询（见 weightedRoundRobinOrder）
 // 参数：
 //   - platform: 平台标识（claude/codex/gemini/custom:xxx）
 //   - level: 当前 Level
@@ -711,6 +803,23 @@ func (prs *ProviderRelayService) roundRobinOrder(platform string, level int, pro
 	prs.rrMu.Lock()
 	defer prs.rrMu.Unlock()
 
+	// 配置了权重：平滑加权轮询
+	weights := make([]int, len(providers))
+	for i, p := range providers {
+		weights[i] = p.Weight
+	}
+	if hasCustomWeights(weights) {
+		names := make([]string, len(providers))
+		for i, p := range providers {
+			names[i] = p.Name
+		}
+		result := make([]Provider, 0, len(providers))
+		for _, idx := range prs.weightedRoundRobinOrder(key, names, weights) {
+			result = append(result, providers[idx])
+		}
+		return result
+	}
+
 	lastStart := prs.rrLastStart[key]
 
 	// 记录本次起始 provider 名称（更新状态）
@@ -760,6 +869,23 @@ func (prs *ProviderRelayService) roundRobinOrderGemini(level int, providers []Ge
 	prs.rrMu.Lock()
 	defer prs.rrMu.Unlock()
 
+	// 配置了权重：平滑加权轮询
+	weights := make([]int, len(providers))
+	for i, p := range providers {
+		weights[i] = p.Weight
+	}
+	if hasCustomWeights(weights) {
+		names := make([]string, len(providers))
+		for i, p := range providers {
+			names[i] = p.Name
+		}
+		result := make([]GeminiProvider, 0, len(providers))
+		for _, idx := range prs.weightedRoundRobinOrder(key, names, weights) {
+			result = append(result, providers[idx])
+		}
+		return result
+	}
+
 	lastStart := prs.rrLastStart[key]
 
 	// 记录本次起始 provider 名称
@@ -930,7 +1056,7 @@ func (prs *ProviderRelayService) stripStaleCapture(requestLog *ReqeustLog) {
 	}
 }
 
-// requestLogInsertSQL 两条写入路径共用的 25 列 INSERT，避免列清单分叉
+// requestLogInsertSQL 两条写入路径共用的 30 列 INSERT，避免列清单分叉
 const requestLogInsertSQL = `
 	INSERT INTO request_log (
 		platform, model, provider, http_code,
@@ -939,8 +1065,8 @@ const requestLogInsertSQL = `
 		ephemeral_5m_tokens, ephemeral_1h_tokens, service_tier,
 		request_url, request_headers, request_body, body_truncated, body_bytes,
 		response_headers, response_body, response_truncated, response_bytes, budget_skipped,
-		capture_session_id
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		capture_session_id, price_multiplier, hedged, session_key, sticky_hit, client_name
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 `
 
 func requestLogInsertArgs(requestLog *ReqeustLog) []interface{} {
@@ -954,7 +1080,8 @@ func requestLogInsertArgs(requestLog *ReqeustLog) []interface{} {
 		boolToInt(requestLog.BodyTruncated), requestLog.BodyBytes,
 		requestLog.ResponseHeaders, requestLog.ResponseBody,
 		boolToInt(requestLog.RespTruncated), requestLog.RespBytes, boolToInt(requestLog.BudgetSkipped),
-		requestLog.CaptureSessionID,
+		requestLog.CaptureSessionID, effectiveLogPriceMultiplier(requestLog.PriceMultiplier),
+		boolToInt(requestLog.Hedged), requestLog.SessionKey, boolToInt(requestLog.StickyHit), requestLog.ClientName,
 	}
 }
 
@@ -1013,7 +1140,7 @@ func (prs *ProviderRelayService) writeRequestLog(requestLog *ReqeustLog) error {
 func (prs *ProviderRelayService) validateConfig() []string {
 	warnings := make([]string, 0)
 
-	for _, kind := range []string{"claude", "codex"} {
+	for _, kind := range providerPlatforms {
 		providers, err := prs.providerService.LoadProviders(kind)
 		if err != nil {
 			warnings = append(warnings, fmt.Sprintf("[%s] 加载配置失败: %v", kind, err))
@@ -1080,6 +1207,7 @@ func (prs *ProviderRelayService) Stop() error {
 	// 清掉绑定地址：停掉之后再对外报告"正在监听 xxx"会误导 UI 与 WSL 可达性判断
 	prs.boundAddrs = nil
 	prs.serverMu.Unlock()
+	prs.heartbeats.stopAll()
 
 	if server == nil {
 		// 代理本就未运行；若录制开关还开着（异常路径），同样封存会话
@@ -1107,8 +1235,14 @@ func (prs *ProviderRelayService) Addr() string {
 }
 
 func (prs *ProviderRelayService) registerRoutes(router gin.IRouter) {
+	// 客户端访问令牌：开启后所有转发入口都须携带有效令牌
+	router.Use(clientAuthMiddleware())
+	// 请求追踪（默认关闭，见 tracing.go）：放在认证之后，被拒的请求不产生 trace
+	router.Use(prs.tracingMiddleware())
 	router.POST("/v1/messages", prs.proxyHandler("claude", "/v1/messages"))
 	router.POST("/responses", prs.proxyHandler("codex", "/responses"))
+	// OpenAI Chat Completions 入口（aider、Continue、openai SDK 脚本等），独立的 openai 平台
+	router.POST("/v1/chat/completions", prs.proxyHandler("openai", "/v1/chat/completions"))
 
 	// /v1/models 端点（OpenAI-compatible API）
 	// 支持 Claude 和 Codex 平台
@@ -1128,6 +1262,10 @@ func (prs *ProviderRelayService) registerRoutes(router gin.IRouter) {
 
 	// 自定义 CLI 工具的 /v1/models 端点
 	router.GET("/custom/:toolId/v1/models", prs.customModelsHandler())
+
+	// 本地管理 API 与 Prometheus 指标（默认关闭，见 admin_api.go、metrics.go）
+	prs.registerAdminRoutes(router)
+	prs.registerMetricsRoute(router)
 }
 
 func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.HandlerFunc {
@@ -1164,6 +1302,25 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 			fmt.Printf("[WARN] 请求未指定模型名，无法执行模型智能降级\n")
 		}
 
+		// 预算硬性停止：本周期平台预算已用尽时直接拒绝，不再转发
+		if exhausted, total, resetAt := prs.platformBudgetExhausted(kind); exhausted {
+			fmt.Printf("[WARN] %s 预算已用尽（硬性停止），拒绝请求\n", kind)
+			respondBudgetHardStop(c, kind, total, resetAt)
+			return
+		}
+		if prs.rejectOverQuotaClient(c, kind) {
+			return
+		}
+
+		// 路由规则：第一条命中的启用规则生效，模型改写先于供应商初筛
+		rule := prs.resolveRoutingRule(kind, c.Request.Header, clientNameOf(c), bodyBytes, requestedModel)
+		bodyBytes, requestedModel = applyRoutingModelOverride(rule, bodyBytes, requestedModel)
+		c.Request.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+
+		selectSpan := startTraceSpan(c, "provider.select")
+		selectSpan.set("model", requestedModel)
+		defer selectSpan.end()
+
 		// (providers, 配置代数) 配对装载：容量热更新以更高代数为准，
 		// 分两步读取会让旧配置带上新代数、降容被来回覆盖
 		providers, configGen, err := prs.providerService.LoadProvidersWithGen(kind)
@@ -1174,10 +1331,11 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 
 		active := make([]Provider, 0, len(providers))
 		skippedCount := 0
-		skippedModel, skippedBlacklist, skippedInvalid := 0, 0, 0
+		var skipped providerSkipCounts
+		var spendResetAt time.Time
 		for _, provider := range providers {
-			// 基础过滤：enabled、URL、APIKey
-			if !provider.Enabled || provider.APIURL == "" || provider.APIKey == "" {
+			// 基础过滤：enabled、URL、密钥（主密钥或额外密钥池至少一把）
+			if !provider.Enabled || provider.APIURL == "" || len(provider.KeyPool()) == 0 {
 				continue
 			}
 
@@ -1185,7 +1343,7 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 			if errs := provider.ValidateConfiguration(); len(errs) > 0 {
 				fmt.Printf("[WARN] Provider %s 配置验证失败，已自动跳过: %v\n", provider.Name, errs)
 				skippedCount++
-				skippedInvalid++
+				skipped.Invalid++
 				continue
 			}
 
@@ -1193,23 +1351,48 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 			if requestedModel != "" && !provider.IsModelSupported(requestedModel) {
 				fmt.Printf("[INFO] Provider %s 不支持模型 %s，已跳过\n", provider.Name, requestedModel)
 				skippedCount++
-				skippedModel++
+				skipped.Model++
 				continue
 			}
 
+			// 路由规则：只保留规则目标集合内的 provider
+			if rule != nil {
+				if ok, why := rule.Action.allows(provider.Name, provider.Level, provider.GetEffectiveModel(requestedModel)); !ok {
+					fmt.Printf("[INFO] 🧭 Provider %s 被路由规则「%s」排除: %s\n", provider.Name, rule.Name, why)
+					skippedCount++
+					skipped.Rule++
+					skipped.RuleName = rule.Name
+					continue
+				}
+			}
+
 			// 黑名单检查：跳过已拉黑的 provider
 			if isBlacklisted, until := prs.blacklistService.IsBlacklisted(kind, provider.Name); isBlacklisted {
 				fmt.Printf("⛔ Provider %s 已拉黑，过期时间: %v\n", provider.Name, until.Format("15:04:05"))
 				skippedCount++
-				skippedBlacklist++
+				skipped.Blacklist++
+				continue
+			}
+
+			// 消费上限：本周期花费已达上限的 provider 退出调度，直到周期重置
+			if capped, resetAt := prs.spendCapExceeded(kind, provider.Name, provider.SpendCap, provider.SpendCapPeriod); capped {
+				fmt.Printf("💸 Provider %s 已达消费上限，%s 重置\n", provider.Name, resetAt.Format("01-02 15:04"))
+				skippedCount++
+				skipped.Spend++
+				spendResetAt = earlierReset(spendResetAt, resetAt)
 				continue
 			}
 
 			active = append(active, provider)
 		}
+		selectSpan.setSelection(len(providers), len(active), skipped, rule)
 
 		if len(active) == 0 {
-			respondNoEligibleProviders(c, requestedModel, skippedModel, skippedBlacklist, skippedInvalid)
+			if skipped.onlySpend() {
+				respondAllSpendCapped(c, kind, spendResetAt)
+				return
+			}
+			respondNoEligibleProviders(c, requestedModel, skipped)
 			return
 		}
 
@@ -1235,11 +1418,28 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 			levels = append(levels, level)
 		}
 		sort.Ints(levels)
+		selectSpan.set("levels", fmt.Sprint(levels))
+		selectSpan.end()
 
 		fmt.Printf("[INFO] 共 %d 个 Level 分组：%v\n", len(levels), levels)
 
 		query := flattenQuery(c.Request.URL.Query())
 		clientHeaders := cloneHeaders(c.Request.Header)
+		prs.markSessionKey(c, bodyBytes)
+
+		// 对冲请求：主尝试迟迟拿不到响应头时，把同一请求发给同 Level 的下一个供应商
+		hedge := prs.newHedgePlan(isStream, func(p Provider, attempt *hedgeAttempt) (bool, error) {
+			model := p.GetEffectiveModel(requestedModel)
+			body := bodyBytes
+			if model != requestedModel && requestedModel != "" {
+				mapped, err := ReplaceModelInRequestBody(bodyBytes, model)
+				if err != nil {
+					return false, err
+				}
+				body = mapped
+			}
+			return prs.forwardRequestAttempt(c, attempt, kind, p, p.GetEffectiveEndpoint(endpoint), query, clientHeaders, body, isStream, model, configGen)
+		})
 
 		// 获取拉黑功能开关状态
 		blacklistEnabled := prs.blacklistService.ShouldUseFixedMode()
@@ -1250,7 +1450,13 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 		if blacklistEnabled {
 			// 缓存轮询设置（单次请求级别，避免重复读取配置文件）
 			roundRobinSettingEnabled := prs.isRoundRobinSettingEnabled()
-			if roundRobinSettingEnabled {
+			costRoutingEnabled := prs.isCostRoutingEnabled()
+			latencyRouting := prs.latencyScope()
+			if costRoutingEnabled {
+				fmt.Printf("[INFO] 🔒 拉黑模式 + 最低成本优先\n")
+			} else if latencyRouting.enabled {
+				fmt.Printf("[INFO] 🔒 拉黑模式 + 最快优先\n")
+			} else if roundRobinSettingEnabled {
 				fmt.Printf("[INFO] 🔒 拉黑模式 + 轮询负载均衡\n")
 			} else {
 				fmt.Printf("[INFO] 🔒 拉黑模式（顺序调度）\n")
@@ -1281,6 +1487,8 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 			attemptedProviders := map[string]bool{}
 			// 因并发满被跳过、尚未真实尝试的候选
 			busyPending := map[string]concurrencyBusyRef{}
+			// 轮询排序每个 Level 每请求只取一次：等待阶段重扫若重复排序，会白白推进轮询起点与加权轮询的 current
+			rrOrders := map[int][]Provider{}
 			for {
 				busySkipped = 0
 				// 每 pass 重建：上一轮候选可能已被拉黑或删除，残留会让容量门控恒真
@@ -1289,14 +1497,24 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 				for _, level := range levels {
 					providersInLevel := levelGroups[level]
 
-					// 如果启用轮询，对同 Level 的 providers 进行轮询排序
-					if roundRobinSettingEnabled {
-						providersInLevel = prs.roundRobinOrder(kind, level, providersInLevel)
+					// 同 Level 排序：最低成本优先 > 最快优先 > 轮询，都未启用时保持用户排序
+					if costRoutingEnabled {
+						providersInLevel = prs.costOrder(providersInLevel, requestedModel, bodyBytes)
+					} else if latencyRouting.appliesTo(level) {
+						providersInLevel = prs.latencyOrder(kind, providersInLevel)
+					} else if roundRobinSettingEnabled {
+						if _, ok := rrOrders[level]; !ok {
+							rrOrders[level] = prs.roundRobinOrder(kind, level, providersInLevel)
+						}
+						providersInLevel = rrOrders[level]
 					}
+					// 会话粘性：把本会话上次成功的供应商提到首位（加权轮询仍按排序时的首位结算）
+					plannedFirst := providersInLevel[0].Name
+					providersInLevel = prs.stickyOrder(c, kind, providersInLevel)
 
 					fmt.Printf("[INFO] === 尝试 Level %d（%d 个 provider）===\n", level, len(providersInLevel))
 
-					for _, provider := range providersInLevel {
+					for i, provider := range providersInLevel {
 						if attemptedProviders[strconv.FormatInt(provider.ID, 10)] {
 							continue
 						}
@@ -1335,17 +1553,25 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 							fmt.Printf("[INFO] [拉黑模式] Provider: %s (Level %d) | 重试 %d/%d | Model: %s\n",
 								provider.Name, level, retryCount+1, maxRetryPerProvider, effectiveModel)
 
+							// 对冲只在首次尝试时发起：原地重试阶段的等待本就由拉黑阈值控制
+							var hedgeRest []Provider
+							if retryCount == 0 {
+								hedgeRest = providersInLevel[i+1:]
+							}
 							startTime := time.Now()
-							ok, err := prs.forwardRequest(c, kind, provider, effectiveEndpoint, query, clientHeaders, currentBodyBytes, isStream, effectiveModel, configGen)
+							served, ok, err := prs.forwardHedged(c, kind, hedge, provider, func(attempt *hedgeAttempt) (bool, error) {
+								return prs.forwardRequestAttempt(c, attempt, kind, provider, effectiveEndpoint, query, clientHeaders, currentBodyBytes, isStream, effectiveModel, configGen)
+							}, hedgeRest, attemptedProviders)
 							duration := time.Since(startTime)
 
 							if ok {
 								fmt.Printf("[INFO] ✓ 成功: %s | 重试 %d 次 | 耗时: %.2fs\n",
-									provider.Name, retryCount+1, duration.Seconds())
-								if err := prs.blacklistService.RecordSuccess(kind, provider.Name); err != nil {
+									served.Name, retryCount+1, duration.Seconds())
+								if err := prs.blacklistService.RecordSuccess(kind, served.Name); err != nil {
 									fmt.Printf("[WARN] 清零失败计数失败: %v\n", err)
 								}
-								prs.setLastUsedProvider(kind, provider.Name)
+								prs.setLastUsedProvider(kind, served.Name)
+								prs.settleWeightedPick(kind, level, plannedFirst, served.Name)
 								return
 							}
 
@@ -1354,27 +1580,27 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 								totalAttempts--
 								// 已真实失败过的供应商重试遇忙不再进等待候选：
 								// 下一 pass 必然跳过它，等它只会把失败聚合错改成 503
-								if pk := strconv.FormatInt(provider.ID, 10); !attemptedProviders[pk] {
+								if pk := strconv.FormatInt(served.ID, 10); !attemptedProviders[pk] {
 									busySkipped++
-									busyPending[pk] = concurrencyBusyRef{Key: pk, Limit: provider.MaxConcurrency, Gen: configGen}
+									busyPending[pk] = concurrencyBusyRef{Key: pk, Limit: served.MaxConcurrency, Gen: configGen}
 								}
-								fmt.Printf("[INFO] Provider %s 并发已满，跳过\n", provider.Name)
+								fmt.Printf("[INFO] Provider %s %s，跳过\n", served.Name, busySkipReason(err))
 								break
 							}
 							// 实际尝试过：等待阶段重扫不再碰它
-							attemptedProviders[strconv.FormatInt(provider.ID, 10)] = true
-							delete(busyPending, strconv.FormatInt(provider.ID, 10))
+							attemptedProviders[strconv.FormatInt(served.ID, 10)] = true
+							delete(busyPending, strconv.FormatInt(served.ID, 10))
 
 							// 失败处理
 							lastError = err
-							lastProvider = provider.Name
+							lastProvider = served.Name
 
 							errorMsg := "未知错误"
 							if err != nil {
 								errorMsg = err.Error()
 							}
 							fmt.Printf("[WARN] ✗ 失败: %s | 重试 %d/%d | 错误: %s | 耗时: %.2fs\n",
-								provider.Name, retryCount+1, maxRetryPerProvider, errorMsg, duration.Seconds())
+								served.Name, retryCount+1, maxRetryPerProvider, errorMsg, duration.Seconds())
 
 							// 客户端请求被拒绝（不支持的格式/功能）：直接返回 400，不重试不拉黑
 							if errors.Is(err, ErrClientRequestRejected) {
@@ -1403,7 +1629,7 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 							// 上游 2xx 后中途断流：响应已部分写出，不能再换供应商（会写出两段响应），
 							// 但必须计入失败，否则半死的供应商永远不会被拉黑
 							if errors.Is(err, errUpstreamStreamAborted) {
-								if err := prs.blacklistService.RecordFailure(kind, provider.Name); err != nil {
+								if err := prs.blacklistService.RecordFailure(kind, served.Name); err != nil {
 									fmt.Printf("[ERROR] 记录失败到黑名单失败: %v\n", err)
 								}
 								return
@@ -1419,13 +1645,20 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 							sawNonClientError = true
 
 							// 记录失败次数（可能触发拉黑）
-							if err := prs.blacklistService.RecordFailure(kind, provider.Name); err != nil {
+							if err := prs.blacklistService.RecordFailure(kind, served.Name); err != nil {
 								fmt.Printf("[ERROR] 记录失败到黑名单失败: %v\n", err)
 							}
 
 							// 检查是否刚被拉黑
-							if blacklisted, _ := prs.blacklistService.IsBlacklisted(kind, provider.Name); blacklisted {
-								fmt.Printf("[INFO] 🚫 Provider %s 达到失败阈值，已被拉黑，切换到下一个\n", provider.Name)
+							if blacklisted, _ := prs.blacklistService.IsBlacklisted(kind, served.Name); blacklisted {
+								fmt.Printf("[INFO] 🚫 Provider %s 达到失败阈值，已被拉黑，切换到下一个\n", served.Name)
+								break
+							}
+
+							// 对冲方抢到响应后失败：端点、模型映射与请求体都是按主供应商准备的，
+							// 不能拿它们原地重试对冲方；失败已记在对冲方名下，直接切下一供应商
+							if served.ID != provider.ID {
+								fmt.Printf("[INFO] 对冲供应商 %s 失败，不原地重试，切换到下一个\n", served.Name)
 								break
 							}
 
@@ -1433,7 +1666,7 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 							// （那会放大成 阈值×地址数 次网络发送），失败已计一次，
 							// 直接切下一供应商
 							if errors.Is(err, errEndpointPoolExhausted) {
-								fmt.Printf("[INFO] Provider %s 地址池耗尽，切换下一供应商\n", provider.Name)
+								fmt.Printf("[INFO] Provider %s 地址池耗尽，切换下一供应商\n", served.Name)
 								break
 							}
 
@@ -1441,9 +1674,7 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 							// 此时继续重试只是白烧上游额度
 							if retryCount < maxRetryPerProvider-1 {
 								fmt.Printf("[INFO] ⏳ 等待 %d 秒后重试...\n", retryWaitSeconds)
-								select {
-								case <-time.After(time.Duration(retryWaitSeconds) * time.Second):
-								case <-c.Request.Context().Done():
+								if !waitBeforeRetry(c, served.Name, errorMsg, time.Duration(retryWaitSeconds)*time.Second) {
 									fmt.Printf("[INFO] 等待重试期间客户端已断开，停止尝试\n")
 									return
 								}
@@ -1469,20 +1700,7 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 					}
 					enteredBusyWait = true
 				}
-				// 唤醒以"忙候选真的有空位"为门控：本轮实际尝试供应商的正常释放
-				// 也会触发全局信号，不加门控直接重扫会形成自唤醒重试风暴
-				woke := false
-				for {
-					capSignal := prs.concurrency.releaseSignal()
-					if prs.concurrency.anyCapacity(kind, busyPending) {
-						woke = true
-						break
-					}
-					if !prs.concurrency.waitForRelease(c.Request.Context(), busyWaitDeadline, capSignal) {
-						break
-					}
-				}
-				if !woke {
+				if !prs.waitForBusyCapacity(c, kind, busyPending, busyWaitDeadline) {
 					respondAllBusy(c, kind)
 					return
 				}
@@ -1514,7 +1732,13 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 
 		// 【降级模式】：拉黑功能关闭，失败自动尝试下一个 provider
 		roundRobinEnabled := prs.isRoundRobinEnabled()
-		if roundRobinEnabled {
+		costRoutingEnabled := prs.isCostRoutingEnabled()
+		latencyRouting := prs.latencyScope()
+		if costRoutingEnabled {
+			fmt.Printf("[INFO] 🔄 降级模式 + 最低成本优先\n")
+		} else if latencyRouting.enabled {
+			fmt.Printf("[INFO] 🔄 降级模式 + 最快优先\n")
+		} else if roundRobinEnabled {
 			fmt.Printf("[INFO] 🔄 降级模式 + 轮询负载均衡\n")
 		} else {
 			fmt.Printf("[INFO] 🔄 降级模式（顺序降级）\n")
@@ -1539,6 +1763,8 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 		attemptedProviders := map[string]bool{}
 		// 因并发满被跳过、尚未真实尝试的候选
 		busyPending := map[string]concurrencyBusyRef{}
+		// 轮询排序每个 Level 每请求只取一次：等待阶段重扫若重复排序，会白白推进轮询起点与加权轮询的 current
+		rrOrders := map[int][]Provider{}
 		for {
 			busySkipped = 0
 			// 每 pass 重建：上一轮候选可能已被拉黑或删除，残留会让容量门控恒真
@@ -1546,10 +1772,20 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 			for _, level := range levels {
 				providersInLevel := levelGroups[level]
 
-				// 如果启用轮询，对同 Level 的 providers 进行轮询排序
-				if roundRobinEnabled {
-					providersInLevel = prs.roundRobinOrder(kind, level, providersInLevel)
+				// 同 Level 排序：最低成本优先 > 最快优先 > 轮询，都未启用时保持用户排序
+				if costRoutingEnabled {
+					providersInLevel = prs.costOrder(providersInLevel, requestedModel, bodyBytes)
+				} else if latencyRouting.appliesTo(level) {
+					providersInLevel = prs.latencyOrder(kind, providersInLevel)
+				} else if roundRobinEnabled {
+					if _, ok := rrOrders[level]; !ok {
+						rrOrders[level] = prs.roundRobinOrder(kind, level, providersInLevel)
+					}
+					providersInLevel = rrOrders[level]
 				}
+				// 会话粘性：把本会话上次成功的供应商提到首位（加权轮询仍按排序时的首位结算）
+				plannedFirst := providersInLevel[0].Name
+				providersInLevel = prs.stickyOrder(c, kind, providersInLevel)
 
 				fmt.Printf("[INFO] === 尝试 Level %d（%d 个 provider）===\n", level, len(providersInLevel))
 
@@ -1582,19 +1818,22 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 					// 获取有效的端点（用户配置优先）
 					effectiveEndpoint := provider.GetEffectiveEndpoint(endpoint)
 					startTime := time.Now()
-					ok, err := prs.forwardRequest(c, kind, provider, effectiveEndpoint, query, clientHeaders, currentBodyBytes, isStream, effectiveModel, configGen)
+					served, ok, err := prs.forwardHedged(c, kind, hedge, provider, func(attempt *hedgeAttempt) (bool, error) {
+						return prs.forwardRequestAttempt(c, attempt, kind, provider, effectiveEndpoint, query, clientHeaders, currentBodyBytes, isStream, effectiveModel, configGen)
+					}, providersInLevel[i+1:], attemptedProviders)
 					duration := time.Since(startTime)
 
 					if ok {
-						fmt.Printf("[INFO]   ✓ Level %d 成功: %s | 耗时: %.2fs\n", level, provider.Name, duration.Seconds())
+						fmt.Printf("[INFO]   ✓ Level %d 成功: %s | 耗时: %.2fs\n", level, served.Name, duration.Seconds())
 
 						// 成功：清零连续失败计数
-						if err := prs.blacklistService.RecordSuccess(kind, provider.Name); err != nil {
+						if err := prs.blacklistService.RecordSuccess(kind, served.Name); err != nil {
 							fmt.Printf("[WARN] 清零失败计数失败: %v\n", err)
 						}
 
 						// 记录最后使用的供应商
-						prs.setLastUsedProvider(kind, provider.Name)
+						prs.setLastUsedProvider(kind, served.Name)
+						prs.settleWeightedPick(kind, level, plannedFirst, served.Name)
 
 						return // 成功，立即返回
 					}
@@ -1603,18 +1842,18 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 					if errors.Is(err, errProviderBusy) {
 						totalAttempts--
 						busySkipped++
-						pk := strconv.FormatInt(provider.ID, 10)
-						busyPending[pk] = concurrencyBusyRef{Key: pk, Limit: provider.MaxConcurrency, Gen: configGen}
-						fmt.Printf("[INFO] Provider %s 并发已满，跳过\n", provider.Name)
+						pk := strconv.FormatInt(served.ID, 10)
+						busyPending[pk] = concurrencyBusyRef{Key: pk, Limit: served.MaxConcurrency, Gen: configGen}
+						fmt.Printf("[INFO] Provider %s %s，跳过\n", served.Name, busySkipReason(err))
 						continue
 					}
 					// 实际尝试过：等待阶段重扫不再碰它
-					attemptedProviders[strconv.FormatInt(provider.ID, 10)] = true
-					delete(busyPending, strconv.FormatInt(provider.ID, 10))
+					attemptedProviders[strconv.FormatInt(served.ID, 10)] = true
+					delete(busyPending, strconv.FormatInt(served.ID, 10))
 
 					// 失败：记录错误并尝试下一个
 					lastError = err
-					lastProvider = provider.Name
+					lastProvider = served.Name
 					lastDuration = duration
 
 					errorMsg := "未知错误"
@@ -1622,7 +1861,7 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 						errorMsg = err.Error()
 					}
 					fmt.Printf("[WARN]   ✗ Level %d 失败: %s | 错误: %s | 耗时: %.2fs\n",
-						level, provider.Name, errorMsg, duration.Seconds())
+						level, served.Name, errorMsg, duration.Seconds())
 
 					// 客户端请求被拒绝（不支持的格式/功能）：直接返回 400，不重试不拉黑
 					if errors.Is(err, ErrClientRequestRejected) {
@@ -1643,13 +1882,13 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 
 					// 客户端中断不计入失败次数，且没必要再换供应商
 					if errors.Is(err, errClientAbort) {
-						fmt.Printf("[INFO] 客户端中断，跳过失败计数: %s\n", provider.Name)
+						fmt.Printf("[INFO] 客户端中断，跳过失败计数: %s\n", served.Name)
 						return
 					}
 
 					// 上游 2xx 后中途断流：响应已部分写出，不能再降级，但必须计入失败
 					if errors.Is(err, errUpstreamStreamAborted) {
-						if err := prs.blacklistService.RecordFailure(kind, provider.Name); err != nil {
+						if err := prs.blacklistService.RecordFailure(kind, served.Name); err != nil {
 							fmt.Printf("[ERROR] 记录失败到黑名单失败: %v\n", err)
 						}
 						return
@@ -1660,7 +1899,7 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 						fmt.Printf("[INFO] 上游拒绝请求内容，不计供应商失败: %s\n", errorMsg)
 					} else {
 						sawNonClientError = true
-						if err := prs.blacklistService.RecordFailure(kind, provider.Name); err != nil {
+						if err := prs.blacklistService.RecordFailure(kind, served.Name); err != nil {
 							fmt.Printf("[ERROR] 记录失败到黑名单失败: %v\n", err)
 						}
 					}
@@ -1687,7 +1926,7 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 						}
 						if nextProvider != "" {
 							prs.notificationService.NotifyProviderSwitch(SwitchNotification{
-								FromProvider: provider.Name,
+								FromProvider: served.Name,
 								ToProvider:   nextProvider,
 								Reason:       errorMsg,
 								Platform:     kind,
@@ -1711,20 +1950,7 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 				}
 				enteredBusyWait = true
 			}
-			// 唤醒以"忙候选真的有空位"为门控：本轮实际尝试供应商的正常释放
-			// 也会触发全局信号，不加门控直接重扫会形成自唤醒重试风暴
-			woke := false
-			for {
-				capSignal := prs.concurrency.releaseSignal()
-				if prs.concurrency.anyCapacity(kind, busyPending) {
-					woke = true
-					break
-				}
-				if !prs.concurrency.waitForRelease(c.Request.Context(), busyWaitDeadline, capSignal) {
-					break
-				}
-			}
-			if !woke {
+			if !prs.waitForBusyCapacity(c, kind, busyPending, busyWaitDeadline) {
 				respondAllBusy(c, kind)
 				return
 			}
@@ -1765,21 +1991,118 @@ func (prs *ProviderRelayService) forwardRequest(
 	isStream bool,
 	model string,
 	configGen int64,
+) (bool, error) {
+	return prs.forwardRequestAttempt(c, nil, kind, provider, endpoint, query, clientHeaders, bodyBytes, isStream, model, configGen)
+}
+
+// forwardRequestAttempt 同 forwardRequest；attempt 非空时本次转发是对冲竞速中的一方
+// （见 hedged_relay.go），只有胜出的一方会写客户端
+func (prs *ProviderRelayService) forwardRequestAttempt(
+	c *gin.Context,
+	attempt *hedgeAttempt,
+	kind string,
+	provider Provider,
+	endpoint string,
+	query map[string]string,
+	clientHeaders map[string]string,
+	bodyBytes []byte,
+	isStream bool,
+	model string,
+	configGen int64,
 ) (bool, error) {
 	headers := cloneMap(clientHeaders)
 
 	// ========== 协议转换检测 ==========
 	upstreamProtocol := provider.ResolveUpstreamProtocol(endpoint)
-	var sseConverter *OpenAIToAnthropicSSEConverter
+	// newConverter 非空表示需要协议转换；转换器有状态，每个地址尝试新建一个
+	var newConverter func() protocolResponseConverter
 	var convertInfo ConvertInfo
 
 	// codex 走的是 OpenAI Responses 协议，请求体不是 Anthropic Messages 格式。
 	// 若供应商被误配成 openai_chat，套用 Anthropic→OpenAI 转换只会产出无意义的请求体，
 	// 这里直接按原样转发并告警，避免静默损坏请求。
-	if upstreamProtocol == UpstreamProtocolOpenAIChat && kind == "codex" {
-		fmt.Printf("[协议转换] Provider %s 被配置为 OpenAI Chat，但 codex 使用 Responses 协议，跳过请求体转换\n", provider.Name)
+	// openai 平台客户端本就是 Chat 协议，openai_chat 即原样转发，无需告警
+	if upstreamProtocol == UpstreamProtocolOpenAIChat && kind == "openai" {
 		upstreamProtocol = UpstreamProtocolAnthropic
 	}
+	if (upstreamProtocol == UpstreamProtocolOpenAIChat || upstreamProtocol == UpstreamProtocolGemini) && isOpenAIClientPlatform(kind) {
+		fmt.Printf("[协议转换] Provider %s 被配置为 %s，但 %s 使用 OpenAI 协议，跳过请求体转换\n", provider.Name, upstreamProtocol, kind)
+		upstreamProtocol = UpstreamProtocolAnthropic
+	}
+	// anthropic_messages 只对 codex/openai 意味着转换；客户端本就是 Messages 协议的平台原样转发
+	if upstreamProtocol == UpstreamProtocolAnthropicMessages && !isOpenAIClientPlatform(kind) {
+		upstreamProtocol = UpstreamProtocolAnthropic
+	}
+
+	// codex/openai 上游是 Anthropic Messages：Responses / Chat 请求转换为 /v1/messages
+	if upstreamProtocol == UpstreamProtocolAnthropicMessages {
+		var convertedBody []byte
+		var info ConvertInfo
+		var err error
+		if kind == "openai" {
+			fmt.Printf("[协议转换] Provider %s 使用 Anthropic Messages 协议（Chat → Messages）\n", provider.Name)
+			convertedBody, info, err = ConvertOpenAIChatToAnthropic(bodyBytes)
+		} else {
+			fmt.Printf("[协议转换] Provider %s 使用 Anthropic Messages 协议（Responses → Messages）\n", provider.Name)
+			convertedBody, info, err = ConvertResponsesToAnthropic(bodyBytes)
+		}
+		if err != nil {
+			return false, err
+		}
+		bodyBytes = convertedBody
+		convertInfo = info
+
+		if len(info.DroppedFields) > 0 {
+			fmt.Printf("[协议转换] 丢弃字段: %v\n", info.DroppedFields)
+		}
+		// 未单独配置端点时，默认端点（/responses、/v1/chat/completions）换成 Messages 端点
+		if strings.TrimSpace(provider.APIEndpoint) == "" {
+			endpoint = "/v1/messages"
+		}
+		if kind == "openai" {
+			includeUsage := info.IncludeUsage
+			newConverter = func() protocolResponseConverter {
+				return NewAnthropicToOpenAIChatSSEConverter(model, includeUsage)
+			}
+		} else {
+			customTools := info.CustomTools
+			newConverter = func() protocolResponseConverter {
+				return NewAnthropicToResponsesSSEConverter(model, customTools)
+			}
+		}
+	}
+
+	// openai 平台原样转发：流式请求补 stream_options.include_usage，否则上游不回报用量、日志计 0
+	if kind == "openai" && upstreamProtocol == UpstreamProtocolAnthropic && isStream &&
+		!gjson.GetBytes(bodyBytes, "stream_options.include_usage").Exists() {
+		if patched, err := sjson.SetBytes(bodyBytes, "stream_options.include_usage", true); err == nil {
+			bodyBytes = patched
+		}
+	}
+
+	// 上游是 Gemini：Messages 请求转换为 generateContent，模型名与流式方式体现在 URL 上
+	if upstreamProtocol == UpstreamProtocolGemini {
+		fmt.Printf("[协议转换] Provider %s 使用 Gemini 协议\n", provider.Name)
+
+		convertedBody, info, err := ConvertAnthropicToGemini(bodyBytes)
+		if err != nil {
+			return false, err
+		}
+		bodyBytes = convertedBody
+		convertInfo = info
+
+		if len(info.DroppedFields) > 0 {
+			fmt.Printf("[协议转换] 丢弃顶层字段: %v\n", info.DroppedFields)
+		}
+		endpoint = geminiUpstreamEndpoint(provider.GetEffectiveEndpoint(""), model, isStream)
+		if isStream {
+			query = cloneMap(query)
+			query["alt"] = "sse"
+		}
+		newConverter = func() protocolResponseConverter {
+			return NewGeminiToAnthropicSSEConverter(model)
+		}
+	}
 
 	// 如果上游是 OpenAI Chat，需要转换请求体
 	if upstreamProtocol == UpstreamProtocolOpenAIChat {
@@ -1807,7 +2130,9 @@ func (prs *ProviderRelayService) forwardRequest(
 		}
 
 		// 创建 SSE 转换器（用于响应处理）
-		sseConverter = NewOpenAIToAnthropicSSEConverter(model)
+		newConverter = func() protocolResponseConverter {
+			return NewOpenAIToAnthropicSSEConverter(model)
+		}
 	}
 	_ = convertInfo // 避免未使用警告
 
@@ -1819,37 +2144,16 @@ func (prs *ProviderRelayService) forwardRequest(
 		headers = sanitizeHeaders(headers, provider.SanitizeConfig)
 	}
 
-	// 根据认证方式设置请求头（默认 Bearer，与 v2.2.x 保持一致）
-	authType := strings.ToLower(strings.TrimSpace(provider.ConnectivityAuthType))
-	switch authType {
-	case "x-api-key":
-		// 仅当用户显式选择 x-api-key 时使用（Anthropic 官方 API）
-		setHeaderCanonical(headers, "x-api-key", provider.APIKey)
-		// 只有 Anthropic 协议的 Anthropic 类平台才注入 anthropic-version，
-		// codex 的 /responses 是 OpenAI Responses 协议，注入该头没有意义
-		if upstreamProtocol == UpstreamProtocolAnthropic && kind != "codex" {
-			setHeaderCanonical(headers, "anthropic-version", "2023-06-01")
-		}
-	case "", "bearer":
-		// 默认使用 Bearer token（兼容所有第三方中转）
-		setHeaderCanonical(headers, "authorization", fmt.Sprintf("Bearer %s", provider.APIKey))
-	default:
-		// 自定义 Header 名
-		headerName := strings.TrimSpace(provider.ConnectivityAuthType)
-		if headerName == "" || strings.EqualFold(headerName, "custom") {
-			headerName = "Authorization"
-		}
-		setHeaderCanonical(headers, headerName, provider.APIKey)
+	// 多密钥供应商：按选择策略与冷却状态排定本次请求的密钥顺序，先用首把
+	keys := provider.KeyPool()
+	multiKey := len(keys) > 1
+	if multiKey {
+		keys = prs.apiKeys.Order(kind, provider.ID, keys, provider.KeySelection)
 	}
-
-	// OpenAI 协议时移除 Anthropic 专用头
-	if upstreamProtocol == UpstreamProtocolOpenAIChat {
-		deleteHeaderFold(headers, "anthropic-version", "anthropic-beta", "x-api-key")
-		// 确保使用 Bearer 认证（上一步可能把 x-api-key 型凭据删掉了）
-		if getHeaderFold(headers, "authorization") == "" {
-			setHeaderCanonical(headers, "authorization", fmt.Sprintf("Bearer %s", provider.APIKey))
-		}
+	if len(keys) == 0 {
+		keys = []string{""}
 	}
+	injectProviderCredential(headers, kind, provider, upstreamProtocol, keys[0])
 
 	if getHeaderFold(headers, "accept") == "" {
 		setHeaderCanonical(headers, "accept", "application/json")
@@ -1864,21 +2168,42 @@ func (prs *ProviderRelayService) forwardRequest(
 		}
 	}
 
+	// 请求改写规则：在凭据注入与请求清理之后执行，抓包录制的即为改写后的实际出站请求。
+	// 改写前的 headers 留底：换密钥时从留底重建，避免 replace 规则在已改写的值上叠加
+	preRewriteHeaders := cloneMap(headers)
+	if len(provider.RewriteRules) > 0 {
+		var bodyApplied []string
+		bodyBytes, bodyApplied = applyBodyRewrites(bodyBytes, provider.RewriteRules)
+		if applied := append(applyHeaderRewrites(headers, provider.RewriteRules), bodyApplied...); len(applied) > 0 {
+			fmt.Printf("[Rewrite] Provider %s: %v\n", provider.Name, applied)
+		}
+	}
+
 	// 并发配额：在本地校验/协议转换之后获取——满载时不能把本应确定
 	// 返回的 400 客户端错误变成"忙"。占用覆盖地址池遍历与 SSE 转发全程
 	// （本函数同步转发到流结束才返回），defer 释放即为流结束时机。
+	// RPM/TPM 限速与并发一同检查：限速同样按"忙"跳过
 	concurrencyProviderKey := strconv.FormatInt(provider.ID, 10)
-	if !prs.concurrency.TryAcquire(kind, concurrencyProviderKey, provider.MaxConcurrency, configGen) {
-		return false, errProviderBusy
+	if err := prs.concurrency.Acquire(kind, concurrencyProviderKey, provider.MaxConcurrency, provider.RPMLimit, provider.TPMLimit, configGen); err != nil {
+		return false, err
 	}
 	defer prs.concurrency.Release(kind, concurrencyProviderKey)
+	// 对冲发起的并发尝试不算供应商切换
+	if !attempt.isHedge() {
+		prs.metrics.noteAttempt(c, kind, provider.Name)
+	}
+	span := startAttemptSpan(c, provider.Name, model, attempt.isHedge())
 
 	requestLog := &ReqeustLog{
-		Platform: kind,
-		Provider: provider.Name,
-		Model:    model,
-		IsStream: isStream,
-	}
+		Platform:        kind,
+		Provider:        provider.Name,
+		Model:           model,
+		IsStream:        isStream,
+		PriceMultiplier: provider.GetPriceMultiplier(model),
+	}
+	requestLog.SessionKey, requestLog.StickyHit = prs.sessionAffinityFor(c, kind, provider.Name)
+	requestLog.ClientName = clientNameOf(c)
+	requestLog.span = span
 	// 抓包模式（全量不脱敏）：录制终态出站 headers/body（映射/清理/认证注入均已
 	// 完成，即实际进 transport 前的应用层形态），并在转发时补 URL 与响应。
 	// URL/response 按"实际尝试"在地址池循环内设置，此处只做请求侧一次性采集。
@@ -1899,24 +2224,87 @@ func (prs *ProviderRelayService) forwardRequest(
 			requestLog.respBuf.release()
 		}
 		requestLog.DurationSec = time.Since(start).Seconds()
+		// TPM 按实际用量扣减（失败、中途断流的请求同样消耗了上游额度）
+		prs.concurrency.ConsumeTokens(kind, concurrencyProviderKey, requestLog.InputTokens+requestLog.OutputTokens)
+		prs.spend.record(kind, provider.Name, requestLog)
+		// 对冲落败方照常落库，但标记出来，不算作一次失败
+		requestLog.Hedged = attempt.isLost()
 		// 若请求过程中发生 rename,把旧名兑换成新名再落库
 		requestLog.Provider = ResolveProviderAlias(requestLog.Platform, requestLog.Provider)
 		// 读锁覆盖"代次校验 + 提交"全程,与清除的写锁互斥,堵死校验后提交前的清除窗口
 		prs.captureWriteMu.RLock()
 		defer prs.captureWriteMu.RUnlock()
 		prs.stripStaleCapture(requestLog)
+		prs.metrics.observeRequest(requestLog)
+		span.set("input_tokens", requestLog.InputTokens)
+		span.set("output_tokens", requestLog.OutputTokens)
+		span.end()
 
 		if err := prs.writeRequestLog(requestLog); err != nil {
 			fmt.Printf("写入 request_log 失败: %v\n", err)
 		}
 	}()
 
-	// ========== 地址池遍历（issue #27）==========
-	// 单地址供应商：保持旧路径 SetRetry(1,500ms) 的错误聚合语义（xrequest 的
-	// Do() 在 attempts=1 时从不真正重发，只把网络错误/5xx 聚合成 resp+err）。
-	// 多地址供应商：同一请求内每个地址至多试一次，仅传输层失败/408/421/429/5xx
-	// 且响应未提交时切下一地址；全部失败返回 errEndpointPoolExhausted，
-	// 调用方记一次供应商失败后立即换供应商。整个池遍历共用上面这一条 requestLog。
+	// ========== 密钥池遍历 ==========
+	// 单密钥供应商：直接走地址池，只记密钥统计不冷却。
+	// 多密钥供应商：401/402/403/429 或额度耗尽且响应未提交时冷却当前密钥、
+	// 换下一把重走地址池；全部失败返回 errAPIKeyPoolExhausted（调度上等同地址池耗尽）。
+	// 整个密钥×地址遍历共用上面这一条 requestLog
+	var lastErr error
+	for i, apiKey := range keys {
+		if i > 0 {
+			// 从改写前的留底重建，清掉上一把密钥写入的凭据头再注入（OpenAI Chat 分支只在 authorization 为空时补写）
+			headers = cloneMap(preRewriteHeaders)
+			deleteHeaderFold(headers, "authorization", "x-api-key", "x-goog-api-key")
+			injectProviderCredential(headers, kind, provider, upstreamProtocol, apiKey)
+			applyHeaderRewrites(headers, provider.RewriteRules)
+			prs.resetAttemptLog(requestLog)
+			if requestLog.respBuf != nil {
+				requestLog.RequestHeaders = rawRequestHeaders(headers)
+			}
+			fmt.Printf("[INFO] Provider %s 密钥轮换: 改用 %s\n", provider.Name, maskAPIKey(apiKey))
+		}
+
+		ok, err := prs.forwardAcrossAddresses(c, attempt, kind, provider, endpoint, query, headers, bodyBytes, isStream, newConverter, requestLog)
+		if ok {
+			prs.apiKeys.MarkSuccess(kind, provider.ID, apiKey)
+			return true, nil
+		}
+		lastErr = err
+		if !multiKey || !apiKeySwitchableError(err) || attempt.responseStarted(c) {
+			if countsAsAPIKeyFailure(err) {
+				prs.apiKeys.MarkFailure(kind, provider.ID, apiKey, 0, err)
+			}
+			span.recordError(err)
+			return false, err
+		}
+		prs.apiKeys.MarkFailure(kind, provider.ID, apiKey, apiKeyCooldownOf(err), err)
+		fmt.Printf("[WARN] Provider %s 密钥 %s 失败，冷却后改用下一把: %v\n", provider.Name, maskAPIKey(apiKey), err)
+	}
+	exhaustedErr := fmt.Errorf("%w: %v", errAPIKeyPoolExhausted, lastErr)
+	span.recordError(exhaustedErr)
+	return false, exhaustedErr
+}
+
+// forwardAcrossAddresses 用当前凭据遍历供应商地址池（issue #27）。
+// 单地址供应商：保持旧路径 SetRetry(1,500ms) 的错误聚合语义（xrequest 的
+// Do() 在 attempts=1 时从不真正重发，只把网络错误/5xx 聚合成 resp+err）。
+// 多地址供应商：同一请求内每个地址至多试一次，仅传输层失败/408/421/429/5xx
+// 且响应未提交时切下一地址；全部失败返回 errEndpointPoolExhausted，
+// 调用方记一次供应商失败后立即换供应商。整个池遍历共用调用方的这一条 requestLog。
+func (prs *ProviderRelayService) forwardAcrossAddresses(
+	c *gin.Context,
+	attempt *hedgeAttempt,
+	kind string,
+	provider Provider,
+	endpoint string,
+	query map[string]string,
+	headers map[string]string,
+	bodyBytes []byte,
+	isStream bool,
+	newConverter func() protocolResponseConverter,
+	requestLog *ReqeustLog,
+) (bool, error) {
 	pool := provider.EndpointPool()
 	if len(pool) == 0 {
 		return false, fmt.Errorf("provider %s 没有可用的 API 地址", provider.Name)
@@ -1927,31 +2315,29 @@ func (prs *ProviderRelayService) forwardRequest(
 	}
 
 	var lastErr error
+	var converter protocolResponseConverter
+	if newConverter != nil {
+		converter = newConverter()
+	}
 	primaryKey := normalizeURL(provider.APIURL)
+	// 每个地址一个 upstream.request 子 span，转发期间挂在 requestLog 上供
+	// forwardToAddress 记录首字节与流转发；返回前还原为供应商尝试的 span
+	attemptSpan := requestLog.span
+	defer func() { requestLog.span = attemptSpan }()
 	for i, addr := range pool {
 		if i > 0 {
-			// 上一地址的失败状态码不能残留进本次尝试的日志
-			requestLog.HttpCode = 0
-			// 抓包按"终态尝试"记录：切地址时丢弃上一地址的 URL/响应捕获，
-			// 释放其占用的在途预算并重建缓冲，否则最终成功行会带上一地址的响应
-			if requestLog.respBuf != nil {
-				requestLog.RequestURL = ""
-				requestLog.ResponseHeaders = ""
-				requestLog.ResponseBody = ""
-				requestLog.RespTruncated = false
-				requestLog.RespBytes = 0
-				requestLog.BudgetSkipped = false
-				requestLog.respBuf.release()
-				requestLog.respBuf = newCaptureBuffer(&prs.captureInflightBytes)
-			}
+			prs.resetAttemptLog(requestLog)
 			// SSE 转换器有状态，跨地址复用会串流，换新
-			if sseConverter != nil {
-				sseConverter = NewOpenAIToAnthropicSSEConverter(model)
+			if newConverter != nil {
+				converter = newConverter()
 			}
 			fmt.Printf("[INFO] Provider %s 地址兜底: 改试 %s\n", provider.Name, addr)
 		}
 
-		ok, err := prs.forwardToAddress(c, kind, provider, joinURL(addr, endpoint), query, headers, bodyBytes, isStream, sseConverter, requestLog, !multiAddress)
+		requestLog.span = attemptSpan.child("upstream.request", spanKindClient)
+		requestLog.span.set("address", traceAddress(addr))
+		ok, err := prs.forwardToAddress(c, attempt, kind, provider, joinURL(addr, endpoint), query, headers, bodyBytes, isStream, converter, requestLog, !multiAddress)
+		requestLog.span.endUpstream(provider.Name, requestLog.HttpCode, err)
 		if ok {
 			if multiAddress {
 				prs.endpointCooldowns.MarkSuccess(kind, provider.ID, addr)
@@ -1960,6 +2346,8 @@ func (prs *ProviderRelayService) forwardRequest(
 					fmt.Printf("[WARN] Provider %s 主地址失败或冷却中，备用地址 %s 接管本次请求\n", provider.Name, addr)
 				}
 			}
+			prs.bindSessionAffinity(c, kind, provider.Name)
+			prs.observeCacheHeartbeat(c, kind, provider, joinURL(addr, endpoint), query, headers, bodyBytes, newConverter == nil, requestLog)
 			return true, nil
 		}
 
@@ -1967,13 +2355,83 @@ func (prs *ProviderRelayService) forwardRequest(
 		if !multiAddress {
 			return false, err
 		}
-		if !addressSwitchableError(err) || c.Writer.Written() {
+		if !addressSwitchableError(err) || attempt.responseStarted(c) {
 			return false, err
 		}
 		prs.endpointCooldowns.MarkFailure(kind, provider.ID, addr, retryAfterOf(err))
 		fmt.Printf("[WARN] Provider %s 地址 %s 失败，冷却后改试下一地址: %v\n", provider.Name, addr, err)
 	}
-	return false, fmt.Errorf("%w: %v", errEndpointPoolExhausted, lastErr)
+	// lastErr 一并包装：外层密钥循环要据其状态码判断是否换密钥
+	return false, fmt.Errorf("%w: %w", errEndpointPoolExhausted, lastErr)
+}
+
+// resetAttemptLog 切换地址或密钥重发前清掉上一次尝试的痕迹：失败状态码不能残留进
+// 本次尝试的日志；抓包按"终态尝试"记录，丢弃上一次的 URL/响应捕获，
+// 释放其占用的在途预算并重建缓冲，否则最终成功行会带上一次尝试的响应
+func (prs *ProviderRelayService) resetAttemptLog(requestLog *ReqeustLog) {
+	requestLog.HttpCode = 0
+	if requestLog.respBuf != nil {
+		requestLog.RequestURL = ""
+		requestLog.ResponseHeaders = ""
+		requestLog.ResponseBody = ""
+		requestLog.RespTruncated = false
+		requestLog.RespBytes = 0
+		requestLog.BudgetSkipped = false
+		requestLog.respBuf.release()
+		requestLog.respBuf = newCaptureBuffer(&prs.captureInflightBytes)
+	}
+}
+
+// injectProviderCredential 按供应商认证方式与上游协议写入凭据头（默认 Bearer，与 v2.2.x 保持一致）。
+// 多密钥供应商换密钥时重复调用，同名头直接覆盖
+func injectProviderCredential(headers map[string]string, kind string, provider Provider, upstreamProtocol UpstreamProtocolType, apiKey string) {
+	authType := strings.ToLower(strings.TrimSpace(provider.ConnectivityAuthType))
+	switch authType {
+	case "x-api-key":
+		// 仅当用户显式选择 x-api-key 时使用（Anthropic 官方 API）
+		setHeaderCanonical(headers, "x-api-key", apiKey)
+		// 只有 Anthropic 协议的 Anthropic 类平台才注入 anthropic-version，
+		// codex 的 /responses 与 openai 的 /v1/chat/completions 是 OpenAI 协议，注入该头没有意义
+		if upstreamProtocol == UpstreamProtocolAnthropic && !isOpenAIClientPlatform(kind) {
+			setHeaderCanonical(headers, "anthropic-version", "2023-06-01")
+		}
+	case "", "bearer":
+		// 默认使用 Bearer token（兼容所有第三方中转）
+		setHeaderCanonical(headers, "authorization", fmt.Sprintf("Bearer %s", apiKey))
+	default:
+		// 自定义 Header 名
+		headerName := strings.TrimSpace(provider.ConnectivityAuthType)
+		if headerName == "" || strings.EqualFold(headerName, "custom") {
+			headerName = "Authorization"
+		}
+		setHeaderCanonical(headers, headerName, apiKey)
+	}
+
+	// Responses / Chat → Messages 转换时补 anthropic-version（官方 API 必填，中转一般忽略），
+	// 并移除 Codex CLI 与 OpenAI SDK 携带的 OpenAI 专用头
+	if upstreamProtocol == UpstreamProtocolAnthropicMessages {
+		setHeaderCanonical(headers, "anthropic-version", "2023-06-01")
+		deleteHeaderFold(headers, "openai-beta", "openai-organization", "openai-project", "chatgpt-account-id")
+	}
+
+	// Gemini 协议：凭据改走 x-goog-api-key（用户自定义头名时保留其选择），移除 Anthropic 专用头
+	if upstreamProtocol == UpstreamProtocolGemini {
+		deleteHeaderFold(headers, "anthropic-version", "anthropic-beta", "x-api-key")
+		if authType == "" || authType == "bearer" || authType == "x-api-key" {
+			deleteHeaderFold(headers, "authorization")
+			setHeaderCanonical(headers, "x-goog-api-key", apiKey)
+		}
+	}
+
+	// OpenAI 协议时移除 Anthropic 专用头
+	if upstreamProtocol == UpstreamProtocolOpenAIChat {
+		deleteHeaderFold(headers, "anthropic-version", "anthropic-beta", "x-api-key")
+		// 确保使用 Bearer 认证（上一步可能把 x-api-key 型凭据删掉了）
+		if getHeaderFold(headers, "authorization") == "" {
+			setHeaderCanonical(headers, "authorization", fmt.Sprintf("Bearer %s", apiKey))
+		}
+	}
+
 }
 
 // forwardToAddress 向单个地址发一次请求并转发响应。
@@ -1981,6 +2439,7 @@ func (prs *ProviderRelayService) forwardRequest(
 // 多地址路径 5xx 由调用方按状态码分支处理，重试预算统一由地址池承担。
 func (prs *ProviderRelayService) forwardToAddress(
 	c *gin.Context,
+	attempt *hedgeAttempt,
 	kind string,
 	provider Provider,
 	targetURL string,
@@ -1988,7 +2447,7 @@ func (prs *ProviderRelayService) forwardToAddress(
 	headers map[string]string,
 	bodyBytes []byte,
 	isStream bool,
-	sseConverter *OpenAIToAnthropicSSEConverter,
+	converter protocolResponseConverter,
 	requestLog *ReqeustLog,
 	singleAddress bool,
 ) (bool, error) {
@@ -1999,12 +2458,24 @@ func (prs *ProviderRelayService) forwardToAddress(
 	// 响应包装为 xrequest.Response（不预读、不缓存解析结果），保证 dev 与 release
 	// 都从 RawResponse.Body 的字节流层 tee 抓包。
 	// singleAddress 保持旧路径 SetRetry(1,500ms) 的错误聚合语义（该配置实际从不重发）。
+	attemptStart := time.Now()
 	resp, finalURL, err := relayDoPost(
-		c.Request.Context(),
-		relayClientFor(provider.InsecureSkipVerify, provider.Name),
+		attempt.requestContext(c),
+		relayClientFor(provider.InsecureSkipVerify, provider.ProxyURL, provider.Name),
 		targetURL, query, headers, bodyBytes, singleAddress,
 		relayBudgetRemaining(c),
 	)
+	if resp != nil {
+		requestLog.span.set("ttfb_ms", time.Since(attemptStart).Milliseconds())
+	}
+
+	// 对冲竞速中已落败：本次尝试被取消，结果一律不作数
+	if attempt.isLost() {
+		if resp != nil && resp.RawResponse != nil && resp.RawResponse.Body != nil {
+			resp.RawResponse.Body.Close()
+		}
+		return false, errHedgeLost
+	}
 
 	// 抓包：记录本次实际尝试的完整 URL（含查询参数，不脱敏）
 	if requestLog.respBuf != nil {
@@ -2072,11 +2543,34 @@ func (prs *ProviderRelayService) forwardToAddress(
 	// 状态码为 0 且无错误：当作成功处理
 	if status == 0 {
 		fmt.Printf("[WARN] Provider %s 返回状态码 0，但无错误，当作成功处理\n", provider.Name)
-		return prs.relayResponseToClient(c, kind, provider, resp, sseConverter, isStream, requestLog)
 	}
 
-	if status >= http.StatusOK && status < http.StatusMultipleChoices {
-		return prs.relayResponseToClient(c, kind, provider, resp, sseConverter, isStream, requestLog)
+	if status == 0 || (status >= http.StatusOK && status < http.StatusMultipleChoices) {
+		// 对冲竞速：先拿到响应头的一方独占客户端，另一方立即取消
+		if !attempt.claim() {
+			if resp.RawResponse != nil && resp.RawResponse.Body != nil {
+				resp.RawResponse.Body.Close()
+			}
+			return false, errHedgeLost
+		}
+		// 流式请求：探测首字节时刻，成功后计入延迟统计（最快优先排序用）
+		var probe *firstByteReader
+		if isStream && resp.RawResponse != nil && resp.RawResponse.Body != nil {
+			probe = &firstByteReader{ReadCloser: resp.RawResponse.Body}
+			resp.RawResponse.Body = probe
+		}
+		relaySpan := requestLog.span.child("stream.relay", spanKindInternal)
+		relaySpan.set("stream", isStream)
+		ok, err := prs.relayResponseToClient(c, kind, provider, resp, converter, isStream, requestLog)
+		if ok {
+			prs.latency.observeStream(kind, provider.Name, attemptStart, probe, requestLog.OutputTokens)
+		}
+		if probe != nil && !probe.first.IsZero() {
+			relaySpan.set("first_byte_ms", probe.first.Sub(attemptStart).Milliseconds())
+		}
+		relaySpan.set("output_tokens", requestLog.OutputTokens)
+		relaySpan.finish(err)
+		return ok, err
 	}
 
 	// 尝试从响应体提取供应商原始错误信息（同时入抓包缓冲）
@@ -2112,7 +2606,7 @@ func (prs *ProviderRelayService) relayResponseToClient(
 	kind string,
 	provider Provider,
 	resp *xrequest.Response,
-	sseConverter *OpenAIToAnthropicSSEConverter,
+	converter protocolResponseConverter,
 	isStream bool,
 	requestLog *ReqeustLog,
 ) (bool, error) {
@@ -2121,22 +2615,37 @@ func (prs *ProviderRelayService) relayResponseToClient(
 	if requestLog.respBuf != nil && resp.RawResponse != nil && resp.RawResponse.Body != nil {
 		resp.RawResponse.Body = newCaptureTeeReader(resp.RawResponse.Body, requestLog.respBuf)
 	}
+	// 流式响应先经前导缓冲：内容开始前的断流不向客户端写任何字节，可安全降级
+	var gate *ssePreambleGate
+	if isStream {
+		gate = newSSEPreambleGate(c.Writer)
+	}
 	var copyErr error
-	if sseConverter != nil && isStream {
+	if converter != nil && isStream {
 		// 使用协议转换 Hook
-		_, copyErr = resp.ToHttpResponseWriter(c.Writer, protocolConvertHook(sseConverter, kind, requestLog))
+		_, copyErr = resp.ToHttpResponseWriter(gate, protocolConvertHook(converter, kind, requestLog))
+		copyErr = gate.finish(copyErr)
 		// 上游未发 [DONE] 就断开时补齐终止事件序列，否则客户端一直等 message_stop，
 		// 且 message_delta 里已捕获的 usage 也会随之丢失。
 		// 只在响应确实已经开始写出时才补：一个字节都没写出去的失败要留给降级重试，
 		// 否则会给客户端伪造一条"完整但空"的消息，用户看到空回答还没有任何报错。
-		if c.Writer.Written() {
-			if tail := sseConverter.FinalizeIfUnterminated(); tail != "" {
-				parseEventPayload(tail, ClaudeCodeParseTokenUsageFromResponse, requestLog)
+		// Anthropic 协议客户端读流出错时改发 error 事件（见下方），不补"正常结束"序列
+		if c.Writer.Written() && (copyErr == nil || isOpenAIClientPlatform(kind)) {
+			if tail := converter.FinalizeIfUnterminated(); tail != "" {
+				converter.ParseUsage("", tail, requestLog)
 				if _, writeErr := c.Writer.Write([]byte(tail)); writeErr == nil {
 					c.Writer.Flush()
 				}
 			}
 		}
+	} else if converter != nil {
+		// 协议转换的非流式请求：整体读取上游响应再转成客户端协议。
+		// 不能走 ToHttpResponseWriter 的 hook——它会原样复制上游 Content-Length，
+		// 而转换后的响应体长度必然不同
+		copyErr = writeConvertedNonStreamResponse(c, resp, converter, requestLog)
+	} else if isStream {
+		_, copyErr = resp.ToHttpResponseWriter(gate, ReqeustLogHook(c, kind, requestLog))
+		copyErr = gate.finish(copyErr)
 	} else {
 		var written int64
 		written, copyErr = resp.ToHttpResponseWriter(c.Writer, ReqeustLogHook(c, kind, requestLog))
@@ -2161,15 +2670,51 @@ func (prs *ProviderRelayService) relayResponseToClient(
 
 	// 一个字节都没写给客户端（例如 xrequest 在 Peek 阶段就读失败，此时响应头还没发出）：
 	// 仍可安全降级到下一个供应商，按普通失败上报
+	// 流式响应还停在前导阶段（message_start/ping 已被缓冲、未写出）同样适用
 	if !c.Writer.Written() {
 		fmt.Printf("[WARN] Provider %s 响应读取失败且尚未写出任何内容，可降级: %v\n", provider.Name, copyErr)
 		return false, fmt.Errorf("upstream read failed before response started: %w", copyErr)
 	}
 
 	fmt.Printf("[WARN] Provider %s 上游中途断流（响应已部分写出，无法降级）: %v\n", provider.Name, copyErr)
+	if isStream && !isOpenAIClientPlatform(kind) {
+		writeAnthropicStreamError(c, fmt.Sprintf("upstream stream interrupted: %v", copyErr))
+	}
 	return false, fmt.Errorf("%w: %v", errUpstreamStreamAborted, copyErr)
 }
 
+// writeConvertedNonStreamResponse 把上游非流式响应经协议转换后写给客户端，并解析 usage。
+// 读取或转换失败时尚未写出任何内容，调用方可照常降级。
+// 这里自己 ReadAll 并检查错误，截断的响应体会直接报错，无需 Content-Length 兜底校验。
+func writeConvertedNonStreamResponse(c *gin.Context, resp *xrequest.Response, converter protocolResponseConverter, requestLog *ReqeustLog) error {
+	if resp.RawResponse == nil || resp.RawResponse.Body == nil {
+		return fmt.Errorf("empty response body")
+	}
+	defer func() {
+		_ = resp.RawResponse.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.RawResponse.Body)
+	if err != nil {
+		return err
+	}
+	converted, err := converter.ConvertResponse(body)
+	if err != nil {
+		return fmt.Errorf("协议转换失败: %w", err)
+	}
+
+	converter.ParseUsage(string(body), string(converted), requestLog)
+
+	status := resp.StatusCode()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(status)
+	_, err = c.Writer.Write(converted)
+	return err
+}
+
 // extractUpstreamError 读取上游错误响应体，返回 ≤512 字节的错误信息预览。
 // 读取策略分层：
 //   - 非抓包：解压后至多读 64KiB——错误体只用于拼错误串，无界 ReadAll 在
@@ -2326,6 +2871,14 @@ func joinURL(base string, endpoint string) string {
 	return base + endpoint
 }
 
+// effectiveLogPriceMultiplier 未设置（0）或非法的倍率按 1（官方价）落库
+func effectiveLogPriceMultiplier(m float64) float64 {
+	if m <= 0 {
+		return 1
+	}
+	return m
+}
+
 func boolToInt(b bool) int {
 	if b {
 		return 1
@@ -2440,6 +2993,11 @@ func ensureRequestLogTableWithDB(db *sql.DB) error {
 		{"response_truncated", "INTEGER DEFAULT 0"},
 		{"response_bytes", "INTEGER DEFAULT 0"},
 		{"budget_skipped", "INTEGER DEFAULT 0"},
+		{"price_multiplier", "REAL DEFAULT 1"},
+		{"hedged", "INTEGER DEFAULT 0"},
+		{"session_key", "TEXT DEFAULT ''"},
+		{"sticky_hit", "INTEGER DEFAULT 0"},
+		{"client_name", "TEXT DEFAULT ''"},
 	}
 	for _, m := range migrations {
 		if err := ensureRequestLogColumn(db, m.column, m.definition); err != nil {
@@ -2447,26 +3005,31 @@ func ensureRequestLogTableWithDB(db *sql.DB) error {
 		}
 	}
 
+	// Prompt Cache 保活心跳日志（独立表，费用统计与 request_log 合并口径）
+	if err := ensureCacheHeartbeatTable(db); err != nil {
+		return err
+	}
+
 	// 抓包会话表与索引（依赖 capture_session_id 列已就位）
 	return ensureCaptureSessionTable(db)
 }
 
-// protocolConvertHook 协议转换 Hook：将 OpenAI SSE 转换为 Anthropic SSE，并提取 usage
+// protocolConvertHook 协议转换 Hook：将上游 SSE 转换为客户端协议的 SSE，并提取 usage
 // 注意：xrequest 的 hook 是逐行回调（每次收到一行 SSE 数据）
-func protocolConvertHook(converter *OpenAIToAnthropicSSEConverter, kind string, usage *ReqeustLog) func(data []byte) (bool, []byte) {
+func protocolConvertHook(converter protocolResponseConverter, kind string, usage *ReqeustLog) func(data []byte) (bool, []byte) {
 	return func(data []byte) (bool, []byte) {
 		// xrequest 逐行回调，直接传给 ProcessLine
 		line := string(data)
 		converted := converter.ProcessLine(line)
 
+		// usage 可能只出现在不产生输出的上游行里（如 message_delta），先于丢弃判断提取
+		converter.ParseUsage(line, converted, usage)
+
 		// 如果没有输出，返回 flush=false 丢弃该行（避免写出空行）
 		if converted == "" {
 			return false, nil
 		}
 
-		// 从转换后的 Anthropic SSE 中提取 usage（使用现有解析器）
-		parseEventPayload(converted, ClaudeCodeParseTokenUsageFromResponse, usage)
-
 		// 返回转换后的数据
 		return true, []byte(converted)
 	}
@@ -2482,6 +3045,8 @@ func ReqeustLogHook(c *gin.Context, kind string, usage *ReqeustLog) func(data []
 			parserFn = CodexParseTokenUsageFromResponse
 		case "gemini":
 			parserFn = GeminiParseTokenUsageFromResponse
+		case "openai":
+			parserFn = OpenAIChatParseTokenUsageFromResponse
 		}
 		parseEventPayload(payload, parserFn, usage)
 
@@ -2509,7 +3074,7 @@ func parseEventPayload(payload string, parser func(string, *ReqeustLog), usage *
 
 type ReqeustLog struct {
 	ID                int64  `json:"id"`
-	Platform          string `json:"platform"` // claude、codex 或 gemini
+	Platform          string `json:"platform"` // claude、codex、gemini 或 openai
 	Model             string `json:"model"`
 	Provider          string `json:"provider"` // provider name
 	HttpCode          int    `json:"http_code"`
@@ -2536,6 +3101,16 @@ type ReqeustLog struct {
 	Ephemeral1hCost float64 `json:"ephemeral_1h_cost"`
 	TotalCost       float64 `json:"total_cost"`
 	HasPricing      bool    `json:"has_pricing"`
+	// PriceMultiplier 请求时供应商对该模型的价格倍率，费用字段均为官方价乘以该倍率
+	PriceMultiplier float64 `json:"price_multiplier"`
+	// Hedged 对冲竞速中落败被取消的尝试（不计失败、不计入供应商统计）
+	Hedged bool `json:"hedged"`
+	// SessionKey 会话粘性识别出的会话标识（哈希），未开启粘性或无法识别时为空
+	SessionKey string `json:"session_key"`
+	// StickyHit 本次尝试发往会话已粘住的供应商
+	StickyHit bool `json:"sticky_hit"`
+	// ClientName 客户端标识：访问令牌名，未开启客户端认证时取 X-Code-Switch-Client 自报名，无法识别时为空
+	ClientName string `json:"client_name"`
 	// HasCapture 列表查询计算列：该行是否录有抓包数据（前端据此显示"查看详情"）
 	HasCapture bool `json:"has_capture"`
 
@@ -2558,6 +3133,8 @@ type ReqeustLog struct {
 	captureGen int64
 	// respBuf 响应体累积缓冲（仅录制请求上创建，转发协程内单线程写）
 	respBuf *captureBuffer
+	// span 当前尝试的追踪 span（未开启追踪为 nil；不落库不序列化）
+	span *traceSpan
 }
 
 // claude code usage parser
@@ -2631,6 +3208,33 @@ func CodexParseTokenUsageFromResponse(data string, usage *ReqeustLog) {
 	}
 }
 
+// openai usage parser(OpenAI Chat Completions API)
+// 流式只有末尾 usage chunk 携带用量(需 stream_options.include_usage),非流式在根级 usage
+func OpenAIChatParseTokenUsageFromResponse(data string, usage *ReqeustLog) {
+	usageResult := gjson.Get(data, "usage")
+	if !usageResult.Exists() || usageResult.Type == gjson.Null {
+		return
+	}
+	promptTokens := int(usageResult.Get("prompt_tokens").Int())
+	completionTokens := int(usageResult.Get("completion_tokens").Int())
+	cacheReadTokens := int(usageResult.Get("prompt_tokens_details.cached_tokens").Int())
+	reasoningTokens := int(usageResult.Get("completion_tokens_details.reasoning_tokens").Int())
+	if cacheReadTokens > promptTokens {
+		cacheReadTokens = promptTokens
+	}
+	if reasoningTokens > completionTokens {
+		reasoningTokens = completionTokens
+	}
+	// 与 Responses 相同:prompt_tokens 含缓存命中、completion_tokens 含推理,拆开分别计价
+	usage.InputTokens = promptTokens - cacheReadTokens
+	usage.OutputTokens = completionTokens - reasoningTokens
+	usage.CacheReadTokens = cacheReadTokens
+	usage.ReasoningTokens = reasoningTokens
+	if rawTier := gjson.Get(data, "service_tier").String(); strings.TrimSpace(rawTier) != "" {
+		usage.ServiceTier = string(modelpricing.NormalizeObservedServiceTier(rawTier, warnUnknownTier))
+	}
+}
+
 // clampCacheEphemerals 兜底 Anthropic ephemeral 拆分的异常情况:
 // 若 5m+1h > total,打印一次警告并截断到 total(保留 5m 优先级,1h 截掉超出部分)。
 // 若 split 非零但 total 为 0,把 total 回填为 split 之和,避免 total 被漏传导致 create cost 计 0。
@@ -2877,17 +3481,35 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 
 		// 从 endpoint 提取请求模型名（Gemini 的模型在 URL 路径而非请求体中）
 		requestedModel := extractGeminiModelFromEndpoint(endpoint)
+		prs.markSessionKey(c, bodyBytes)
+		if prs.rejectOverQuotaClient(c, "gemini") {
+			return
+		}
+
+		// 路由规则：Gemini 的模型在 URL 路径中，改写模型即改写 endpoint
+		rule := prs.resolveRoutingRule("gemini", c.Request.Header, clientNameOf(c), bodyBytes, requestedModel)
+		if rule != nil && rule.Action.ModelOverride != "" && requestedModel != "" && rule.Action.ModelOverride != requestedModel {
+			fmt.Printf("[Gemini] 🧭 路由规则「%s」改写模型: %s → %s\n", rule.Name, requestedModel, rule.Action.ModelOverride)
+			endpoint = rewriteGeminiModelInEndpoint(endpoint, requestedModel, rule.Action.ModelOverride)
+			requestedModel = rule.Action.ModelOverride
+		}
+
+		selectSpan := startTraceSpan(c, "provider.select")
+		selectSpan.set("model", requestedModel)
+		defer selectSpan.end()
 
 		// 加载 Gemini providers（与配置代数配对）
 		providers, geminiGen := prs.geminiService.providersWithGen()
 		if len(providers) == 0 {
-			respondNoEligibleProviders(c, requestedModel, 0, 0, 0)
+			selectSpan.setSelection(0, 0, providerSkipCounts{}, rule)
+			respondNoEligibleProviders(c, requestedModel, providerSkipCounts{})
 			return
 		}
 
 		// 1. 过滤可用的 providers（启用 + BaseURL 配置 + 配置合法 + 支持请求模型 + 未被拉黑）
 		var activeProviders []GeminiProvider
-		skippedModel, skippedBlacklist, skippedInvalid := 0, 0, 0
+		var skipped providerSkipCounts
+		var spendResetAt time.Time
 		for _, p := range providers {
 			if !p.Enabled || p.BaseURL == "" {
 				continue
@@ -2895,14 +3517,14 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 			// 配置验证：失败自动跳过（与 Claude/Codex 行为一致）
 			if errs := p.ValidateConfiguration(); len(errs) > 0 {
 				fmt.Printf("[Gemini] ⚠️ Provider %s 配置验证失败，已自动跳过: %v\n", p.Name, errs)
-				skippedInvalid++
+				skipped.Invalid++
 				continue
 			}
 			if requestedModel != "" {
 				// 模型白名单过滤：不支持请求模型的 provider 直接跳过
 				if !p.IsModelSupported(requestedModel) {
 					fmt.Printf("[Gemini] ℹ️ Provider %s 不支持模型 %s，已跳过\n", p.Name, requestedModel)
-					skippedModel++
+					skipped.Model++
 					continue
 				}
 				// 白名单非空时，最终转发的 effective model 必须仍在白名单内。
@@ -2911,15 +3533,31 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 				if len(p.SupportedModels) > 0 {
 					if effective := p.GetEffectiveModel(requestedModel); !modelInWhitelist(p.SupportedModels, effective) {
 						fmt.Printf("[Gemini] ⚠️ Provider %s 映射结果 %s 不在白名单中，已跳过\n", p.Name, effective)
-						skippedModel++
+						skipped.Model++
 						continue
 					}
 				}
 			}
+			// 路由规则：只保留规则目标集合内的 provider
+			if rule != nil {
+				if ok, why := rule.Action.allows(p.Name, p.Level, p.GetEffectiveModel(requestedModel)); !ok {
+					fmt.Printf("[Gemini] 🧭 Provider %s 被路由规则「%s」排除: %s\n", p.Name, rule.Name, why)
+					skipped.Rule++
+					skipped.RuleName = rule.Name
+					continue
+				}
+			}
 			// 检查黑名单
 			if isBlacklisted, until := prs.blacklistService.IsBlacklisted("gemini", p.Name); isBlacklisted {
 				fmt.Printf("[Gemini] ⛔ Provider %s 已拉黑，过期时间: %v\n", p.Name, until.Format("15:04:05"))
-				skippedBlacklist++
+				skipped.Blacklist++
+				continue
+			}
+			// 消费上限
+			if capped, resetAt := prs.spendCapExceeded("gemini", p.Name, p.SpendCap, p.SpendCapPeriod); capped {
+				fmt.Printf("[Gemini] 💸 Provider %s 已达消费上限，%s 重置\n", p.Name, resetAt.Format("01-02 15:04"))
+				skipped.Spend++
+				spendResetAt = earlierReset(spendResetAt, resetAt)
 				continue
 			}
 			// Level 默认值处理
@@ -2928,9 +3566,14 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 			}
 			activeProviders = append(activeProviders, p)
 		}
+		selectSpan.setSelection(len(providers), len(activeProviders), skipped, rule)
 
 		if len(activeProviders) == 0 {
-			respondNoEligibleProviders(c, requestedModel, skippedModel, skippedBlacklist, skippedInvalid)
+			if skipped.onlySpend() {
+				respondAllSpendCapped(c, "gemini", spendResetAt)
+				return
+			}
+			respondNoEligibleProviders(c, requestedModel, skipped)
 			return
 		}
 
@@ -2946,6 +3589,8 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 			sortedLevels = append(sortedLevels, level)
 		}
 		sort.Ints(sortedLevels)
+		selectSpan.set("levels", fmt.Sprint(sortedLevels))
+		selectSpan.end()
 
 		fmt.Printf("[Gemini] 共 %d 个 Level 分组: %v\n", len(sortedLevels), sortedLevels)
 
@@ -2977,6 +3622,7 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 			prs.captureWriteMu.RLock()
 			defer prs.captureWriteMu.RUnlock()
 			prs.stripStaleCapture(requestLog)
+			prs.metrics.observeRequest(requestLog)
 			if err := prs.writeRequestLog(requestLog); err != nil {
 				fmt.Printf("[Gemini] 写入 request_log 失败: %v\n", err)
 			}
@@ -2989,7 +3635,13 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 		if blacklistEnabled {
 			// 缓存轮询设置（单次请求级别，避免重复读取配置文件）
 			roundRobinSettingEnabled := prs.isRoundRobinSettingEnabled()
-			if roundRobinSettingEnabled {
+			costRoutingEnabled := prs.isCostRoutingEnabled()
+			latencyRouting := prs.latencyScope()
+			if costRoutingEnabled {
+				fmt.Printf("[Gemini] 🔒 拉黑模式 + 最低成本优先\n")
+			} else if latencyRouting.enabled {
+				fmt.Printf("[Gemini] 🔒 拉黑模式 + 最快优先\n")
+			} else if roundRobinSettingEnabled {
 				fmt.Printf("[Gemini] 🔒 拉黑模式 + 轮询负载均衡\n")
 			} else {
 				fmt.Printf("[Gemini] 🔒 拉黑模式（顺序调度）\n")
@@ -3020,6 +3672,8 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 			attemptedProviders := map[string]bool{}
 			// 因并发满被跳过、尚未真实尝试的候选
 			busyPending := map[string]concurrencyBusyRef{}
+			// 轮询排序每个 Level 每请求只取一次：等待阶段重扫若重复排序，会白白推进轮询起点
+			rrOrders := map[int][]GeminiProvider{}
 			for {
 				busySkipped = 0
 				// 每 pass 重建：上一轮候选可能已被拉黑或删除，残留会让容量门控恒真
@@ -3028,10 +3682,20 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 				for _, level := range sortedLevels {
 					providersInLevel := levelGroups[level]
 
-					// 如果启用轮询，对同 Level 的 providers 进行轮询排序
-					if roundRobinSettingEnabled {
-						providersInLevel = prs.roundRobinOrderGemini(level, providersInLevel)
+					// 同 Level 排序：最低成本优先 > 最快优先 > 轮询，都未启用时保持用户排序
+					if costRoutingEnabled {
+						providersInLevel = prs.costOrderGemini(providersInLevel, requestedModel, bodyBytes)
+					} else if latencyRouting.appliesTo(level) {
+						providersInLevel = prs.latencyOrderGemini(providersInLevel)
+					} else if roundRobinSettingEnabled {
+						if _, ok := rrOrders[level]; !ok {
+							rrOrders[level] = prs.roundRobinOrderGemini(level, providersInLevel)
+						}
+						providersInLevel = rrOrders[level]
 					}
+					// 会话粘性：把本会话上次成功的供应商提到首位（加权轮询仍按排序时的首位结算）
+					plannedFirst := providersInLevel[0].Name
+					providersInLevel = prs.stickyOrderGemini(c, providersInLevel)
 
 					fmt.Printf("[Gemini] === 尝试 Level %d（%d 个 provider）===\n", level, len(providersInLevel))
 
@@ -3067,8 +3731,8 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 
 							// 并发配额：每次尝试独立占用，重试间隙让出；
 							// 满载不算尝试、不计失败，换下一个供应商
-							if !prs.concurrency.TryAcquire("gemini", provider.ID, provider.MaxConcurrency, geminiGen) {
-								fmt.Printf("[Gemini] Provider %s 并发已满，跳过\n", provider.Name)
+							if err := prs.concurrency.Acquire("gemini", provider.ID, provider.MaxConcurrency, provider.RPMLimit, provider.TPMLimit, geminiGen); err != nil {
+								fmt.Printf("[Gemini] Provider %s %s，跳过\n", provider.Name, busySkipReason(err))
 								// 已真实失败过的供应商重试遇忙不再进等待候选：
 								// 下一 pass 必然跳过它，等它只会把失败聚合错改成 503
 								if !attemptedProviders[provider.ID] {
@@ -3101,6 +3765,7 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 								fmt.Printf("[Gemini] ✓ 成功: %s | 重试 %d 次\n", provider.Name, retryCount+1)
 								_ = prs.blacklistService.RecordSuccess("gemini", provider.Name)
 								prs.setLastUsedProvider("gemini", provider.Name)
+								prs.settleWeightedPick("gemini", level, plannedFirst, provider.Name)
 								return
 							}
 
@@ -3149,9 +3814,7 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 							// 等待后重试（除非是最后一次）；等待期间客户端可能已经离开
 							if retryCount < maxRetryPerProvider-1 {
 								fmt.Printf("[Gemini] ⏳ 等待 %d 秒后重试...\n", retryWaitSeconds)
-								select {
-								case <-time.After(time.Duration(retryWaitSeconds) * time.Second):
-								case <-c.Request.Context().Done():
+								if !waitBeforeRetry(c, provider.Name, errMsg, time.Duration(retryWaitSeconds)*time.Second) {
 									fmt.Printf("[Gemini] 等待重试期间客户端已断开，停止尝试\n")
 									return
 								}
@@ -3172,20 +3835,7 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 					}
 					enteredBusyWait = true
 				}
-				// 唤醒以"忙候选真的有空位"为门控：本轮实际尝试供应商的正常释放
-				// 也会触发全局信号，不加门控直接重扫会形成自唤醒重试风暴
-				woke := false
-				for {
-					capSignal := prs.concurrency.releaseSignal()
-					if prs.concurrency.anyCapacity("gemini", busyPending) {
-						woke = true
-						break
-					}
-					if !prs.concurrency.waitForRelease(c.Request.Context(), busyWaitDeadline, capSignal) {
-						break
-					}
-				}
-				if !woke {
+				if !prs.waitForBusyCapacity(c, "gemini", busyPending, busyWaitDeadline) {
 					respondAllBusy(c, "gemini")
 					return
 				}
@@ -3222,7 +3872,13 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 
 		// 【降级模式】：按 Level 顺序尝试所有 provider
 		roundRobinEnabled := prs.isRoundRobinEnabled()
-		if roundRobinEnabled {
+		costRoutingEnabled := prs.isCostRoutingEnabled()
+		latencyRouting := prs.latencyScope()
+		if costRoutingEnabled {
+			fmt.Printf("[Gemini] 🔄 降级模式 + 最低成本优先\n")
+		} else if latencyRouting.enabled {
+			fmt.Printf("[Gemini] 🔄 降级模式 + 最快优先\n")
+		} else if roundRobinEnabled {
 			fmt.Printf("[Gemini] 🔄 降级模式 + 轮询负载均衡\n")
 		} else {
 			fmt.Printf("[Gemini] 🔄 降级模式（顺序降级）\n")
@@ -3243,6 +3899,8 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 		attemptedProviders := map[string]bool{}
 		// 因并发满被跳过、尚未真实尝试的候选
 		busyPending := map[string]concurrencyBusyRef{}
+		// 轮询排序每个 Level 每请求只取一次：等待阶段重扫若重复排序，会白白推进轮询起点
+		rrOrders := map[int][]GeminiProvider{}
 		for {
 			busySkipped = 0
 			// 每 pass 重建：上一轮候选可能已被拉黑或删除，残留会让容量门控恒真
@@ -3250,10 +3908,20 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 			for _, level := range sortedLevels {
 				providersInLevel := levelGroups[level]
 
-				// 如果启用轮询，对同 Level 的 providers 进行轮询排序
-				if roundRobinEnabled {
-					providersInLevel = prs.roundRobinOrderGemini(level, providersInLevel)
+				// 同 Level 排序：最低成本优先 > 最快优先 > 轮询，都未启用时保持用户排序
+				if costRoutingEnabled {
+					providersInLevel = prs.costOrderGemini(providersInLevel, requestedModel, bodyBytes)
+				} else if latencyRouting.appliesTo(level) {
+					providersInLevel = prs.latencyOrderGemini(providersInLevel)
+				} else if roundRobinEnabled {
+					if _, ok := rrOrders[level]; !ok {
+						rrOrders[level] = prs.roundRobinOrderGemini(level, providersInLevel)
+					}
+					providersInLevel = rrOrders[level]
 				}
+				// 会话粘性：把本会话上次成功的供应商提到首位（加权轮询仍按排序时的首位结算）
+				plannedFirst := providersInLevel[0].Name
+				providersInLevel = prs.stickyOrderGemini(c, providersInLevel)
 
 				fmt.Printf("[Gemini] === 尝试 Level %d（%d 个 provider）===\n", level, len(providersInLevel))
 
@@ -3264,8 +3932,8 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 					fmt.Printf("[Gemini]   [%d/%d] Provider: %s\n", idx+1, len(providersInLevel), provider.Name)
 
 					// 并发配额：满载不算尝试、不计失败，换下一个供应商
-					if !prs.concurrency.TryAcquire("gemini", provider.ID, provider.MaxConcurrency, geminiGen) {
-						fmt.Printf("[Gemini] Provider %s 并发已满，跳过\n", provider.Name)
+					if err := prs.concurrency.Acquire("gemini", provider.ID, provider.MaxConcurrency, provider.RPMLimit, provider.TPMLimit, geminiGen); err != nil {
+						fmt.Printf("[Gemini] Provider %s %s，跳过\n", provider.Name, busySkipReason(err))
 						busySkipped++
 						busyPending[provider.ID] = concurrencyBusyRef{Key: provider.ID, Limit: provider.MaxConcurrency, Gen: geminiGen}
 						continue
@@ -3296,6 +3964,7 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 						_ = prs.blacklistService.RecordSuccess("gemini", provider.Name)
 						// 记录最后使用的供应商
 						prs.setLastUsedProvider("gemini", provider.Name)
+						prs.settleWeightedPick("gemini", level, plannedFirst, provider.Name)
 						fmt.Printf("[Gemini] ✓ 请求完成 | Provider: %s | 总耗时: %.2fs\n", provider.Name, time.Since(start).Seconds())
 						return // 成功，退出
 					}
@@ -3343,20 +4012,7 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 				}
 				enteredBusyWait = true
 			}
-			// 唤醒以"忙候选真的有空位"为门控：本轮实际尝试供应商的正常释放
-			// 也会触发全局信号，不加门控直接重扫会形成自唤醒重试风暴
-			woke := false
-			for {
-				capSignal := prs.concurrency.releaseSignal()
-				if prs.concurrency.anyCapacity("gemini", busyPending) {
-					woke = true
-					break
-				}
-				if !prs.concurrency.waitForRelease(c.Request.Context(), busyWaitDeadline, capSignal) {
-					break
-				}
-			}
-			if !woke {
+			if !prs.waitForBusyCapacity(c, "gemini", busyPending, busyWaitDeadline) {
 				respondAllBusy(c, "gemini")
 				return
 			}
@@ -3457,10 +4113,32 @@ func (prs *ProviderRelayService) forwardGeminiRequest(
 	requestLog *ReqeustLog,
 ) (success bool, errMsg string, responseWritten bool) {
 	providerStart := time.Now()
+	prs.metrics.noteAttempt(c, "gemini", provider.Name)
 
 	// 构建目标 URL
 	targetURL := strings.TrimSuffix(provider.BaseURL, "/") + endpoint
 
+	// Gemini 供应商只有单一地址：尝试与地址两层 span 一并建立，流程与主链路一致
+	span := startAttemptSpan(c, provider.Name, extractGeminiModelFromEndpoint(endpoint), false)
+	upstreamSpan := span.child("upstream.request", spanKindClient)
+	upstreamSpan.set("address", traceAddress(provider.BaseURL))
+	defer func() {
+		var err error
+		if !success {
+			err = errors.New(errMsg)
+			if errMsg == geminiClientAbortMsg {
+				err = errClientAbort
+			}
+		}
+		upstreamSpan.endUpstream(provider.Name, requestLog.HttpCode, err)
+		span.set("input_tokens", requestLog.InputTokens)
+		span.set("output_tokens", requestLog.OutputTokens)
+		span.finish(err)
+		// TPM 按实际用量扣减（失败、中途断流的请求同样消耗了上游额度）
+		prs.concurrency.ConsumeTokens("gemini", provider.ID, requestLog.InputTokens+requestLog.OutputTokens)
+		prs.spend.record("gemini", provider.Name, requestLog)
+	}()
+
 	// 预先填充日志，保证失败也能记录 provider 和模型
 	requestLog.Provider = provider.Name
 	// 【修复】每次尝试开始前重置 HttpCode，避免重试时沿用上一次的状态码
@@ -3489,6 +4167,9 @@ func (prs *ProviderRelayService) forwardGeminiRequest(
 	} else {
 		requestLog.Model = provider.Model
 	}
+	requestLog.PriceMultiplier = provider.GetPriceMultiplier(requestLog.Model)
+	requestLog.SessionKey, requestLog.StickyHit = prs.sessionAffinityFor(c, "gemini", provider.Name)
+	requestLog.ClientName = clientNameOf(c)
 
 	// 创建 HTTP 请求（绑定客户端 context:客户端取消时立即释放上游连接,
 	// 配合 32h 长超时不至于让被放弃的请求占用资源）
@@ -3507,7 +4188,7 @@ func (prs *ProviderRelayService) forwardGeminiRequest(
 	// （透传后 Go 不再自动解压，流式 usageMetadata 解析拿到压缩字节，计费恒为 0）
 	for _, name := range []string{
 		"Authorization", "Proxy-Authorization", "X-Api-Key", "Api-Key", "X-Goog-Api-Key",
-		"Accept-Encoding", "Connection", "Keep-Alive", "Te", "Upgrade",
+		clientNameHeader, "Accept-Encoding", "Connection", "Keep-Alive", "Te", "Upgrade",
 	} {
 		req.Header.Del(name)
 	}
@@ -3532,8 +4213,11 @@ func (prs *ProviderRelayService) forwardGeminiRequest(
 
 	// 发送请求（与 Claude/Codex 转发共用连接池，避免每请求新建 Transport；
 	// 超时同为 32 小时以适配长推理/长输出任务，提前中止依靠请求 context）
-	resp, err := relayClientFor(provider.InsecureSkipVerify, provider.Name).Do(req)
+	resp, err := relayClientFor(provider.InsecureSkipVerify, provider.ProxyURL, provider.Name).Do(req)
 	providerDuration := time.Since(providerStart).Seconds()
+	if err == nil {
+		upstreamSpan.set("ttfb_ms", time.Since(providerStart).Milliseconds())
+	}
 
 	if err != nil {
 		// 客户端取消(context 已终止)不是供应商故障:立即止损,不计失败
@@ -3582,6 +4266,9 @@ func (prs *ProviderRelayService) forwardGeminiRequest(
 	fmt.Printf("[Gemini]   ✓ 连接成功: %s | HTTP %d | 耗时: %.2fs\n", provider.Name, resp.StatusCode, providerDuration)
 
 	// 处理响应
+	relaySpan := upstreamSpan.child("stream.relay", spanKindInternal)
+	relaySpan.set("stream", isStream)
+	defer relaySpan.end()
 	if isStream {
 		// 流式模式：先写 header 再流式传输
 		for key, values := range resp.Header {
@@ -3592,7 +4279,14 @@ func (prs *ProviderRelayService) forwardGeminiRequest(
 		c.Status(resp.StatusCode)
 		c.Writer.Flush()
 		// 【重要】从 Flush() 开始，响应头已写入客户端，任何失败都不能重试
-		copyErr := streamGeminiResponseWithHook(resp.Body, c.Writer, requestLog)
+		// 探测首字节时刻，成功后计入延迟统计（最快优先排序用）
+		probe := &firstByteReader{ReadCloser: resp.Body}
+		copyErr := streamGeminiResponseWithHook(probe, c.Writer, requestLog)
+		if !probe.first.IsZero() {
+			relaySpan.set("first_byte_ms", probe.first.Sub(providerStart).Milliseconds())
+		}
+		relaySpan.set("output_tokens", requestLog.OutputTokens)
+		relaySpan.recordError(copyErr)
 		if copyErr != nil {
 			// 客户端主动断开（如用户取消）不是供应商故障。
 			// 取消发生在等待上游下一个 chunk 时（最常见时序）不会有写失败，
@@ -3608,9 +4302,11 @@ func (prs *ProviderRelayService) forwardGeminiRequest(
 			// 流式传输中断：已写入部分响应，客户端会收到不完整数据
 			return false, fmt.Sprintf("流式传输中断: %v", copyErr), true
 		}
+		prs.latency.observeStream("gemini", provider.Name, providerStart, probe, requestLog.OutputTokens)
 	} else {
 		// 非流式模式：先读完 body 再写 header（允许读取失败时重试）
 		body, readErr := io.ReadAll(resp.Body)
+		relaySpan.recordError(readErr)
 		if readErr != nil {
 			fmt.Printf("[Gemini]   ⚠️ 读取响应失败: %s | 错误: %v\n", provider.Name, readErr)
 			// 【修复】此时 header 尚未写入客户端，可以重试/降级
@@ -3631,6 +4327,7 @@ func (prs *ProviderRelayService) forwardGeminiRequest(
 		c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
 	}
 
+	prs.bindSessionAffinity(c, "gemini", provider.Name)
 	return true, "", true
 }
 
@@ -3695,6 +4392,18 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 		if requestedModel == "" {
 			fmt.Printf("[CustomCLI][WARN] 请求未指定模型名，无法执行模型智能降级\n")
 		}
+		if prs.rejectOverQuotaClient(c, kind) {
+			return
+		}
+
+		// 路由规则（与 claude/codex 主链路同规则）
+		rule := prs.resolveRoutingRule(kind, c.Request.Header, clientNameOf(c), bodyBytes, requestedModel)
+		bodyBytes, requestedModel = applyRoutingModelOverride(rule, bodyBytes, requestedModel)
+		c.Request.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+
+		selectSpan := startTraceSpan(c, "provider.select")
+		selectSpan.set("model", requestedModel)
+		defer selectSpan.end()
 
 		// 加载该 CLI 工具的 providers
 		// (providers, 配置代数) 配对装载
@@ -3707,39 +4416,65 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 		// 过滤可用的 providers
 		active := make([]Provider, 0, len(providers))
 		skippedCount := 0
-		skippedModel, skippedBlacklist, skippedInvalid := 0, 0, 0
+		var skipped providerSkipCounts
+		var spendResetAt time.Time
 		for _, provider := range providers {
-			if !provider.Enabled || provider.APIURL == "" || provider.APIKey == "" {
+			if !provider.Enabled || provider.APIURL == "" || len(provider.KeyPool()) == 0 {
 				continue
 			}
 
 			if errs := provider.ValidateConfiguration(); len(errs) > 0 {
 				fmt.Printf("[CustomCLI][WARN] Provider %s 配置验证失败，已自动跳过: %v\n", provider.Name, errs)
 				skippedCount++
-				skippedInvalid++
+				skipped.Invalid++
 				continue
 			}
 
 			if requestedModel != "" && !provider.IsModelSupported(requestedModel) {
 				fmt.Printf("[CustomCLI][INFO] Provider %s 不支持模型 %s，已跳过\n", provider.Name, requestedModel)
 				skippedCount++
-				skippedModel++
+				skipped.Model++
 				continue
 			}
 
+			// 路由规则：只保留规则目标集合内的 provider
+			if rule != nil {
+				if ok, why := rule.Action.allows(provider.Name, provider.Level, provider.GetEffectiveModel(requestedModel)); !ok {
+					fmt.Printf("[CustomCLI] 🧭 Provider %s 被路由规则「%s」排除: %s\n", provider.Name, rule.Name, why)
+					skippedCount++
+					skipped.Rule++
+					skipped.RuleName = rule.Name
+					continue
+				}
+			}
+
 			// 黑名单检查
 			if isBlacklisted, until := prs.blacklistService.IsBlacklisted(kind, provider.Name); isBlacklisted {
 				fmt.Printf("[CustomCLI] ⛔ Provider %s 已拉黑，过期时间: %v\n", provider.Name, until.Format("15:04:05"))
 				skippedCount++
-				skippedBlacklist++
+				skipped.Blacklist++
+				continue
+			}
+
+			// 消费上限：本周期花费已达上限的 provider 退出调度，直到周期重置
+			if capped, resetAt := prs.spendCapExceeded(kind, provider.Name, provider.SpendCap, provider.SpendCapPeriod); capped {
+				fmt.Printf("[CustomCLI] 💸 Provider %s 已达消费上限，%s 重置\n", provider.Name, resetAt.Format("01-02 15:04"))
+				skippedCount++
+				skipped.Spend++
+				spendResetAt = earlierReset(spendResetAt, resetAt)
 				continue
 			}
 
 			active = append(active, provider)
 		}
+		selectSpan.setSelection(len(providers), len(active), skipped, rule)
 
 		if len(active) == 0 {
-			respondNoEligibleProviders(c, requestedModel, skippedModel, skippedBlacklist, skippedInvalid)
+			if skipped.onlySpend() {
+				respondAllSpendCapped(c, kind, spendResetAt)
+				return
+			}
+			respondNoEligibleProviders(c, requestedModel, skipped)
 			return
 		}
 
@@ -3764,11 +4499,28 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 			levels = append(levels, level)
 		}
 		sort.Ints(levels)
+		selectSpan.set("levels", fmt.Sprint(levels))
+		selectSpan.end()
 
 		fmt.Printf("[CustomCLI][INFO] 共 %d 个 Level 分组：%v\n", len(levels), levels)
 
 		query := flattenQuery(c.Request.URL.Query())
 		clientHeaders := cloneHeaders(c.Request.Header)
+		prs.markSessionKey(c, bodyBytes)
+
+		// 对冲请求：主尝试迟迟拿不到响应头时，把同一请求发给同 Level 的下一个供应商
+		hedge := prs.newHedgePlan(isStream, func(p Provider, attempt *hedgeAttempt) (bool, error) {
+			model := p.GetEffectiveModel(requestedModel)
+			body := bodyBytes
+			if model != requestedModel && requestedModel != "" {
+				mapped, err := ReplaceModelInRequestBody(bodyBytes, model)
+				if err != nil {
+					return false, err
+				}
+				body = mapped
+			}
+			return prs.forwardRequestAttempt(c, attempt, kind, p, p.GetEffectiveEndpoint(endpoint), query, clientHeaders, body, isStream, model, configGen)
+		})
 
 		// 获取拉黑功能开关状态
 		blacklistEnabled := prs.blacklistService.ShouldUseFixedMode()
@@ -3777,7 +4529,13 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 		if blacklistEnabled {
 			// 缓存轮询设置（单次请求级别，避免重复读取配置文件）
 			roundRobinSettingEnabled := prs.isRoundRobinSettingEnabled()
-			if roundRobinSettingEnabled {
+			costRoutingEnabled := prs.isCostRoutingEnabled()
+			latencyRouting := prs.latencyScope()
+			if costRoutingEnabled {
+				fmt.Printf("[CustomCLI][INFO] 🔒 拉黑模式 + 最低成本优先\n")
+			} else if latencyRouting.enabled {
+				fmt.Printf("[CustomCLI][INFO] 🔒 拉黑模式 + 最快优先\n")
+			} else if roundRobinSettingEnabled {
 				fmt.Printf("[CustomCLI][INFO] 🔒 拉黑模式 + 轮询负载均衡\n")
 			} else {
 				fmt.Printf("[CustomCLI][INFO] 🔒 拉黑模式（顺序调度）\n")
@@ -3808,6 +4566,8 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 			attemptedProviders := map[string]bool{}
 			// 因并发满被跳过、尚未真实尝试的候选
 			busyPending := map[string]concurrencyBusyRef{}
+			// 轮询排序每个 Level 每请求只取一次：等待阶段重扫若重复排序，会白白推进轮询起点与加权轮询的 current
+			rrOrders := map[int][]Provider{}
 			for {
 				busySkipped = 0
 				// 每 pass 重建：上一轮候选可能已被拉黑或删除，残留会让容量门控恒真
@@ -3816,14 +4576,24 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 				for _, level := range levels {
 					providersInLevel := levelGroups[level]
 
-					// 如果启用轮询，对同 Level 的 providers 进行轮询排序
-					if roundRobinSettingEnabled {
-						providersInLevel = prs.roundRobinOrder(kind, level, providersInLevel)
+					// 同 Level 排序：最低成本优先 > 最快优先 > 轮询，都未启用时保持用户排序
+					if costRoutingEnabled {
+						providersInLevel = prs.costOrder(providersInLevel, requestedModel, bodyBytes)
+					} else if latencyRouting.appliesTo(level) {
+						providersInLevel = prs.latencyOrder(kind, providersInLevel)
+					} else if roundRobinSettingEnabled {
+						if _, ok := rrOrders[level]; !ok {
+							rrOrders[level] = prs.roundRobinOrder(kind, level, providersInLevel)
+						}
+						providersInLevel = rrOrders[level]
 					}
+					// 会话粘性：把本会话上次成功的供应商提到首位（加权轮询仍按排序时的首位结算）
+					plannedFirst := providersInLevel[0].Name
+					providersInLevel = prs.stickyOrder(c, kind, providersInLevel)
 
 					fmt.Printf("[CustomCLI][INFO] === 尝试 Level %d（%d 个 provider）===\n", level, len(providersInLevel))
 
-					for _, provider := range providersInLevel {
+					for i, provider := range providersInLevel {
 						if attemptedProviders[strconv.FormatInt(provider.ID, 10)] {
 							continue
 						}
@@ -3862,17 +4632,25 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 							fmt.Printf("[CustomCLI][INFO] [拉黑模式] Provider: %s (Level %d) | 重试 %d/%d | Model: %s\n",
 								provider.Name, level, retryCount+1, maxRetryPerProvider, effectiveModel)
 
+							// 对冲只在首次尝试时发起：原地重试阶段的等待本就由拉黑阈值控制
+							var hedgeRest []Provider
+							if retryCount == 0 {
+								hedgeRest = providersInLevel[i+1:]
+							}
 							startTime := time.Now()
-							ok, err := prs.forwardRequest(c, kind, provider, effectiveEndpoint, query, clientHeaders, currentBodyBytes, isStream, effectiveModel, configGen)
+							served, ok, err := prs.forwardHedged(c, kind, hedge, provider, func(attempt *hedgeAttempt) (bool, error) {
+								return prs.forwardRequestAttempt(c, attempt, kind, provider, effectiveEndpoint, query, clientHeaders, currentBodyBytes, isStream, effectiveModel, configGen)
+							}, hedgeRest, attemptedProviders)
 							duration := time.Since(startTime)
 
 							if ok {
 								fmt.Printf("[CustomCLI][INFO] ✓ 成功: %s | 重试 %d 次 | 耗时: %.2fs\n",
-									provider.Name, retryCount+1, duration.Seconds())
-								if err := prs.blacklistService.RecordSuccess(kind, provider.Name); err != nil {
+									served.Name, retryCount+1, duration.Seconds())
+								if err := prs.blacklistService.RecordSuccess(kind, served.Name); err != nil {
 									fmt.Printf("[CustomCLI][WARN] 清零失败计数失败: %v\n", err)
 								}
-								prs.setLastUsedProvider(kind, provider.Name)
+								prs.setLastUsedProvider(kind, served.Name)
+								prs.settleWeightedPick(kind, level, plannedFirst, served.Name)
 								return
 							}
 
@@ -3881,27 +4659,27 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 								totalAttempts--
 								// 已真实失败过的供应商重试遇忙不再进等待候选：
 								// 下一 pass 必然跳过它，等它只会把失败聚合错改成 503
-								if pk := strconv.FormatInt(provider.ID, 10); !attemptedProviders[pk] {
+								if pk := strconv.FormatInt(served.ID, 10); !attemptedProviders[pk] {
 									busySkipped++
-									busyPending[pk] = concurrencyBusyRef{Key: pk, Limit: provider.MaxConcurrency, Gen: configGen}
+									busyPending[pk] = concurrencyBusyRef{Key: pk, Limit: served.MaxConcurrency, Gen: configGen}
 								}
-								fmt.Printf("[CustomCLI][INFO] Provider %s 并发已满，跳过\n", provider.Name)
+								fmt.Printf("[CustomCLI][INFO] Provider %s %s，跳过\n", served.Name, busySkipReason(err))
 								break
 							}
 							// 实际尝试过：等待阶段重扫不再碰它
-							attemptedProviders[strconv.FormatInt(provider.ID, 10)] = true
-							delete(busyPending, strconv.FormatInt(provider.ID, 10))
+							attemptedProviders[strconv.FormatInt(served.ID, 10)] = true
+							delete(busyPending, strconv.FormatInt(served.ID, 10))
 
 							// 失败处理
 							lastError = err
-							lastProvider = provider.Name
+							lastProvider = served.Name
 
 							errorMsg := "未知错误"
 							if err != nil {
 								errorMsg = err.Error()
 							}
 							fmt.Printf("[CustomCLI][WARN] ✗ 失败: %s | 重试 %d/%d | 错误: %s | 耗时: %.2fs\n",
-								provider.Name, retryCount+1, maxRetryPerProvider, errorMsg, duration.Seconds())
+								served.Name, retryCount+1, maxRetryPerProvider, errorMsg, duration.Seconds())
 
 							// 客户端请求被拒绝（协议转换不支持的格式/功能）：直接返回 400，不重试不拉黑。
 							// 与 claude/codex 路径保持一致，否则客户端自身的问题会被算成供应商故障
@@ -3929,7 +4707,7 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 
 							// 上游 2xx 后中途断流：响应已部分写出，不能再换供应商，但必须计入失败
 							if errors.Is(err, errUpstreamStreamAborted) {
-								if err := prs.blacklistService.RecordFailure(kind, provider.Name); err != nil {
+								if err := prs.blacklistService.RecordFailure(kind, served.Name); err != nil {
 									fmt.Printf("[CustomCLI][ERROR] 记录失败到黑名单失败: %v\n", err)
 								}
 								return
@@ -3944,28 +4722,33 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 							sawNonClientError = true
 
 							// 记录失败次数（可能触发拉黑）
-							if err := prs.blacklistService.RecordFailure(kind, provider.Name); err != nil {
+							if err := prs.blacklistService.RecordFailure(kind, served.Name); err != nil {
 								fmt.Printf("[CustomCLI][ERROR] 记录失败到黑名单失败: %v\n", err)
 							}
 
 							// 检查是否刚被拉黑
-							if blacklisted, _ := prs.blacklistService.IsBlacklisted(kind, provider.Name); blacklisted {
-								fmt.Printf("[CustomCLI][INFO] 🚫 Provider %s 达到失败阈值，已被拉黑，切换到下一个\n", provider.Name)
+							if blacklisted, _ := prs.blacklistService.IsBlacklisted(kind, served.Name); blacklisted {
+								fmt.Printf("[CustomCLI][INFO] 🚫 Provider %s 达到失败阈值，已被拉黑，切换到下一个\n", served.Name)
+								break
+							}
+
+							// 对冲方抢到响应后失败：端点、模型映射与请求体都是按主供应商准备的，
+							// 不能拿它们原地重试对冲方；失败已记在对冲方名下，直接切下一供应商
+							if served.ID != provider.ID {
+								fmt.Printf("[CustomCLI][INFO] 对冲供应商 %s 失败，不原地重试，切换到下一个\n", served.Name)
 								break
 							}
 
 							// 多地址池已整轮试过：失败已计一次，直接切下一供应商
 							if errors.Is(err, errEndpointPoolExhausted) {
-								fmt.Printf("[CustomCLI][INFO] Provider %s 地址池耗尽，切换下一供应商\n", provider.Name)
+								fmt.Printf("[CustomCLI][INFO] Provider %s 地址池耗尽，切换下一供应商\n", served.Name)
 								break
 							}
 
 							// 等待后重试（除非是最后一次）；等待期间客户端可能已经离开
 							if retryCount < maxRetryPerProvider-1 {
 								fmt.Printf("[CustomCLI][INFO] ⏳ 等待 %d 秒后重试...\n", retryWaitSeconds)
-								select {
-								case <-time.After(time.Duration(retryWaitSeconds) * time.Second):
-								case <-c.Request.Context().Done():
+								if !waitBeforeRetry(c, served.Name, errorMsg, time.Duration(retryWaitSeconds)*time.Second) {
 									fmt.Printf("[CustomCLI][INFO] 等待重试期间客户端已断开，停止尝试\n")
 									return
 								}
@@ -3991,20 +4774,7 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 					}
 					enteredBusyWait = true
 				}
-				// 唤醒以"忙候选真的有空位"为门控：本轮实际尝试供应商的正常释放
-				// 也会触发全局信号，不加门控直接重扫会形成自唤醒重试风暴
-				woke := false
-				for {
-					capSignal := prs.concurrency.releaseSignal()
-					if prs.concurrency.anyCapacity(kind, busyPending) {
-						woke = true
-						break
-					}
-					if !prs.concurrency.waitForRelease(c.Request.Context(), busyWaitDeadline, capSignal) {
-						break
-					}
-				}
-				if !woke {
+				if !prs.waitForBusyCapacity(c, kind, busyPending, busyWaitDeadline) {
 					respondAllBusy(c, kind)
 					return
 				}
@@ -4036,7 +4806,13 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 
 		// 【降级模式】：失败自动尝试下一个 provider
 		roundRobinEnabled := prs.isRoundRobinEnabled()
-		if roundRobinEnabled {
+		costRoutingEnabled := prs.isCostRoutingEnabled()
+		latencyRouting := prs.latencyScope()
+		if costRoutingEnabled {
+			fmt.Printf("[CustomCLI][INFO] 🔄 降级模式 + 最低成本优先\n")
+		} else if latencyRouting.enabled {
+			fmt.Printf("[CustomCLI][INFO] 🔄 降级模式 + 最快优先\n")
+		} else if roundRobinEnabled {
 			fmt.Printf("[CustomCLI][INFO] 🔄 降级模式 + 轮询负载均衡\n")
 		} else {
 			fmt.Printf("[CustomCLI][INFO] 🔄 降级模式（顺序降级）\n")
@@ -4061,6 +4837,8 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 		attemptedProviders := map[string]bool{}
 		// 因并发满被跳过、尚未真实尝试的候选
 		busyPending := map[string]concurrencyBusyRef{}
+		// 轮询排序每个 Level 每请求只取一次：等待阶段重扫若重复排序，会白白推进轮询起点与加权轮询的 current
+		rrOrders := map[int][]Provider{}
 		for {
 			busySkipped = 0
 			// 每 pass 重建：上一轮候选可能已被拉黑或删除，残留会让容量门控恒真
@@ -4068,10 +4846,20 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 			for _, level := range levels {
 				providersInLevel := levelGroups[level]
 
-				// 如果启用轮询，对同 Level 的 providers 进行轮询排序
-				if roundRobinEnabled {
-					providersInLevel = prs.roundRobinOrder(kind, level, providersInLevel)
+				// 同 Level 排序：最低成本优先 > 最快优先 > 轮询，都未启用时保持用户排序
+				if costRoutingEnabled {
+					providersInLevel = prs.costOrder(providersInLevel, requestedModel, bodyBytes)
+				} else if latencyRouting.appliesTo(level) {
+					providersInLevel = prs.latencyOrder(kind, providersInLevel)
+				} else if roundRobinEnabled {
+					if _, ok := rrOrders[level]; !ok {
+						rrOrders[level] = prs.roundRobinOrder(kind, level, providersInLevel)
+					}
+					providersInLevel = rrOrders[level]
 				}
+				// 会话粘性：把本会话上次成功的供应商提到首位（加权轮询仍按排序时的首位结算）
+				plannedFirst := providersInLevel[0].Name
+				providersInLevel = prs.stickyOrder(c, kind, providersInLevel)
 
 				fmt.Printf("[CustomCLI][INFO] === 尝试 Level %d（%d 个 provider）===\n", level, len(providersInLevel))
 
@@ -4098,15 +4886,18 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 					effectiveEndpoint := provider.GetEffectiveEndpoint(endpoint)
 
 					startTime := time.Now()
-					ok, err := prs.forwardRequest(c, kind, provider, effectiveEndpoint, query, clientHeaders, currentBodyBytes, isStream, effectiveModel, configGen)
+					served, ok, err := prs.forwardHedged(c, kind, hedge, provider, func(attempt *hedgeAttempt) (bool, error) {
+						return prs.forwardRequestAttempt(c, attempt, kind, provider, effectiveEndpoint, query, clientHeaders, currentBodyBytes, isStream, effectiveModel, configGen)
+					}, providersInLevel[i+1:], attemptedProviders)
 					duration := time.Since(startTime)
 
 					if ok {
-						fmt.Printf("[CustomCLI][INFO]   ✓ Level %d 成功: %s | 耗时: %.2fs\n", level, provider.Name, duration.Seconds())
-						if err := prs.blacklistService.RecordSuccess(kind, provider.Name); err != nil {
+						fmt.Printf("[CustomCLI][INFO]   ✓ Level %d 成功: %s | 耗时: %.2fs\n", level, served.Name, duration.Seconds())
+						if err := prs.blacklistService.RecordSuccess(kind, served.Name); err != nil {
 							fmt.Printf("[CustomCLI][WARN] 清零失败计数失败: %v\n", err)
 						}
-						prs.setLastUsedProvider(kind, provider.Name)
+						prs.setLastUsedProvider(kind, served.Name)
+						prs.settleWeightedPick(kind, level, plannedFirst, served.Name)
 						return
 					}
 
@@ -4114,17 +4905,17 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 					if errors.Is(err, errProviderBusy) {
 						totalAttempts--
 						busySkipped++
-						pk := strconv.FormatInt(provider.ID, 10)
-						busyPending[pk] = concurrencyBusyRef{Key: pk, Limit: provider.MaxConcurrency, Gen: configGen}
-						fmt.Printf("[CustomCLI][INFO] Provider %s 并发已满，跳过\n", provider.Name)
+						pk := strconv.FormatInt(served.ID, 10)
+						busyPending[pk] = concurrencyBusyRef{Key: pk, Limit: served.MaxConcurrency, Gen: configGen}
+						fmt.Printf("[CustomCLI][INFO] Provider %s %s，跳过\n", served.Name, busySkipReason(err))
 						continue
 					}
 					// 实际尝试过：等待阶段重扫不再碰它
-					attemptedProviders[strconv.FormatInt(provider.ID, 10)] = true
-					delete(busyPending, strconv.FormatInt(provider.ID, 10))
+					attemptedProviders[strconv.FormatInt(served.ID, 10)] = true
+					delete(busyPending, strconv.FormatInt(served.ID, 10))
 
 					lastError = err
-					lastProvider = provider.Name
+					lastProvider = served.Name
 					lastDuration = duration
 
 					errorMsg := "未知错误"
@@ -4132,7 +4923,7 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 						errorMsg = err.Error()
 					}
 					fmt.Printf("[CustomCLI][WARN]   ✗ Level %d 失败: %s | 错误: %s | 耗时: %.2fs\n",
-						level, provider.Name, errorMsg, duration.Seconds())
+						level, served.Name, errorMsg, duration.Seconds())
 
 					// 客户端请求被拒绝（协议转换不支持的格式/功能）：直接返回 400，不重试不拉黑
 					if errors.Is(err, ErrClientRequestRejected) {
@@ -4152,13 +4943,13 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 					}
 
 					if errors.Is(err, errClientAbort) {
-						fmt.Printf("[CustomCLI][INFO] 客户端中断，跳过失败计数: %s\n", provider.Name)
+						fmt.Printf("[CustomCLI][INFO] 客户端中断，跳过失败计数: %s\n", served.Name)
 						return
 					}
 
 					// 上游 2xx 后中途断流：响应已部分写出，不能再降级，但必须计入失败
 					if errors.Is(err, errUpstreamStreamAborted) {
-						if err := prs.blacklistService.RecordFailure(kind, provider.Name); err != nil {
+						if err := prs.blacklistService.RecordFailure(kind, served.Name); err != nil {
 							fmt.Printf("[CustomCLI][ERROR] 记录失败到黑名单失败: %v\n", err)
 						}
 						return
@@ -4169,7 +4960,7 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 						fmt.Printf("[CustomCLI][INFO] 上游拒绝请求内容，不计供应商失败: %s\n", errorMsg)
 					} else {
 						sawNonClientError = true
-						if err := prs.blacklistService.RecordFailure(kind, provider.Name); err != nil {
+						if err := prs.blacklistService.RecordFailure(kind, served.Name); err != nil {
 							fmt.Printf("[CustomCLI][ERROR] 记录失败到黑名单失败: %v\n", err)
 						}
 					}
@@ -4194,7 +4985,7 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 						}
 						if nextProvider != "" {
 							prs.notificationService.NotifyProviderSwitch(SwitchNotification{
-								FromProvider: provider.Name,
+								FromProvider: served.Name,
 								ToProvider:   nextProvider,
 								Reason:       errorMsg,
 								Platform:     kind,
@@ -4218,20 +5009,7 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 				}
 				enteredBusyWait = true
 			}
-			// 唤醒以"忙候选真的有空位"为门控：本轮实际尝试供应商的正常释放
-			// 也会触发全局信号，不加门控直接重扫会形成自唤醒重试风暴
-			woke := false
-			for {
-				capSignal := prs.concurrency.releaseSignal()
-				if prs.concurrency.anyCapacity(kind, busyPending) {
-					woke = true
-					break
-				}
-				if !prs.concurrency.waitForRelease(c.Request.Context(), busyWaitDeadline, capSignal) {
-					break
-				}
-			}
-			if !woke {
+			if !prs.waitForBusyCapacity(c, kind, busyPending, busyWaitDeadline) {
 				respondAllBusy(c, kind)
 				return
 			}
@@ -4277,10 +5055,10 @@ func (prs *ProviderRelayService) forwardModelsRequest(
 		return fmt.Errorf("failed to load providers: %w", err)
 	}
 
-	// 过滤可用的 providers（启用 + URL + APIKey）
+	// 过滤可用的 providers（启用 + URL + 密钥池非空）
 	var activeProviders []Provider
 	for _, provider := range providers {
-		if !provider.Enabled || provider.APIURL == "" || provider.APIKey == "" {
+		if !provider.Enabled || provider.APIURL == "" || len(provider.KeyPool()) == 0 {
 			continue
 		}
 
@@ -4321,18 +5099,16 @@ func (prs *ProviderRelayService) forwardModelsRequest(
 		ordered = append(ordered, levelGroups[level]...)
 	}
 
-	// 复用共享连接池；client 级 Timeout 32h 不适合模型列表这类短请求，
-	// 这里用 30s 的轻量包装挂到同一个 Transport 上，按供应商选择验证策略
-	modelsClient := &http.Client{Timeout: 30 * time.Second, Transport: relayHTTPClient.Transport}
-	modelsClientInsecure := &http.Client{Timeout: 30 * time.Second, Transport: relayHTTPClientInsecure.Transport}
+	// 复用共享连接池，按供应商选择验证策略与出站代理
 	var lastErr error
 	for i := range ordered {
 		selectedProvider := &ordered[i]
-		client := modelsClient
+		client := relayModelsClient
 		if selectedProvider.InsecureSkipVerify {
 			warnInsecureProviderOnce(selectedProvider.Name)
-			client = modelsClientInsecure
+			client = relayModelsClientInsecure
 		}
+		client = clientWithProxy(client, selectedProvider.ProxyURL)
 		fmt.Printf("[%s] 使用 Provider: %s | URL: %s\n", logPrefix, selectedProvider.Name, selectedProvider.APIURL)
 
 		// 地址池：与聊天转发同语义——多地址供应商按冷却排序逐地址尝试，
@@ -4364,7 +5140,7 @@ func (prs *ProviderRelayService) forwardModelsRequest(
 			// 清掉客户端自带凭据，避免用户本机 Key 被转发给第三方供应商
 			for _, name := range []string{
 				"Authorization", "Proxy-Authorization", "X-Api-Key", "Api-Key", "X-Goog-Api-Key",
-				"Accept-Encoding", "Connection", "Keep-Alive", "Te", "Upgrade",
+				clientNameHeader, "Accept-Encoding", "Connection", "Keep-Alive", "Te", "Upgrade",
 			} {
 				req.Header.Del(name)
 			}
@@ -4374,20 +5150,22 @@ func (prs *ProviderRelayService) forwardModelsRequest(
 				sanitizeHTTPHeaders(req.Header, selectedProvider.SanitizeConfig)
 			}
 
-			// 根据认证方式设置请求头（默认 Bearer，与 v2.2.x 保持一致）
+			// 根据认证方式设置请求头（默认 Bearer，与 v2.2.x 保持一致）；
+			// 只配置了额外密钥的供应商取密钥池首把
+			apiKey := selectedProvider.KeyPool()[0]
 			authType := strings.ToLower(strings.TrimSpace(selectedProvider.ConnectivityAuthType))
 			switch authType {
 			case "x-api-key":
-				req.Header.Set("x-api-key", selectedProvider.APIKey)
+				req.Header.Set("x-api-key", apiKey)
 				req.Header.Set("anthropic-version", "2023-06-01")
 			case "", "bearer":
-				req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", selectedProvider.APIKey))
+				req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
 			default:
 				headerName := strings.TrimSpace(selectedProvider.ConnectivityAuthType)
 				if headerName == "" || strings.EqualFold(headerName, "custom") {
 					headerName = "Authorization"
 				}
-				req.Header.Set(headerName, selectedProvider.APIKey)
+				req.Header.Set(headerName, apiKey)
 			}
 
 			// 设置默认 Accept 头
@@ -977,7 +977,7 @@ func TestRespondNoEligibleProvidersBranches(t *testing.T) {
 	run := func(model string, m, b, i int) string {
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
-		respondNoEligibleProviders(c, model, m, b, i)
+		respondNoEligibleProviders(c, model, providerSkipCounts{Model: m, Blacklist: b, Invalid: i})
 		if w.Code != http.StatusNotFound {
 			t.Fatalf("应为 404, 实际 %d", w.Code)
 		}
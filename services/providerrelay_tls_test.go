@@ -28,14 +28,14 @@ func isCertError(err error) bool {
 }
 
 func TestRelayClientForSelection(t *testing.T) {
-	if relayClientFor(false, "p1") != relayHTTPClient {
+	if relayClientFor(false, "", "p1") != relayHTTPClient {
 		t.Fatal("secure provider must use the default shared client")
 	}
-	if relayClientFor(true, "p1") != relayHTTPClientInsecure {
+	if relayClientFor(true, "", "p1") != relayHTTPClientInsecure {
 		t.Fatal("insecure provider must use the insecure shared client")
 	}
 	// 再次调用命中告警去重路径，不应 panic
-	if relayClientFor(true, "p1") != relayHTTPClientInsecure {
+	if relayClientFor(true, "", "p1") != relayHTTPClientInsecure {
 		t.Fatal("selection must be stable across calls")
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -59,10 +60,36 @@ type Provider struct {
 	// 全部失败才算该供应商一次失败。仅 claude/codex/custom 转发路径生效
 	FallbackAPIURLs []string `json:"fallbackApiUrls,omitempty"`
 
+	// 额外 API 密钥（可选，最多 16 个）- 与 APIKey 组成密钥池，各自额度独立
+	// 密钥被拒（401/403）、限流（429）或额度耗尽时冷却该密钥并在同一请求内换下一把，
+	// 全部失败才算该供应商一次失败。KeySelection：fill_first（默认，用尽一把再换）/ round_robin（轮询）
+	ExtraAPIKeys []string `json:"extraApiKeys,omitempty"`
+	KeySelection string   `json:"keySelection,omitempty"`
+
 	// 最大并发请求数（0=不限）- 仅约束代理转发的推理请求，
 	// /v1/models、健康检查等内部请求不占配额；为单进程内限制
 	MaxConcurrency int `json:"maxConcurrency,omitempty"`
 
+	// 速率上限（0=不限）- 每分钟请求数与每分钟 token 数（输入+输出，按请求日志实际用量），
+	// 令牌桶按分钟匀速恢复；超限的供应商与并发满载一样跳过，全部超限时短暂排队
+	RPMLimit int `json:"rpmLimit,omitempty"`
+	TPMLimit int `json:"tpmLimit,omitempty"`
+
+	// 消费上限（USD，0=不限）- 按请求日志费用统计当前周期（daily/weekly/monthly，默认 daily）
+	// 的花费，达到上限后该供应商退出调度，直到周期重置
+	SpendCap       float64 `json:"spendCap,omitempty"`
+	SpendCapPeriod string  `json:"spendCapPeriod,omitempty"`
+
+	// 负载权重（0=默认 1）- 开启轮询时同 Level 内按平滑加权轮询分配请求，
+	// 如 3 与 1 约为 75%/25%；全部为默认值时即普通轮询
+	Weight int `json:"weight,omitempty"`
+
+	// 价格倍率（0=按官方价 1 倍）- 中转站相对官方定价的计费倍率，
+	// 用于请求日志费用与"最低成本优先"排序；ModelPriceMultipliers 按模型覆盖
+	// （精确或通配符，如 "claude-opus-*": 1.5），命中时优先于统一倍率
+	PriceMultiplier       float64            `json:"priceMultiplier,omitempty"`
+	ModelPriceMultipliers map[string]float64 `json:"modelPriceMultipliers,omitempty"`
+
 	// 模型白名单 - Provider 原生支持的模型名
 	// 使用 map 实现 O(1) 查找，向后兼容（omitempty）
 	SupportedModels map[string]bool `json:"supportedModels,omitempty"`
@@ -94,21 +121,31 @@ type Provider struct {
 	// 空值时使用平台默认（claude: x-api-key, codex: bearer）
 	ConnectivityAuthType string `json:"connectivityAuthType,omitempty"`
 
-	// 上游协议类型 - anthropic / openai_chat / auto
-	// anthropic: 上游使用 Anthropic Messages API（默认）
-	// openai_chat: 上游使用 OpenAI Chat Completions API，自动转换请求/响应格式
-	// auto: 根据 APIEndpoint 自动检测（包含 /chat/completions 则为 openai_chat）
+	// 上游协议类型 - anthropic / openai_chat / anthropic_messages / gemini / auto
+	// anthropic: 上游与客户端协议一致，原样转发（默认）
+	// openai_chat: 上游使用 OpenAI Chat Completions API，自动转换请求/响应格式（openai 平台即原样转发）
+	// anthropic_messages: codex/openai 专用，上游使用 Anthropic Messages API，Responses / Chat 请求与响应自动转换
+	// gemini: 上游使用 Gemini generateContent API，Messages 请求/响应自动转换（apiEndpoint 支持 {model} 占位）
+	// auto: 根据 APIEndpoint 自动检测（包含 /chat/completions 则为 openai_chat，包含 generateContent 则为 gemini，以 /messages 结尾则为 anthropic_messages）
 	UpstreamProtocol string `json:"upstreamProtocol,omitempty"`
 
 	// 跳过上游 TLS 证书验证 - 仅对该供应商生效（自签名证书/企业代理场景）
 	// 默认 false；开启后该供应商的转发与健康/连通性探测都不再校验证书，存在中间人风险
 	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
 
+	// 出站代理 - 仅对该供应商生效，支持 http/https/socks5/socks5h，认证写在地址中（user:pass@host:port）
+	// 为空时直连（仍遵循 HTTP(S)_PROXY 环境变量）；转发、健康检查、连通性测试与模型列表请求统一走该代理
+	ProxyURL string `json:"proxyUrl,omitempty"`
+
 	// 请求清理开关 - 启用后转发前移除非标准字段和不支持的请求头
 	// 解决 LiteLLM 等中转服务的 "Extra inputs are not permitted" 兼容性问题
 	RequestSanitizeEnabled bool            `json:"requestSanitizeEnabled,omitempty"`
 	SanitizeConfig         *SanitizeConfig `json:"sanitizeConfig,omitempty"`
 
+	// 请求改写规则（可选，最多 32 条）- 按声明序对请求头 / 请求体 JSON 路径执行 set/delete/replace，
+	// 在凭据注入与请求清理之后执行，不受请求清理开关影响（见 request_rewrite.go）
+	RewriteRules []RewriteRule `json:"rewriteRules,omitempty"`
+
 	// ========== 旧字段（已废弃，仅用于读取迁移） ==========
 	// 这些字段在保存时不再写入，但读取时会自动迁移到新字段
 
@@ -157,6 +194,12 @@ func NewProviderService() *ProviderService {
 func (ps *ProviderService) Start() error { return nil }
 func (ps *ProviderService) Stop() error  { return nil }
 
+// providerPlatforms ProviderService 管理的内置平台（gemini 由 GeminiService 单独管理）
+var providerPlatforms = []string{"claude", "codex", "openai"}
+
+// blacklistPlatforms 未指定平台时黑名单列出的平台
+var blacklistPlatforms = []string{"claude", "codex", "openai", "gemini"}
+
 func providerFilePath(kind string) (string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -172,6 +215,8 @@ func providerFilePath(kind string) (string, error) {
 		filename = "claude-code.json"
 	case "codex":
 		filename = "codex.json"
+	case "openai":
+		filename = "openai.json"
 	default:
 		// 支持自定义 CLI 工具的供应商存储：custom:{tool-id}
 		if strings.HasPrefix(kind, "custom:") {
@@ -550,6 +595,7 @@ func (ps *ProviderService) DuplicateProvider(kind string, sourceID int64) (*Prov
 		UpstreamProtocol:     source.UpstreamProtocol,     // 复制上游协议配置
 		ConnectivityAuthType: source.ConnectivityAuthType, // 复制认证方式
 		InsecureSkipVerify:   source.InsecureSkipVerify,   // 复制 TLS 跳验开关
+		ProxyURL:             source.ProxyURL,             // 复制出站代理
 		// 请求清理配置
 		RequestSanitizeEnabled: source.RequestSanitizeEnabled,
 		// 可用性监控配置
@@ -565,6 +611,9 @@ func (ps *ProviderService) DuplicateProvider(kind string, sourceID int64) (*Prov
 			BlockedBetaValues: cloneStringListPtr(source.SanitizeConfig.BlockedBetaValues),
 		}
 	}
+	if len(source.RewriteRules) > 0 {
+		cloned.RewriteRules = append([]RewriteRule(nil), source.RewriteRules...)
+	}
 
 	// 6. 深拷贝 map（避免共享引用）
 	if source.SupportedModels != nil {
@@ -593,8 +642,24 @@ func (ps *ProviderService) DuplicateProvider(kind string, sourceID int64) (*Prov
 	if len(source.FallbackAPIURLs) > 0 {
 		cloned.FallbackAPIURLs = append([]string(nil), source.FallbackAPIURLs...)
 	}
+	if len(source.ExtraAPIKeys) > 0 {
+		cloned.ExtraAPIKeys = append([]string(nil), source.ExtraAPIKeys...)
+	}
+	cloned.KeySelection = source.KeySelection
 
 	cloned.MaxConcurrency = source.MaxConcurrency
+	cloned.RPMLimit = source.RPMLimit
+	cloned.TPMLimit = source.TPMLimit
+	cloned.SpendCap = source.SpendCap
+	cloned.SpendCapPeriod = source.SpendCapPeriod
+	cloned.Weight = source.Weight
+	cloned.PriceMultiplier = source.PriceMultiplier
+	if source.ModelPriceMultipliers != nil {
+		cloned.ModelPriceMultipliers = make(map[string]float64, len(source.ModelPriceMultipliers))
+		for k, v := range source.ModelPriceMultipliers {
+			cloned.ModelPriceMultipliers[k] = v
+		}
+	}
 
 	// 7. 添加到列表并保存（使用内部方法避免死锁）
 	providers = append(providers, *cloned)
@@ -694,6 +759,11 @@ func (p *Provider) GetEffectiveModel(requestedModel string) string {
 	return effectiveModelFor(p.ModelMapping, requestedModel)
 }
 
+// GetPriceMultiplier 获取指定模型（映射后的实际模型名）的价格倍率
+func (p *Provider) GetPriceMultiplier(model string) float64 {
+	return priceMultiplierFor(p.PriceMultiplier, p.ModelPriceMultipliers, model)
+}
+
 // GetEffectiveEndpoint 获取有效的 API 端点
 // 优先使用用户配置的端点，否则使用平台默认
 func (p *Provider) GetEffectiveEndpoint(defaultEndpoint string) string {
@@ -724,6 +794,11 @@ const (
 	UpstreamProtocolAnthropic UpstreamProtocolType = "anthropic"
 	// UpstreamProtocolOpenAIChat OpenAI Chat Completions API
 	UpstreamProtocolOpenAIChat UpstreamProtocolType = "openai_chat"
+	// UpstreamProtocolAnthropicMessages 上游为 Anthropic Messages API，codex/openai 平台专用：
+	// Responses / Chat 请求转换为 /v1/messages；客户端本就是 Messages 协议的平台等同 anthropic
+	UpstreamProtocolAnthropicMessages UpstreamProtocolType = "anthropic_messages"
+	// UpstreamProtocolGemini Gemini generateContent API，Messages 请求/响应自动转换
+	UpstreamProtocolGemini UpstreamProtocolType = "gemini"
 	// UpstreamProtocolAuto 自动检测
 	UpstreamProtocolAuto UpstreamProtocolType = "auto"
 )
@@ -735,6 +810,10 @@ func (p *Provider) GetUpstreamProtocol() UpstreamProtocolType {
 	switch protocol {
 	case "openai_chat", "openai-chat", "openai":
 		return UpstreamProtocolOpenAIChat
+	case "anthropic_messages", "anthropic-messages", "messages":
+		return UpstreamProtocolAnthropicMessages
+	case "gemini", "google":
+		return UpstreamProtocolGemini
 	case "auto":
 		return UpstreamProtocolAuto
 	default:
@@ -750,6 +829,14 @@ func DetectUpstreamProtocol(endpoint string) UpstreamProtocolType {
 	if strings.Contains(ep, "/chat/completions") {
 		return UpstreamProtocolOpenAIChat
 	}
+	// 检测 Gemini generateContent / streamGenerateContent 端点
+	if strings.Contains(ep, "generatecontent") {
+		return UpstreamProtocolGemini
+	}
+	// 检测 Anthropic Messages 端点（codex 供应商据此启用 Responses → Messages 转换）
+	if strings.HasSuffix(strings.TrimRight(ep, "/"), "/messages") {
+		return UpstreamProtocolAnthropicMessages
+	}
 	// 默认 Anthropic
 	return UpstreamProtocolAnthropic
 }
@@ -808,13 +895,71 @@ func validateModelConfig(supported map[string]bool, mapping map[string]string) [
 func (p *Provider) ValidateConfiguration() []string {
 	errors := validateModelConfig(p.SupportedModels, p.ModelMapping)
 	errors = append(errors, validateFallbackURLs(p.FallbackAPIURLs)...)
+	errors = append(errors, validateExtraAPIKeys(p.ExtraAPIKeys, p.KeySelection)...)
+	errors = append(errors, validateRewriteRules(p.RewriteRules)...)
+	errors = append(errors, validateProxyURL(p.ProxyURL)...)
 	if p.MaxConcurrency < 0 {
 		errors = append(errors, "最大并发数不能为负（0 表示不限）")
 	}
+	errors = append(errors, validateRateLimits(p.RPMLimit, p.TPMLimit)...)
+	errors = append(errors, validateSpendCap(p.SpendCap, p.SpendCapPeriod)...)
+	if p.Weight < 0 {
+		errors = append(errors, "负载权重不能为负（0 表示默认权重 1）")
+	}
+	errors = append(errors, validatePriceMultipliers(p.PriceMultiplier, p.ModelPriceMultipliers)...)
 	p.configErrors = errors
 	return errors
 }
 
+// priceMultiplierFor 返回模型的价格倍率：模型倍率表精确命中优先，其次通配符
+// （字面量最长优先，等长按字典序，与模型映射同一规则），都未命中用统一倍率；
+// 未配置或非正数按 1（官方价）处理
+func priceMultiplierFor(base float64, perModel map[string]float64, model string) float64 {
+	if len(perModel) > 0 && model != "" {
+		if m, ok := perModel[model]; ok && m > 0 {
+			return m
+		}
+		bestPattern := ""
+		bestLiteral := -1
+		for pattern, m := range perModel {
+			if m <= 0 || !matchWildcard(pattern, model) {
+				continue
+			}
+			literal := len(strings.ReplaceAll(pattern, "*", ""))
+			if literal > bestLiteral || (literal == bestLiteral && pattern < bestPattern) {
+				bestPattern = pattern
+				bestLiteral = literal
+			}
+		}
+		if bestPattern != "" {
+			return perModel[bestPattern]
+		}
+	}
+	if base <= 0 {
+		return 1
+	}
+	return base
+}
+
+// validatePriceMultipliers 校验价格倍率：统一倍率不能为负，模型倍率必须为正
+func validatePriceMultipliers(base float64, perModel map[string]float64) []string {
+	var errs []string
+	if base < 0 {
+		errs = append(errs, "价格倍率不能为负（0 表示按官方价）")
+	}
+	for pattern, m := range perModel {
+		if strings.TrimSpace(pattern) == "" {
+			errs = append(errs, "模型价格倍率的模型名不能为空")
+			continue
+		}
+		if m <= 0 {
+			errs = append(errs, fmt.Sprintf("模型 %s 的价格倍率必须大于 0", pattern))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
 // matchWildcard 通配符匹配函数
 // 支持 * 通配符，如 "claude-*" 匹配 "claude-sonnet-4"
 func matchWildcard(pattern, text string) bool {
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ========== 按供应商速率限制（RPM / TPM 令牌桶）==========
+//
+// 很多中转站按"每分钟请求数 / 每分钟 token 数"限额，而不是并发数。
+// 每个供应商两个令牌桶，容量为每分钟上限、按 上限/60 每秒匀速恢复：
+//
+//   - RPM 桶在占用配额时扣 1，桶里不足 1 个令牌即视为限速；
+//   - TPM 桶在请求结束后按请求日志的实际用量（输入+输出 token）扣减，可以扣成负数
+//     （大请求透支），恢复到正数前视为限速——事先无法知道一次请求会用多少 token；
+//   - 限速与并发满载同一语义：不计失败、不进黑名单、不写请求日志，调度器跳过该供应商；
+//     全部候选都忙或限速时经 waitForRelease 有界排队，令牌恢复时刻由定时器广播唤醒；
+//   - 与并发配额共用条目与配置代数，同样是单进程内的限制。
+
+// errProviderThrottled 供应商已达 RPM/TPM 上限。包装 errProviderBusy，
+// 调度循环、对冲与地址池对"忙"的处理对它同样适用
+var errProviderThrottled = fmt.Errorf("%w: rate limit reached", errProviderBusy)
+
+// rateBucket 每分钟上限的令牌桶；limit<=0 表示不限
+type rateBucket struct {
+	limit  int
+	tokens float64
+	at     time.Time
+}
+
+// configure 更新上限：从不限改为有限时桶按满额起步，调小上限时截断余量
+func (b *rateBucket) configure(limit int, now time.Time) {
+	if limit == b.limit {
+		return
+	}
+	if limit <= 0 {
+		*b = rateBucket{}
+		return
+	}
+	if b.limit <= 0 {
+		b.tokens = float64(limit)
+	} else {
+		b.refill(now)
+		if b.tokens > float64(limit) {
+			b.tokens = float64(limit)
+		}
+	}
+	b.limit = limit
+	b.at = now
+}
+
+// refill 按流逝时间恢复令牌，不超过容量
+func (b *rateBucket) refill(now time.Time) {
+	if b.limit <= 0 {
+		return
+	}
+	if elapsed := now.Sub(b.at).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * float64(b.limit) / 60
+		if b.tokens > float64(b.limit) {
+			b.tokens = float64(b.limit)
+		}
+	}
+	b.at = now
+}
+
+// ready 桶里至少还有 1 个令牌
+func (b *rateBucket) ready() bool {
+	return b.limit <= 0 || b.tokens >= 1
+}
+
+// waitFor 恢复到 1 个令牌还需多久
+func (b *rateBucket) waitFor() time.Duration {
+	if b.ready() {
+		return 0
+	}
+	seconds := (1 - b.tokens) * 60 / float64(b.limit)
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func (b *rateBucket) consume(n int) {
+	if b.limit > 0 && n > 0 {
+		b.tokens -= float64(n)
+	}
+}
+
+// Acquire 占用一个转发配额：先看并发，再看 RPM/TPM 令牌桶。
+// 满载返回 errProviderBusy，限速返回 errProviderThrottled；两者都不占用任何配额。
+// gen 规则同 TryAcquire：更高代数才允许更新容量与速率上限
+func (l *concurrencyLimiter) Acquire(platform string, providerKey string, maxConcurrency, rpm, tpm int, gen int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	entry, latest := l.entryLocked(platform, providerKey, maxConcurrency, gen)
+	if latest {
+		entry.rpm.configure(rpm, now)
+		entry.tpm.configure(tpm, now)
+	}
+	if entry.limit > 0 && entry.inFlight >= entry.limit {
+		return errProviderBusy
+	}
+	if !l.rateReadyLocked(entry, now) {
+		return errProviderThrottled
+	}
+	entry.rpm.consume(1)
+	entry.inFlight++
+	return nil
+}
+
+// ConsumeTokens 请求结束后按实际用量扣减 TPM 令牌桶
+func (l *concurrencyLimiter) ConsumeTokens(platform string, providerKey string, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.entries[concurrencyKey(platform, providerKey)]
+	if !ok || entry.tpm.limit <= 0 {
+		return
+	}
+	entry.tpm.refill(l.now())
+	entry.tpm.consume(tokens)
+}
+
+// rateReadyLocked 恢复令牌后判断是否未限速；限速时挂上令牌恢复时刻的唤醒定时器
+func (l *concurrencyLimiter) rateReadyLocked(entry *concurrencyEntry, now time.Time) bool {
+	entry.rpm.refill(now)
+	entry.tpm.refill(now)
+	if entry.rpm.ready() && entry.tpm.ready() {
+		return true
+	}
+	wait := entry.rpm.waitFor()
+	if w := entry.tpm.waitFor(); w > wait {
+		wait = w
+	}
+	l.scheduleWakeLocked(entry, now, wait)
+	return false
+}
+
+// scheduleWakeLocked wait 之后广播释放信号；已有更早的定时器时复用
+func (l *concurrencyLimiter) scheduleWakeLocked(entry *concurrencyEntry, now time.Time, wait time.Duration) {
+	at := now.Add(wait)
+	if entry.wakeTimer != nil && !entry.wakeAt.After(at) {
+		return
+	}
+	if entry.wakeTimer != nil {
+		entry.wakeTimer.Stop()
+	}
+	var timer *time.Timer
+	// 回调先拿 l.mu，调用方持锁期间完成 timer 赋值，读写不会竞争
+	timer = time.AfterFunc(wait, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if entry.wakeTimer == timer {
+			entry.wakeTimer = nil
+		}
+		l.broadcastLocked()
+	})
+	entry.wakeTimer = timer
+	entry.wakeAt = at
+}
+
+// busySkipReason 调度日志里"忙"跳过的原因
+func busySkipReason(err error) string {
+	if errors.Is(err, errProviderThrottled) {
+		return "已达速率上限"
+	}
+	return "并发已满"
+}
+
+// validateRateLimits 校验 RPM/TPM 上限：不能为负（0 表示不限）
+func validateRateLimits(rpm, tpm int) []string {
+	var errs []string
+	if rpm < 0 {
+		errs = append(errs, "每分钟请求数上限不能为负（0 表示不限）")
+	}
+	if tpm < 0 {
+		errs = append(errs, "每分钟 token 数上限不能为负（0 表示不限）")
+	}
+	return errs
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestAcquireRPM RPM 桶耗尽即限速（不占并发配额），按分钟匀速恢复
+func TestAcquireRPM(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := newConcurrencyLimiter()
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if err := l.Acquire("claude", "1", 0, 2, 0, 0); err != nil {
+			t.Fatalf("第 %d 次应放行: %v", i+1, err)
+		}
+		l.Release("claude", "1")
+	}
+	err := l.Acquire("claude", "1", 0, 2, 0, 0)
+	if !errors.Is(err, errProviderThrottled) || !errors.Is(err, errProviderBusy) {
+		t.Fatalf("超过 RPM 应按忙限速, 实际 %v", err)
+	}
+	if n := l.snapshotInFlight("claude", "1"); n != 0 {
+		t.Errorf("限速不应占用并发配额, inFlight=%d", n)
+	}
+
+	now = now.Add(30 * time.Second)
+	if err := l.Acquire("claude", "1", 0, 2, 0, 0); err != nil {
+		t.Errorf("半分钟后应恢复 1 个令牌: %v", err)
+	}
+}
+
+// TestAcquireTPMDebt TPM 按实际用量扣减，大请求透支后要等额度恢复为正
+func TestAcquireTPMDebt(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := newConcurrencyLimiter()
+	l.now = func() time.Time { return now }
+
+	if err := l.Acquire("codex", "7", 0, 0, 600, 0); err != nil {
+		t.Fatalf("首个请求应放行: %v", err)
+	}
+	l.Release("codex", "7")
+	l.ConsumeTokens("codex", "7", 1200)
+
+	if err := l.Acquire("codex", "7", 0, 0, 600, 0); !errors.Is(err, errProviderThrottled) {
+		t.Fatalf("透支后应限速, 实际 %v", err)
+	}
+	now = now.Add(60 * time.Second)
+	if err := l.Acquire("codex", "7", 0, 0, 600, 0); !errors.Is(err, errProviderThrottled) {
+		t.Fatalf("一分钟只恢复到 0，仍应限速, 实际 %v", err)
+	}
+	now = now.Add(time.Second)
+	if err := l.Acquire("codex", "7", 0, 0, 600, 0); err != nil {
+		t.Errorf("额度恢复为正后应放行: %v", err)
+	}
+}
+
+// TestAnyCapacityWakesWhenTokensRefill 全部限速时等待阶段由令牌恢复定时器唤醒
+func TestAnyCapacityWakesWhenTokensRefill(t *testing.T) {
+	l := newConcurrencyLimiter()
+	// 6000 RPM：每 10ms 恢复一个令牌
+	for {
+		err := l.Acquire("claude", "1", 0, 6000, 0, 0)
+		if err != nil {
+			break
+		}
+		l.Release("claude", "1")
+	}
+	pending := map[string]concurrencyBusyRef{"1": {Key: "1"}}
+
+	signal := l.releaseSignal()
+	if l.anyCapacity("claude", pending) {
+		// 恰好跨过了一个令牌的恢复时刻，等价于已唤醒
+		return
+	}
+	if !l.waitForRelease(context.Background(), time.Now().Add(2*time.Second), signal) {
+		t.Fatal("令牌恢复时应广播唤醒")
+	}
+	if !l.anyCapacity("claude", pending) {
+		t.Error("唤醒后候选应已有额度")
+	}
+}
+
+// TestValidateRateLimits 负数上限报错
+func TestValidateRateLimits(t *testing.T) {
+	p := Provider{RPMLimit: -1, TPMLimit: -1}
+	if errs := p.ValidateConfiguration(); len(errs) != 2 {
+		t.Errorf("负 RPM/TPM 都应报错: %v", errs)
+	}
+	g := GeminiProvider{RPMLimit: 10, TPMLimit: 0}
+	if errs := g.ValidateConfiguration(); len(errs) != 0 {
+		t.Errorf("合法上限不应报错: %v", errs)
+	}
+}
+
+// TestGeminiStreamFailureConsumesTPM Gemini 流式中途断开时，已产生的用量同样计入 TPM
+func TestGeminiStreamFailureConsumesTPM(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"usageMetadata\":{\"promptTokenCount\":700,\"candidatesTokenCount\":50}}\n\n"))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler) // 不发结束块直接断开连接
+	}))
+	defer upstream.Close()
+
+	now := time.Unix(1700000000, 0)
+	prs := newTestRelayService(NewProviderService())
+	prs.concurrency.now = func() time.Time { return now }
+	if err := prs.concurrency.Acquire("gemini", "g1", 0, 0, 600, 0); err != nil {
+		t.Fatalf("首个请求应放行: %v", err)
+	}
+	prs.concurrency.Release("gemini", "g1")
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/gemini/v1beta/models/gemini-2.5-pro:streamGenerateContent", nil)
+	provider := &GeminiProvider{ID: "g1", Name: "g1", BaseURL: upstream.URL, APIKey: "k", Enabled: true}
+	requestLog := &ReqeustLog{Platform: "gemini"}
+	ok, _, written := prs.forwardGeminiRequest(c, provider, "/v1beta/models/gemini-2.5-pro:streamGenerateContent?alt=sse", []byte(`{}`), true, requestLog)
+	if ok || !written {
+		t.Fatalf("断流应判定为已写出响应的失败: ok=%v written=%v", ok, written)
+	}
+	if requestLog.InputTokens != 700 {
+		t.Fatalf("断流前的用量应已解析: %+v", requestLog)
+	}
+	if err := prs.concurrency.Acquire("gemini", "g1", 0, 0, 600, 0); !errors.Is(err, errProviderThrottled) {
+		t.Errorf("断流请求的用量应扣减 TPM, 实际 %v", err)
+	}
+}
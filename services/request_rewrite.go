@@ -0,0 +1,208 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// ========== 供应商请求改写规则 ==========
+//
+// SanitizeConfig 只能删除；部分中转还要求补头（自定义 User-Agent、组织 ID、固定
+// anthropic-version）或改写请求体（强制 max_tokens、注入 metadata）。RewriteRules
+// 是按声明序执行的 set/delete/replace 规则：
+//
+//   - target=header：path 为请求头名（大小写不敏感）
+//     set 写入 value；delete 删除；replace 仅在头已存在时生效——from 为空整值替换为 value，
+//     否则把值中的 from 子串全部替换为 value
+//   - target=body：path 为 sjson 路径（如 max_tokens、metadata.user_id、tools.0.name）
+//     set 写入 value（JSON 字面量：数字、true、"字符串"、{...}）；delete 删除；
+//     replace 仅在路径已存在时写入，from 非空时还要求当前值（字符串取文本，其余取原始 JSON）等于 from
+//
+// 执行时机：凭据注入与请求清理（sanitizeRequestBody）之后、发送之前，因此抓包记录的
+// 就是实际发往上游的请求；多密钥供应商换密钥重新注入凭据后会再执行一遍头规则。
+// 仅 claude/codex/custom 转发路径生效。
+
+// rewriteRuleLimit 单个供应商的改写规则上限
+const rewriteRuleLimit = 32
+
+// 改写目标与操作
+const (
+	RewriteTargetHeader = "header"
+	RewriteTargetBody   = "body"
+
+	RewriteOpSet     = "set"
+	RewriteOpDelete  = "delete"
+	RewriteOpReplace = "replace"
+)
+
+// rewriteProtectedHeaders 由 Go 传输层管理的头，改写只会造成协议错误
+var rewriteProtectedHeaders = map[string]bool{
+	"host":              true,
+	"content-length":    true,
+	"transfer-encoding": true,
+	"connection":        true,
+}
+
+// RewriteRule 一条请求改写规则
+type RewriteRule struct {
+	Target string `json:"target"`          // header / body
+	Op     string `json:"op"`              // set / delete / replace
+	Path   string `json:"path"`            // 请求头名或 sjson 路径
+	Value  string `json:"value,omitempty"` // set/replace 的新值；body 为 JSON 字面量
+	From   string `json:"from,omitempty"`  // replace 的匹配条件（可选）
+}
+
+// validateRewriteRules 校验改写规则（供 ValidateConfiguration 复用）
+func validateRewriteRules(rules []RewriteRule) []string {
+	errs := make([]string, 0)
+	if len(rules) > rewriteRuleLimit {
+		errs = append(errs, fmt.Sprintf("改写规则最多 %d 条（当前 %d 条）", rewriteRuleLimit, len(rules)))
+	}
+	for i, r := range rules {
+		prefix := fmt.Sprintf("改写规则 #%d", i+1)
+		path := strings.TrimSpace(r.Path)
+		if path == "" {
+			errs = append(errs, prefix+": 路径不能为空")
+			continue
+		}
+		switch r.Op {
+		case RewriteOpSet, RewriteOpDelete, RewriteOpReplace:
+		default:
+			errs = append(errs, fmt.Sprintf("%s: 未知操作 %q（可选 set / delete / replace）", prefix, r.Op))
+			continue
+		}
+		switch r.Target {
+		case RewriteTargetHeader:
+			if !validHeaderName(path) {
+				errs = append(errs, fmt.Sprintf("%s: 请求头名 %q 不合法", prefix, path))
+			} else if rewriteProtectedHeaders[strings.ToLower(path)] {
+				errs = append(errs, fmt.Sprintf("%s: 请求头 %s 由传输层管理，不能改写", prefix, path))
+			}
+			if r.Op != RewriteOpDelete && strings.ContainsAny(r.Value, "\r\n\x00") {
+				errs = append(errs, fmt.Sprintf("%s: 请求头值不能包含换行等控制字符", prefix))
+			}
+		case RewriteTargetBody:
+			if r.Op != RewriteOpDelete && !gjson.Valid(r.Value) {
+				errs = append(errs, fmt.Sprintf("%s: 请求体新值必须是 JSON 字面量（字符串需加引号）", prefix))
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("%s: 未知目标 %q（可选 header / body）", prefix, r.Target))
+		}
+	}
+	return errs
+}
+
+// applyHeaderRewrites 按序执行头规则，返回生效的规则描述（供日志）
+func applyHeaderRewrites(headers map[string]string, rules []RewriteRule) []string {
+	var applied []string
+	for _, r := range rules {
+		if r.Target != RewriteTargetHeader {
+			continue
+		}
+		name := strings.TrimSpace(r.Path)
+		switch r.Op {
+		case RewriteOpSet:
+			setHeaderCanonical(headers, name, r.Value)
+		case RewriteOpDelete:
+			if _, ok := lookupHeaderFold(headers, name); !ok {
+				continue
+			}
+			deleteHeaderFold(headers, name)
+		case RewriteOpReplace:
+			current, ok := lookupHeaderFold(headers, name)
+			if !ok {
+				continue
+			}
+			next := r.Value
+			if r.From != "" {
+				if !strings.Contains(current, r.From) {
+					continue
+				}
+				next = strings.ReplaceAll(current, r.From, r.Value)
+			}
+			setHeaderCanonical(headers, name, next)
+		default:
+			continue
+		}
+		applied = append(applied, r.Op+" header "+http.CanonicalHeaderKey(name))
+	}
+	return applied
+}
+
+// applyBodyRewrites 按序执行请求体规则；非 JSON 请求体原样返回。
+// 单条规则失败（如路径与现有结构冲突）跳过并告警，不影响其余规则
+func applyBodyRewrites(body []byte, rules []RewriteRule) ([]byte, []string) {
+	if len(rules) == 0 || !gjson.ValidBytes(body) {
+		return body, nil
+	}
+	var applied []string
+	for _, r := range rules {
+		if r.Target != RewriteTargetBody {
+			continue
+		}
+		path := strings.TrimSpace(r.Path)
+		var (
+			next []byte
+			err  error
+		)
+		switch r.Op {
+		case RewriteOpSet:
+			next, err = sjson.SetRawBytes(body, path, []byte(r.Value))
+		case RewriteOpDelete:
+			if !gjson.GetBytes(body, path).Exists() {
+				continue
+			}
+			next, err = sjson.DeleteBytes(body, path)
+		case RewriteOpReplace:
+			current := gjson.GetBytes(body, path)
+			if !current.Exists() || (r.From != "" && rewriteComparable(current) != r.From) {
+				continue
+			}
+			next, err = sjson.SetRawBytes(body, path, []byte(r.Value))
+		default:
+			continue
+		}
+		if err != nil {
+			fmt.Printf("[Rewrite] 规则 %s body %s 执行失败，已跳过: %v\n", r.Op, path, err)
+			continue
+		}
+		body = next
+		applied = append(applied, r.Op+" body "+path)
+	}
+	return body, applied
+}
+
+// rewriteComparable replace 条件比较用的当前值：字符串取文本，其余取原始 JSON
+func rewriteComparable(v gjson.Result) string {
+	if v.Type == gjson.String {
+		return v.String()
+	}
+	return v.Raw
+}
+
+// lookupHeaderFold 大小写不敏感地取头部值，并区分"不存在"与"空值"
+func lookupHeaderFold(headers map[string]string, name string) (string, bool) {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// validHeaderName 请求头名只能由 RFC 7230 token 字符组成
+func validHeaderName(name string) bool {
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
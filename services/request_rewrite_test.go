@@ -0,0 +1,178 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// TestApplyHeaderRewrites set 覆盖任意大小写的同名头，replace 只改已存在的头，delete 不存在时不计入
+func TestApplyHeaderRewrites(t *testing.T) {
+	headers := map[string]string{"user-agent": "claude-cli/1.0 (external)", "X-Trace": "1"}
+	applied := applyHeaderRewrites(headers, []RewriteRule{
+		{Target: "header", Op: "set", Path: "anthropic-version", Value: "2023-06-01"},
+		{Target: "header", Op: "replace", Path: "User-Agent", From: "external", Value: "relay"},
+		{Target: "header", Op: "replace", Path: "X-Missing", Value: "x"},
+		{Target: "header", Op: "delete", Path: "x-trace"},
+		{Target: "header", Op: "delete", Path: "x-absent"},
+		{Target: "body", Op: "set", Path: "max_tokens", Value: "1"},
+	})
+	if len(applied) != 3 {
+		t.Errorf("应生效 3 条头规则: %v", applied)
+	}
+	if headers["Anthropic-Version"] != "2023-06-01" || headers["User-Agent"] != "claude-cli/1.0 (relay)" {
+		t.Errorf("set/replace 结果不符: %v", headers)
+	}
+	if _, ok := lookupHeaderFold(headers, "x-trace"); ok || len(headers) != 2 {
+		t.Errorf("delete 后不应残留: %v", headers)
+	}
+}
+
+// TestApplyBodyRewrites set 写入 JSON 字面量，replace 按条件改写，delete 删除嵌套路径
+func TestApplyBodyRewrites(t *testing.T) {
+	body := []byte(`{"model":"m","max_tokens":64000,"metadata":{"user_id":"u"},"temperature":1}`)
+	out, applied := applyBodyRewrites(body, []RewriteRule{
+		{Target: "body", Op: "replace", Path: "max_tokens", From: "64000", Value: "32000"},
+		{Target: "body", Op: "set", Path: "metadata.org", Value: `"acme"`},
+		{Target: "body", Op: "delete", Path: "metadata.user_id"},
+		{Target: "body", Op: "replace", Path: "top_p", Value: "0.9"},
+		{Target: "body", Op: "replace", Path: "temperature", From: "0.5", Value: "0"},
+	})
+	if len(applied) != 3 {
+		t.Errorf("应生效 3 条体规则: %v", applied)
+	}
+	if gjson.GetBytes(out, "max_tokens").Int() != 32000 || gjson.GetBytes(out, "metadata.org").String() != "acme" {
+		t.Errorf("set/replace 结果不符: %s", out)
+	}
+	if gjson.GetBytes(out, "metadata.user_id").Exists() || gjson.GetBytes(out, "top_p").Exists() ||
+		gjson.GetBytes(out, "temperature").Int() != 1 {
+		t.Errorf("delete 或条件 replace 结果不符: %s", out)
+	}
+
+	if same, applied := applyBodyRewrites([]byte("not json"), []RewriteRule{{Target: "body", Op: "set", Path: "a", Value: "1"}}); string(same) != "not json" || applied != nil {
+		t.Error("非 JSON 请求体应原样放行")
+	}
+}
+
+// TestValidateRewriteRules 目标、操作、头名与 JSON 字面量校验
+func TestValidateRewriteRules(t *testing.T) {
+	errs := validateRewriteRules([]RewriteRule{
+		{Target: "header", Op: "set", Path: "bad header", Value: "v"},
+		{Target: "header", Op: "set", Path: "Content-Length", Value: "1"},
+		{Target: "header", Op: "set", Path: "X-A", Value: "a\r\nb"},
+		{Target: "body", Op: "set", Path: "metadata.org", Value: "acme"},
+		{Target: "query", Op: "set", Path: "a"},
+		{Target: "body", Op: "upsert", Path: "a"},
+		{Target: "body", Op: "delete", Path: " "},
+	})
+	if len(errs) != 7 {
+		t.Errorf("应报 7 个错误: %v", errs)
+	}
+	p := Provider{RewriteRules: []RewriteRule{
+		{Target: "header", Op: "set", Path: "User-Agent", Value: "relay/1.0"},
+		{Target: "body", Op: "set", Path: "max_tokens", Value: "8192"},
+	}}
+	if errs := p.ValidateConfiguration(); len(errs) != 0 {
+		t.Errorf("合法规则不应报错: %v", errs)
+	}
+}
+
+// TestProxyHandlerAppliesRewriteRules 改写在请求清理之后执行，上游收到的是改写后的头与体
+func TestProxyHandlerAppliesRewriteRules(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+
+	var gotUA, gotOrg atomic.Value
+	var gotBody atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUA.Store(r.Header.Get("User-Agent"))
+		gotOrg.Store(r.Header.Get("X-Org-Id"))
+		body, _ := io.ReadAll(r.Body)
+		gotBody.Store(string(body))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"usage":{"input_tokens":1,"output_tokens":1}}`))
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{{
+		ID: 1, Name: "rw", APIURL: upstream.URL, APIKey: "k", Enabled: true,
+		RequestSanitizeEnabled: true,
+		RewriteRules: []RewriteRule{
+			{Target: "header", Op: "set", Path: "User-Agent", Value: "relay/1.0"},
+			{Target: "header", Op: "set", Path: "x-org-id", Value: "org-1"},
+			{Target: "body", Op: "set", Path: "max_tokens", Value: "4096"},
+			{Target: "body", Op: "set", Path: "metadata", Value: `{"source":"relay"}`},
+		},
+	}}); err != nil {
+		t.Fatalf("保存供应商失败: %v", err)
+	}
+	prs := newTestRelayService(ps)
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("POST", "/v1/messages",
+		strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":64000}`))
+	c.Request.Header.Set("User-Agent", "claude-cli/2.0")
+	prs.proxyHandler("claude", "/v1/messages")(c)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("应转发成功: %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	if ua, _ := gotUA.Load().(string); ua != "relay/1.0" {
+		t.Errorf("User-Agent 应被改写: %q", ua)
+	}
+	if org, _ := gotOrg.Load().(string); org != "org-1" {
+		t.Errorf("应注入组织头: %q", org)
+	}
+	body, _ := gotBody.Load().(string)
+	if gjson.Get(body, "max_tokens").Int() != 4096 || gjson.Get(body, "metadata.source").String() != "relay" {
+		t.Errorf("请求体应被改写: %s", body)
+	}
+}
+
+// TestRewriteRulesNotStackedOnKeyRotation 换密钥重发时从改写前的头重建，replace 规则不会叠加
+func TestRewriteRulesNotStackedOnKeyRotation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+
+	var agents []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.Header.Get("User-Agent"))
+		if r.Header.Get("Authorization") != "Bearer good-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"usage":{"input_tokens":1,"output_tokens":1}}`))
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{{
+		ID: 1, Name: "pool", APIURL: upstream.URL, APIKey: "revoked-key", Enabled: true,
+		ExtraAPIKeys: []string{"good-key"},
+		RewriteRules: []RewriteRule{{Target: "header", Op: "replace", Path: "User-Agent", From: "claude-cli", Value: "claude-cli (relay)"}},
+	}}); err != nil {
+		t.Fatalf("保存供应商失败: %v", err)
+	}
+	prs := newTestRelayService(ps)
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5"}`))
+	c.Request.Header.Set("User-Agent", "claude-cli")
+	prs.proxyHandler("claude", "/v1/messages")(c)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("换密钥后应成功: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if len(agents) != 2 || agents[0] != "claude-cli (relay)" || agents[1] != "claude-cli (relay)" {
+		t.Errorf("每把密钥的出站 User-Agent 都应只改写一次: %q", agents)
+	}
+}
@@ -0,0 +1,597 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// ========== 声明式路由规则 ==========
+//
+// 每个平台一张有序规则表（~/.code-switch/routing-rules.json），请求按顺序与规则比对，
+// 第一条命中的启用规则生效，其余忽略。匹配条件全部满足才算命中（未填写的条件不参与）：
+//
+//   - models：请求模型通配符（任一命中）
+//   - headers：请求头 → 通配符值（"*" 表示存在即可），全部命中
+//   - thinking：是否开启 thinking / reasoning
+//   - minTools / maxTools：tools 数量区间
+//   - minInputTokens / maxInputTokens：预估输入 token 区间（请求体字节数 / 4，与最低成本优先同一口径）
+//   - clients：客户端标识通配符（任一命中），当前取 User-Agent
+//
+// 命中后的动作在 Level 分组之前作用于候选供应商：
+//
+//   - modelOverride：把请求模型改写为该模型，再经各供应商自己的模型映射
+//   - providers：只保留名称命中的供应商（通配符）
+//   - mappedModels：只保留映射后模型命中的供应商，如 ">150k 输入只发往映射到 1M 上下文模型的供应商"
+//   - minLevel / maxLevel：只保留 Level 在区间内的供应商
+//
+// 规则被动作排除的供应商不计失败；全部被排除时按"没有可用供应商"返回并注明规则名。
+// DryRunRouting 用样例请求解释每条规则是否命中、每个供应商为何入选或被跳过。
+
+// routingRulesFile 路由规则配置文件名（位于 ~/.code-switch）
+const routingRulesFile = "routing-rules.json"
+
+// RoutingRule 一条路由规则
+type RoutingRule struct {
+	Name    string            `json:"name"`
+	Enabled bool              `json:"enabled"`
+	Match   RoutingRuleMatch  `json:"match"`
+	Action  RoutingRuleAction `json:"action"`
+}
+
+// RoutingRuleMatch 规则的匹配条件；零值条件不参与匹配
+type RoutingRuleMatch struct {
+	Models         []string          `json:"models,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	Thinking       *bool             `json:"thinking,omitempty"`
+	MinTools       int               `json:"minTools,omitempty"`
+	MaxTools       *int              `json:"maxTools,omitempty"`
+	MinInputTokens int               `json:"minInputTokens,omitempty"`
+	MaxInputTokens int               `json:"maxInputTokens,omitempty"`
+	Clients        []string          `json:"clients,omitempty"`
+}
+
+// RoutingRuleAction 规则命中后的动作
+type RoutingRuleAction struct {
+	Providers     []string `json:"providers,omitempty"`
+	MappedModels  []string `json:"mappedModels,omitempty"`
+	ModelOverride string   `json:"modelOverride,omitempty"`
+	MinLevel      int      `json:"minLevel,omitempty"`
+	MaxLevel      int      `json:"maxLevel,omitempty"`
+}
+
+// routingRequest 参与规则匹配的请求特征
+type routingRequest struct {
+	Model       string
+	Headers     http.Header
+	Thinking    bool
+	Tools       int
+	InputTokens int
+	Client      string // User-Agent
+	ClientName  string // 客户端令牌名（或未开启认证时的自报标识）
+}
+
+// newRoutingRequest 从请求头与请求体提取匹配特征
+func newRoutingRequest(headers http.Header, body []byte, model string) routingRequest {
+	if headers == nil {
+		headers = http.Header{}
+	}
+	return routingRequest{
+		Model:       model,
+		Headers:     headers,
+		Thinking:    requestWantsThinking(body),
+		Tools:       requestToolCount(body),
+		InputTokens: estimateRequestUsage(body).InputTokens,
+		Client:      headers.Get("User-Agent"),
+	}
+}
+
+// requestWantsThinking 请求是否开启了思考：Anthropic thinking、OpenAI reasoning、Gemini thinkingConfig
+func requestWantsThinking(body []byte) bool {
+	if thinking := gjson.GetBytes(body, "thinking"); thinking.Exists() {
+		return thinking.Get("type").String() != "disabled"
+	}
+	if gjson.GetBytes(body, "reasoning").Exists() || gjson.GetBytes(body, "reasoning_effort").String() != "" {
+		return true
+	}
+	return gjson.GetBytes(body, "generationConfig.thinkingConfig").Exists()
+}
+
+// requestToolCount 请求声明的工具数；Gemini 按 functionDeclarations 计数
+func requestToolCount(body []byte) int {
+	count := 0
+	for _, tool := range gjson.GetBytes(body, "tools").Array() {
+		if decls := tool.Get("functionDeclarations"); decls.IsArray() {
+			count += len(decls.Array())
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// explain 判断请求是否满足全部条件；不满足时返回第一条不满足的原因
+func (m RoutingRuleMatch) explain(req routingRequest) (bool, string) {
+	if len(m.Models) > 0 && !matchAnyWildcard(m.Models, req.Model) {
+		return false, fmt.Sprintf("模型 %s 不在 %v 中", req.Model, m.Models)
+	}
+	for _, name := range sortedKeys(m.Headers) {
+		values, ok := req.Headers[http.CanonicalHeaderKey(name)]
+		if !ok || !matchAnyValue(m.Headers[name], values) {
+			return false, fmt.Sprintf("请求头 %s 不匹配 %q", name, m.Headers[name])
+		}
+	}
+	if m.Thinking != nil && *m.Thinking != req.Thinking {
+		return false, fmt.Sprintf("thinking=%v 与条件 %v 不符", req.Thinking, *m.Thinking)
+	}
+	if req.Tools < m.MinTools || (m.MaxTools != nil && req.Tools > *m.MaxTools) {
+		return false, fmt.Sprintf("工具数 %d 不在区间内", req.Tools)
+	}
+	if req.InputTokens < m.MinInputTokens || (m.MaxInputTokens > 0 && req.InputTokens > m.MaxInputTokens) {
+		return false, fmt.Sprintf("预估输入 %d tokens 不在区间内", req.InputTokens)
+	}
+	// 客户端先按令牌名匹配，再回退 User-Agent（未开启认证、也未自报标识的客户端只有 UA）
+	if len(m.Clients) > 0 && !(req.ClientName != "" && matchAnyWildcard(m.Clients, req.ClientName)) &&
+		!matchAnyWildcard(m.Clients, req.Client) {
+		if req.ClientName != "" {
+			return false, fmt.Sprintf("客户端 %q（%q）不在 %v 中", req.ClientName, req.Client, m.Clients)
+		}
+		return false, fmt.Sprintf("客户端 %q 不在 %v 中", req.Client, m.Clients)
+	}
+	return true, ""
+}
+
+// allows 判断供应商是否在动作的目标集合内；不在时返回原因
+func (a RoutingRuleAction) allows(name string, level int, mappedModel string) (bool, string) {
+	if len(a.Providers) > 0 && !matchAnyWildcard(a.Providers, name) {
+		return false, "不在规则指定的供应商中"
+	}
+	if len(a.MappedModels) > 0 && !matchAnyWildcard(a.MappedModels, mappedModel) {
+		return false, fmt.Sprintf("映射后模型 %s 不在规则指定的 %v 中", mappedModel, a.MappedModels)
+	}
+	if level <= 0 {
+		level = 1
+	}
+	if (a.MinLevel > 0 && level < a.MinLevel) || (a.MaxLevel > 0 && level > a.MaxLevel) {
+		return false, fmt.Sprintf("Level %d 不在规则指定的区间内", level)
+	}
+	return true, ""
+}
+
+// ValidateConfiguration 验证规则配置，返回错误列表（空表示通过）
+func (r *RoutingRule) ValidateConfiguration() []string {
+	var errs []string
+	patterns := map[string][]string{
+		"models":       r.Match.Models,
+		"clients":      r.Match.Clients,
+		"providers":    r.Action.Providers,
+		"mappedModels": r.Action.MappedModels,
+	}
+	for _, field := range sortedKeys(patterns) {
+		for _, pattern := range patterns[field] {
+			if strings.TrimSpace(pattern) == "" || strings.Count(pattern, "*") > 1 {
+				errs = append(errs, fmt.Sprintf("%s 中的 %q 无效（不能为空，最多一个 *）", field, pattern))
+			}
+		}
+	}
+	for _, name := range sortedKeys(r.Match.Headers) {
+		if strings.TrimSpace(name) == "" || strings.Count(r.Match.Headers[name], "*") > 1 {
+			errs = append(errs, fmt.Sprintf("请求头条件 %q 无效（名称不能为空，值最多一个 *）", name))
+		}
+	}
+	if r.Match.MinTools < 0 || (r.Match.MaxTools != nil && *r.Match.MaxTools < r.Match.MinTools) {
+		errs = append(errs, "工具数区间无效")
+	}
+	if r.Match.MinInputTokens < 0 || r.Match.MaxInputTokens < 0 ||
+		(r.Match.MaxInputTokens > 0 && r.Match.MaxInputTokens < r.Match.MinInputTokens) {
+		errs = append(errs, "预估输入 token 区间无效")
+	}
+	if r.Action.ModelOverride != "" && strings.Contains(r.Action.ModelOverride, "*") {
+		errs = append(errs, "改写模型不能包含通配符")
+	}
+	if r.Action.MinLevel < 0 || r.Action.MinLevel > 10 || r.Action.MaxLevel < 0 || r.Action.MaxLevel > 10 ||
+		(r.Action.MaxLevel > 0 && r.Action.MaxLevel < r.Action.MinLevel) {
+		errs = append(errs, "Level 区间无效（1-10，下限不能大于上限）")
+	}
+	a := r.Action
+	if len(a.Providers) == 0 && len(a.MappedModels) == 0 && a.ModelOverride == "" && a.MinLevel == 0 && a.MaxLevel == 0 {
+		errs = append(errs, "规则没有任何动作（供应商、映射后模型、改写模型或 Level 区间至少填一项）")
+	}
+	return errs
+}
+
+// validateRoutingRules 逐条校验，错误带上规则序号与名称
+func validateRoutingRules(rules []RoutingRule) []string {
+	var errs []string
+	for i := range rules {
+		for _, e := range rules[i].ValidateConfiguration() {
+			errs = append(errs, fmt.Sprintf("规则 #%d（%s）: %s", i+1, rules[i].Name, e))
+		}
+	}
+	return errs
+}
+
+// matchRoutingRule 返回第一条命中的启用规则（下标与规则）；配置无效的规则跳过
+func matchRoutingRule(rules []RoutingRule, req routingRequest) (int, *RoutingRule) {
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.Enabled || len(rule.ValidateConfiguration()) > 0 {
+			continue
+		}
+		if ok, _ := rule.Match.explain(req); ok {
+			return i, rule
+		}
+	}
+	return -1, nil
+}
+
+func matchAnyWildcard(patterns []string, text string) bool {
+	for _, pattern := range patterns {
+		if matchWildcard(pattern, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAnyValue(pattern string, values []string) bool {
+	for _, v := range values {
+		if matchWildcard(pattern, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ---------- 存储 ----------
+
+// routingRuleStore 路由规则文件的进程内缓存：按文件修改时间失效，手工编辑文件同样生效
+type routingRuleStore struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	size    int64
+	rules   map[string][]RoutingRule
+}
+
+var routingRules = &routingRuleStore{}
+
+func routingRulesPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("获取用户目录失败: %w", err)
+	}
+	return filepath.Join(home, appSettingsDir, routingRulesFile), nil
+}
+
+// load 返回全部平台的规则（调用方不得修改返回值）
+func (s *routingRuleStore) load() (map[string][]RoutingRule, error) {
+	path, err := routingRulesPath()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loadLocked(path)
+}
+
+// loadLocked load 的实现，调用方须持有 s.mu
+func (s *routingRuleStore) loadLocked(path string) (map[string][]RoutingRule, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.path, s.rules = path, map[string][]RoutingRule{}
+		s.modTime, s.size = time.Time{}, 0
+		return s.rules, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.rules != nil && s.path == path && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.rules, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取路由规则失败: %w", err)
+	}
+	rules := map[string][]RoutingRule{}
+	if len(strings.TrimSpace(string(data))) > 0 {
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return nil, fmt.Errorf("解析路由规则失败: %w", err)
+		}
+	}
+	s.path, s.rules, s.modTime, s.size = path, rules, info.ModTime(), info.Size()
+	return rules, nil
+}
+
+// save 替换一个平台的规则并落盘。
+// 读-改-写全程持锁，并发保存不同平台不会互相覆盖
+func (s *routingRuleStore) save(platform string, rules []RoutingRule) error {
+	path, err := routingRulesPath()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.loadLocked(path)
+	if err != nil {
+		return err
+	}
+	next := make(map[string][]RoutingRule, len(all)+1)
+	for k, v := range all {
+		next[k] = v
+	}
+	if len(rules) == 0 {
+		delete(next, platform)
+	} else {
+		next[platform] = append([]RoutingRule(nil), rules...)
+	}
+	data, err := json.MarshalIndent(next, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化路由规则失败: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("创建配置目录失败: %w", err)
+	}
+	if err := atomicWriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("写入路由规则失败: %w", err)
+	}
+	s.rules = nil // 下次读取按新文件重建缓存
+	return nil
+}
+
+// GetRoutingRules 读取平台的路由规则（有序）
+func (prs *ProviderRelayService) GetRoutingRules(platform string) ([]RoutingRule, error) {
+	all, err := routingRules.load()
+	if err != nil {
+		return nil, err
+	}
+	return append([]RoutingRule{}, all[platform]...), nil
+}
+
+// SaveRoutingRules 校验并保存平台的路由规则；任一规则无效则整体拒绝
+func (prs *ProviderRelayService) SaveRoutingRules(platform string, rules []RoutingRule) error {
+	platform = strings.TrimSpace(platform)
+	if platform == "" {
+		return errors.New("平台不能为空")
+	}
+	if errs := validateRoutingRules(rules); len(errs) > 0 {
+		return fmt.Errorf("路由规则校验失败: %s", strings.Join(errs, "；"))
+	}
+	return routingRules.save(platform, rules)
+}
+
+// resolveRoutingRule 为请求匹配路由规则；读取失败时记日志并按无规则处理
+func (prs *ProviderRelayService) resolveRoutingRule(platform string, headers http.Header, clientName string, body []byte, model string) *RoutingRule {
+	all, err := routingRules.load()
+	if err != nil {
+		fmt.Printf("[WARN] 读取路由规则失败，按无规则调度: %v\n", err)
+		return nil
+	}
+	rules := all[platform]
+	if len(rules) == 0 {
+		return nil
+	}
+	req := newRoutingRequest(headers, body, model)
+	req.ClientName = clientName
+	idx, rule := matchRoutingRule(rules, req)
+	if rule != nil {
+		fmt.Printf("[INFO] 🧭 命中路由规则 #%d（%s）\n", idx+1, rule.Name)
+	}
+	return rule
+}
+
+// applyRoutingModelOverride 按命中规则改写请求体中的模型；未命中、无改写或改写失败时原样返回
+func applyRoutingModelOverride(rule *RoutingRule, body []byte, model string) ([]byte, string) {
+	if rule == nil || rule.Action.ModelOverride == "" || rule.Action.ModelOverride == model {
+		return body, model
+	}
+	rewritten, err := ReplaceModelInRequestBody(body, rule.Action.ModelOverride)
+	if err != nil {
+		fmt.Printf("[WARN] 路由规则「%s」改写模型失败，保持原模型: %v\n", rule.Name, err)
+		return body, model
+	}
+	fmt.Printf("[INFO] 🧭 路由规则「%s」改写模型: %s → %s\n", rule.Name, model, rule.Action.ModelOverride)
+	return rewritten, rule.Action.ModelOverride
+}
+
+// ---------- 演练（dry-run）----------
+
+// RoutingSample 演练用的样例请求。Body 填写时从中提取 thinking/tools/输入 token，
+// 否则用手填的 Thinking/Tools/InputTokens
+type RoutingSample struct {
+	Model       string            `json:"model"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        string            `json:"body,omitempty"`
+	Thinking    bool              `json:"thinking,omitempty"`
+	Tools       int               `json:"tools,omitempty"`
+	InputTokens int               `json:"inputTokens,omitempty"`
+	ClientName  string            `json:"clientName,omitempty"` // 客户端令牌名，留空时只按 User-Agent 匹配
+}
+
+// RoutingRuleTrace 一条规则的比对结果
+type RoutingRuleTrace struct {
+	Index   int    `json:"index"`
+	Name    string `json:"name"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// RoutingProviderTrace 一个供应商的初筛结果
+type RoutingProviderTrace struct {
+	Name        string `json:"name"`
+	Level       int    `json:"level"`
+	MappedModel string `json:"mappedModel,omitempty"`
+	Eligible    bool   `json:"eligible"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// RoutingDryRunResult 演练结果：Providers 按 Level、再按配置顺序排列。
+// 同 Level 内实际顺序还取决于轮询/最快优先/最低成本/会话粘性，以及并发与速率是否满载
+type RoutingDryRunResult struct {
+	Model       string                 `json:"model"`
+	MatchedRule string                 `json:"matchedRule,omitempty"`
+	RuleIndex   int                    `json:"ruleIndex"`
+	Rules       []RoutingRuleTrace     `json:"rules"`
+	Providers   []RoutingProviderTrace `json:"providers"`
+}
+
+// routingCandidate 演练时 claude/codex 与 Gemini 供应商的统一视图
+type routingCandidate struct {
+	name      string
+	level     int
+	ready     bool
+	errs      []string
+	supported func(model string) bool
+	mapped    func(model string) string
+	spendCap  float64
+	period    string
+}
+
+// DryRunRouting 用样例请求演练路由：解释每条规则是否命中、每个供应商为何入选或被跳过
+func (prs *ProviderRelayService) DryRunRouting(platform string, sample RoutingSample) (*RoutingDryRunResult, error) {
+	rules, err := prs.GetRoutingRules(platform)
+	if err != nil {
+		return nil, err
+	}
+	headers := http.Header{}
+	for k, v := range sample.Headers {
+		headers.Set(k, v)
+	}
+	var req routingRequest
+	if strings.TrimSpace(sample.Body) != "" {
+		if !gjson.Valid(sample.Body) {
+			return nil, errors.New("样例请求体不是合法 JSON")
+		}
+		model := sample.Model
+		if model == "" {
+			model = gjson.Get(sample.Body, "model").String()
+		}
+		req = newRoutingRequest(headers, []byte(sample.Body), model)
+	} else {
+		req = routingRequest{Model: sample.Model, Headers: headers, Thinking: sample.Thinking,
+			Tools: sample.Tools, InputTokens: sample.InputTokens, Client: headers.Get("User-Agent")}
+	}
+	req.ClientName = strings.TrimSpace(sample.ClientName)
+
+	result := &RoutingDryRunResult{Model: req.Model, RuleIndex: -1}
+	var rule *RoutingRule
+	for i := range rules {
+		trace := RoutingRuleTrace{Index: i, Name: rules[i].Name}
+		switch {
+		case !rules[i].Enabled:
+			trace.Reason = "未启用"
+		case len(rules[i].ValidateConfiguration()) > 0:
+			trace.Reason = "配置无效: " + strings.Join(rules[i].ValidateConfiguration(), "；")
+		case rule != nil:
+			trace.Reason = "已有前序规则命中"
+		default:
+			trace.Matched, trace.Reason = rules[i].Match.explain(req)
+			if trace.Matched {
+				rule = &rules[i]
+				result.MatchedRule, result.RuleIndex = rule.Name, i
+			}
+		}
+		result.Rules = append(result.Rules, trace)
+	}
+	if rule != nil && rule.Action.ModelOverride != "" {
+		result.Model = rule.Action.ModelOverride
+	}
+
+	candidates, err := prs.routingCandidates(platform)
+	if err != nil {
+		return nil, err
+	}
+	for _, cand := range candidates {
+		trace := RoutingProviderTrace{Name: cand.name, Level: cand.level}
+		if result.Model != "" {
+			trace.MappedModel = cand.mapped(result.Model)
+		}
+		switch {
+		case !cand.ready:
+			trace.Reason = "未启用或未填写地址/密钥"
+		case len(cand.errs) > 0:
+			trace.Reason = "配置校验失败: " + strings.Join(cand.errs, "；")
+		case result.Model != "" && !cand.supported(result.Model):
+			trace.Reason = "不支持该模型"
+		default:
+			if rule != nil {
+				if ok, why := rule.Action.allows(cand.name, cand.level, trace.MappedModel); !ok {
+					trace.Reason = fmt.Sprintf("路由规则「%s」: %s", rule.Name, why)
+					break
+				}
+			}
+			if blacklisted, until := prs.blacklistService.IsBlacklisted(platform, cand.name); blacklisted {
+				trace.Reason = fmt.Sprintf("已拉黑至 %s", until.Format("15:04:05"))
+				break
+			}
+			// 演练只读：不能像真实调度那样触发"已达消费上限"通知
+			if capped, resetAt := prs.spendCapReached(platform, cand.name, cand.spendCap, cand.period); capped {
+				trace.Reason = fmt.Sprintf("已达消费上限，%s 重置", resetAt.Format("01-02 15:04"))
+				break
+			}
+			trace.Eligible = true
+		}
+		result.Providers = append(result.Providers, trace)
+	}
+	sort.SliceStable(result.Providers, func(i, j int) bool {
+		return result.Providers[i].Level < result.Providers[j].Level
+	})
+	return result, nil
+}
+
+// routingCandidates 读取平台供应商，转换为统一视图
+func (prs *ProviderRelayService) routingCandidates(platform string) ([]routingCandidate, error) {
+	var out []routingCandidate
+	level := func(l int) int {
+		if l <= 0 {
+			return 1
+		}
+		return l
+	}
+	if platform == "gemini" {
+		if prs.geminiService == nil {
+			return nil, nil
+		}
+		providers, _ := prs.geminiService.providersWithGen()
+		for i := range providers {
+			p := providers[i]
+			out = append(out, routingCandidate{
+				name: p.Name, level: level(p.Level), ready: p.Enabled && p.BaseURL != "",
+				errs: p.ValidateConfiguration(), supported: p.IsModelSupported, mapped: p.GetEffectiveModel,
+				spendCap: p.SpendCap, period: p.SpendCapPeriod,
+			})
+		}
+		return out, nil
+	}
+	providers, err := prs.providerService.LoadProviders(platform)
+	if err != nil {
+		return nil, err
+	}
+	for i := range providers {
+		p := providers[i]
+		out = append(out, routingCandidate{
+			name: p.Name, level: level(p.Level), ready: p.Enabled && p.APIURL != "" && len(p.KeyPool()) > 0,
+			errs: p.ValidateConfiguration(), supported: p.IsModelSupported, mapped: p.GetEffectiveModel,
+			spendCap: p.SpendCap, period: p.SpendCapPeriod,
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestRoutingRuleMatch 条件全部满足才命中，第一条命中的启用规则生效
+func TestRoutingRuleMatch(t *testing.T) {
+	yes := true
+	rules := []RoutingRule{
+		{Name: "disabled", Enabled: false, Action: RoutingRuleAction{MinLevel: 1}},
+		{Name: "long", Enabled: true,
+			Match:  RoutingRuleMatch{Models: []string{"claude-*"}, MinInputTokens: 150000},
+			Action: RoutingRuleAction{MappedModels: []string{"*[1m]"}}},
+		{Name: "thinking-ide", Enabled: true,
+			Match:  RoutingRuleMatch{Thinking: &yes, Headers: map[string]string{"x-client": "ide-*"}, MinTools: 1},
+			Action: RoutingRuleAction{Providers: []string{"fast-*"}}},
+	}
+
+	long := newRoutingRequest(nil, []byte(`{"model":"claude-sonnet-4-5","messages":"`+strings.Repeat("x", 700000)+`"}`), "claude-sonnet-4-5")
+	if idx, rule := matchRoutingRule(rules, long); rule == nil || idx != 1 {
+		t.Fatalf("长上下文请求应命中 long, 实际 %d", idx)
+	}
+
+	headers := http.Header{}
+	headers.Set("X-Client", "ide-vscode")
+	body := []byte(`{"model":"claude-sonnet-4-5","thinking":{"type":"enabled"},"tools":[{"name":"a"},{"name":"b"}]}`)
+	req := newRoutingRequest(headers, body, "claude-sonnet-4-5")
+	if req.Tools != 2 || !req.Thinking {
+		t.Fatalf("请求特征提取错误: %+v", req)
+	}
+	if _, rule := matchRoutingRule(rules, req); rule == nil || rule.Name != "thinking-ide" {
+		t.Fatalf("应命中 thinking-ide, 实际 %+v", rule)
+	}
+
+	headers.Set("X-Client", "cli")
+	if _, rule := matchRoutingRule(rules, newRoutingRequest(headers, body, "claude-sonnet-4-5")); rule != nil {
+		t.Errorf("请求头不匹配不应命中: %s", rule.Name)
+	}
+
+	// 客户端条件先按令牌名匹配，再回退 User-Agent
+	clientRules := []RoutingRule{{Name: "team", Enabled: true,
+		Match:  RoutingRuleMatch{Clients: []string{"team-*"}},
+		Action: RoutingRuleAction{MinLevel: 2}}}
+	uaHeaders := http.Header{}
+	uaHeaders.Set("User-Agent", "claude-cli/2.0")
+	byName := newRoutingRequest(uaHeaders, nil, "m")
+	byName.ClientName = "team-alice"
+	if _, rule := matchRoutingRule(clientRules, byName); rule == nil {
+		t.Error("令牌名匹配时应命中")
+	}
+	if _, rule := matchRoutingRule(clientRules, newRoutingRequest(uaHeaders, nil, "m")); rule != nil {
+		t.Error("令牌名与 UA 都不匹配时不应命中")
+	}
+	uaHeaders.Set("User-Agent", "team-bot/1.0")
+	byUA := newRoutingRequest(uaHeaders, nil, "m")
+	byUA.ClientName = "ci"
+	if _, rule := matchRoutingRule(clientRules, byUA); rule == nil {
+		t.Error("令牌名不匹配时应回退 User-Agent")
+	}
+
+	gemini := []byte(`{"tools":[{"functionDeclarations":[{"name":"a"},{"name":"b"},{"name":"c"}]}],"generationConfig":{"thinkingConfig":{"thinkingBudget":1024}}}`)
+	if n := requestToolCount(gemini); n != 3 {
+		t.Errorf("Gemini 工具数应按 functionDeclarations 计: %d", n)
+	}
+	if !requestWantsThinking(gemini) || requestWantsThinking([]byte(`{"thinking":{"type":"disabled"}}`)) {
+		t.Error("thinking 识别错误")
+	}
+}
+
+// TestRoutingRuleValidate 通配符、区间、Level 与空动作校验
+func TestRoutingRuleValidate(t *testing.T) {
+	maxTools := 1
+	bad := RoutingRule{
+		Match:  RoutingRuleMatch{Models: []string{"a*b*"}, MinTools: 2, MaxTools: &maxTools, MinInputTokens: 10, MaxInputTokens: 5},
+		Action: RoutingRuleAction{MinLevel: 5, MaxLevel: 3},
+	}
+	if errs := bad.ValidateConfiguration(); len(errs) != 4 {
+		t.Errorf("应报 4 个错误: %v", errs)
+	}
+	if errs := (&RoutingRule{Name: "empty"}).ValidateConfiguration(); len(errs) != 1 {
+		t.Errorf("没有动作应报错: %v", errs)
+	}
+	good := RoutingRule{Match: RoutingRuleMatch{Models: []string{"gpt-*"}}, Action: RoutingRuleAction{ModelOverride: "gpt-5", MaxLevel: 2}}
+	if errs := good.ValidateConfiguration(); len(errs) != 0 {
+		t.Errorf("合法规则不应报错: %v", errs)
+	}
+}
+
+// TestRoutingRulesSaveAndDryRun 保存时整体校验；演练解释规则命中与每个供应商的去留
+func TestRoutingRulesSaveAndDryRun(t *testing.T) {
+	setupRenameTestEnv(t)
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "short", APIURL: "http://127.0.0.1:1", APIKey: "k", Enabled: true, Level: 1},
+		{ID: 2, Name: "long", APIURL: "http://127.0.0.1:1", APIKey: "k", Enabled: true, Level: 2,
+			ModelMapping: map[string]string{"claude-sonnet-4-5": "claude-sonnet-4-5[1m]"}},
+		{ID: 3, Name: "off", APIURL: "http://127.0.0.1:1", APIKey: "k", Enabled: false},
+	}); err != nil {
+		t.Fatalf("保存供应商失败: %v", err)
+	}
+	prs := newTestRelayService(ps)
+
+	if err := prs.SaveRoutingRules("claude", []RoutingRule{{Name: "bad", Enabled: true}}); err == nil {
+		t.Fatal("无效规则应拒绝保存")
+	}
+	rules := []RoutingRule{{Name: "1m", Enabled: true,
+		Match:  RoutingRuleMatch{MinInputTokens: 150000},
+		Action: RoutingRuleAction{MappedModels: []string{"*[1m]"}}}}
+	if err := prs.SaveRoutingRules("claude", rules); err != nil {
+		t.Fatalf("保存规则失败: %v", err)
+	}
+	if got, _ := prs.GetRoutingRules("claude"); len(got) != 1 || got[0].Name != "1m" {
+		t.Fatalf("读回规则不一致: %+v", got)
+	}
+
+	result, err := prs.DryRunRouting("claude", RoutingSample{Model: "claude-sonnet-4-5", InputTokens: 200000})
+	if err != nil {
+		t.Fatalf("演练失败: %v", err)
+	}
+	if result.MatchedRule != "1m" || len(result.Providers) != 3 {
+		t.Fatalf("演练结果不符: %+v", result)
+	}
+	eligible := map[string]bool{}
+	for _, p := range result.Providers {
+		eligible[p.Name] = p.Eligible
+		if !p.Eligible && p.Reason == "" {
+			t.Errorf("被跳过的供应商应说明原因: %+v", p)
+		}
+	}
+	if eligible["short"] || !eligible["long"] || eligible["off"] {
+		t.Errorf("只有映射到 1M 模型的供应商应入选: %+v", result.Providers)
+	}
+
+	result, _ = prs.DryRunRouting("claude", RoutingSample{Model: "claude-sonnet-4-5", InputTokens: 1000})
+	if result.MatchedRule != "" || !result.Providers[0].Eligible {
+		t.Errorf("短请求不应命中规则: %+v", result)
+	}
+}
+
+// TestRoutingRuleStoreConcurrentSave 并发保存不同平台的规则，各平台都应保留
+func TestRoutingRuleStoreConcurrentSave(t *testing.T) {
+	setupRenameTestEnv(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rule := RoutingRule{Name: "r", Enabled: true, Match: RoutingRuleMatch{MinInputTokens: 1}}
+			if err := routingRules.save(fmt.Sprintf("cli%d", i), []RoutingRule{rule}); err != nil {
+				t.Errorf("保存失败: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	all, err := routingRules.load()
+	if err != nil {
+		t.Fatalf("读取失败: %v", err)
+	}
+	if len(all) != 8 {
+		t.Fatalf("并发保存丢失了平台规则: %d", len(all))
+	}
+}
+
+// TestDryRunRoutingSpendCapDoesNotNotify 演练遇到已达消费上限的供应商只说明原因，不占用"首次触达"通知
+func TestDryRunRoutingSpendCapDoesNotNotify(t *testing.T) {
+	setupRenameTestEnv(t)
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "capped", APIURL: "http://127.0.0.1:1", APIKey: "k", Enabled: true, SpendCap: 5},
+	}); err != nil {
+		t.Fatalf("保存供应商失败: %v", err)
+	}
+	prs := newTestRelayService(ps)
+	now := prs.spend.now()
+	dayStart, _ := spendCapWindow(now, "daily")
+	prs.spend.windows[spendKey("claude", "capped")] = &spendWindow{start: dayStart, spent: 6, syncedAt: now}
+
+	result, err := prs.DryRunRouting("claude", RoutingSample{Model: "claude-sonnet-4-5"})
+	if err != nil {
+		t.Fatalf("演练失败: %v", err)
+	}
+	if len(result.Providers) != 1 || result.Providers[0].Eligible || !strings.Contains(result.Providers[0].Reason, "消费上限") {
+		t.Fatalf("应说明已达消费上限: %+v", result.Providers)
+	}
+	if !prs.spend.claimNotify("claude", "capped", dayStart) {
+		t.Error("演练不应消耗消费上限通知")
+	}
+}
+
+// TestProxyHandlerRoutingRule 命中规则后改写模型并只发往目标供应商；全部被排除时 404 注明规则
+func TestProxyHandlerRoutingRule(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+
+	var hitsA, hitsB atomic.Int32
+	var gotModel atomic.Value
+	upstream := func(hits *atomic.Int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			body, _ := io.ReadAll(r.Body)
+			gotModel.Store(string(body))
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"usage":{"input_tokens":1,"output_tokens":1}}`))
+		}))
+	}
+	srvA, srvB := upstream(&hitsA), upstream(&hitsB)
+	defer srvA.Close()
+	defer srvB.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "a", APIURL: srvA.URL, APIKey: "k", Enabled: true, Level: 1},
+		{ID: 2, Name: "b", APIURL: srvB.URL, APIKey: "k", Enabled: true, Level: 2},
+	}); err != nil {
+		t.Fatalf("保存供应商失败: %v", err)
+	}
+	prs := newTestRelayService(ps)
+	if err := prs.SaveRoutingRules("claude", []RoutingRule{{Name: "haiku-to-b", Enabled: true,
+		Match:  RoutingRuleMatch{Models: []string{"claude-haiku-*"}},
+		Action: RoutingRuleAction{Providers: []string{"b"}, ModelOverride: "claude-sonnet-4-5"}}}); err != nil {
+		t.Fatalf("保存规则失败: %v", err)
+	}
+
+	serve := func(model string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model":"`+model+`"}`))
+		prs.proxyHandler("claude", "/v1/messages")(c)
+		return recorder
+	}
+
+	if rec := serve("claude-haiku-4-5"); rec.Code != http.StatusOK {
+		t.Fatalf("应转发成功: %d %s", rec.Code, rec.Body.String())
+	}
+	if hitsA.Load() != 0 || hitsB.Load() != 1 {
+		t.Errorf("只应发往 b: a=%d b=%d", hitsA.Load(), hitsB.Load())
+	}
+	if body, _ := gotModel.Load().(string); !strings.Contains(body, "claude-sonnet-4-5") {
+		t.Errorf("模型应被改写: %s", body)
+	}
+
+	if err := prs.SaveRoutingRules("claude", []RoutingRule{{Name: "nowhere", Enabled: true,
+		Action: RoutingRuleAction{Providers: []string{"missing"}}}}); err != nil {
+		t.Fatalf("保存规则失败: %v", err)
+	}
+	rec := serve("claude-haiku-4-5")
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "nowhere") {
+		t.Errorf("全部被规则排除应 404 并注明规则: %d %s", rec.Code, rec.Body.String())
+	}
+}
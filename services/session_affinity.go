@@ -0,0 +1,278 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// ========== 会话粘性路由（保护 Prompt Cache 命中）==========
+//
+// 轮询与降级会把同一个 CLI 会话打散到不同供应商，上游的 prompt cache 无法复用，
+// cache_create 费用随之膨胀。开启后，从请求中识别会话标识，把会话"粘"在最近一次
+// 成功承接它的供应商上：同 Level 排序（成本/延迟/轮询）完成后，粘住的供应商被
+// 提到该 Level 首位。
+//
+//   - 粘性只在 Level 内生效，不改变 Level 之间的优先级；
+//   - 供应商被拉黑、禁用或失败时照常降级，新的承接者成功后接管粘性；
+//   - 每次成功都会刷新有效期（滑动 TTL），会话闲置超过 TTL 即失效；
+//   - 绑定只在内存中，重启即清空；请求日志记录会话标识与是否命中粘性。
+//
+// 会话标识的来源按优先级：
+//   1. metadata.user_id（Claude Code 在其中带有会话 ID）；
+//   2. 会话请求头（Codex CLI 的 session_id 等）；
+//   3. prompt_cache_key（OpenAI Responses 协议）；
+//   4. 系统提示词 + 第一条用户消息的哈希（同一会话后续请求的前缀不变）。
+
+const (
+	defaultStickyTTLMinutes = 60
+	maxStickyTTLMinutes     = 24 * 60
+
+	// maxSessionAffinityEntries 绑定数上限，超过时先清理过期项，仍超限则淘汰最久未用的
+	maxSessionAffinityEntries = 4096
+
+	// relaySessionKeyKey 存于 gin.Context：本次客户端请求的会话标识（未开启粘性时不设置）
+	relaySessionKeyKey = "relaySessionKey"
+)
+
+// sessionHeaderNames 携带会话标识的请求头（大小写不敏感）
+var sessionHeaderNames = []string{"session_id", "x-session-id", "conversation_id"}
+
+// deriveSessionKey 从请求中识别会话标识，返回不可逆的短哈希；无法识别时返回空串
+func deriveSessionKey(headers http.Header, body []byte) string {
+	if v := strings.TrimSpace(gjson.GetBytes(body, "metadata.user_id").String()); v != "" {
+		return hashSessionSource("user_id", v)
+	}
+	for _, name := range sessionHeaderNames {
+		for key, values := range headers {
+			if strings.EqualFold(key, name) && len(values) > 0 && strings.TrimSpace(values[0]) != "" {
+				return hashSessionSource("header", strings.TrimSpace(values[0]))
+			}
+		}
+	}
+	if v := strings.TrimSpace(gjson.GetBytes(body, "prompt_cache_key").String()); v != "" {
+		return hashSessionSource("prompt_cache_key", v)
+	}
+
+	// 兜底：系统提示词 + 第一条用户消息（Anthropic / OpenAI Chat / Responses / Gemini）
+	system := firstExisting(body, "system", "instructions", "systemInstruction")
+	firstUser := firstExisting(body,
+		`messages.#(role=="user")`, `input.#(role=="user")`, `contents.#(role=="user")`)
+	if firstUser == "" {
+		if input := gjson.GetBytes(body, "input"); input.Type == gjson.String {
+			firstUser = input.Raw
+		}
+	}
+	if firstUser == "" {
+		return ""
+	}
+	return hashSessionSource("prefix", system+"\x00"+firstUser)
+}
+
+// firstExisting 返回第一个存在的 JSON 路径的原始值
+func firstExisting(body []byte, paths ...string) string {
+	for _, path := range paths {
+		if v := gjson.GetBytes(body, path); v.Exists() {
+			return v.Raw
+		}
+	}
+	return ""
+}
+
+func hashSessionSource(source, value string) string {
+	sum := sha256.Sum256([]byte(source + ":" + value))
+	return hex.EncodeToString(sum[:8])
+}
+
+// affinityEntry 单个会话的粘性绑定
+type affinityEntry struct {
+	provider  string
+	boundAt   time.Time
+	expiresAt time.Time
+}
+
+// sessionAffinity 按 "platform:sessionKey" 维护会话到供应商的绑定（进程内）
+type sessionAffinity struct {
+	mu      sync.Mutex
+	entries map[string]*affinityEntry
+	// now 取当前时间，测试可替换
+	now func() time.Time
+}
+
+func newSessionAffinity() *sessionAffinity {
+	return &sessionAffinity{
+		entries: make(map[string]*affinityEntry),
+		now:     time.Now,
+	}
+}
+
+// lookup 返回未过期的绑定供应商
+func (s *sessionAffinity) lookup(platform, key string) (string, bool) {
+	if s == nil || key == "" {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.entries[platform+":"+key]
+	if entry == nil {
+		return "", false
+	}
+	if !s.now().Before(entry.expiresAt) {
+		delete(s.entries, platform+":"+key)
+		return "", false
+	}
+	return entry.provider, true
+}
+
+// bind 把会话绑定到供应商并刷新有效期
+func (s *sessionAffinity) bind(platform, key, provider string, ttl time.Duration) {
+	if s == nil || key == "" || provider == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	mapKey := platform + ":" + key
+	entry := s.entries[mapKey]
+	if entry == nil {
+		if len(s.entries) >= maxSessionAffinityEntries {
+			s.evictLocked(now)
+		}
+		entry = &affinityEntry{}
+		s.entries[mapKey] = entry
+	}
+	if entry.provider != provider {
+		entry.provider = provider
+		entry.boundAt = now
+	}
+	entry.expiresAt = now.Add(ttl)
+}
+
+// evictLocked 清理过期绑定；仍超限时淘汰最早到期的一项
+func (s *sessionAffinity) evictLocked(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for k, e := range s.entries {
+		if !now.Before(e.expiresAt) {
+			delete(s.entries, k)
+			continue
+		}
+		if oldestKey == "" || e.expiresAt.Before(oldest) {
+			oldestKey, oldest = k, e.expiresAt
+		}
+	}
+	if len(s.entries) >= maxSessionAffinityEntries && oldestKey != "" {
+		delete(s.entries, oldestKey)
+	}
+}
+
+// isStickyRoutingEnabled 检查会话粘性是否启用
+func (prs *ProviderRelayService) isStickyRoutingEnabled() bool {
+	if prs.appSettings == nil {
+		return false
+	}
+	settings, err := prs.appSettings.GetAppSettings()
+	if err != nil {
+		return false
+	}
+	return settings.EnableStickyRouting
+}
+
+// stickyTTL 读取粘性有效期，非法值回退默认
+func (prs *ProviderRelayService) stickyTTL() time.Duration {
+	minutes := defaultStickyTTLMinutes
+	if prs.appSettings != nil {
+		if settings, err := prs.appSettings.GetAppSettings(); err == nil {
+			minutes = normalizeStickyTTLMinutes(settings.StickyTTLMinutes)
+		}
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// normalizeStickyTTLMinutes 粘性有效期：未配置/非正数取默认，上限 24 小时
+func normalizeStickyTTLMinutes(minutes int) int {
+	if minutes <= 0 {
+		return defaultStickyTTLMinutes
+	}
+	if minutes > maxStickyTTLMinutes {
+		return maxStickyTTLMinutes
+	}
+	return minutes
+}
+
+// markSessionKey 在 handler 入口识别会话标识并存入 gin.Context；未开启粘性时为空操作
+func (prs *ProviderRelayService) markSessionKey(c *gin.Context, body []byte) {
+	if !prs.isStickyRoutingEnabled() {
+		return
+	}
+	if key := deriveSessionKey(c.Request.Header, body); key != "" {
+		c.Set(relaySessionKeyKey, key)
+	}
+}
+
+// sessionKeyOf 读取本次请求的会话标识
+func sessionKeyOf(c *gin.Context) string {
+	return c.GetString(relaySessionKeyKey)
+}
+
+// sessionAffinityFor 返回本次请求的会话标识，以及发往 providerName 是否命中粘性（写请求日志用）
+func (prs *ProviderRelayService) sessionAffinityFor(c *gin.Context, platform, providerName string) (string, bool) {
+	key := sessionKeyOf(c)
+	if key == "" {
+		return "", false
+	}
+	bound, ok := prs.affinity.lookup(platform, key)
+	return key, ok && bound == providerName
+}
+
+// bindSessionAffinity 转发成功后把会话绑定到实际承接的供应商
+func (prs *ProviderRelayService) bindSessionAffinity(c *gin.Context, platform, providerName string) {
+	if key := sessionKeyOf(c); key != "" {
+		prs.affinity.bind(platform, key, providerName, prs.stickyTTL())
+	}
+}
+
+// stickyOrder 把会话粘住的供应商提到同 Level 首位（返回新切片，不修改原切片）
+func (prs *ProviderRelayService) stickyOrder(c *gin.Context, platform string, providers []Provider) []Provider {
+	bound, ok := prs.affinity.lookup(platform, sessionKeyOf(c))
+	if !ok || len(providers) <= 1 || providers[0].Name == bound {
+		return providers
+	}
+	for i, p := range providers {
+		if p.Name == bound {
+			result := make([]Provider, 0, len(providers))
+			result = append(result, p)
+			result = append(result, providers[:i]...)
+			result = append(result, providers[i+1:]...)
+			fmt.Printf("[INFO] 🧲 会话粘性: 优先使用 %s\n", bound)
+			return result
+		}
+	}
+	return providers
+}
+
+// stickyOrderGemini 对 Gemini providers 应用会话粘性（复用相同逻辑）
+func (prs *ProviderRelayService) stickyOrderGemini(c *gin.Context, providers []GeminiProvider) []GeminiProvider {
+	bound, ok := prs.affinity.lookup("gemini", sessionKeyOf(c))
+	if !ok || len(providers) <= 1 || providers[0].Name == bound {
+		return providers
+	}
+	for i, p := range providers {
+		if p.Name == bound {
+			result := make([]GeminiProvider, 0, len(providers))
+			result = append(result, p)
+			result = append(result, providers[:i]...)
+			result = append(result, providers[i+1:]...)
+			fmt.Printf("[Gemini] 🧲 会话粘性: 优先使用 %s\n", bound)
+			return result
+		}
+	}
+	return providers
+}
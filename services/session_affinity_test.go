@@ -0,0 +1,105 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestDeriveSessionKey 会话标识按 user_id > 会话请求头 > prompt_cache_key > 前缀哈希 的优先级识别
+func TestDeriveSessionKey(t *testing.T) {
+	withUser := []byte(`{"metadata":{"user_id":"user_abc_session_1"},"messages":[{"role":"user","content":"hi"}]}`)
+	header := http.Header{"Session_id": []string{"s-1"}}
+	if deriveSessionKey(header, withUser) != deriveSessionKey(nil, withUser) {
+		t.Error("metadata.user_id 应优先于会话请求头")
+	}
+	if deriveSessionKey(header, []byte(`{}`)) == "" {
+		t.Error("应从 session_id 请求头识别会话")
+	}
+
+	first := []byte(`{"system":"sys","messages":[{"role":"user","content":"hi"}]}`)
+	later := []byte(`{"system":"sys","messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"yo"},{"role":"user","content":"more"}]}`)
+	other := []byte(`{"system":"sys","messages":[{"role":"user","content":"bye"}]}`)
+	if k := deriveSessionKey(nil, first); k == "" || k != deriveSessionKey(nil, later) {
+		t.Error("同一会话的后续请求前缀不变，应得到相同标识")
+	}
+	if deriveSessionKey(nil, first) == deriveSessionKey(nil, other) {
+		t.Error("首条用户消息不同应视为不同会话")
+	}
+	if deriveSessionKey(nil, []byte(`{"model":"m"}`)) != "" {
+		t.Error("无法识别时应返回空串")
+	}
+	if deriveSessionKey(nil, []byte(`{"input":"hello"}`)) == "" {
+		t.Error("Responses 协议字符串 input 应能识别")
+	}
+}
+
+// TestSessionAffinityTTL 绑定在 TTL 内有效，成功时滑动续期，过期后失效
+func TestSessionAffinityTTL(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := newSessionAffinity()
+	s.now = func() time.Time { return now }
+
+	s.bind("claude", "k", "A", time.Minute)
+	if p, ok := s.lookup("claude", "k"); !ok || p != "A" {
+		t.Fatalf("lookup = %q %v", p, ok)
+	}
+	if _, ok := s.lookup("codex", "k"); ok {
+		t.Error("绑定应按平台隔离")
+	}
+
+	now = now.Add(50 * time.Second)
+	s.bind("claude", "k", "B", time.Minute)
+	now = now.Add(50 * time.Second)
+	if p, ok := s.lookup("claude", "k"); !ok || p != "B" {
+		t.Fatalf("续期后应仍有效且指向新供应商: %q %v", p, ok)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := s.lookup("claude", "k"); ok {
+		t.Error("过期后应失效")
+	}
+}
+
+// TestStickyOrder 粘住的供应商提到首位，其余保持原顺序；未绑定或不在本 Level 时原样返回
+func TestStickyOrder(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	prs := &ProviderRelayService{affinity: newSessionAffinity()}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	providers := []Provider{{Name: "A"}, {Name: "B"}, {Name: "C"}}
+
+	if got := prs.stickyOrder(c, "claude", providers); got[0].Name != "A" {
+		t.Fatalf("无会话标识时应保持原顺序")
+	}
+
+	c.Set(relaySessionKeyKey, "k")
+	prs.affinity.bind("claude", "k", "C", time.Minute)
+	got := prs.stickyOrder(c, "claude", providers)
+	if got[0].Name != "C" || got[1].Name != "A" || got[2].Name != "B" {
+		t.Fatalf("stickyOrder = %v", got)
+	}
+	if providers[0].Name != "A" {
+		t.Error("不应修改原切片")
+	}
+	if key, hit := prs.sessionAffinityFor(c, "claude", "C"); key != "k" || !hit {
+		t.Errorf("发往粘住的供应商应记为命中: %q %v", key, hit)
+	}
+
+	prs.affinity.bind("claude", "k", "Z", time.Minute)
+	if got := prs.stickyOrder(c, "claude", providers); got[0].Name != "A" {
+		t.Error("粘住的供应商不在本 Level 时应保持原顺序")
+	}
+}
+
+// TestNormalizeStickyTTLMinutes 未配置取默认，上限 24 小时
+func TestNormalizeStickyTTLMinutes(t *testing.T) {
+	cases := map[int]int{0: defaultStickyTTLMinutes, -5: defaultStickyTTLMinutes, 30: 30, 99999: maxStickyTTLMinutes}
+	for in, want := range cases {
+		if got := normalizeStickyTTLMinutes(in); got != want {
+			t.Errorf("normalizeStickyTTLMinutes(%d) = %d, want %d", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========== 消费上限（按供应商 / 平台总预算）==========
+//
+// 供应商可配置按日/周/月的消费上限（SpendCap + SpendCapPeriod），花费取请求日志费用
+// （与日志页、托盘同一口径，含价格倍率）。当前周期花费达到上限后，该供应商在初筛阶段
+// 被跳过，直到周期重置；首次触达时经 NotificationService 通知一次。
+//
+// 托盘的平台总预算（budget_total / budget_total_codex）原本只做展示；开启
+// budget_hard_stop 后，预算周期内已用（含手动校正）达到总额时代理直接回 429，
+// 周期口径与托盘一致（未开启周期 = 自然日；daily/weekly 按刷新时刻与刷新日）。
+//
+// 花费统计是近似的：每分钟按日志库校准一次，其间用本进程完成的请求费用累加，
+// 不会每个请求都扫描日志库。日志库写入是异步的，上限可能被小幅越过。
+// 统计查询失败时放行（fail-open）：统计故障不应让所有供应商一起停摆。
+
+// spendResyncInterval 花费缓存按日志库校准的间隔
+const spendResyncInterval = time.Minute
+
+// spendCapPeriods 供应商消费上限支持的周期
+var spendCapPeriods = map[string]bool{"daily": true, "weekly": true, "monthly": true}
+
+// spendWindow 一个统计窗口的花费缓存（tokens 仅客户端窗口使用）
+type spendWindow struct {
+	start    time.Time
+	spent    float64
+	tokens   int64
+	syncedAt time.Time
+	notified bool
+}
+
+// spendTracker 按 platform/provider 与客户端缓存当前周期的花费
+type spendTracker struct {
+	mu      sync.Mutex
+	logs    *LogService
+	windows map[string]*spendWindow // key = platform|provider（provider 为空表示整个平台）或 clientSpendKey
+	now     func() time.Time
+}
+
+func newSpendTracker(logs *LogService) *spendTracker {
+	return &spendTracker{
+		logs:    logs,
+		windows: make(map[string]*spendWindow),
+		now:     time.Now,
+	}
+}
+
+func spendKey(platform, provider string) string {
+	return platform + "|" + provider
+}
+
+// clientSpendKey 客户端窗口的 key，前缀与 platform|provider 不会冲突
+func clientSpendKey(client string) string {
+	return "@client|" + client
+}
+
+// spent 返回自 start 起的花费（provider 为空 = 整个平台）。窗口起点变化即重置缓存
+func (t *spendTracker) spent(platform, provider string, start time.Time) (float64, error) {
+	spent, _, err := t.usage(spendKey(platform, provider), start, func() (float64, int64, error) {
+		cost, err := t.logs.costSince(start, platform, provider)
+		return cost, 0, err
+	})
+	return spent, err
+}
+
+// clientUsage 返回客户端自 start 起的花费与 token 用量
+func (t *spendTracker) clientUsage(client string, start time.Time) (float64, int64, error) {
+	return t.usage(clientSpendKey(client), start, func() (float64, int64, error) {
+		return t.logs.clientUsageSince(start, client)
+	})
+}
+
+// usage 窗口缓存的通用读取：首次与每个校准间隔经 load 查日志库，其间返回累加值
+func (t *spendTracker) usage(key string, start time.Time, load func() (float64, int64, error)) (float64, int64, error) {
+	now := t.now()
+
+	t.mu.Lock()
+	w := t.windows[key]
+	if w == nil || !w.start.Equal(start) {
+		w = &spendWindow{start: start}
+		t.windows[key] = w
+	}
+	if !w.syncedAt.IsZero() {
+		spent, tokens := w.spent, w.tokens
+		if now.Sub(w.syncedAt) < spendResyncInterval {
+			t.mu.Unlock()
+			return spent, tokens, nil
+		}
+		// 抢占本轮校准：并发请求继续用缓存值，只有一个去查日志库
+		w.syncedAt = now
+		t.mu.Unlock()
+		if total, totalTokens, err := load(); err == nil {
+			t.mu.Lock()
+			w.spent, w.tokens = total, totalTokens
+			t.mu.Unlock()
+			return total, totalTokens, nil
+		}
+		return spent, tokens, nil
+	}
+	t.mu.Unlock()
+
+	total, totalTokens, err := load()
+	if err != nil {
+		return 0, 0, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if w.syncedAt.IsZero() {
+		w.spent, w.tokens = total, totalTokens
+		w.syncedAt = now
+	}
+	return w.spent, w.tokens, nil
+}
+
+// record 把一次已完成请求的费用累加到缓存（供应商窗口、平台窗口与客户端窗口）；未被统计过的窗口忽略
+func (t *spendTracker) record(platform, provider string, requestLog *ReqeustLog) {
+	if requestLog == nil || (requestLog.InputTokens == 0 && requestLog.OutputTokens == 0 &&
+		requestLog.CacheCreateTokens == 0 && requestLog.CacheReadTokens == 0) {
+		return
+	}
+	keys := []string{spendKey(platform, provider), spendKey(platform, "")}
+	if requestLog.ClientName != "" {
+		keys = append(keys, clientSpendKey(requestLog.ClientName))
+	}
+	t.mu.Lock()
+	tracked := false
+	for _, key := range keys {
+		tracked = tracked || t.windows[key] != nil
+	}
+	t.mu.Unlock()
+	if !tracked {
+		return
+	}
+
+	entry := *requestLog
+	entry.respBuf = nil
+	t.logs.decorateCost(&entry)
+	tokens := quotaTokens(int64(entry.InputTokens), int64(entry.OutputTokens), int64(entry.CacheCreateTokens))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range keys {
+		if w := t.windows[key]; w != nil && !w.syncedAt.IsZero() {
+			if entry.TotalCost > 0 {
+				w.spent += entry.TotalCost
+			}
+			w.tokens += tokens
+		}
+	}
+}
+
+// claimNotify 当前窗口内首次触达上限时返回 true（每个周期只通知一次）
+func (t *spendTracker) claimNotify(platform, provider string, start time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w := t.windows[spendKey(platform, provider)]
+	if w == nil || !w.start.Equal(start) || w.notified {
+		return false
+	}
+	w.notified = true
+	return true
+}
+
+// spendCapWindow 供应商消费上限的当前周期 [start, next)，按本地时间：
+// daily 自然日，weekly 自周一 00:00，monthly 自每月 1 日 00:00
+func spendCapWindow(now time.Time, period string) (time.Time, time.Time) {
+	day := startOfDay(now)
+	switch period {
+	case "weekly":
+		offset := (int(day.Weekday()) + 6) % 7
+		start := day.AddDate(0, 0, -offset)
+		return start, start.AddDate(0, 0, 7)
+	case "monthly":
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(0, 1, 0)
+	default:
+		return day, day.AddDate(0, 0, 1)
+	}
+}
+
+// budgetCycleWindow 平台总预算的当前周期 [start, next)，与托盘的周期计算一致
+func budgetCycleWindow(now time.Time, cycleEnabled bool, mode, refreshTime string, refreshDay int) (time.Time, time.Time) {
+	if !cycleEnabled {
+		day := startOfDay(now)
+		return day, day.AddDate(0, 0, 1)
+	}
+	hour, minute := parseBudgetRefreshTime(refreshTime)
+	if mode == "weekly" {
+		if refreshDay < 0 || refreshDay > 6 {
+			refreshDay = 1
+		}
+		target := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+		target = target.AddDate(0, 0, refreshDay-int(now.Weekday()))
+		if now.Before(target) {
+			return target.AddDate(0, 0, -7), target
+		}
+		return target, target.AddDate(0, 0, 7)
+	}
+	start := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if now.Before(start) {
+		start = start.AddDate(0, 0, -1)
+	}
+	return start, start.AddDate(0, 0, 1)
+}
+
+// parseBudgetRefreshTime 解析 "HH:MM"，非法部分按 0 处理（与托盘一致）
+func parseBudgetRefreshTime(value string) (int, int) {
+	var hour, minute int
+	if t, err := time.Parse("15:04", value); err == nil {
+		hour, minute = t.Hour(), t.Minute()
+	}
+	return hour, minute
+}
+
+// validateSpendCap 校验消费上限：不能为负，周期只能是 daily/weekly/monthly（空 = daily）
+func validateSpendCap(limit float64, period string) []string {
+	var errs []string
+	if limit < 0 || math.IsNaN(limit) || math.IsInf(limit, 0) {
+		errs = append(errs, "消费上限必须是非负数（0 表示不限）")
+	}
+	if period != "" && !spendCapPeriods[period] {
+		errs = append(errs, fmt.Sprintf("消费上限周期 '%s' 无效，只能是 daily/weekly/monthly", period))
+	}
+	return errs
+}
+
+// spendCapExceeded 供应商当前周期花费是否已达消费上限；达到时返回周期重置时刻。
+// 首次触达时发通知
+func (prs *ProviderRelayService) spendCapExceeded(platform, name string, limit float64, period string) (bool, time.Time) {
+	capped, spent, start, next := prs.spendCapStatus(platform, name, limit, period)
+	if !capped {
+		return false, time.Time{}
+	}
+	if prs.spend.claimNotify(platform, name, start) && prs.notificationService != nil {
+		prs.notificationService.NotifySpendCapReached(platform, name, spent, limit)
+	}
+	return true, next
+}
+
+// spendCapReached 同 spendCapExceeded，但不发通知（供演练等只读场景使用）
+func (prs *ProviderRelayService) spendCapReached(platform, name string, limit float64, period string) (bool, time.Time) {
+	capped, _, _, next := prs.spendCapStatus(platform, name, limit, period)
+	return capped, next
+}
+
+// spendCapStatus 统计供应商当前周期花费，返回是否达到上限、已花费、周期起止
+func (prs *ProviderRelayService) spendCapStatus(platform, name string, limit float64, period string) (bool, float64, time.Time, time.Time) {
+	if limit <= 0 || prs.spend == nil {
+		return false, 0, time.Time{}, time.Time{}
+	}
+	start, next := spendCapWindow(prs.spend.now(), period)
+	spent, err := prs.spend.spent(platform, name, start)
+	if err != nil {
+		fmt.Printf("[WARN] 统计 Provider %s 消费失败，本次不限制: %v\n", name, err)
+		return false, 0, time.Time{}, time.Time{}
+	}
+	return spent >= limit, spent, start, next
+}
+
+// platformBudgetExhausted 平台总预算硬性停止：开启且本周期已用（含手动校正）达到总额时
+// 返回 true 与周期重置时刻。仅 claude/codex 有总预算设置
+func (prs *ProviderRelayService) platformBudgetExhausted(kind string) (bool, float64, time.Time) {
+	if prs.appSettings == nil || prs.spend == nil || (kind != "claude" && kind != "codex") {
+		return false, 0, time.Time{}
+	}
+	settings, err := prs.appSettings.GetAppSettings()
+	if err != nil {
+		return false, 0, time.Time{}
+	}
+	hardStop, total, adjustment := settings.BudgetHardStop, settings.BudgetTotal, settings.BudgetUsedAdjustment
+	cycleEnabled, mode := settings.BudgetCycleEnabled, settings.BudgetCycleMode
+	refreshTime, refreshDay := settings.BudgetRefreshTime, settings.BudgetRefreshDay
+	if kind == "codex" {
+		hardStop, total, adjustment = settings.BudgetHardStopCodex, settings.BudgetTotalCodex, settings.BudgetUsedAdjustmentCodex
+		cycleEnabled, mode = settings.BudgetCycleEnabledCodex, settings.BudgetCycleModeCodex
+		refreshTime, refreshDay = settings.BudgetRefreshTimeCodex, settings.BudgetRefreshDayCodex
+	}
+	if !hardStop || total <= 0 {
+		return false, 0, time.Time{}
+	}
+	start, next := budgetCycleWindow(prs.spend.now(), cycleEnabled, mode, refreshTime, refreshDay)
+	spent, err := prs.spend.spent(kind, "", start)
+	if err != nil {
+		fmt.Printf("[WARN] 统计 %s 预算用量失败，本次不限制: %v\n", kind, err)
+		return false, 0, time.Time{}
+	}
+	if spent+adjustment < total {
+		return false, 0, time.Time{}
+	}
+	if prs.spend.claimNotify(kind, "", start) && prs.notificationService != nil {
+		prs.notificationService.NotifySpendCapReached(kind, "", spent+adjustment, total)
+	}
+	return true, total, next
+}
+
+// respondBudgetExhausted 预算/消费上限用尽的 429 终态，带稳定机器码与 Retry-After（距周期重置的秒数）。
+// 按平台给各自协议兼容的错误结构
+func respondBudgetExhausted(c *gin.Context, kind, code, msg string, resetAt time.Time) {
+	if !resetAt.IsZero() {
+		if seconds := int(math.Ceil(time.Until(resetAt).Seconds())); seconds > 0 {
+			c.Header("Retry-After", strconv.Itoa(seconds))
+		}
+	}
+	switch {
+	case isOpenAIClientPlatform(kind):
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": gin.H{
+				"type":    "insufficient_quota",
+				"code":    code,
+				"message": msg,
+			},
+		})
+	case kind == "gemini":
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": gin.H{
+				"code":    http.StatusTooManyRequests,
+				"status":  "RESOURCE_EXHAUSTED",
+				"message": msg,
+				"details": []gin.H{{
+					"@type":  "type.googleapis.com/google.rpc.ErrorInfo",
+					"reason": code,
+				}},
+			},
+		})
+	default:
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"type":    "rate_limit_error",
+				"code":    code,
+				"message": msg,
+			},
+			"message": msg,
+		})
+	}
+}
+
+// respondBudgetHardStop 平台总预算用尽的终态
+func respondBudgetHardStop(c *gin.Context, kind string, total float64, resetAt time.Time) {
+	msg := fmt.Sprintf("%s 本周期预算 $%.2f 已用尽，代理已暂停转发（预算硬性停止），将于 %s 重置",
+		kind, total, resetAt.Format("2006-01-02 15:04"))
+	respondBudgetExhausted(c, kind, "budget_exhausted", msg, resetAt)
+}
+
+// respondAllSpendCapped 候选供应商全部因消费上限被跳过的终态
+func respondAllSpendCapped(c *gin.Context, kind string, resetAt time.Time) {
+	msg := fmt.Sprintf("所有可用供应商均已达到消费上限，最早将于 %s 重置", resetAt.Format("2006-01-02 15:04"))
+	respondBudgetExhausted(c, kind, "provider_spend_cap_exhausted", msg, resetAt)
+}
+
+// earlierReset 取两个重置时刻中较早者（零值视为未设置）
+func earlierReset(current, candidate time.Time) time.Time {
+	if current.IsZero() || (!candidate.IsZero() && candidate.Before(current)) {
+		return candidate
+	}
+	return current
+}
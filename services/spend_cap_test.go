@@ -0,0 +1,148 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestSpendCapWindow 日/周/月周期按本地时间对齐：周从周一、月从 1 日开始
+func TestSpendCapWindow(t *testing.T) {
+	loc := withFixedLocal(t)
+	now := time.Date(2026, 3, 18, 15, 30, 0, 0, loc) // 周三
+
+	cases := []struct {
+		period      string
+		start, next time.Time
+	}{
+		{"", time.Date(2026, 3, 18, 0, 0, 0, 0, loc), time.Date(2026, 3, 19, 0, 0, 0, 0, loc)},
+		{"weekly", time.Date(2026, 3, 16, 0, 0, 0, 0, loc), time.Date(2026, 3, 23, 0, 0, 0, 0, loc)},
+		{"monthly", time.Date(2026, 3, 1, 0, 0, 0, 0, loc), time.Date(2026, 4, 1, 0, 0, 0, 0, loc)},
+	}
+	for _, tc := range cases {
+		start, next := spendCapWindow(now, tc.period)
+		if !start.Equal(tc.start) || !next.Equal(tc.next) {
+			t.Errorf("周期 %q: 得到 [%v, %v), 期望 [%v, %v)", tc.period, start, next, tc.start, tc.next)
+		}
+	}
+}
+
+// TestBudgetCycleWindowMatchesTray 平台预算周期与托盘计算一致：刷新时刻未到时属于上一周期
+func TestBudgetCycleWindowMatchesTray(t *testing.T) {
+	loc := withFixedLocal(t)
+	now := time.Date(2026, 3, 18, 6, 0, 0, 0, loc) // 周三 06:00
+
+	start, next := budgetCycleWindow(now, true, "daily", "08:30", 1)
+	if want := time.Date(2026, 3, 17, 8, 30, 0, 0, loc); !start.Equal(want) || !next.Equal(want.AddDate(0, 0, 1)) {
+		t.Errorf("每日 08:30 刷新: 得到 [%v, %v)", start, next)
+	}
+
+	start, _ = budgetCycleWindow(now, true, "weekly", "00:00", 5)
+	if want := time.Date(2026, 3, 13, 0, 0, 0, 0, loc); !start.Equal(want) {
+		t.Errorf("每周五刷新: 起点 %v, 期望 %v", start, want)
+	}
+
+	start, _ = budgetCycleWindow(now, false, "weekly", "08:30", 5)
+	if want := time.Date(2026, 3, 18, 0, 0, 0, 0, loc); !start.Equal(want) {
+		t.Errorf("未开启周期应按自然日: 起点 %v", start)
+	}
+}
+
+// TestSpendTrackerCachesAndRecords 首次查日志库，之后用进程内累加，超过校准间隔再查
+func TestSpendTrackerCachesAndRecords(t *testing.T) {
+	db := setupLogFixTestDB(t)
+	insertLogFixRecord(t, db, time.Now().UTC().Add(-time.Minute).Format(timeLayout))
+
+	now := time.Now()
+	tracker := newSpendTracker(NewLogService())
+	tracker.now = func() time.Time { return now }
+	start := now.Add(-time.Hour)
+
+	base, err := tracker.spent("claude", "p1", start)
+	if err != nil || base <= 0 {
+		t.Fatalf("应统计到日志库中的费用: spent=%v err=%v", base, err)
+	}
+	if other, _ := tracker.spent("claude", "p2", start); other != 0 {
+		t.Errorf("其它供应商不应计入: %v", other)
+	}
+
+	tracker.record("claude", "p1", &ReqeustLog{Model: "claude-haiku-4-5", InputTokens: 1000000})
+	cached, _ := tracker.spent("claude", "p1", start)
+	if cached <= base*1.5 {
+		t.Errorf("完成的请求应累加到缓存: before=%v after=%v", base, cached)
+	}
+
+	now = now.Add(spendResyncInterval + time.Second)
+	if synced, _ := tracker.spent("claude", "p1", start); synced != base {
+		t.Errorf("校准后应以日志库为准: %v, 期望 %v", synced, base)
+	}
+}
+
+// TestProxyHandlerSpendCapAndHardStop 达到消费上限的供应商全部跳过时回 429；
+// 平台预算硬性停止同样回 429 并带 Retry-After
+func TestProxyHandlerSpendCapAndHardStop(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{{
+		ID: 1, Name: "capped", APIURL: "http://127.0.0.1:1", APIKey: "k", Enabled: true, SpendCap: 5,
+	}}); err != nil {
+		t.Fatalf("保存供应商失败: %v", err)
+	}
+	prs := newTestRelayService(ps)
+	now := prs.spend.now()
+	dayStart, _ := spendCapWindow(now, "daily")
+	prs.spend.windows[spendKey("claude", "capped")] = &spendWindow{start: dayStart, spent: 6, syncedAt: now}
+
+	serve := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5"}`))
+		prs.proxyHandler("claude", "/v1/messages")(c)
+		return recorder
+	}
+
+	recorder := serve()
+	var payload struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(recorder.Body.Bytes(), &payload)
+	if recorder.Code != http.StatusTooManyRequests || payload.Error.Code != "provider_spend_cap_exhausted" {
+		t.Fatalf("全部达到消费上限应回 429: %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	settings, _ := prs.appSettings.GetAppSettings()
+	settings.BudgetTotal = 10
+	settings.BudgetHardStop = true
+	if _, err := prs.appSettings.SaveAppSettings(settings); err != nil {
+		t.Fatalf("保存设置失败: %v", err)
+	}
+	prs.spend.windows[spendKey("claude", "")] = &spendWindow{start: dayStart, spent: 12, syncedAt: now}
+
+	recorder = serve()
+	_ = json.Unmarshal(recorder.Body.Bytes(), &payload)
+	if recorder.Code != http.StatusTooManyRequests || payload.Error.Code != "budget_exhausted" {
+		t.Fatalf("预算硬性停止应回 429 budget_exhausted: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder.Header().Get("Retry-After") == "" {
+		t.Error("应带距周期重置的 Retry-After")
+	}
+}
+
+// TestValidateSpendCap 负数上限与未知周期报错
+func TestValidateSpendCap(t *testing.T) {
+	if errs := validateSpendCap(-1, "yearly"); len(errs) != 2 {
+		t.Errorf("负上限与未知周期都应报错: %v", errs)
+	}
+	if errs := validateSpendCap(20, "monthly"); len(errs) != 0 {
+		t.Errorf("合法配置不应报错: %v", errs)
+	}
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// ========== 流式前导缓冲（内容开始前断流可降级）==========
+//
+// 上游 2xx 后通常先发 message_start、ping 等前导事件，隔一段时间才开始输出内容。
+// 以前前导一写给客户端响应就算"已开始"，此后上游断流只能报错，不能换供应商。
+// ssePreambleGate 把前导事件暂存在内存里，直到看到第一条真正的内容事件
+// （content_block_delta、response.output_text.delta、message_delta 等）才连同前导一起写出。
+// content_block_start 与 Responses 的 output_item/content_part.added 只是空壳，
+// 同样算前导：上游常在打开 block 后、首个 delta 前断开：
+//
+//   - 前导阶段上游断流、正常结束却没有任何内容、或发来 error 事件：
+//     客户端一个字节都没收到，调用方按普通失败降级到下一个供应商；
+//   - 内容开始后断流：Anthropic 协议客户端收到一条规范的 error 事件再结束，
+//     而不是一段不完整的流（见 relayResponseToClient）。
+//
+// 前导按事件类型识别，覆盖 Anthropic Messages 与 OpenAI Responses 两种 SSE；
+// 其它格式（如 Chat Completions 分片）第一条 data 就视为内容开始，行为与原先一致。
+
+// ssePreambleLimit 前导缓冲上限，超过即放行（防止异常上游无限发送 ping 占用内存）
+const ssePreambleLimit = 64 * 1024
+
+// ssePreambleEvents 内容开始前的前导事件类型
+var ssePreambleEvents = map[string]bool{
+	"message_start":               true,
+	"ping":                        true,
+	"content_block_start":         true,
+	"response.created":            true,
+	"response.in_progress":        true,
+	"response.output_item.added":  true,
+	"response.content_part.added": true,
+}
+
+// errUpstreamPreambleError 上游在内容开始前就发来 error 事件（如 overloaded_error）
+var errUpstreamPreambleError = errors.New("upstream sent error event before content")
+
+// errUpstreamEndedBeforeContent 上游在内容开始前就结束了流
+var errUpstreamEndedBeforeContent = errors.New("upstream stream ended before any content")
+
+// ssePreambleGate 包装客户端 ResponseWriter：内容开始前的写入（含响应头）全部暂存
+type ssePreambleGate struct {
+	w         gin.ResponseWriter
+	header    http.Header
+	status    int
+	buf       bytes.Buffer
+	partial   []byte // 跨 Write 的未完整行
+	lastEvent string
+	open      bool
+}
+
+func newSSEPreambleGate(w gin.ResponseWriter) *ssePreambleGate {
+	return &ssePreambleGate{w: w, header: make(http.Header)}
+}
+
+func (g *ssePreambleGate) Header() http.Header {
+	if g.open {
+		return g.w.Header()
+	}
+	return g.header
+}
+
+func (g *ssePreambleGate) WriteHeader(code int) {
+	if g.open {
+		g.w.WriteHeader(code)
+		return
+	}
+	g.status = code
+}
+
+func (g *ssePreambleGate) Flush() {
+	if g.open {
+		g.w.Flush()
+	}
+}
+
+func (g *ssePreambleGate) Write(p []byte) (int, error) {
+	if g.open {
+		return g.w.Write(p)
+	}
+	g.buf.Write(p)
+
+	data := append(g.partial, p...)
+	g.partial = nil
+	contentStarted := false
+	for len(data) > 0 {
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 {
+			g.partial = append([]byte(nil), data...)
+			break
+		}
+		line := strings.TrimRight(string(data[:idx]), "\r")
+		data = data[idx+1:]
+		started, err := g.classifyLine(line)
+		if err != nil {
+			return 0, err
+		}
+		if started {
+			contentStarted = true
+			break
+		}
+	}
+
+	if contentStarted || g.buf.Len() > ssePreambleLimit {
+		if err := g.commit(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// classifyLine 判断一行 SSE 是否意味着内容已经开始；前导阶段的 error 事件返回错误
+func (g *ssePreambleGate) classifyLine(line string) (bool, error) {
+	switch {
+	case line == "" || strings.HasPrefix(line, ":") ||
+		strings.HasPrefix(line, "id:") || strings.HasPrefix(line, "retry:"):
+		return false, nil
+	case strings.HasPrefix(line, "event:"):
+		g.lastEvent = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		return false, nil
+	case strings.HasPrefix(line, "data:"):
+		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		eventType := gjson.Get(payload, "type").String()
+		if eventType == "" {
+			eventType = g.lastEvent
+		}
+		if eventType == "error" || eventType == "response.failed" {
+			msg := gjson.Get(payload, "error.message").String()
+			if msg == "" {
+				msg = gjson.Get(payload, "response.error.message").String()
+			}
+			if msg == "" {
+				msg = payload
+			}
+			return false, fmt.Errorf("%w: %s", errUpstreamPreambleError, msg)
+		}
+		return !ssePreambleEvents[eventType], nil
+	default:
+		return true, nil
+	}
+}
+
+// commit 放行：写出暂存的响应头与前导，此后直接透传
+func (g *ssePreambleGate) commit() error {
+	if g.open {
+		return nil
+	}
+	g.open = true
+	dst := g.w.Header()
+	for key, values := range g.header {
+		dst[key] = values
+	}
+	if g.status != 0 {
+		g.w.WriteHeader(g.status)
+	}
+	if g.buf.Len() > 0 {
+		if _, err := g.w.Write(g.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	g.buf.Reset()
+	g.partial = nil
+	g.w.Flush()
+	return nil
+}
+
+// finish 在上游流结束后调用：出错时丢弃暂存内容交给调用方降级；
+// 正常结束但只有前导没有内容视为断流；否则放行剩余内容
+func (g *ssePreambleGate) finish(copyErr error) error {
+	if copyErr != nil || g.open {
+		return copyErr
+	}
+	// 末行没有换行符（或上游回的是一整段非 SSE 响应）时还没被识别过
+	if len(g.partial) > 0 {
+		started, err := g.classifyLine(strings.TrimRight(string(g.partial), "\r"))
+		if err != nil {
+			return err
+		}
+		if started {
+			return g.commit()
+		}
+	}
+	if g.buf.Len() > 0 {
+		return errUpstreamEndedBeforeContent
+	}
+	return g.commit()
+}
+
+// writeAnthropicStreamError 内容开始后上游断流：给 Anthropic 协议客户端补一条 error 事件，
+// 客户端据此报错重试，而不是把半截输出当成完整回答
+func writeAnthropicStreamError(c *gin.Context, message string) {
+	event := formatAnthropicSSEEvent("error", map[string]interface{}{
+		"type": "error",
+		"error": map[string]interface{}{
+			"type":    "api_error",
+			"message": message,
+		},
+	})
+	if event == "" {
+		return
+	}
+	if _, err := c.Writer.Write([]byte(event)); err == nil {
+		c.Writer.Flush()
+	}
+}
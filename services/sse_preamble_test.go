@@ -0,0 +1,180 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testMessageStart = "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_%s\",\"usage\":{\"input_tokens\":10}}}\n\n"
+	testPing         = "event: ping\ndata: {\"type\": \"ping\"}\n\n"
+	testBlockStart   = "event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n"
+	testContentDelta = "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"hi\"}}\n\n"
+	testMessageStop  = "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+)
+
+// testSSEPadding SSE 注释行：让断流发生在 xrequest 首次 Peek(1024) 之后，走到逐行转发阶段
+var testSSEPadding = ": " + strings.Repeat("x", 1100) + "\n\n"
+
+// sseTestUpstream 上游按序写出 events；dropAfter 为 true 时写完直接断开连接（不正常结束分块流）
+func sseTestUpstream(t *testing.T, dropAfter bool, events ...string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		for _, e := range events {
+			_, _ = w.Write([]byte(e))
+		}
+		w.(http.Flusher).Flush()
+		if !dropAfter {
+			return
+		}
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				_ = conn.Close()
+			}
+		}
+	}))
+}
+
+func sseTestForward(prs *ProviderRelayService, c *gin.Context, name, url string) (bool, error) {
+	provider := Provider{Name: name, APIURL: url, APIKey: "k", Enabled: true}
+	return prs.forwardRequest(c, "claude", provider, "/v1/messages",
+		map[string]string{}, map[string]string{}, []byte(`{"model":"m","stream":true}`), true, "m", 0)
+}
+
+// TestSSEPreambleGateBuffersUntilContent 前导事件暂存，第一条内容事件到达时连同前导按序写出
+func TestSSEPreambleGateBuffersUntilContent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+
+	gate := newSSEPreambleGate(c.Writer)
+	gate.Header().Set("Content-Type", "text/event-stream")
+	gate.WriteHeader(http.StatusOK)
+	for _, line := range strings.SplitAfter(strings.ReplaceAll(testMessageStart, "%s", "a")+testPing, "\n") {
+		_, _ = gate.Write([]byte(line))
+	}
+	if c.Writer.Written() {
+		t.Fatal("前导阶段不应向客户端写出任何内容")
+	}
+
+	_, _ = gate.Write([]byte(testContentDelta))
+	body := recorder.Body.String()
+	if !strings.HasPrefix(body, "event: message_start") || !strings.Contains(body, "content_block_delta") {
+		t.Errorf("内容开始后应按序写出前导与内容, 实际 %q", body)
+	}
+	if ct := recorder.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("放行时应带上暂存的响应头, 实际 %q", ct)
+	}
+}
+
+// TestForwardRequestFailsOverWhenStreamDiesInPreamble 前导阶段断流不写客户端，
+// 下一个供应商接手后客户端只看到一条完整的流
+func TestForwardRequestFailsOverWhenStreamDiesInPreamble(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+
+	broken := sseTestUpstream(t, true, strings.ReplaceAll(testMessageStart, "%s", "broken"), testPing, testSSEPadding)
+	defer broken.Close()
+	healthy := sseTestUpstream(t, false, strings.ReplaceAll(testMessageStart, "%s", "ok"), testContentDelta, testMessageStop)
+	defer healthy.Close()
+
+	prs := newTestRelayService(NewProviderService())
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("POST", "/v1/messages", strings.NewReader(`{}`))
+
+	ok, err := sseTestForward(prs, c, "broken", broken.URL)
+	if ok || err == nil {
+		t.Fatalf("前导阶段断流应判为失败: ok=%v err=%v", ok, err)
+	}
+	if errors.Is(err, errUpstreamStreamAborted) || c.Writer.Written() {
+		t.Fatalf("前导阶段断流应可降级且不写客户端: err=%v written=%v", err, c.Writer.Written())
+	}
+
+	if ok, err := sseTestForward(prs, c, "healthy", healthy.URL); !ok {
+		t.Fatalf("下一个供应商应成功: %v", err)
+	}
+	body := recorder.Body.String()
+	if strings.Contains(body, "msg_broken") || strings.Count(body, "message_start") != 2 || !strings.Contains(body, "msg_ok") {
+		t.Errorf("客户端只应收到接手供应商的流, 实际 %q", body)
+	}
+}
+
+// TestForwardRequestFailsOverWhenStreamDiesAfterBlockStart 打开 block 后、首个 delta 前断流：
+// content_block_start 只是空壳，仍可降级
+func TestForwardRequestFailsOverWhenStreamDiesAfterBlockStart(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+
+	broken := sseTestUpstream(t, true, strings.ReplaceAll(testMessageStart, "%s", "broken"), testBlockStart, testSSEPadding)
+	defer broken.Close()
+	healthy := sseTestUpstream(t, false, strings.ReplaceAll(testMessageStart, "%s", "ok"), testBlockStart, testContentDelta, testMessageStop)
+	defer healthy.Close()
+
+	prs := newTestRelayService(NewProviderService())
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("POST", "/v1/messages", strings.NewReader(`{}`))
+
+	ok, err := sseTestForward(prs, c, "broken", broken.URL)
+	if ok || errors.Is(err, errUpstreamStreamAborted) || c.Writer.Written() {
+		t.Fatalf("content_block_start 后断流应可降级且不写客户端: ok=%v err=%v written=%v", ok, err, c.Writer.Written())
+	}
+	if ok, err := sseTestForward(prs, c, "healthy", healthy.URL); !ok {
+		t.Fatalf("下一个供应商应成功: %v", err)
+	}
+	body := recorder.Body.String()
+	if strings.Contains(body, "msg_broken") || strings.Count(body, "content_block_start") != 2 || !strings.Contains(body, "msg_ok") {
+		t.Errorf("客户端只应收到接手供应商的流, 实际 %q", body)
+	}
+}
+
+// TestForwardRequestFailsOverOnPreambleErrorEvent 内容开始前上游发来 error 事件同样可降级
+func TestForwardRequestFailsOverOnPreambleErrorEvent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+
+	overloaded := sseTestUpstream(t, false, strings.ReplaceAll(testMessageStart, "%s", "x"),
+		"event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n")
+	defer overloaded.Close()
+
+	prs := newTestRelayService(NewProviderService())
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("POST", "/v1/messages", strings.NewReader(`{}`))
+
+	ok, err := sseTestForward(prs, c, "overloaded", overloaded.URL)
+	if ok || !errors.Is(err, errUpstreamPreambleError) || c.Writer.Written() {
+		t.Fatalf("前导阶段的 error 事件应判为可降级失败: ok=%v err=%v written=%v", ok, err, c.Writer.Written())
+	}
+}
+
+// TestForwardRequestEmitsErrorEventAfterContent 内容开始后断流：客户端收到规范的 error 事件
+func TestForwardRequestEmitsErrorEventAfterContent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRenameTestEnv(t)
+
+	upstream := sseTestUpstream(t, true, strings.ReplaceAll(testMessageStart, "%s", "x"), testContentDelta, testSSEPadding)
+	defer upstream.Close()
+
+	prs := newTestRelayService(NewProviderService())
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest("POST", "/v1/messages", strings.NewReader(`{}`))
+
+	ok, err := sseTestForward(prs, c, "p", upstream.URL)
+	if ok || !errors.Is(err, errUpstreamStreamAborted) {
+		t.Fatalf("内容开始后断流应判为已写出的中断: ok=%v err=%v", ok, err)
+	}
+	body := recorder.Body.String()
+	if !strings.HasSuffix(body, "\n\n") || !strings.Contains(body, "event: error\ndata: {") || !strings.Contains(body, `"type":"api_error"`) {
+		t.Errorf("应以 Anthropic error 事件结束, 实际 %q", body)
+	}
+}
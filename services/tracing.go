@@ -0,0 +1,607 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========== 请求追踪（OpenTelemetry 风格）==========
+//
+// 一条 request_log 只记录最终落在哪家供应商，一次跨三家供应商、两个备用地址的
+// 降级过程全被折叠掉。开启追踪后，每个转发请求生成一条 trace：
+//
+//	POST /v1/messages                 根 span（SERVER），最终状态码
+//	├─ provider.select                候选/可用/跳过数、Level 分组、命中的路由规则
+//	├─ provider.attempt               一次供应商尝试：provider、model、retry.reason
+//	│  └─ upstream.request            一次地址尝试（CLIENT）：address、状态码、ttfb_ms
+//	│     └─ stream.relay             2xx 响应向客户端转发的全程
+//	├─ retry.wait                     拉黑模式下原地重试前的等待
+//	└─ concurrency.wait               全部候选并发满载时的有界等待
+//
+// 未开启时 trace 为 nil，所有 span 方法都是空操作，热路径零开销。
+// 根 span 结束后整条 trace 交给后台导出协程：OTLP/HTTP（JSON 编码）发往
+// 本地 collector，或按每行一个 span 追加写入 JSON Lines 文件。
+// 导出队列满时直接丢弃，绝不阻塞转发。
+
+const (
+	tracingExporterFile = "file"
+	tracingExporterOTLP = "otlp"
+
+	defaultTracingEndpoint = "http://127.0.0.1:4318/v1/traces"
+	defaultTracingFile     = "traces.jsonl"
+
+	traceContextKey      = "codeswitch.trace"
+	traceExportQueueSize = 256
+	traceExportTimeout   = 5 * time.Second
+)
+
+// OTLP SpanKind / StatusCode 取值
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+
+	spanStatusOK    = 1
+	spanStatusError = 2
+)
+
+// tracingConfig 单条 trace 的导出目标（开始时快照，改设置不影响在途请求）
+type tracingConfig struct {
+	exporter string
+	endpoint string
+	file     string
+}
+
+// tracingSettings 读取追踪设置；未开启返回 false
+func (prs *ProviderRelayService) tracingSettings() (tracingConfig, bool) {
+	if prs.appSettings == nil {
+		return tracingConfig{}, false
+	}
+	settings, err := prs.appSettings.GetAppSettings()
+	if err != nil || !settings.EnableTracing {
+		return tracingConfig{}, false
+	}
+	return tracingConfig{
+		exporter: normalizeTracingExporter(settings.TracingExporter),
+		endpoint: normalizeTracingEndpoint(settings.TracingEndpoint),
+		file:     normalizeTracingFile(settings.TracingFile),
+	}, true
+}
+
+// normalizeTracingExporter 未知导出方式回退为文件（不依赖外部 collector）
+func normalizeTracingExporter(exporter string) string {
+	if strings.EqualFold(strings.TrimSpace(exporter), tracingExporterOTLP) {
+		return tracingExporterOTLP
+	}
+	return tracingExporterFile
+}
+
+func normalizeTracingEndpoint(endpoint string) string {
+	if endpoint = strings.TrimSpace(endpoint); endpoint == "" {
+		return defaultTracingEndpoint
+	}
+	return endpoint
+}
+
+// normalizeTracingFile 未配置时写 ~/.code-switch/traces.jsonl，支持 ~/ 前缀
+func normalizeTracingFile(path string) string {
+	path = strings.TrimSpace(path)
+	home, err := getUserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	switch {
+	case path == "":
+		return filepath.Join(home, appSettingsDir, defaultTracingFile)
+	case path == "~":
+		return home
+	case strings.HasPrefix(path, "~/"):
+		return filepath.Join(home, path[2:])
+	}
+	return path
+}
+
+// relayTrace 一个入站请求的追踪上下文。对冲的两个尝试会并发写 span，统一加锁
+type relayTrace struct {
+	mu          sync.Mutex
+	id          [16]byte
+	cfg         tracingConfig
+	root        *traceSpan
+	ended       []*traceSpan
+	attempts    int
+	lastFailure string // 最近一次上游失败原因，作为下一次尝试的 retry.reason
+}
+
+type traceAttr struct {
+	key   string
+	value any
+}
+
+// traceSpan 追踪中的一段。nil 表示未开启追踪，各方法均为空操作
+type traceSpan struct {
+	trace   *relayTrace
+	id      [8]byte
+	parent  [8]byte
+	name    string
+	kind    int
+	startAt time.Time
+	endAt   time.Time
+	attrs   []traceAttr
+	status  int
+	errMsg  string
+	done    bool
+}
+
+// tracingMiddleware 为各转发入口建立 trace 与根 span；管理路由、/metrics 与模型列表不追踪
+func (prs *ProviderRelayService) tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		platform := clientAuthPlatform(c)
+		if c.Request.Method != http.MethodPost || platform == "" {
+			c.Next()
+			return
+		}
+		cfg, ok := prs.tracingSettings()
+		if !ok {
+			c.Next()
+			return
+		}
+		trace := &relayTrace{cfg: cfg}
+		_, _ = rand.Read(trace.id[:])
+		root := trace.newSpan(nil, c.Request.Method+" "+c.FullPath(), spanKindServer)
+		trace.root = root
+		root.set("platform", platform)
+		root.set("http.method", c.Request.Method)
+		root.set("url.path", c.Request.URL.Path)
+		c.Set(traceContextKey, trace)
+
+		defer func() {
+			status := c.Writer.Status()
+			root.set("http.status_code", status)
+			if name := clientNameOf(c); name != "" {
+				root.set("client.name", name)
+			}
+			if status >= http.StatusInternalServerError {
+				root.fail(http.StatusText(status))
+			}
+			root.end()
+			defaultTraceExporter.enqueue(trace)
+		}()
+		c.Next()
+	}
+}
+
+// traceOf 取当前请求的 trace；未开启追踪返回 nil
+func traceOf(c *gin.Context) *relayTrace {
+	if c == nil {
+		return nil
+	}
+	if v, ok := c.Get(traceContextKey); ok {
+		if trace, ok := v.(*relayTrace); ok {
+			return trace
+		}
+	}
+	return nil
+}
+
+// startTraceSpan 在根 span 下开一个 INTERNAL span
+func startTraceSpan(c *gin.Context, name string) *traceSpan {
+	trace := traceOf(c)
+	if trace == nil {
+		return nil
+	}
+	return trace.newSpan(trace.root, name, spanKindInternal)
+}
+
+func (t *relayTrace) newSpan(parent *traceSpan, name string, kind int) *traceSpan {
+	s := &traceSpan{trace: t, name: name, kind: kind, startAt: time.Now()}
+	_, _ = rand.Read(s.id[:])
+	if parent != nil {
+		s.parent = parent.id
+	}
+	return s
+}
+
+// noteFailure 记下一次上游失败，供下一次尝试标注 retry.reason
+func (t *relayTrace) noteFailure(provider, reason string) {
+	if t == nil || reason == "" {
+		return
+	}
+	t.mu.Lock()
+	t.lastFailure = provider + ": " + reason
+	t.mu.Unlock()
+}
+
+// takeFailure 取出并清空最近一次失败原因
+func (t *relayTrace) takeFailure() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	reason := t.lastFailure
+	t.lastFailure = ""
+	return reason
+}
+
+// startAttemptSpan 开一次供应商尝试的 span：序号按本请求累计，
+// retry.reason 取上一次上游失败（同供应商原地重试或降级自上一家）
+func startAttemptSpan(c *gin.Context, provider, model string, hedge bool) *traceSpan {
+	trace := traceOf(c)
+	if trace == nil {
+		return nil
+	}
+	trace.mu.Lock()
+	trace.attempts++
+	n := trace.attempts
+	trace.mu.Unlock()
+	s := trace.newSpan(trace.root, "provider.attempt", spanKindInternal)
+	s.set("provider", provider)
+	if model != "" {
+		s.set("model", model)
+	}
+	s.set("attempt", n)
+	if hedge {
+		s.set("hedge", true)
+	}
+	if reason := trace.takeFailure(); reason != "" {
+		s.set("retry.reason", reason)
+	}
+	return s
+}
+
+// endUpstream 结束一次地址尝试的 span；真实的上游失败（非对冲落败、非客户端断开）
+// 记为下一次尝试的 retry.reason
+func (s *traceSpan) endUpstream(provider string, status int, err error) {
+	if s == nil {
+		return
+	}
+	if status > 0 {
+		s.set("http.status_code", status)
+	}
+	if err != nil && !errors.Is(err, errHedgeLost) && !errors.Is(err, errClientAbort) {
+		s.trace.noteFailure(provider, truncateTraceValue(err.Error()))
+	}
+	s.finish(err)
+}
+
+// child 在本 span 下开子 span
+func (s *traceSpan) child(name string, kind int) *traceSpan {
+	if s == nil {
+		return nil
+	}
+	return s.trace.newSpan(s, name, kind)
+}
+
+// set 设置属性（同名覆盖）；值限 string / bool / 整数 / float64
+func (s *traceSpan) set(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+	for i := range s.attrs {
+		if s.attrs[i].key == key {
+			s.attrs[i].value = value
+			return
+		}
+	}
+	s.attrs = append(s.attrs, traceAttr{key: key, value: value})
+}
+
+// fail 标记 span 失败
+func (s *traceSpan) fail(msg string) {
+	if s == nil {
+		return
+	}
+	s.trace.mu.Lock()
+	s.status = spanStatusError
+	s.errMsg = truncateTraceValue(msg)
+	s.trace.mu.Unlock()
+}
+
+// recordError 记录尝试结果：err 为 nil 不改状态；对冲落败不算失败，只打标记
+func (s *traceSpan) recordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	if errors.Is(err, errHedgeLost) {
+		s.set("hedge.lost", true)
+		return
+	}
+	s.fail(err.Error())
+}
+
+// finish 按错误结束 span
+func (s *traceSpan) finish(err error) {
+	s.recordError(err)
+	s.end()
+}
+
+// end 结束 span（重复调用无副作用）；未结束的 span 不会被导出
+func (s *traceSpan) end() {
+	if s == nil {
+		return
+	}
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+	if s.done {
+		return
+	}
+	s.done = true
+	s.endAt = time.Now()
+	if s.status == 0 {
+		s.status = spanStatusOK
+	}
+	s.trace.ended = append(s.trace.ended, s)
+}
+
+// setSelection 记录选路初筛结果：候选数、可用数、按原因拆分的跳过数与命中的路由规则
+func (s *traceSpan) setSelection(candidates, eligible int, skipped providerSkipCounts, rule *RoutingRule) {
+	if s == nil {
+		return
+	}
+	s.set("candidates", candidates)
+	s.set("eligible", eligible)
+	for _, kv := range []struct {
+		key string
+		n   int
+	}{
+		{"skipped.model", skipped.Model},
+		{"skipped.blacklist", skipped.Blacklist},
+		{"skipped.invalid", skipped.Invalid},
+		{"skipped.spend_cap", skipped.Spend},
+		{"skipped.rule", skipped.Rule},
+	} {
+		if kv.n > 0 {
+			s.set(kv.key, kv.n)
+		}
+	}
+	if rule != nil {
+		s.set("routing.rule", rule.Name)
+	}
+	if eligible == 0 {
+		s.fail("no eligible provider")
+	}
+}
+
+// truncateTraceValue 上游错误体可能是整页 HTML，属性值截断到 512 字节（不切断多字节字符）
+func truncateTraceValue(v string) string {
+	const limit = 512
+	if len(v) <= limit {
+		return v
+	}
+	return truncateUTF8(v, limit) + "..."
+}
+
+// traceAddress 去掉地址中的 user:pass@，导出的链路文件不应带出上游凭据
+func traceAddress(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.User == nil {
+		return raw
+	}
+	parsed.User = nil
+	return parsed.String()
+}
+
+// traceSpanRecord JSON Lines 导出格式：每行一个 span
+type traceSpanRecord struct {
+	TraceID       string         `json:"trace_id"`
+	SpanID        string         `json:"span_id"`
+	ParentSpanID  string         `json:"parent_span_id,omitempty"`
+	Name          string         `json:"name"`
+	Kind          string         `json:"kind"`
+	StartTime     time.Time      `json:"start_time"`
+	EndTime       time.Time      `json:"end_time"`
+	DurationMs    float64        `json:"duration_ms"`
+	Status        string         `json:"status"`
+	StatusMessage string         `json:"status_message,omitempty"`
+	Attributes    map[string]any `json:"attributes,omitempty"`
+}
+
+// snapshot 复制已结束的 span，供导出协程在锁外编码
+func (t *relayTrace) snapshot() []traceSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	spans := make([]traceSpan, 0, len(t.ended))
+	for _, s := range t.ended {
+		cp := *s
+		cp.attrs = append([]traceAttr(nil), s.attrs...)
+		spans = append(spans, cp)
+	}
+	return spans
+}
+
+func (s *traceSpan) record() traceSpanRecord {
+	r := traceSpanRecord{
+		TraceID:       hex.EncodeToString(s.trace.id[:]),
+		SpanID:        hex.EncodeToString(s.id[:]),
+		Name:          s.name,
+		Kind:          map[int]string{spanKindServer: "server", spanKindClient: "client"}[s.kind],
+		StartTime:     s.startAt,
+		EndTime:       s.endAt,
+		DurationMs:    float64(s.endAt.Sub(s.startAt).Microseconds()) / 1000,
+		Status:        "ok",
+		StatusMessage: s.errMsg,
+	}
+	if r.Kind == "" {
+		r.Kind = "internal"
+	}
+	if s.parent != [8]byte{} {
+		r.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	if s.status == spanStatusError {
+		r.Status = "error"
+	}
+	if len(s.attrs) > 0 {
+		r.Attributes = make(map[string]any, len(s.attrs))
+		for _, a := range s.attrs {
+			r.Attributes[a.key] = a.value
+		}
+	}
+	return r
+}
+
+// otlpValue 按 OTLP/JSON 的 AnyValue 编码属性值（int64 按规范编码为字符串）
+func otlpValue(v any) map[string]any {
+	switch x := v.(type) {
+	case bool:
+		return map[string]any{"boolValue": x}
+	case int:
+		return map[string]any{"intValue": strconv.Itoa(x)}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(x, 10)}
+	case float64:
+		return map[string]any{"doubleValue": x}
+	case string:
+		return map[string]any{"stringValue": x}
+	default:
+		return map[string]any{"stringValue": fmt.Sprint(x)}
+	}
+}
+
+// otlpPayload 把一条 trace 编码为 OTLP/HTTP JSON 的 ExportTraceServiceRequest
+func otlpPayload(spans []traceSpan) ([]byte, error) {
+	out := make([]map[string]any, 0, len(spans))
+	for i := range spans {
+		s := &spans[i]
+		attrs := make([]map[string]any, 0, len(s.attrs))
+		for _, a := range s.attrs {
+			attrs = append(attrs, map[string]any{"key": a.key, "value": otlpValue(a.value)})
+		}
+		status := map[string]any{"code": s.status}
+		if s.errMsg != "" {
+			status["message"] = s.errMsg
+		}
+		span := map[string]any{
+			"traceId":           hex.EncodeToString(s.trace.id[:]),
+			"spanId":            hex.EncodeToString(s.id[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.startAt.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.endAt.UnixNano(), 10),
+			"attributes":        attrs,
+			"status":            status,
+		}
+		if s.parent != [8]byte{} {
+			span["parentSpanId"] = hex.EncodeToString(s.parent[:])
+		}
+		out = append(out, span)
+	}
+	return json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": []any{
+				map[string]any{"key": "service.name", "value": otlpValue("codeswitch")},
+			}},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "codeswitch/relay"},
+				"spans": out,
+			}},
+		}},
+	})
+}
+
+// traceExporter 后台导出：单协程顺序写，队列满即丢弃
+type traceExporter struct {
+	once   sync.Once
+	queue  chan *relayTrace
+	client *http.Client
+	fileMu sync.Mutex
+}
+
+var defaultTraceExporter = &traceExporter{}
+
+func (e *traceExporter) enqueue(trace *relayTrace) {
+	e.once.Do(func() {
+		e.queue = make(chan *relayTrace, traceExportQueueSize)
+		// 本地 collector 不走系统代理
+		e.client = &http.Client{Timeout: traceExportTimeout, Transport: &http.Transport{Proxy: nil}}
+		go e.run()
+	})
+	select {
+	case e.queue <- trace:
+	default:
+		fmt.Printf("[Tracing] 导出队列已满，丢弃 trace %s\n", hex.EncodeToString(trace.id[:]))
+	}
+}
+
+func (e *traceExporter) run() {
+	for trace := range e.queue {
+		if err := e.export(trace); err != nil {
+			fmt.Printf("[Tracing] 导出 trace 失败: %v\n", err)
+		}
+	}
+}
+
+func (e *traceExporter) export(trace *relayTrace) error {
+	spans := trace.snapshot()
+	if len(spans) == 0 {
+		return nil
+	}
+	if trace.cfg.exporter == tracingExporterOTLP {
+		return e.exportOTLP(trace.cfg.endpoint, spans)
+	}
+	return e.exportFile(trace.cfg.file, spans)
+}
+
+func (e *traceExporter) exportOTLP(endpoint string, spans []traceSpan) error {
+	payload, err := otlpPayload(spans)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), traceExportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector 返回 HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// exportFile 追加写入 JSON Lines；文件含请求路径与上游错误信息，仅属主可读写
+func (e *traceExporter) exportFile(path string, spans []traceSpan) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := range spans {
+		if err := enc.Encode(spans[i].record()); err != nil {
+			return err
+		}
+	}
+	e.fileMu.Lock()
+	defer e.fileMu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("创建目录失败: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -18,7 +18,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/wailsapp/wails/v3/pkg/application"
 	"golang.org/x/sync/singleflight"
 )
 
@@ -130,7 +129,7 @@ type UpdateService struct {
 	dismissedVersion string
 
 	// 事件发送
-	app *application.App
+	app DesktopApp
 
 	// 并发控制
 	checkGroup  singleflight.Group
@@ -205,9 +204,9 @@ func NewUpdateService(currentVersion string, policy ...string) *UpdateService {
 	return us
 }
 
-// SetApp 设置 Wails App 引用
+// SetApp 设置桌面外壳引用
 // 注册为 Wails 服务后该方法可被前端 RPC 调用，传 nil 会让更新事件广播失效，忽略之。
-func (us *UpdateService) SetApp(app *application.App) {
+func (us *UpdateService) SetApp(app DesktopApp) {
 	if app == nil {
 		return
 	}
@@ -1551,7 +1550,7 @@ func (us *UpdateService) emitState() {
 	// 在持有锁时构建快照
 	snapshot := us.getStateLocked()
 	// 异步发送事件，避免在持有锁时阻塞
-	go us.app.Event.Emit("update:state", snapshot)
+	go us.app.Emit("update:state", snapshot)
 }
 
 // emitStateUnlocked 发送状态事件（调用前不持有锁）
@@ -1559,7 +1558,7 @@ func (us *UpdateService) emitStateUnlocked() {
 	if us.app == nil {
 		return
 	}
-	us.app.Event.Emit("update:state", us.GetState())
+	us.app.Emit("update:state", us.GetState())
 }
 
 // getStateLocked 获取状态快照（调用前必须持有锁）
@@ -1612,7 +1611,7 @@ func (us *UpdateService) emitProgressThrottled(downloaded, total int64) {
 		us.lastEmitPercent = percent
 		us.lastEmitState = us.state
 		us.mu.Unlock()
-		us.app.Event.Emit("update:progress", map[string]interface{}{
+		us.app.Emit("update:progress", map[string]interface{}{
 			"downloaded": downloaded,
 			"total":      total,
 			"percent":    percent,
@@ -1634,7 +1633,7 @@ func (us *UpdateService) emitProgressThrottled(downloaded, total int64) {
 	us.lastEmitPercent = percent
 	us.mu.Unlock()
 
-	us.app.Event.Emit("update:progress", map[string]interface{}{
+	us.app.Emit("update:progress", map[string]interface{}{
 		"downloaded": downloaded,
 		"total":      total,
 		"percent":    percent,
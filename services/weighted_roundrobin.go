@@ -0,0 +1,186 @@
+package services
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ========== 同 Level 平滑加权轮询 ==========
+//
+// 开启轮询且同 Level 内有供应商配置了非 1 的权重时，roundRobinOrder 改用
+// 平滑加权轮询（nginx smooth weighted round-robin）：每次排序给所有成员的
+// current 加上各自权重，current 最大者排第一并减去总权重。3:1 的权重会得到
+// A A B A A A B A ... 这样均匀交错的序列，而不是连续打爆高权重供应商。
+//
+// 排序发生在请求开始时，但实际承接请求的未必是排第一的供应商（并发满载跳过、
+// 失败降级）。成功时 settleWeightedPick 把这一轮的"份额"从排第一者转给实际
+// 承接者，保证权重分配按真实流量收敛，而不是被跳过的供应商白白消耗份额。
+// 拉黑的供应商在排序前已被过滤，不参与本轮分配。
+
+// weightedRRState 一个 "platform:level" 的加权轮询状态
+type weightedRRState struct {
+	members string         // 成员签名（name=weight 列表），变化时重置 current
+	current map[string]int // 平滑加权轮询的 current 值
+	weights map[string]int // 当前成员权重
+	order   []string       // 成员的用户排序，统计输出用
+	served  map[string]int64
+}
+
+// WeightedRRStat 加权轮询分配统计（供前端展示权重与实际分配比例）
+type WeightedRRStat struct {
+	Platform      string  `json:"platform"`
+	Level         int     `json:"level"`
+	ProviderName  string  `json:"provider_name"`
+	Weight        int     `json:"weight"`
+	ExpectedShare float64 `json:"expected_share"` // 按权重应得的比例
+	Served        int64   `json:"served"`         // 本次启动以来实际承接的成功请求数
+	ActualShare   float64 `json:"actual_share"`   // 实际承接比例
+}
+
+// effectiveWeight 未配置或非法的权重按 1 处理
+func effectiveWeight(weight int) int {
+	if weight <= 0 {
+		return 1
+	}
+	return weight
+}
+
+// hasCustomWeights 同组内是否有供应商配置了非默认权重；全为默认时保持原有的按名轮询
+func hasCustomWeights(weights []int) bool {
+	for _, w := range weights {
+		if effectiveWeight(w) != 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// weightedRoundRobinOrder 对一组成员执行一步平滑加权轮询，返回排序后的下标：
+// 选中者在首位，其余按 current 降序（同值保持用户排序）作为降级顺序。
+// 调用方需持有 rrMu。
+func (prs *ProviderRelayService) weightedRoundRobinOrder(key string, names []string, weights []int) []int {
+	if prs.wrrStates == nil {
+		prs.wrrStates = make(map[string]*weightedRRState)
+	}
+
+	var sig strings.Builder
+	total := 0
+	for i, name := range names {
+		w := effectiveWeight(weights[i])
+		total += w
+		sig.WriteString(name)
+		sig.WriteByte('=')
+		sig.WriteString(strconv.Itoa(w))
+		sig.WriteByte(';')
+	}
+
+	state := prs.wrrStates[key]
+	if state == nil {
+		state = &weightedRRState{served: make(map[string]int64)}
+		prs.wrrStates[key] = state
+	}
+	// 成员或权重变化（增删、拉黑、改权重）：current 从零开始，避免旧累计值让某个成员连续被选中
+	if state.members != sig.String() {
+		state.members = sig.String()
+		state.current = make(map[string]int, len(names))
+		state.weights = make(map[string]int, len(names))
+		for i, name := range names {
+			state.weights[name] = effectiveWeight(weights[i])
+		}
+		state.order = append([]string(nil), names...)
+	}
+
+	picked := 0
+	for i, name := range names {
+		state.current[name] += state.weights[name]
+		if state.current[name] > state.current[names[picked]] {
+			picked = i
+		}
+	}
+	state.current[names[picked]] -= total
+
+	order := make([]int, 0, len(names))
+	order = append(order, picked)
+	for i := range names {
+		if i != picked {
+			order = append(order, i)
+		}
+	}
+	rest := order[1:]
+	sort.SliceStable(rest, func(a, b int) bool {
+		return state.current[names[rest[a]]] > state.current[names[rest[b]]]
+	})
+	return order
+}
+
+// settleWeightedPick 请求成功后结算加权轮询份额：picked 为排序时排第一的供应商，
+// served 为实际承接者。二者不同时把本轮份额转给 served，并累计承接次数。
+// 未启用加权（该 Level 无状态或成员已变化）时为空操作。
+func (prs *ProviderRelayService) settleWeightedPick(platform string, level int, picked, served string) {
+	prs.rrMu.Lock()
+	defer prs.rrMu.Unlock()
+
+	state := prs.wrrStates[platform+":"+strconv.Itoa(level)]
+	if state == nil {
+		return
+	}
+	if _, ok := state.weights[served]; !ok {
+		return
+	}
+	if picked != served {
+		if _, ok := state.weights[picked]; ok {
+			total := 0
+			for _, w := range state.weights {
+				total += w
+			}
+			state.current[picked] += total
+			state.current[served] -= total
+		}
+	}
+	state.served[served]++
+}
+
+// GetAllWeightedRoundRobinStats 获取各平台加权轮询的权重与实际分配统计（按平台分组）
+func (prs *ProviderRelayService) GetAllWeightedRoundRobinStats() map[string][]WeightedRRStat {
+	prs.rrMu.Lock()
+	defer prs.rrMu.Unlock()
+
+	result := make(map[string][]WeightedRRStat)
+	keys := make([]string, 0, len(prs.wrrStates))
+	for key := range prs.wrrStates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		state := prs.wrrStates[key]
+		sep := strings.LastIndex(key, ":")
+		platform := key[:sep]
+		level, _ := strconv.Atoi(key[sep+1:])
+
+		totalWeight := 0
+		var totalServed int64
+		for _, name := range state.order {
+			totalWeight += state.weights[name]
+			totalServed += state.served[name]
+		}
+		for _, name := range state.order {
+			stat := WeightedRRStat{
+				Platform:     platform,
+				Level:        level,
+				ProviderName: name,
+				Weight:       state.weights[name],
+				Served:       state.served[name],
+			}
+			if totalWeight > 0 {
+				stat.ExpectedShare = float64(stat.Weight) / float64(totalWeight)
+			}
+			if totalServed > 0 {
+				stat.ActualShare = float64(stat.Served) / float64(totalServed)
+			}
+			result[platform] = append(result[platform], stat)
+		}
+	}
+	return result
+}
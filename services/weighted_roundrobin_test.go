@@ -0,0 +1,71 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestWeightedRoundRobinOrderSmooth 权重 3:1 时每 4 次排序 A 居首 3 次、B 1 次，且交错分布
+func TestWeightedRoundRobinOrderSmooth(t *testing.T) {
+	prs := &ProviderRelayService{rrLastStart: make(map[string]string)}
+	providers := []Provider{{Name: "A", Weight: 3}, {Name: "B", Weight: 1}}
+
+	var seq []string
+	for i := 0; i < 8; i++ {
+		ordered := prs.roundRobinOrder("claude", 1, providers)
+		if len(ordered) != 2 {
+			t.Fatalf("排序结果应保留全部成员: %v", ordered)
+		}
+		seq = append(seq, ordered[0].Name)
+		prs.settleWeightedPick("claude", 1, ordered[0].Name, ordered[0].Name)
+	}
+	if got := strings.Join(seq, ""); got != "AABAAABA" {
+		t.Errorf("平滑加权序列 = %s, want AABAAABA", got)
+	}
+
+	stats := prs.GetAllWeightedRoundRobinStats()["claude"]
+	if len(stats) != 2 || stats[0].Served != 6 || stats[1].Served != 2 || stats[0].ExpectedShare != 0.75 || stats[1].ActualShare != 0.25 {
+		t.Errorf("分配统计错误: %+v", stats)
+	}
+}
+
+// TestWeightedRoundRobinSettleTransfersSkippedShare 排第一的供应商并发满被跳过时，
+// 份额转给实际承接者：下一轮仍优先被跳过的一方，总体比例按真实流量收敛
+func TestWeightedRoundRobinSettleTransfersSkippedShare(t *testing.T) {
+	prs := &ProviderRelayService{rrLastStart: make(map[string]string)}
+	providers := []Provider{{Name: "A", Weight: 1}, {Name: "B", Weight: 2}}
+
+	first := prs.roundRobinOrder("codex", 1, providers)
+	if first[0].Name != "B" {
+		t.Fatalf("首轮应选权重高的 B, 实际 %s", first[0].Name)
+	}
+	// B 满载，A 承接
+	prs.settleWeightedPick("codex", 1, "B", "A")
+
+	second := prs.roundRobinOrder("codex", 1, providers)
+	if second[0].Name != "B" {
+		t.Errorf("被跳过的 B 份额应保留, 次轮仍应选 B, 实际 %s", second[0].Name)
+	}
+
+	stats := prs.GetAllWeightedRoundRobinStats()["codex"]
+	if stats[0].ProviderName != "A" || stats[0].Served != 1 || stats[1].Served != 0 {
+		t.Errorf("承接次数应记在实际承接者上: %+v", stats)
+	}
+}
+
+// TestRoundRobinOrderWithoutWeights 未配置权重时保持原有的按名轮询
+func TestRoundRobinOrderWithoutWeights(t *testing.T) {
+	prs := &ProviderRelayService{rrLastStart: make(map[string]string)}
+	providers := []Provider{{Name: "A"}, {Name: "B"}, {Name: "C"}}
+
+	var seq []string
+	for i := 0; i < 4; i++ {
+		seq = append(seq, prs.roundRobinOrder("claude", 1, providers)[0].Name)
+	}
+	if got := strings.Join(seq, ""); got != "ABCA" {
+		t.Errorf("轮询序列 = %s, want ABCA", got)
+	}
+	if len(prs.GetAllWeightedRoundRobinStats()) != 0 {
+		t.Errorf("未配置权重不应产生加权统计")
+	}
+}